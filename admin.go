@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+)
+
+// adminToken guards /admin, through ADMIN_TOKEN. Without one the admin API
+// isn't served.
+var adminToken string
+
+func adminHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Use(requireAdmin)
+	r.Get("/shedding", getShedding)
+	r.With(requireJSON).Put("/shedding", putShedding)
+	r.Get("/maintenance", getMaintenance)
+	r.With(requireJSON).Put("/maintenance", putMaintenance)
+	r.Get("/flags", getFlags)
+	r.With(requireJSON).Put("/flags/{name}", putFlag)
+	r.Delete("/flags/{name}", deleteFlag)
+	return r
+}
+
+// isAdmin reports whether r bears ADMIN_TOKEN.
+func isAdmin(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// requireAdmin accepts requests bearing ADMIN_TOKEN.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			respond(w, r, http.StatusUnauthorized, renderer.M{"message": "Admin token required"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	mrand "math/rand"
+	"os"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// anonymizedCollections are what an anonymized dump holds. Settings are
+// keyed by users' tokens, dedupe keys are client supplied and the history
+// keeps every old title, so they are left out.
+var anonymizedCollections = []string{templateCollection, collectionName, shortIDCollection}
+
+const loremLetters = "loremipsumdolorsitametconsecteturadipiscingelitseddoeiusmodtemporincididuntutlaboreetdoloremagnaaliqua"
+
+// maxLocationJitter is how far, in degrees, a location may be moved.
+const maxLocationJitter = 0.05
+
+// anonymizer rewrites documents so a dump can be shared without its
+// content. Everything it does is derived from the seed and each
+// document's _id, so two dumps of the same data with the same seed are
+// identical.
+type anonymizer struct {
+	seed   []byte
+	jitter time.Duration
+}
+
+// runAnonymizeDump writes an archive, restorable with import-archive, of
+// the todos and templates with their text replaced.
+func runAnonymizeDump(args []string) int {
+	fs := flag.NewFlagSet("anonymize-dump", flag.ContinueOnError)
+	out := fs.String("o", "go-todo-anonymized.tar.gz", "file to write, - for stdout")
+	seed := fs.String("seed", "", "seed making the output reproducible; random when empty")
+	jitter := fs.Duration("jitter", 72*time.Hour, "largest shift applied to a document's timestamps")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *jitter < 0 {
+		fmt.Fprintln(os.Stderr, "error: -jitter may not be negative")
+		return exitUsage
+	}
+	if *seed == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		*seed = hex.EncodeToString(b)
+		fmt.Fprintf(os.Stderr, "seed: %s\n", *seed)
+	}
+
+	a := anonymizer{seed: []byte(*seed), jitter: *jitter}
+	return exportArchiveFile(*out, anonymizedCollections, a.document)
+}
+
+// document anonymizes one document. IDs, short IDs, statuses, priorities,
+// buckets, positions and every other non-text field are kept as they are.
+func (a anonymizer) document(collection string, doc bson.D) bson.D {
+	id := fmt.Sprint(lookupD(doc, "_id"))
+	offset := a.offset(id)
+
+	out := make(bson.D, 0, len(doc))
+	for _, e := range doc {
+		switch e.Key {
+		case "title", "name", "locationLabel":
+			if s, ok := e.Value.(string); ok {
+				e.Value = a.text(id+"\x00"+e.Key, s)
+			}
+		case "tags":
+			if tags, ok := e.Value.(bson.A); ok {
+				pseudonyms := make(bson.A, len(tags))
+				for i, tag := range tags {
+					s, _ := tag.(string)
+					pseudonyms[i] = a.tag(s)
+				}
+				e.Value = pseudonyms
+			}
+		case "metadata":
+			e.Value = a.metadata(id+"\x00metadata", e.Value)
+		case "location":
+			if loc, ok := e.Value.(bson.D); ok {
+				e.Value = a.location(id, loc)
+			}
+		}
+		e.Value = shiftDates(e.Value, offset)
+		out = append(out, e)
+	}
+	return out
+}
+
+// rng returns a generator seeded by the seed and key.
+func (a anonymizer) rng(key string) *mrand.Rand {
+	mac := hmac.New(sha256.New, a.seed)
+	mac.Write([]byte(key))
+	return mrand.New(mrand.NewSource(int64(binary.BigEndian.Uint64(mac.Sum(nil)))))
+}
+
+// offset is the shift applied to every timestamp of a document, the same
+// for all of them so their order is kept.
+func (a anonymizer) offset(id string) time.Duration {
+	if a.jitter == 0 {
+		return 0
+	}
+	return time.Duration(a.rng(id+"\x00time").Int63n(int64(2*a.jitter)+1)) - a.jitter
+}
+
+// text replaces letters with lorem ipsum and digits with other digits,
+// keeping the length, case, spacing and punctuation, and any template
+// placeholder such as {{date}} intact.
+func (a anonymizer) text(key, s string) string {
+	rng := a.rng(key)
+	lorem := []rune(loremLetters)
+	i := rng.Intn(len(lorem))
+
+	replace := func(part string) string {
+		out := []rune(part)
+		for j, r := range out {
+			switch {
+			case unicode.IsLetter(r):
+				l := lorem[i%len(lorem)]
+				i++
+				if unicode.IsUpper(r) {
+					l = unicode.ToUpper(l)
+				}
+				out[j] = l
+			case unicode.IsDigit(r):
+				out[j] = rune('0' + rng.Intn(10))
+			}
+		}
+		return string(out)
+	}
+
+	var out []byte
+	last := 0
+	for _, m := range placeholderRe.FindAllStringIndex(s, -1) {
+		out = append(out, replace(s[last:m[0]])...)
+		out = append(out, s[m[0]:m[1]]...)
+		last = m[1]
+	}
+	out = append(out, replace(s[last:])...)
+	return string(out)
+}
+
+// tag maps a tag to its pseudonym, the same one wherever it appears.
+func (a anonymizer) tag(tag string) string {
+	mac := hmac.New(sha256.New, a.seed)
+	mac.Write([]byte("tag\x00" + tag))
+	return "tag-" + hex.EncodeToString(mac.Sum(nil))[:8]
+}
+
+// metadata keeps the keys and the shape of v but anonymizes its strings.
+func (a anonymizer) metadata(key string, v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		return a.text(key, val)
+	case bson.D:
+		out := make(bson.D, len(val))
+		for i, e := range val {
+			out[i] = bson.E{Key: e.Key, Value: a.metadata(key+"."+e.Key, e.Value)}
+		}
+		return out
+	case bson.A:
+		out := make(bson.A, len(val))
+		for i, item := range val {
+			out[i] = a.metadata(fmt.Sprintf("%s.%d", key, i), item)
+		}
+		return out
+	}
+	return v
+}
+
+// location moves a GeoJSON point by up to maxLocationJitter degrees.
+func (a anonymizer) location(id string, loc bson.D) bson.D {
+	out := make(bson.D, len(loc))
+	copy(out, loc)
+	for i, e := range out {
+		coords, ok := e.Value.(bson.A)
+		if e.Key != "coordinates" || !ok || len(coords) != 2 {
+			continue
+		}
+		lng, ok1 := coords[0].(float64)
+		lat, ok2 := coords[1].(float64)
+		if !ok1 || !ok2 {
+			continue
+		}
+		rng := a.rng(id + "\x00location")
+		lng += (rng.Float64()*2 - 1) * maxLocationJitter
+		lat += (rng.Float64()*2 - 1) * maxLocationJitter
+		lat = min(max(lat, -90), 90)
+		if lng > 180 {
+			lng -= 360
+		} else if lng < -180 {
+			lng += 360
+		}
+		out[i].Value = bson.A{lng, lat}
+	}
+	return out
+}
+
+// shiftDates moves every date in v by offset.
+func shiftDates(v interface{}, offset time.Duration) interface{} {
+	switch val := v.(type) {
+	case primitive.DateTime:
+		return primitive.NewDateTimeFromTime(val.Time().Add(offset))
+	case bson.D:
+		out := make(bson.D, len(val))
+		for i, e := range val {
+			out[i] = bson.E{Key: e.Key, Value: shiftDates(e.Value, offset)}
+		}
+		return out
+	case bson.A:
+		out := make(bson.A, len(val))
+		for i, item := range val {
+			out[i] = shiftDates(item, offset)
+		}
+		return out
+	}
+	return v
+}
+
+func lookupD(doc bson.D, key string) interface{} {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value
+		}
+	}
+	return nil
+}
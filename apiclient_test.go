@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	todoclient "github.com/Heismanish/todo/pkg/client"
+)
+
+// newTestClient serves the real todo routes on a test database and returns
+// a client for them. The first *unavailable requests are answered with 503
+// before reaching the routes, and every request is counted in *requests.
+func newTestClient(t *testing.T) (c *todoclient.Client, unavailable, requests *atomic.Int32) {
+	useTestDB(t)
+	unavailable, requests = new(atomic.Int32), new(atomic.Int32)
+	routes := testRouter()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if unavailable.Add(-1) >= 0 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, `{"message":"Busy"}`, http.StatusServiceUnavailable)
+			return
+		}
+		unavailable.Store(0)
+		routes.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return todoclient.New(srv.URL, "", 5*time.Second), unavailable, requests
+}
+
+func createForClient(t *testing.T, c *todoclient.Client, title string) string {
+	t.Helper()
+	id, err := c.Create(context.Background(), todoclient.Todo{Title: title})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestClientGet(t *testing.T) {
+	c, _, _ := newTestClient(t)
+	id := createForClient(t, c, "write tests")
+
+	got, err := c.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != id || got.Title != "write tests" || got.Status != statusTodo {
+		t.Errorf("Get = %+v", got)
+	}
+
+	if _, err := c.Get(context.Background(), "65f0c0ffee0000000000beef"); !errors.Is(err, todoclient.ErrNotFound) {
+		t.Errorf("Get of a missing todo = %v; want ErrNotFound", err)
+	}
+}
+
+func TestClientUpdate(t *testing.T) {
+	c, _, _ := newTestClient(t)
+	id := createForClient(t, c, "write tests")
+
+	td, err := c.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	td.Title = "write more tests"
+	if err := c.Update(context.Background(), *td); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get(context.Background(), id); err != nil || got.Title != "write more tests" {
+		t.Errorf("after Update, Get = %+v, %v; want the new title", got, err)
+	}
+
+	err = c.Update(context.Background(), todoclient.Todo{ID: id})
+	var ve *todoclient.ValidationError
+	if !errors.As(err, &ve) || ve.Field != "title" || !errors.Is(err, todoclient.ErrValidation) {
+		t.Errorf("Update without a title = %v; want a ValidationError on title", err)
+	}
+}
+
+func TestClientToggle(t *testing.T) {
+	c, _, requests := newTestClient(t)
+	id := createForClient(t, c, "write tests")
+
+	for _, want := range []bool{true, false} {
+		got, err := c.Toggle(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status := map[bool]string{true: statusDone, false: statusTodo}[want]
+		if got.Completed != want || got.Status != status {
+			t.Errorf("Toggle = completed %v, status %s; want %v, %s", got.Completed, got.Status, want, status)
+		}
+		stored, err := c.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Completed != want || stored.Status != status {
+			t.Errorf("stored = completed %v, status %s; want %v, %s", stored.Completed, stored.Status, want, status)
+		}
+	}
+	// A create, then a GET and a PUT for each toggle plus the GET checking
+	// it.
+	if got := requests.Load(); got != 7 {
+		t.Errorf("made %d requests; want 7", got)
+	}
+}
+
+func TestClientPatch(t *testing.T) {
+	c, _, _ := newTestClient(t)
+	id := createForClient(t, c, "write tests")
+
+	got, err := c.Patch(context.Background(), id, map[string]interface{}{"priority": priorityHigh})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Priority != priorityHigh || got.Title != "write tests" {
+		t.Errorf("Patch = %+v; want priority high and the title kept", got)
+	}
+
+	_, err = c.Patch(context.Background(), id, map[string]interface{}{"colour": "red"})
+	var ve *todoclient.ValidationError
+	if !errors.As(err, &ve) || ve.Field != "colour" {
+		t.Errorf("Patch of an unknown field = %v; want a ValidationError on colour", err)
+	}
+}
+
+func TestClientRetriesUnavailable(t *testing.T) {
+	c, unavailable, requests := newTestClient(t)
+	id := createForClient(t, c, "write tests")
+
+	requests.Store(0)
+	unavailable.Store(2)
+	if _, err := c.Get(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("made %d requests; want 3", got)
+	}
+
+	unavailable.Store(int32(c.MaxRetries + 1))
+	_, err := c.Get(context.Background(), id)
+	var ae *todoclient.APIError
+	if !errors.As(err, &ae) || ae.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Get past MaxRetries = %v; want an APIError with 503", err)
+	}
+}
+
+func TestClientCreate(t *testing.T) {
+	c, _, _ := newTestClient(t)
+	estimate := 30
+	id, err := c.Create(context.Background(), todoclient.Todo{
+		Title:    "write tests",
+		Tags:     []string{"work"},
+		Priority: priorityHigh,
+		Estimate: &estimate,
+		Metadata: map[string]interface{}{"source": "client"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Priority != priorityHigh || got.Estimate == nil || *got.Estimate != estimate || got.Metadata["source"] != "client" {
+		t.Errorf("Get = priority %q, estimate %v, metadata %v; want what was created", got.Priority, got.Estimate, got.Metadata)
+	}
+	if len(got.Tags) != 1 || got.Tags[0] != "work" {
+		t.Errorf("tags = %v; want [work]", got.Tags)
+	}
+
+	_, err = c.Create(context.Background(), todoclient.Todo{Title: "write tests", Priority: "urgent"})
+	var ve *todoclient.ValidationError
+	if !errors.As(err, &ve) || ve.Field != "priority" || ve.StatusCode != http.StatusBadRequest {
+		t.Errorf("Create with an unknown priority = %v; want a 400 ValidationError on priority", err)
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	archiveFormat        = "go-todo-archive"
+	archiveSchemaVersion = 3
+	archiveManifest      = "manifest.json"
+	archiveBatchSize     = 500
+)
+
+// archiveCollections are the collections an archive holds, in the order
+// they are restored: todos before the short IDs, dedupe keys, history and
+// tombstones that point at them. Tombstones go along so sync clients of the
+// new instance still learn of deletions made before the move.
+var archiveCollections = []string{
+	settingsCollection,
+	featureFlagCollection,
+	wipLimitsCollection,
+	templateCollection,
+	collectionName,
+	shortIDCollection,
+	dedupeCollection,
+	auditCollection,
+	tombstoneCollection,
+}
+
+type archiveManifestFile struct {
+	Format        string           `json:"format"`
+	SchemaVersion int              `json:"schema_version"`
+	CreatedAt     time.Time        `json:"created_at"`
+	Counts        map[string]int64 `json:"counts"`
+}
+
+// runExportArchive writes every collection the app owns to a tar.gz: a
+// manifest followed by one NDJSON file per collection, in MongoDB Extended
+// JSON so ObjectIDs and dates survive the round trip.
+func runExportArchive(args []string) int {
+	fs := flag.NewFlagSet("export-archive", flag.ContinueOnError)
+	out := fs.String("o", "go-todo-archive.tar.gz", "file to write, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	return exportArchiveFile(*out, archiveCollections, nil)
+}
+
+// exportArchiveFile connects to Mongo and writes an archive of collections
+// to the file out, or stdout for -, removing a partial file on failure.
+func exportArchiveFile(out string, collections []string, transform documentTransform) int {
+	setup()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	w := io.Writer(os.Stdout)
+	if out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return exitError
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := exportArchive(ctx, w, collections, transform); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		if out != "-" {
+			os.Remove(out)
+		}
+		return exitError
+	}
+	return exitOK
+}
+
+// documentTransform rewrites a document of the named collection on its way
+// into an archive.
+type documentTransform func(collection string, doc bson.D) bson.D
+
+// exportArchive writes collections, a subset of archiveCollections in their
+// order, to w, passing each document through transform if there is one.
+func exportArchive(ctx context.Context, w io.Writer, collections []string, transform documentTransform) error {
+	// A tar header needs the size of its file, so each collection is dumped
+	// to a temporary file first, which also yields the manifest counts.
+	dumps := map[string]*os.File{}
+	defer func() {
+		for _, f := range dumps {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	manifest := archiveManifestFile{
+		Format:        archiveFormat,
+		SchemaVersion: archiveSchemaVersion,
+		CreatedAt:     time.Now().UTC(),
+		Counts:        map[string]int64{},
+	}
+	for _, name := range collections {
+		f, err := os.CreateTemp("", "go-todo-"+name+"-*.ndjson")
+		if err != nil {
+			return err
+		}
+		dumps[name] = f
+		n, err := dumpCollection(ctx, name, f, transform)
+		if err != nil {
+			return fmt.Errorf("exporting %s: %w", name, err)
+		}
+		manifest.Counts[name] = n
+		fmt.Fprintf(os.Stderr, "%s: %d documents\n", name, n)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: archiveManifest, Mode: 0o644, Size: int64(len(b)), ModTime: manifest.CreatedAt}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(b); err != nil {
+		return err
+	}
+	for _, name := range collections {
+		f := dumps[name]
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: name + ".ndjson", Mode: 0o644, Size: info.Size(), ModTime: manifest.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, f); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func dumpCollection(ctx context.Context, name string, w io.Writer, transform documentTransform) (int64, error) {
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetBatchSize(archiveBatchSize)
+	cur, err := db.Collection(name).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	bw := bufio.NewWriter(w)
+	var n int64
+	for cur.Next(ctx) {
+		var doc interface{} = cur.Current
+		if transform != nil {
+			var d bson.D
+			if err := bson.Unmarshal(cur.Current, &d); err != nil {
+				return n, err
+			}
+			doc = transform(name, d)
+		}
+		line, err := bson.MarshalExtJSON(doc, true, false)
+		if err != nil {
+			return n, err
+		}
+		bw.Write(line)
+		bw.WriteByte('\n')
+		n++
+	}
+	if err := cur.Err(); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// runImportArchive restores an archive written by export-archive. It
+// refuses to touch a database that already holds data unless --force is
+// given, in which case the archived collections are emptied first so the
+// result matches the archive.
+func runImportArchive(args []string) int {
+	fs := flag.NewFlagSet("import-archive", flag.ContinueOnError)
+	force := fs.Bool("force", false, "replace the data already in the database")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: go-todo import-archive [--force] <file>")
+		return exitUsage
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return exitError
+	}
+	defer f.Close()
+
+	setup()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	if err := importArchive(ctx, f, *force); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func importArchive(ctx context.Context, r io.Reader, force bool) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("not a gzip archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != archiveManifest {
+		return fmt.Errorf("archive does not start with %s", archiveManifest)
+	}
+	var manifest archiveManifestFile
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return fmt.Errorf("reading %s: %w", archiveManifest, err)
+	}
+	if manifest.Format != archiveFormat {
+		return fmt.Errorf("not a go-todo archive")
+	}
+	if manifest.SchemaVersion < 1 || manifest.SchemaVersion > archiveSchemaVersion {
+		return fmt.Errorf("archive schema version %d is not supported, expected at most %d", manifest.SchemaVersion, archiveSchemaVersion)
+	}
+
+	if err := prepareImport(ctx, force); err != nil {
+		return err
+	}
+
+	restored := map[string]bool{}
+	next := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name, ok := strings.CutSuffix(hdr.Name, ".ndjson")
+		if !ok || restored[name] {
+			return fmt.Errorf("unexpected file %s in archive", hdr.Name)
+		}
+		// Entries must come in restore order; skipping ahead is allowed
+		// for collections an older archive doesn't have.
+		for next < len(archiveCollections) && archiveCollections[next] != name {
+			next++
+		}
+		if next == len(archiveCollections) {
+			return fmt.Errorf("unexpected file %s in archive", hdr.Name)
+		}
+		n, err := restoreCollection(ctx, name, tr, manifest.Counts[name])
+		if err != nil {
+			return fmt.Errorf("restoring %s: %w", name, err)
+		}
+		if n != manifest.Counts[name] {
+			return fmt.Errorf("restoring %s: manifest lists %d documents, archive holds %d", name, manifest.Counts[name], n)
+		}
+		restored[name] = true
+	}
+
+	for name, count := range manifest.Counts {
+		if !restored[name] && count > 0 {
+			return fmt.Errorf("archive is missing %s.ndjson", name)
+		}
+	}
+	return nil
+}
+
+// prepareImport checks that the archived collections are empty or, with
+// force, empties them.
+func prepareImport(ctx context.Context, force bool) error {
+	for _, name := range archiveCollections {
+		collection := db.Collection(name)
+		if force {
+			if _, err := collection.DeleteMany(ctx, bson.M{}); err != nil {
+				return fmt.Errorf("clearing %s: %w", name, err)
+			}
+			continue
+		}
+		n, err := collection.EstimatedDocumentCount(ctx)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return errors.New("the database already holds data; rerun with --force to replace it")
+		}
+	}
+	return nil
+}
+
+func restoreCollection(ctx context.Context, name string, r io.Reader, total int64) (int64, error) {
+	collection := db.Collection(name)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+
+	var (
+		batch []interface{}
+		n     int64
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := collection.InsertMany(ctx, batch); err != nil {
+			return err
+		}
+		n += int64(len(batch))
+		batch = batch[:0]
+		fmt.Fprintf(os.Stderr, "%s: %d/%d\n", name, n, total)
+		return nil
+	}
+
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON(sc.Bytes(), true, &doc); err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+		batch = append(batch, doc)
+		if len(batch) == archiveBatchSize {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return n, err
+	}
+	if err := flush(); err != nil {
+		return n, err
+	}
+	if n == 0 {
+		fmt.Fprintf(os.Stderr, "%s: 0/0\n", name)
+	}
+	return n, nil
+}
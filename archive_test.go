@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestArchiveRoundTrip exports a document of every archived collection and
+// imports the archive again, expecting each document to be inserted back
+// into its collection exactly as it was read.
+func TestArchiveRoundTrip(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("archive", func(mt *mtest.T) {
+		useMockDB(mt)
+
+		at := primitive.NewDateTimeFromTime(time.Date(2024, 3, 24, 18, 25, 59, 0, time.UTC))
+		todoID := primitive.NewObjectID()
+		docs := map[string]bson.D{
+			settingsCollection:    {{Key: "_id", Value: "user-1"}, {Key: "timezone", Value: "Europe/Berlin"}},
+			featureFlagCollection: {{Key: "_id", Value: flagFeed}, {Key: "enabled", Value: false}, {Key: "updatedAt", Value: at}},
+			wipLimitsCollection:   {{Key: "_id", Value: statusInProgress}, {Key: "limit", Value: 3}, {Key: "moves", Value: int64(12)}},
+			templateCollection:    {{Key: "_id", Value: primitive.NewObjectID()}, {Key: "name", Value: "weekly review"}},
+			collectionName:        {{Key: "_id", Value: todoID}, {Key: "title", Value: "write tests"}, {Key: "createAt", Value: at}},
+			shortIDCollection:     {{Key: "_id", Value: "abc123"}, {Key: "todoId", Value: todoID}},
+			dedupeCollection:      {{Key: "_id", Value: "key-1"}, {Key: "todoId", Value: todoID}},
+			auditCollection:       {{Key: "_id", Value: primitive.NewObjectID()}, {Key: "todoId", Value: todoID}, {Key: "at", Value: at}},
+			tombstoneCollection:   {{Key: "_id", Value: primitive.NewObjectID()}, {Key: "deletedAt", Value: at}},
+		}
+		for _, name := range archiveCollections {
+			doc, ok := docs[name]
+			if !ok {
+				mt.Fatalf("no test document for %s", name)
+			}
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+"."+name, mtest.FirstBatch, doc))
+		}
+
+		var archive bytes.Buffer
+		if err := exportArchive(context.Background(), &archive, archiveCollections, nil); err != nil {
+			mt.Fatal(err)
+		}
+
+		// --force empties every collection, then each gets one insert.
+		for range archiveCollections {
+			mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+		}
+		for range archiveCollections {
+			mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+		}
+		mt.ClearEvents()
+		if err := importArchive(context.Background(), &archive, true); err != nil {
+			mt.Fatal(err)
+		}
+
+		restored := map[string]bool{}
+		for _, ev := range mt.GetAllStartedEvents() {
+			if ev.CommandName != "insert" {
+				continue
+			}
+			name := ev.Command.Lookup("insert").StringValue()
+			inserted, err := ev.Command.Lookup("documents").Array().Values()
+			if err != nil || len(inserted) != 1 {
+				mt.Fatalf("insert into %s: %v documents, %v", name, len(inserted), err)
+			}
+			want, err := bson.Marshal(docs[name])
+			if err != nil {
+				mt.Fatal(err)
+			}
+			if got := inserted[0].Document(); !bytes.Equal(got, want) {
+				mt.Errorf("%s: restored %s; want %s", name, got, bson.Raw(want))
+			}
+			restored[name] = true
+		}
+		for name := range docs {
+			if !restored[name] {
+				mt.Errorf("%s was not restored", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// assetCacheControl lets browsers keep an asset for good: its URL carries a
+// hash of its content, so a changed file is fetched from a new URL.
+const assetCacheControl = "public, max-age=31536000, immutable"
+
+type staticAsset struct {
+	name string
+	body []byte
+}
+
+var (
+	// assetURLs maps a file under static, e.g. app.css, to its versioned
+	// URL, e.g. /static/app.1a2b3c4d.css.
+	assetURLs map[string]string
+	// assetFiles maps a versioned file name back to the asset.
+	assetFiles map[string]staticAsset
+)
+
+// loadAssets reads and hashes every file in fsys other than the templates.
+// It runs once at startup; an edited file is served once the binary is
+// rebuilt.
+func loadAssets(fsys fs.FS) error {
+	urls := map[string]string{}
+	files := map[string]staticAsset{}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(name) == ".tpl" {
+			return err
+		}
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		versioned := versionedName(name, body)
+		urls[name] = "/static/" + versioned
+		files[versioned] = staticAsset{name: name, body: body}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	assetURLs, assetFiles = urls, files
+	return nil
+}
+
+// versionedName inserts the first 8 hex digits of the SHA-256 of body
+// before the extension of name.
+func versionedName(name string, body []byte) string {
+	sum := sha256.Sum256(body)
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+}
+
+// assetURL is the template function asset. An unknown name fails the
+// render rather than leaving a broken link in the page.
+func assetURL(name string) (string, error) {
+	u, ok := assetURLs[name]
+	if !ok {
+		return "", fmt.Errorf("no static asset %s", name)
+	}
+	return u, nil
+}
+
+// serveAsset serves /static/<versioned name>. Any other name, including an
+// asset's unversioned one, is a 404 so stale links show up.
+func serveAsset(w http.ResponseWriter, r *http.Request) {
+	a, ok := assetFiles[chi.URLParam(r, "*")]
+	if !ok {
+		notFoundHandler(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", assetCacheControl)
+	http.ServeContent(w, r, a.name, time.Time{}, bytes.NewReader(a.body))
+}
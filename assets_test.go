@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadAssetsHashesContent(t *testing.T) {
+	defer func(urls map[string]string, files map[string]staticAsset) {
+		assetURLs, assetFiles = urls, files
+	}(assetURLs, assetFiles)
+
+	load := func(css string) string {
+		t.Helper()
+		fsys := fstest.MapFS{
+			"app.css":  {Data: []byte(css)},
+			"home.tpl": {Data: []byte(`{{asset "app.css"}}`)},
+		}
+		if err := loadAssets(fsys); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := assetURLs["home.tpl"]; ok {
+			t.Error("templates are served as assets")
+		}
+		u, err := assetURL("app.css")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(u, "/static/app.") || !strings.HasSuffix(u, ".css") {
+			t.Errorf("assetURL(app.css) = %s; want /static/app.<hash>.css", u)
+		}
+		if a, ok := assetFiles[strings.TrimPrefix(u, "/static/")]; !ok || string(a.body) != css {
+			t.Errorf("%s doesn't serve app.css", u)
+		}
+		return u
+	}
+
+	a := load("body { color: black }")
+	b := load("body { color: white }")
+	if a == b {
+		t.Errorf("both versions of app.css are at %s", a)
+	}
+	if again := load("body { color: black }"); again != a {
+		t.Errorf("the same app.css moved from %s to %s", a, again)
+	}
+}
+
+func TestLoadTemplatesFromEmbed(t *testing.T) {
+	if err := loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := assetURL("app.css"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	auditCollection = "audit"
+	auditQueueSize  = 1024
+
+	auditCreate = "create"
+	auditUpdate = "update"
+	auditDelete = "delete"
+)
+
+// auditRetention is how long history entries are kept, configurable
+// through AUDIT_RETENTION.
+var auditRetention = 90 * 24 * time.Hour
+
+// auditQueue feeds runAuditLog, so recording a change never waits on the
+// database. When it is full, entries are dropped rather than slowing the
+// request down.
+var auditQueue = make(chan auditEntry, auditQueueSize)
+
+var auditDropped = newCounter("audit_entries_dropped_total", "History entries dropped because the audit queue was full.")
+
+type (
+	auditEntry struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		TodoID    primitive.ObjectID `bson:"todoId"`
+		SessionID string             `bson:"sessionId,omitempty"`
+		Type      string             `bson:"type"`
+		Before    *todoModel         `bson:"before,omitempty"`
+		After     *todoModel         `bson:"after,omitempty"`
+		At        time.Time          `bson:"at"`
+		ExpireAt  time.Time          `bson:"expireAt"`
+	}
+	historyEntry struct {
+		Type   string    `json:"type" xml:"type"`
+		At     time.Time `json:"at" xml:"at"`
+		Before *todo     `json:"before,omitempty" xml:"before,omitempty"`
+		After  *todo     `json:"after,omitempty" xml:"after,omitempty"`
+	}
+)
+
+// recordChange queues a history entry for the todo. before is nil for a
+// create and after is nil for a delete.
+func recordChange(kind string, before, after *todoModel) {
+	now := time.Now()
+	e := auditEntry{ID: primitive.NewObjectID(), Type: kind, Before: before, After: after, At: now, ExpireAt: now.Add(auditRetention)}
+	t := after
+	if t == nil {
+		t = before
+	}
+	e.TodoID = t.ID
+	// A demo session's history goes with the rest of its data.
+	if e.SessionID = t.SessionID; t.SessionExpireAt != nil && t.SessionExpireAt.Before(e.ExpireAt) {
+		e.ExpireAt = *t.SessionExpireAt
+	}
+	select {
+	case auditQueue <- e:
+	default:
+		auditDropped.Inc()
+	}
+}
+
+// runAuditLog writes queued history entries until ctx is cancelled, then
+// flushes whatever is still queued.
+func runAuditLog(ctx context.Context) {
+	for {
+		select {
+		case e := <-auditQueue:
+			writeAuditEntry(ctx, e)
+		case <-ctx.Done():
+			flush, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			for {
+				select {
+				case e := <-auditQueue:
+					writeAuditEntry(flush, e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func writeAuditEntry(parent context.Context, e auditEntry) {
+	ctx, cancel := context.WithTimeout(parent, 5*time.Second)
+	defer cancel()
+	if _, err := db.Collection(auditCollection).InsertOne(ctx, e); err != nil {
+		log.Printf("audit: recording %s of %s: %v", e.Type, e.TodoID.Hex(), err)
+	}
+}
+
+// updateTodoAudited applies update to the todo matching filter within the
+// caller's demo session, records the change and returns the updated
+// document, or mongo.ErrNoDocuments when nothing matched. The update
+// returns the document it wrote, so the recorded after is exactly this
+// update's result. The before is read just ahead of it, and the update is
+// limited to that todo.
+func updateTodoAudited(ctx context.Context, filter bson.M, update interface{}) (todoModel, error) {
+	collection := db.Collection(collectionName)
+	filter = scoped(ctx, filter)
+
+	var before todoModel
+	if err := collection.FindOne(ctx, filter).Decode(&before); err != nil {
+		return todoModel{}, err
+	}
+	var after todoModel
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := collection.FindOneAndUpdate(ctx, bson.M{"$and": bson.A{filter, bson.M{"_id": before.ID}}}, update, opts).Decode(&after)
+	if err != nil {
+		return todoModel{}, err
+	}
+	todosChanged()
+	recordChange(auditUpdate, &before, &after)
+	return after, nil
+}
+
+// updateManyAudited applies update to every todo matching filter within
+// the caller's demo session, records a change for each one it modified
+// and returns how many that was. The matching todos are read first and the
+// update is limited to them, so one that starts matching in between is
+// left alone; their states after the update are read back once it is done.
+func updateManyAudited(ctx context.Context, filter bson.M, update interface{}) (int64, error) {
+	collection := db.Collection(collectionName)
+	filter = scoped(ctx, filter)
+
+	cur, err := collection.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	var before []todoModel
+	if err := cur.All(ctx, &before); err != nil {
+		return 0, err
+	}
+	if len(before) == 0 {
+		return 0, nil
+	}
+	ids := make([]primitive.ObjectID, len(before))
+	for i, t := range before {
+		ids[i] = t.ID
+	}
+	matched := bson.M{"_id": bson.M{"$in": ids}}
+
+	res, err := collection.UpdateMany(ctx, bson.M{"$and": bson.A{filter, matched}}, update)
+	todosChanged()
+	if err != nil {
+		return 0, err
+	}
+
+	cur, err = collection.Find(ctx, matched)
+	if err != nil {
+		return res.ModifiedCount, err
+	}
+	var after []todoModel
+	if err := cur.All(ctx, &after); err != nil {
+		return res.ModifiedCount, err
+	}
+	afterByID := make(map[primitive.ObjectID]*todoModel, len(after))
+	for i := range after {
+		afterByID[after[i].ID] = &after[i]
+	}
+	for i := range before {
+		if a, ok := afterByID[before[i].ID]; ok && !reflect.DeepEqual(&before[i], a) {
+			recordChange(auditUpdate, &before[i], a)
+		}
+	}
+	return res.ModifiedCount, nil
+}
+
+// todoHistory lists a todo's recorded changes, oldest first. It keeps
+// working after the todo is deleted, until the entries expire.
+func todoHistory(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePaging(r.URL.Query())
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid list options", "error": err.Error()})
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	opts := page.apply(options.Find().SetSort(bson.D{{Key: "at", Value: 1}, {Key: "_id", Value: 1}}))
+	cur, err := db.Collection(auditCollection).Find(ctx, scoped(ctx, bson.M{"todoId": todoID(r)}), opts)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch history", "error": err.Error()})
+		return
+	}
+	defer cur.Close(ctx)
+
+	var entries []auditEntry
+	if err := cur.All(ctx, &entries); err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch history", "error": err.Error()})
+		return
+	}
+
+	history := []historyEntry{}
+	for _, e := range entries {
+		h := historyEntry{Type: e.Type, At: e.At}
+		if e.Before != nil {
+			t := e.Before.toTodo()
+			h.Before = &t
+		}
+		if e.After != nil {
+			t := e.After.toTodo()
+			h.After = &t
+		}
+		history = append(history, h)
+	}
+	respond(w, r, http.StatusOK, renderer.M{"data": history, "paging": page.meta(w)})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// drainAudit empties auditQueue and returns what was in it.
+func drainAudit() []auditEntry {
+	var out []auditEntry
+	for {
+		select {
+		case e := <-auditQueue:
+			out = append(out, e)
+		default:
+			return out
+		}
+	}
+}
+
+// TestUpdateTodoAuditedRecordsWrittenDocument expects the history entry's
+// after to be the document the update returned, not a later read.
+func TestUpdateTodoAuditedRecordsWrittenDocument(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("update", func(mt *mtest.T) {
+		useMockDB(mt)
+		drainAudit()
+
+		id := primitive.NewObjectID()
+		ns := mt.DB.Name() + "." + collectionName
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: id}, {Key: "title", Value: "before"}}),
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{{Key: "_id", Value: id}, {Key: "title", Value: "after"}}}},
+		)
+
+		after, err := updateTodoAudited(context.Background(), bson.M{"_id": id}, bson.M{"$set": bson.M{"title": "after"}})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if after.Title != "after" {
+			mt.Errorf("returned title %q; want after", after.Title)
+		}
+		entries := drainAudit()
+		if len(entries) != 1 || entries[0].Before.Title != "before" || entries[0].After.Title != "after" {
+			mt.Fatalf("history = %+v; want one update from before to after", entries)
+		}
+
+		started := mt.GetAllStartedEvents()
+		if len(started) != 2 || started[0].CommandName != "find" || started[1].CommandName != "findAndModify" {
+			mt.Errorf("commands = %d; want a find and a findAndModify", len(started))
+		}
+		if nw, _ := started[1].Command.Lookup("new").BooleanOK(); !nw {
+			mt.Error("findAndModify did not ask for the updated document")
+		}
+	})
+}
+
+// TestUpdateManyAuditedRecordsEachChange updates two matching todos, one of
+// which was already in the target state, and expects history for the
+// other only.
+func TestUpdateManyAuditedRecordsEachChange(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("update many", func(mt *mtest.T) {
+		useMockDB(mt)
+		drainAudit()
+
+		changed, same := primitive.NewObjectID(), primitive.NewObjectID()
+		ns := mt.DB.Name() + "." + collectionName
+		doc := func(id primitive.ObjectID, tags ...string) bson.D {
+			return bson.D{{Key: "_id", Value: id}, {Key: "title", Value: "t"}, {Key: "tags", Value: tags}}
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, doc(changed, "old"), doc(same, "new")),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, doc(changed, "new"), doc(same, "new")),
+		)
+
+		modified, err := updateManyAudited(context.Background(), bson.M{"tags": "old"}, bson.M{"$set": bson.M{"tags": bson.A{"new"}}})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if modified != 1 {
+			mt.Errorf("modified = %d; want 1", modified)
+		}
+		entries := drainAudit()
+		if len(entries) != 1 || entries[0].TodoID != changed || entries[0].After.Tags[0] != "new" {
+			mt.Fatalf("history = %+v; want one update of %s", entries, changed.Hex())
+		}
+	})
+}
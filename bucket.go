@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Buckets of the inbox workflow. New todos land in the inbox until they
+// are triaged into active or someday. Todos from before buckets existed
+// have none stored and count as inbox.
+const (
+	bucketInbox   = "inbox"
+	bucketActive  = "active"
+	bucketSomeday = "someday"
+)
+
+var buckets = map[string]bool{bucketInbox: true, bucketActive: true, bucketSomeday: true}
+
+// defaultBuckets is the view of GET /todos without ?bucket=.
+var defaultBuckets = []string{bucketInbox, bucketActive}
+
+// somedayDueWindow is how soon a due date must be to be warned about when
+// a todo is put off to someday.
+const somedayDueWindow = 7 * 24 * time.Hour
+
+func validateBucket(b string) renderer.M {
+	if !buckets[b] {
+		return renderer.M{"message": "Bucket must be inbox, active or someday", "field": "bucket"}
+	}
+	return nil
+}
+
+// parseBuckets reads ?bucket=, a comma separated list of buckets or "all".
+// Without it the someday backlog is left out.
+func parseBuckets(raw string) ([]string, error) {
+	switch raw {
+	case "":
+		return defaultBuckets, nil
+	case "all":
+		return nil, nil
+	}
+	var out []string
+	for _, b := range strings.Split(raw, ",") {
+		b = strings.TrimSpace(b)
+		if !buckets[b] {
+			return nil, fmt.Errorf("bucket must be inbox, active, someday or all")
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// bucketCond matches todos in any of bs. A missing bucket counts as inbox.
+func bucketCond(bs []string) bson.M {
+	in := bson.A{}
+	for _, b := range bs {
+		in = append(in, b)
+		if b == bucketInbox {
+			in = append(in, nil)
+		}
+	}
+	return bson.M{"bucket": bson.M{"$in": in}}
+}
+
+// triageTodo moves a todo to another bucket. The first move out of the
+// inbox records triagedAt. Putting off a todo due within a week is a
+// warning, or a 422 under strict handling.
+func triageTodo(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Bucket string `json:"bucket"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Bucket == "" {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Bucket field is required", "field": "bucket"})
+		return
+	}
+	if m := validateBucket(req.Bucket); m != nil {
+		respond(w, r, http.StatusBadRequest, m)
+		return
+	}
+
+	idFilter := scoped(r.Context(), bson.M{"_id": todoID(r)})
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	var current todoDue
+	opts := options.FindOne().SetProjection(projectionOf(current))
+	err := db.Collection(collectionName).FindOne(ctx, idFilter, opts).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Todo not found"})
+		return
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch todo", "error": err.Error()})
+		return
+	}
+
+	warnings, ok := checkWarnings(w, r, ctx, warningInput{ID: current.ID, DueDate: current.DueDate, Bucket: req.Bucket})
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	fw := newFieldWrites(now)
+	fw.set("bucket", "bucket", req.Bucket)
+	if req.Bucket != bucketInbox {
+		fw.expr("triagedAt", bson.M{"$ifNull": bson.A{"$triagedAt", now}})
+	}
+	t, err := updateTodoAudited(ctx, idFilter, fw.pipeline())
+	if err == mongo.ErrNoDocuments {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Todo not found"})
+		return
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to triage todo", "error": err.Error()})
+		return
+	}
+
+	respond(w, r, http.StatusOK, withWarnings(r, renderer.M{"message": "Successfully triaged TODO", "data": t.toTodo()}, warnings))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readCacheTTL is how long GET /tags and GET /todos/stats answers are
+// reused, configurable through READ_CACHE_TTL. 0 disables the cache.
+var readCacheTTL = 5 * time.Second
+
+// dataVersion is bumped by todosChanged after every write of todos, which
+// invalidates everything in readCache. Other instances' writes aren't seen,
+// so there the TTL is the bound on staleness.
+var dataVersion atomic.Uint64
+
+var readCache = &versionedCache{entries: map[string]cacheEntry{}}
+
+var readCacheHits = newCounter("read_cache_hits_total", "Tag and stats requests answered from the in-memory cache.")
+
+type (
+	versionedCache struct {
+		mu      sync.RWMutex
+		version uint64
+		entries map[string]cacheEntry
+	}
+	cacheEntry struct {
+		value   interface{}
+		expires time.Time
+	}
+)
+
+// get returns the value cached under key if it is younger than the TTL and
+// no write has happened since it was computed.
+func (c *versionedCache) get(key string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.version != dataVersion.Load() {
+		return nil, false
+	}
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// put stores value as computed at version, the dataVersion read before the
+// query started. A value from before the latest write is dropped; one from
+// a newer version clears everything older.
+func (c *versionedCache) put(key string, version uint64, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version < c.version || version != dataVersion.Load() {
+		return
+	}
+	if version > c.version {
+		c.version = version
+		c.entries = map[string]cacheEntry{}
+	}
+	c.entries[key] = cacheEntry{value: value, expires: time.Now().Add(readCacheTTL)}
+}
+
+// cachedRead answers from readCache or runs fn and caches its result. Reads
+// carrying a consistency token skip the cache, as they must observe a
+// particular write. Cached values are shared and must not be modified.
+func cachedRead(r *http.Request, key string, fn func() (interface{}, error)) (interface{}, error) {
+	if readCacheTTL <= 0 || r.Header.Get(consistencyHeader) != "" {
+		return fn()
+	}
+	if s := demoSessionOf(r.Context()).ID; s != "" {
+		key += "@" + s
+	}
+	if v, ok := readCache.get(key); ok {
+		readCacheHits.Inc()
+		return v, nil
+	}
+	version := dataVersion.Load()
+	v, err := fn()
+	if err == nil {
+		readCache.put(key, version, v)
+	}
+	return v, err
+}
+
+// todosChanged bumps dataVersion, so no read after a write is served from
+// the cache. Every path writing todos calls it once its write is done,
+// whether it runs for a request or in the background, such as import jobs,
+// the resurfacer and link previews.
+func todosChanged() {
+	dataVersion.Add(1)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVersionedCacheInvalidatedByWrite(t *testing.T) {
+	c := &versionedCache{entries: map[string]cacheEntry{}}
+	c.put("tags", dataVersion.Load(), "before")
+	if v, ok := c.get("tags"); !ok || v != "before" {
+		t.Fatalf("get = %v, %v; want before, true", v, ok)
+	}
+	todosChanged()
+	if v, ok := c.get("tags"); ok {
+		t.Fatalf("get after a write = %v; want a miss", v)
+	}
+}
+
+func TestVersionedCacheDropsStalePut(t *testing.T) {
+	c := &versionedCache{entries: map[string]cacheEntry{}}
+	version := dataVersion.Load()
+	// A write lands while the query is running.
+	todosChanged()
+	c.put("tags", version, "stale")
+	if v, ok := c.get("tags"); ok {
+		t.Fatalf("get = %v; want the stale value dropped", v)
+	}
+}
+
+func TestVersionedCacheExpires(t *testing.T) {
+	defer func(ttl time.Duration) { readCacheTTL = ttl }(readCacheTTL)
+	readCacheTTL = time.Millisecond
+	c := &versionedCache{entries: map[string]cacheEntry{}}
+	c.put("tags", dataVersion.Load(), "old")
+	time.Sleep(5 * time.Millisecond)
+	if v, ok := c.get("tags"); ok {
+		t.Fatalf("get = %v; want the expired value gone", v)
+	}
+}
+
+func TestCachedReadSeesWrites(t *testing.T) {
+	defer func(ttl time.Duration) { readCacheTTL = ttl }(readCacheTTL)
+	readCacheTTL = time.Minute
+	r := httptest.NewRequest("GET", "/tags", nil)
+	calls := 0
+	read := func() interface{} {
+		v, err := cachedRead(r, "test-cached-read", func() (interface{}, error) {
+			calls++
+			return calls, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	if v := read(); v != 1 {
+		t.Fatalf("first read = %v; want 1", v)
+	}
+	if v := read(); v != 1 {
+		t.Fatalf("second read = %v; want the cached 1", v)
+	}
+	todosChanged()
+	if v := read(); v != 2 {
+		t.Fatalf("read after a write = %v; want 2", v)
+	}
+}
+
+// TestVersionedCacheConcurrent is for go test -race.
+func TestVersionedCacheConcurrent(t *testing.T) {
+	c := &versionedCache{entries: map[string]cacheEntry{}}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				switch j % 3 {
+				case 0:
+					c.put("k", dataVersion.Load(), j)
+				case 1:
+					c.get("k")
+				default:
+					if i == 0 {
+						todosChanged()
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Once the writes are over, a fresh put is served again.
+	c.put("k", dataVersion.Load(), "last")
+	if v, ok := c.get("k"); !ok || v != "last" {
+		t.Fatalf("get = %v, %v; want last, true", v, ok)
+	}
+}
+
+// TestCachedReadConcurrent hammers cachedRead over a few keys from many
+// goroutines, some of them writing, for go test -race. A read after a
+// goroutine's own write must never be answered from before it.
+func TestCachedReadConcurrent(t *testing.T) {
+	defer func(ttl time.Duration) { readCacheTTL = ttl }(readCacheTTL)
+	readCacheTTL = time.Minute
+	r := httptest.NewRequest("GET", "/todos/stats", nil)
+	keys := []string{"race-tags", "race-stats:7", "race-stats:30"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 300; j++ {
+				key := keys[(i+j)%len(keys)]
+				var after uint64
+				if i%4 == 0 && j%10 == 0 {
+					todosChanged()
+					after = dataVersion.Load()
+				}
+				v, err := cachedRead(r, key, func() (interface{}, error) {
+					return dataVersion.Load(), nil
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got := v.(uint64); got < after {
+					t.Errorf("%s read after a write at version %d was computed at %d", key, after, got)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+)
+
+// apiVersion is bumped on breaking changes to the API.
+const apiVersion = "1"
+
+// capabilityRoutes name the features that exist when a route does. The
+// capabilities document checks the router rather than trusting a list.
+var capabilityRoutes = map[string]string{
+	"sync":          "GET /todos/changes",
+	"export_jobs":   "POST /todos/export-jobs",
+	"import_jobs":   "POST /todos/import-jobs",
+	"csv_import":    "POST /todos/import.csv",
+	"batch_patch":   "PATCH /todos/batch",
+	"templates":     "POST /templates/",
+	"history":       "GET /todos/{id}/history",
+	"rss":           "GET /todos/feed.xml",
+	"validate":      "POST /todos/validate",
+	"admin":         "PUT /admin/maintenance",
+	"server_events": "GET /events",
+	"websocket":     "GET /ws",
+	"webhooks":      "POST /webhooks",
+}
+
+// capabilitiesHandler serves GET /capabilities: the features and limits of
+// this deployment, read from its configuration, its feature flags and the
+// routes registered on router, so clients don't have to probe. The
+// document carries an ETag, as it only changes with the configuration or
+// runtime switches such as maintenance mode.
+func capabilitiesHandler(router chi.Routes, limiter *rateLimiter) http.HandlerFunc {
+	var (
+		once   sync.Once
+		routes map[string]bool
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The routes are walked on first use, once all are registered.
+		once.Do(func() {
+			routes = map[string]bool{}
+			chi.Walk(router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+				routes[method+" "+route] = true
+				return nil
+			})
+		})
+
+		doc := capabilities(routes, limiter)
+		b, err := json.Marshal(doc)
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to build capabilities", "error": err.Error()})
+			return
+		}
+		sum := sha256.Sum256(b)
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		respond(w, r, http.StatusOK, renderer.M{"data": doc})
+	}
+}
+
+func capabilities(routes map[string]bool, limiter *rateLimiter) renderer.M {
+	features := renderer.M{}
+	for name, route := range capabilityRoutes {
+		features[name] = routes[route]
+	}
+	for _, f := range featureFlags {
+		for _, name := range f.features {
+			on, _ := features[name].(bool)
+			features[name] = on && f.on()
+		}
+	}
+
+	searchModes := []string{searchModeRegex, searchModeWord}
+	if textIndexReady.Load() {
+		searchModes = append([]string{searchModeText}, searchModes...)
+	}
+	features["search"] = renderer.M{"enabled": routes["GET /todos/"], "modes": searchModes}
+
+	authMode := "none"
+	if demoMode {
+		authMode = "demo_session"
+	}
+
+	var rateLimit interface{}
+	if limiter != nil {
+		rateLimit = renderer.M{"limit": limiter.limit, "window_seconds": limiter.window.Seconds()}
+	}
+
+	fields := make([]string, len(todoSchema))
+	for i, f := range todoSchema {
+		fields[i] = jsonFieldName(f.Name)
+	}
+
+	return renderer.M{
+		"api_version": apiVersion,
+		"auth": renderer.M{
+			"mode":  authMode,
+			"admin": adminToken != "",
+		},
+		"writes_enabled": !maintenance.Load(),
+		"features":       features,
+		"flags":          flagStates(),
+		"formats": renderer.M{
+			"responses": []string{"application/json", "application/xml", jsonAPIMediaType, "application/x-ndjson"},
+			"exports":   []string{"json", "ndjson"},
+			"imports":   []string{"text/csv"},
+		},
+		"todo_fields": fields,
+		"limits": renderer.M{
+			"max_page_size":        maxPageSize,
+			"default_page_size":    defaultPageSize,
+			"max_body_bytes":       maxBodyBytes,
+			"batch_max_items":      maxBatchSize,
+			"import_max_rows":      importMaxRows,
+			"import_max_bytes":     importMaxBytes,
+			"import_job_max_bytes": importJobMaxBytes,
+			"max_title_length":     maxTitleLength,
+			"max_tags":             maxTags,
+			"max_tag_length":       maxTagLength,
+			"max_in_flight":        maxInFlight,
+		},
+		"rate_limit": rateLimit,
+	}
+}
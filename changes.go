@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const tombstoneCollection = "tombstones"
+
+var (
+	// tombstoneRetention is how long a deleted todo is remembered for
+	// sync, through TOMBSTONE_RETENTION. A client that last synced longer
+	// ago than this should sync from scratch.
+	tombstoneRetention = 30 * 24 * time.Hour
+	// changesOverlap widens every sync window backwards, so a write stamped
+	// just before a sync but committed just after it isn't missed.
+	changesOverlap = 5 * time.Second
+)
+
+// tombstone records a deleted todo. Its _id is the todo's.
+type tombstone struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id" xml:"id"`
+	DeletedAt time.Time          `bson:"deletedAt" json:"deleted_at" xml:"deleted_at"`
+	SessionID string             `bson:"sessionId,omitempty" json:"-" xml:"-"`
+	ExpireAt  time.Time          `bson:"expireAt" json:"-" xml:"-"`
+}
+
+// recordTombstone remembers that t was deleted, for GET /todos/changes.
+func recordTombstone(ctx context.Context, t todoModel) {
+	now := time.Now()
+	ts := tombstone{ID: t.ID, DeletedAt: now, SessionID: t.SessionID, ExpireAt: now.Add(tombstoneRetention)}
+	opts := options.Replace().SetUpsert(true)
+	if _, err := db.Collection(tombstoneCollection).ReplaceOne(ctx, bson.M{"_id": t.ID}, ts, opts); err != nil {
+		log.Printf("recording tombstone of %s: %v", t.ID.Hex(), err)
+	}
+}
+
+func ensureTombstoneIndexes(ctx context.Context) {
+	_, err := db.Collection(tombstoneCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "deletedAt", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expireAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to create tombstone indexes: %v", err)
+	}
+}
+
+// fetchChanges serves delta sync: the todos created or changed after
+// ?since=, an RFC 3339 time, and the IDs of those deleted since. Without
+// since every todo is returned. server_time is the since of the next sync.
+func fetchChanges(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid since", "field": "since", "error": "since must be an RFC 3339 time"})
+			return
+		}
+	}
+	tf, err := parseTimeFormat(r.URL.Query())
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid time format", "error": err.Error()})
+		return
+	}
+
+	// Taken before reading, so anything written during the reads is sent
+	// again next time rather than missed.
+	serverTime := time.Now()
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	filter := bson.M{}
+	deletedFilter := bson.M{}
+	if !since.IsZero() {
+		after := since.Add(-changesOverlap)
+		// Todos last written before updatedAt was recorded count as
+		// changed when they were created.
+		filter = bson.M{"$or": bson.A{
+			bson.M{"updatedAt": bson.M{"$gt": after}},
+			bson.M{"updatedAt": nil, "createAt": bson.M{"$gt": after}},
+		}}
+		deletedFilter = bson.M{"deletedAt": bson.M{"$gt": after}}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "updatedAt", Value: 1}, {Key: "_id", Value: 1}})
+	cur, err := db.Collection(collectionName).Find(ctx, scoped(ctx, filter), opts)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch changes", "error": err.Error()})
+		return
+	}
+	defer cur.Close(ctx)
+	todos, skipped, err := decodeTodos(ctx, cur)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch changes", "error": err.Error()})
+		return
+	}
+
+	deleted := []tombstone{}
+	if !since.IsZero() {
+		opts := options.Find().SetSort(bson.D{{Key: "deletedAt", Value: 1}})
+		cur, err := db.Collection(tombstoneCollection).Find(ctx, scoped(ctx, deletedFilter), opts)
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch changes", "error": err.Error()})
+			return
+		}
+		defer cur.Close(ctx)
+		if err := cur.All(ctx, &deleted); err != nil {
+			respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch changes", "error": err.Error()})
+			return
+		}
+	}
+
+	list := []todo{}
+	for _, t := range todos {
+		list = append(list, t.toTodo().withTimeFormat(tf))
+	}
+	res := renderer.M{"data": list, "deleted": deleted, "server_time": serverTime}
+	if skipped > 0 {
+		res["meta"] = renderer.M{"skipped": skipped}
+	}
+	respond(w, r, http.StatusOK, res)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	todoclient "github.com/Heismanish/todo/pkg/client"
+	"github.com/joho/godotenv"
+)
+
+// Exit codes used by the CLI subcommands.
+const (
+	exitOK         = 0
+	exitError      = 1
+	exitUsage      = 2
+	exitNotFound   = 3
+	exitValidation = 4
+	exitProblems   = 5
+)
+
+const defaultServerURL = "http://localhost" + port
+
+func runCLI(cmd string, args []string) int {
+	c, err := newCLIClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	switch cmd {
+	case "list":
+		err = cliList(ctx, c)
+	case "add":
+		err = cliAdd(ctx, c, args)
+	case "done":
+		err = cliDone(ctx, c, args)
+	case "rm":
+		err = cliRemove(ctx, c, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", cmd)
+		cliUsage()
+		return exitUsage
+	}
+	return cliExitCode(err)
+}
+
+func cliUsage() {
+	fmt.Fprintln(os.Stderr, `usage:
+  go-todo [serve]
+  go-todo list
+  go-todo add <title> [--due today|tomorrow|YYYY-MM-DD]
+  go-todo done <id>
+  go-todo rm <id>
+  go-todo fsck [--repair]
+  go-todo export-archive [-o file.tar.gz]
+  go-todo import-archive [--force] <file.tar.gz>
+  go-todo anonymize-dump [-o file.tar.gz] [-seed s] [-jitter 72h]
+
+The server URL and token are read from TODO_SERVER_URL and TODO_TOKEN,
+falling back to ~/.config/go-todo/config. fsck and the archive commands
+connect to Mongo directly with the server's configuration.`)
+}
+
+// newCLIClient builds a client from the environment, falling back to the
+// KEY=value config file for anything the environment does not set.
+func newCLIClient() (*todoclient.Client, error) {
+	conf := map[string]string{}
+	if home, err := os.UserHomeDir(); err == nil {
+		path := filepath.Join(home, ".config", "go-todo", "config")
+		if _, err := os.Stat(path); err == nil {
+			if conf, err = godotenv.Read(path); err != nil {
+				return nil, fmt.Errorf("reading %s: %w", path, err)
+			}
+		}
+	}
+
+	lookup := func(key, def string) string {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+		if v := conf[key]; v != "" {
+			return v
+		}
+		return def
+	}
+
+	return todoclient.New(lookup("TODO_SERVER_URL", defaultServerURL), lookup("TODO_TOKEN", ""), 10*time.Second), nil
+}
+
+func cliList(ctx context.Context, c *todoclient.Client) error {
+	todos, err := c.List(ctx, todoclient.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tDONE\tDUE\tTITLE")
+	for _, t := range todos {
+		done := " "
+		if t.Completed {
+			done = "x"
+		}
+		due := "-"
+		if t.DueDate != nil {
+			due = t.DueDate.Local().Format("2006-01-02")
+		}
+		id := t.ShortID
+		if id == "" {
+			id = t.ID
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", id, done, due, t.Title)
+	}
+	return tw.Flush()
+}
+
+func cliAdd(ctx context.Context, c *todoclient.Client, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	due := fs.String("due", "", "due date: today, tomorrow or YYYY-MM-DD")
+	rest, err := parseInterspersed(fs, args)
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	if len(rest) == 0 {
+		return usageError{"add needs a title"}
+	}
+
+	var dueDate *time.Time
+	if *due != "" {
+		d, err := parseDue(*due, time.Now())
+		if err != nil {
+			return usageError{err.Error()}
+		}
+		dueDate = &d
+	}
+
+	id, err := c.Create(ctx, todoclient.Todo{Title: strings.Join(rest, " "), DueDate: dueDate})
+	if err != nil {
+		return err
+	}
+	fmt.Println(id)
+	return nil
+}
+
+func cliDone(ctx context.Context, c *todoclient.Client, args []string) error {
+	if len(args) != 1 {
+		return usageError{"done needs exactly one id"}
+	}
+	t, err := c.Get(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	t.Completed = true
+	t.Status = "done"
+	return c.Update(ctx, *t)
+}
+
+func cliRemove(ctx context.Context, c *todoclient.Client, args []string) error {
+	if len(args) != 1 {
+		return usageError{"rm needs exactly one id"}
+	}
+	return c.Delete(ctx, args[0])
+}
+
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+func cliExitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	fmt.Fprintln(os.Stderr, "error:", err)
+
+	var usage usageError
+	switch {
+	case errors.Is(err, todoclient.ErrNotFound):
+		return exitNotFound
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.Is(err, todoclient.ErrValidation):
+		return exitValidation
+	}
+	return exitError
+}
+
+// parseInterspersed lets flags appear after positional arguments, e.g.
+// `add "Buy milk" --due tomorrow`, which the flag package alone rejects.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return rest, nil
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func parseDue(s string, now time.Time) (time.Time, error) {
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+
+	switch strings.ToLower(s) {
+	case "today":
+		return today, nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, now.Location()); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid due date %q", s)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// listFlights coalesces identical list queries that are in flight at the
+// same time into one database round trip.
+var listFlights singleflight.Group
+
+var listCoalesced = newCounter("list_queries_coalesced_total", "List requests answered by another request's identical in-flight query.")
+
+type listResult struct {
+	Todos      []todoModel
+	SearchMode string
+	// Skipped counts the documents that failed to decode and were left
+	// out of Todos.
+	Skipped int
+	// Counts is nil when LIST_COUNTS is off. It is shared between callers.
+	Counts *listCounts
+	// EstimateMinutes totals the estimates of a ?view=today list, and is
+	// nil for other lists.
+	EstimateMinutes *int64
+}
+
+// coalescedList runs fn, or waits for an identical query already running
+// for the same caller and parameters. fn runs detached from any single
+// request, so a waiter hanging up never cancels it for the others. Every
+// caller gets its own copy of the slice; the documents in it are shared and
+// must not be modified.
+//
+// Reads carrying a consistency token run on their own: they must observe a
+// particular write, which a query started earlier might not.
+func coalescedList(r *http.Request, q url.Values, fn func() (listResult, error)) (listResult, error) {
+	if r.Header.Get(consistencyHeader) != "" {
+		return fn()
+	}
+	key, _ := settingsKey(r)
+	key += "\x00" + demoSessionOf(r.Context()).ID + "\x00" + q.Encode()
+
+	ran := false
+	ch := listFlights.DoChan(key, func() (interface{}, error) {
+		ran = true
+		return fn()
+	})
+	select {
+	case <-r.Context().Done():
+		return listResult{}, r.Context().Err()
+	case res := <-ch:
+		if !ran {
+			listCoalesced.Inc()
+		}
+		if res.Err != nil {
+			return listResult{}, res.Err
+		}
+		out := res.Val.(listResult)
+		out.Todos = slices.Clone(out.Todos)
+		return out, nil
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkListCoalescing runs identical list queries from many goroutines
+// at once, each taking a millisecond like a database round trip, and
+// reports how many queries reached the database per request.
+func BenchmarkListCoalescing(b *testing.B) {
+	q := url.Values{"completed": {"false"}, "limit": {"20"}}
+	r := httptest.NewRequest(http.MethodGet, "/todos?"+q.Encode(), nil)
+	todos := make([]todoModel, 20)
+
+	for _, bc := range []struct {
+		name string
+		list func(fn func() (listResult, error)) (listResult, error)
+	}{
+		{"direct", func(fn func() (listResult, error)) (listResult, error) { return fn() }},
+		{"coalesced", func(fn func() (listResult, error)) (listResult, error) { return coalescedList(r, q, fn) }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var queries atomic.Int64
+			query := func() (listResult, error) {
+				queries.Add(1)
+				time.Sleep(time.Millisecond)
+				return listResult{Todos: todos}, nil
+			}
+			b.SetParallelism(64)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := bc.list(query); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(queries.Load())/float64(b.N), "queries/op")
+		})
+	}
+}
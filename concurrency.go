@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// maxInFlight caps the requests handled at once, through MAX_IN_FLIGHT.
+// Zero means no cap.
+var maxInFlight = 0
+
+var (
+	inFlight     atomic.Int64
+	requestsShed = newCounter("http_requests_shed_total", "Requests refused with 503 because MAX_IN_FLIGHT requests were already in flight.")
+)
+
+var _ = newGaugeFunc("http_requests_in_flight", "Requests being handled right now.", func() float64 {
+	return float64(inFlight.Load())
+})
+
+// limitConcurrency counts the requests in flight and, with a limit, turns
+// away those past it with 503 straight away rather than queueing them, so
+// a spike can't pile work onto the database. Health checks and metrics
+// are always served so an overloaded server can still be seen.
+func limitConcurrency(limit int) func(http.Handler) http.Handler {
+	var slots chan struct{}
+	if limit > 0 {
+		slots = make(chan struct{}, limit)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slots != nil && !exemptFromConcurrencyLimit(r) {
+				select {
+				case slots <- struct{}{}:
+					defer func() { <-slots }()
+				default:
+					requestsShed.Inc()
+					w.Header().Set("Retry-After", "1")
+					respond(w, r, http.StatusServiceUnavailable, renderer.M{
+						"message": "The server is busy, try again shortly",
+						"code":    "overloaded",
+					})
+					return
+				}
+			}
+			inFlight.Add(1)
+			defer inFlight.Add(-1)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func exemptFromConcurrencyLimit(r *http.Request) bool {
+	return r.URL.Path == "/healthz" || r.URL.Path == "/metrics" || strings.HasPrefix(r.URL.Path, "/static/")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envString returns the value of name or def when it is unset.
+func envString(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt parses name as an integer. A malformed value is a startup error.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		log.Fatalf("%s must be an integer: %v", name, err)
+	}
+	return n
+}
+
+// envBool parses name as a boolean. A malformed value is a startup error.
+func envBool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		log.Fatalf("%s must be true or false: %v", name, err)
+	}
+	return b
+}
+
+// envDuration parses name with time.ParseDuration. A malformed value is a
+// startup error.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil {
+		log.Fatalf("%s must be a duration like 30s: %v", name, err)
+	}
+	return d
+}
+
+// envList splits a comma separated variable, dropping empty entries.
+func envList(name string) []string {
+	var out []string
+	for _, part := range strings.Split(os.Getenv(name), ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
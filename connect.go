@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// startupDBTimeout is how long the server keeps trying to reach Mongo at
+// startup, configurable through STARTUP_DB_TIMEOUT. When both start
+// together, as under docker-compose, Mongo is often not listening yet.
+var startupDBTimeout = 30 * time.Second
+
+const (
+	connectBackoffMin  = 500 * time.Millisecond
+	connectBackoffMax  = 5 * time.Second
+	connectPingTimeout = 5 * time.Second
+)
+
+// connectMongo connects and pings until Mongo answers, backing off between
+// attempts, and gives up once startupDBTimeout has passed.
+func connectMongo(opts *options.ClientOptions) (*mongo.Client, error) {
+	deadline := time.Now().Add(startupDBTimeout)
+	backoff := connectBackoffMin
+	for attempt := 1; ; attempt++ {
+		c, err := tryConnect(opts)
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Connected to Mongo on attempt %d", attempt)
+			}
+			return c, nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, fmt.Errorf("giving up on Mongo after %d attempts over %s: %w", attempt, startupDBTimeout, err)
+		}
+		log.Printf("Connecting to Mongo, attempt %d failed, retrying in %s: %v", attempt, backoff, err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, connectBackoffMax)
+	}
+}
+
+func tryConnect(opts *options.ClientOptions) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectPingTimeout)
+	defer cancel()
+
+	c, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Ping(ctx, nil); err != nil {
+		c.Disconnect(context.Background())
+		return nil, err
+	}
+	return c, nil
+}
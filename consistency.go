@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// consistencyHeader carries a read-your-writes token. Writes return it;
+// reads that send it back are guaranteed to observe at least that write.
+const consistencyHeader = "X-Consistency-Token"
+
+// maxConsistencyToken bounds the header we are willing to decode.
+const maxConsistencyToken = 64
+
+// causalConsistency runs each request's database work in a causally
+// consistent session. It is attached through the request context, which
+// dbContext preserves, so handlers need no changes. Reads without a token
+// skip the session entirely and behave exactly as before.
+func causalConsistency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		write := r.Method != http.MethodGet && r.Method != http.MethodHead
+		raw := r.Header.Get(consistencyHeader)
+		if !write && raw == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var after *primitive.Timestamp
+		if raw != "" {
+			ts, err := decodeConsistencyToken(raw)
+			if err != nil {
+				log.Printf("ignoring consistency token: %v", err)
+				w.Header().Add("Warning", `199 - "invalid consistency token ignored"`)
+				if !write {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			after = ts
+		}
+
+		sess, err := client.StartSession()
+		if err != nil {
+			log.Printf("starting session: %v", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer sess.EndSession(r.Context())
+
+		if after != nil {
+			if err := sess.AdvanceOperationTime(after); err != nil {
+				w.Header().Add("Warning", `199 - "invalid consistency token ignored"`)
+			}
+		}
+
+		ctx := mongo.NewSessionContext(r.Context(), sess)
+		if write {
+			w = &consistencyWriter{ResponseWriter: w, sess: sess}
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// consistencyWriter adds the session's operation time as a token just
+// before the response headers go out, which is after the handler's write.
+type consistencyWriter struct {
+	http.ResponseWriter
+	sess    mongo.Session
+	stamped bool
+}
+
+func (cw *consistencyWriter) stamp() {
+	if cw.stamped {
+		return
+	}
+	cw.stamped = true
+	if ts := cw.sess.OperationTime(); ts != nil {
+		cw.Header().Set(consistencyHeader, encodeConsistencyToken(ts))
+	}
+}
+
+func (cw *consistencyWriter) WriteHeader(code int) {
+	cw.stamp()
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *consistencyWriter) Write(b []byte) (int, error) {
+	cw.stamp()
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *consistencyWriter) Flush() {
+	cw.stamp()
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func encodeConsistencyToken(ts *primitive.Timestamp) string {
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:4], ts.T)
+	binary.BigEndian.PutUint32(b[4:], ts.I)
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+func decodeConsistencyToken(s string) (*primitive.Timestamp, error) {
+	if len(s) > maxConsistencyToken {
+		return nil, errors.New("token too long")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) != 8 {
+		return nil, errors.New("malformed token")
+	}
+	ts := &primitive.Timestamp{T: binary.BigEndian.Uint32(b[:4]), I: binary.BigEndian.Uint32(b[4:])}
+	if ts.T == 0 {
+		return nil, errors.New("malformed token")
+	}
+	return ts, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type corsConfig struct {
+	AllowedOrigins   []string
+	AllowCredentials bool
+	MaxAge           int
+}
+
+// loadCORSConfig reads the CORS settings. CORS is disabled when
+// CORS_ALLOWED_ORIGINS is empty.
+func loadCORSConfig() corsConfig {
+	c := corsConfig{
+		AllowedOrigins:   envList("CORS_ALLOWED_ORIGINS"),
+		AllowCredentials: envBool("CORS_ALLOW_CREDENTIALS", false),
+		MaxAge:           envInt("CORS_MAX_AGE", 600),
+	}
+	if err := c.validate(); err != nil {
+		log.Fatal(err)
+	}
+	return c
+}
+
+// validate rejects settings browsers would refuse. They never accept a
+// credentialed response with a wildcard origin, so an explicit allowlist is
+// insisted on instead of failing at request time.
+func (c corsConfig) validate() error {
+	if c.AllowCredentials && c.allowsAny() {
+		return errors.New("CORS_ALLOW_CREDENTIALS cannot be combined with a wildcard CORS_ALLOWED_ORIGINS")
+	}
+	return nil
+}
+
+func (c corsConfig) allowsAny() bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (c corsConfig) allows(origin string) bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func corsMiddleware(c corsConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(c.AllowedOrigins) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !c.allows(origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if c.allowsAny() {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if c.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			h.Set("Access-Control-Expose-Headers", consistencyHeader+", X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After, Location")
+
+			if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+				h.Set("Access-Control-Allow-Headers", reqHeaders)
+			}
+			if c.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// listCountsEnabled turns the counts in GET /todos meta on or off, through
+// LIST_COUNTS.
+var listCountsEnabled = true
+
+// listCounts are the numbers a list header shows, such as "5 open, 2 due
+// today, 1 overdue". They cover the list's filter less its paging and its
+// completed and status conditions, so every status segment can show its
+// own number whichever one is selected.
+type listCounts struct {
+	Open       int `json:"open" xml:"open"`
+	Todo       int `json:"todo" xml:"todo"`
+	InProgress int `json:"in_progress" xml:"in_progress"`
+	Blocked    int `json:"blocked" xml:"blocked"`
+	Done       int `json:"done" xml:"done"`
+	// DueToday and Overdue count open todos due during today, in the
+	// caller's timezone, and due before it.
+	DueToday int `json:"due_today" xml:"due_today"`
+	Overdue  int `json:"overdue" xml:"overdue"`
+}
+
+// countTodos computes the counts of f in one $facet aggregation.
+func countTodos(ctx context.Context, collection *mongo.Collection, f todoFilter, loc *time.Location) (*listCounts, error) {
+	f.Completed = nil
+	f.Statuses = nil
+	f.After = nil
+
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	tomorrow := today.AddDate(0, 0, 1)
+
+	open := bson.M{"completed": false}
+	facet := bson.M{
+		"status": bson.A{bson.M{"$group": bson.M{
+			"_id": bson.M{"$cond": bson.A{"$completed", statusDone, bson.M{"$ifNull": bson.A{"$status", statusTodo}}}},
+			"n":   bson.M{"$sum": 1},
+		}}},
+		"dueToday": bson.A{
+			bson.M{"$match": bson.M{"$and": bson.A{open, bson.M{"dueDate": bson.M{"$gte": today, "$lt": tomorrow}}}}},
+			bson.M{"$count": "n"},
+		},
+		"overdue": bson.A{
+			bson.M{"$match": bson.M{"$and": bson.A{open, bson.M{"dueDate": bson.M{"$lt": today}}}}},
+			bson.M{"$count": "n"},
+		},
+	}
+
+	// $geoNear has to come first, and already restricts the todos to the
+	// query.
+	var pipeline mongo.Pipeline
+	if f.Near != nil {
+		pipeline = f.Near.pipeline(f.query(), listOptions{}, false)
+	} else {
+		pipeline = mongo.Pipeline{{{Key: "$match", Value: f.query()}}}
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$facet", Value: facet}})
+
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	type count struct {
+		ID string `bson:"_id"`
+		N  int    `bson:"n"`
+	}
+	var res []struct {
+		Status   []count `bson:"status"`
+		DueToday []count `bson:"dueToday"`
+		Overdue  []count `bson:"overdue"`
+	}
+	if err := cur.All(ctx, &res); err != nil {
+		return nil, err
+	}
+
+	c := &listCounts{}
+	if len(res) == 0 {
+		return c, nil
+	}
+	for _, s := range res[0].Status {
+		switch s.ID {
+		case statusTodo:
+			c.Todo = s.N
+		case statusInProgress:
+			c.InProgress = s.N
+		case statusBlocked:
+			c.Blocked = s.N
+		case statusDone:
+			c.Done = s.N
+		}
+		if s.ID != statusDone {
+			c.Open += s.N
+		}
+	}
+	if len(res[0].DueToday) > 0 {
+		c.DueToday = res[0].DueToday[0].N
+	}
+	if len(res[0].Overdue) > 0 {
+		c.Overdue = res[0].Overdue[0].N
+	}
+	return c, nil
+}
+
+// sumEstimates totals the estimates, in minutes, of every todo matching f
+// regardless of paging. Todos without an estimate count as zero.
+func sumEstimates(ctx context.Context, collection *mongo.Collection, f todoFilter) (int64, error) {
+	f.After = nil
+
+	var pipeline mongo.Pipeline
+	if f.Near != nil {
+		pipeline = f.Near.pipeline(f.query(), listOptions{}, false)
+	} else {
+		pipeline = mongo.Pipeline{{{Key: "$match", Value: f.query()}}}
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$group", Value: bson.M{"_id": nil, "minutes": bson.M{"$sum": "$estimate"}}}})
+
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	var res []struct {
+		Minutes int64 `bson:"minutes"`
+	}
+	if err := cur.All(ctx, &res); err != nil {
+		return 0, err
+	}
+	if len(res) == 0 {
+		return 0, nil
+	}
+	return res[0].Minutes, nil
+}
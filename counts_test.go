@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BenchmarkListCounts lists a page of 100k todos with and without the
+// $facet counts, to show what the counts add to a list request.
+func BenchmarkListCounts(b *testing.B) {
+	useTestDB(b)
+	ctx := context.Background()
+	collection := db.Collection(collectionName)
+
+	const n = 100_000
+	now := time.Now()
+	batch := make([]interface{}, 0, 5000)
+	for i := 0; i < n; i++ {
+		tm := todoModel{ID: primitive.NewObjectID(), Title: "todo " + strconv.Itoa(i), Completed: i%4 == 0, CreateAt: now}
+		if i%3 == 0 {
+			due := now.AddDate(0, 0, i%7-3)
+			tm.DueDate = &due
+		}
+		batch = append(batch, tm)
+		if len(batch) == cap(batch) {
+			if _, err := collection.InsertMany(ctx, batch); err != nil {
+				b.Fatal(err)
+			}
+			batch = batch[:0]
+		}
+	}
+
+	opts := listOptions{SortField: "createAt", paging: paging{Page: 1, Limit: 50}}
+	var f todoFilter
+	for _, withCounts := range []bool{false, true} {
+		name := "page"
+		if withCounts {
+			name = "page+counts"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				cur, err := findTodos(ctx, collection, f, opts, false)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, _, err := decodeTodos(ctx, cur); err != nil {
+					b.Fatal(err)
+				}
+				cur.Close(ctx)
+				if withCounts {
+					if _, err := countTodos(ctx, collection, f, time.UTC); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CSV import limits, configurable through IMPORT_MAX_ROWS and
+// IMPORT_MAX_BYTES.
+var (
+	importMaxRows        = 1000
+	importMaxBytes int64 = 5 << 20
+)
+
+type csvSkippedRow struct {
+	Row    int    `json:"row" xml:"row"`
+	Field  string `json:"field,omitempty" xml:"field,omitempty"`
+	Reason string `json:"reason" xml:"reason"`
+}
+
+// csvColumns maps the columns the import understands to their index in
+// the header. due_date is accepted as another name for due.
+type csvColumns struct {
+	title, completed, due int
+}
+
+func parseCSVHeader(header []string) (csvColumns, error) {
+	cols := csvColumns{title: -1, completed: -1, due: -1}
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+		case "title":
+			cols.title = i
+		case "completed":
+			cols.completed = i
+		case "due", "due_date":
+			cols.due = i
+		}
+	}
+	if cols.title < 0 {
+		return cols, fmt.Errorf("the header row has no title column")
+	}
+	return cols, nil
+}
+
+func (c csvColumns) get(record []string, i int) string {
+	if i < 0 || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// parseCSVDue reads a due date as RFC 3339 or a plain YYYY-MM-DD, which
+// means midnight UTC.
+func parseCSVDue(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("due must be RFC 3339 or YYYY-MM-DD")
+	}
+	return t, nil
+}
+
+// importTodosCSV creates todos from an uploaded CSV file, sent either as the
+// request body with Content-Type text/csv or as the "file" field of a
+// multipart form. The first row names the columns: title is required,
+// completed and due are optional and others are ignored. Invalid rows are
+// skipped and reported; the rest are inserted together.
+func importTodosCSV(w http.ResponseWriter, r *http.Request) {
+	body, ok := csvUpload(w, r, importMaxBytes)
+	if !ok {
+		return
+	}
+	defer body.Close()
+
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "CSV is empty", "code": codeEmptyBody})
+		return
+	}
+	if err != nil {
+		csvReadFailed(w, r, err, importMaxBytes)
+		return
+	}
+	cols, err := parseCSVHeader(header)
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid CSV header", "error": err.Error()})
+		return
+	}
+
+	var (
+		valid   []todo
+		rows    []int
+		skipped = []csvSkippedRow{}
+	)
+	for n := 0; ; n++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			csvReadFailed(w, r, err, importMaxBytes)
+			return
+		}
+		if n == importMaxRows {
+			respond(w, r, http.StatusBadRequest, renderer.M{
+				"message": fmt.Sprintf("CSV may contain at most %d rows", importMaxRows),
+			})
+			return
+		}
+		line, _ := cr.FieldPos(0)
+
+		t, m := csvTodo(cols, record)
+		if m != nil {
+			skipped = append(skipped, csvSkipped(line, m))
+			continue
+		}
+		valid = append(valid, t)
+		rows = append(rows, line)
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 30*time.Second)
+	defer cancel()
+
+	imported, failed, err := insertImported(ctx, valid, rows, nil)
+	if err == errDemoFull {
+		demoFull(w, r)
+		return
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to import todos", "error": err.Error()})
+		return
+	}
+	skipped = append(skipped, failed...)
+	respond(w, r, http.StatusOK, renderer.M{
+		"message":  fmt.Sprintf("Imported %d todos", imported),
+		"imported": imported,
+		"skipped":  skipped,
+	})
+}
+
+// csvUpload returns the uploaded CSV, capped at limit bytes. On failure it
+// writes the error response and returns false.
+func csvUpload(w http.ResponseWriter, r *http.Request, limit int64) (io.ReadCloser, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return r.Body, true
+	case "multipart/form-data":
+		f, _, err := r.FormFile("file")
+		if err == nil {
+			return f, true
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			csvReadFailed(w, r, err, limit)
+			return nil, false
+		}
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "The form has no file field", "error": err.Error()})
+		return nil, false
+	}
+	respond(w, r, http.StatusUnsupportedMediaType, renderer.M{
+		"message": "Content-Type must be text/csv or multipart/form-data",
+		"code":    "unsupported_media_type",
+	})
+	return nil, false
+}
+
+func csvReadFailed(w http.ResponseWriter, r *http.Request, err error, limit int64) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respond(w, r, http.StatusRequestEntityTooLarge, renderer.M{
+			"message": fmt.Sprintf("CSV may be at most %d bytes", limit),
+			"code":    codeBodyTooLarge,
+		})
+		return
+	}
+	respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid CSV", "error": err.Error()})
+}
+
+// csvSkipped reports the row on line as skipped for the validation error m.
+func csvSkipped(line int, m renderer.M) csvSkippedRow {
+	reason, _ := m["message"].(string)
+	if detail, ok := m["error"].(string); ok {
+		reason += ": " + detail
+	}
+	field, _ := m["field"].(string)
+	return csvSkippedRow{Row: line, Field: field, Reason: reason}
+}
+
+// csvTodo builds and validates the todo of one row.
+func csvTodo(cols csvColumns, record []string) (todo, renderer.M) {
+	t := todo{Title: cols.get(record, cols.title)}
+	if m := validateTodo(&t, nil); m != nil {
+		return t, m
+	}
+	if s := cols.get(record, cols.completed); s != "" {
+		done, err := strconv.ParseBool(s)
+		if err != nil {
+			return t, renderer.M{"message": "Invalid completed", "field": "completed", "error": "completed must be true or false"}
+		}
+		t.Completed = done
+	}
+	if s := cols.get(record, cols.due); s != "" {
+		due, err := parseCSVDue(s)
+		if err != nil {
+			return t, renderer.M{"message": "Invalid due date", "field": "due", "error": err.Error()}
+		}
+		t.DueDate = &due
+	}
+	return t, nil
+}
+
+// insertImported stores the todos with InsertMany, in file order at the
+// bottom of the list. Documents the database rejects are reported as
+// skipped, with their line in rows, and their short IDs released.
+//
+// ids, if given, are the _ids to store the todos under. A todo already
+// stored under its id, by an earlier run of the same import, counts as
+// imported, which makes running a batch again harmless.
+func insertImported(ctx context.Context, todos []todo, rows []int, ids []primitive.ObjectID) (int, []csvSkippedRow, error) {
+	if len(todos) == 0 {
+		return 0, nil, nil
+	}
+	if err := demoRoom(ctx, collectionName, len(todos)); err != nil {
+		return 0, nil, err
+	}
+	pos, err := edgePosition(ctx, false)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	now := time.Now()
+	session, expireAt := demoExpiry(ctx)
+	docs := make([]interface{}, len(todos))
+	models := make([]todoModel, len(todos))
+	for i, t := range todos {
+		tm := todoModel{
+			ID:        primitive.NewObjectID(),
+			Title:     t.Title,
+			Completed: t.Completed,
+			CreateAt:  now,
+			DueDate:   t.DueDate,
+			Bucket:    bucketInbox,
+			Position:  pos + float64(i),
+
+			StatusChangedAt: &now,
+			UpdatedAt:       &now,
+
+			SessionID:       session,
+			SessionExpireAt: expireAt,
+		}
+		if ids != nil {
+			tm.ID = ids[i]
+		}
+		if t.Completed {
+			tm.CompletedAt = &now
+		}
+		if tm.ShortID, err = reserveShortID(ctx); err != nil {
+			for _, m := range models[:i] {
+				releaseShortID(ctx, m.ShortID)
+			}
+			return 0, nil, err
+		}
+		models[i], docs[i] = tm, tm
+	}
+
+	failed := map[int]string{}
+	stored := map[int]bool{}
+	_, err = db.Collection(collectionName).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	todosChanged()
+	var bwe mongo.BulkWriteException
+	switch {
+	case errors.As(err, &bwe) && bwe.WriteConcernError == nil:
+		for _, we := range bwe.WriteErrors {
+			if ids != nil && we.Code == 11000 && strings.Contains(we.Message, "_id_") {
+				stored[we.Index] = true
+				continue
+			}
+			failed[we.Index] = we.Message
+		}
+	case err != nil:
+		return 0, nil, err
+	}
+
+	var skipped []csvSkippedRow
+	for i := range models {
+		if stored[i] {
+			releaseShortID(ctx, models[i].ShortID)
+			continue
+		}
+		if reason, ok := failed[i]; ok {
+			releaseShortID(ctx, models[i].ShortID)
+			skipped = append(skipped, csvSkippedRow{Row: rows[i], Reason: reason})
+			continue
+		}
+		recordChange(auditCreate, nil, &models[i])
+	}
+	return len(models) - len(failed), skipped, nil
+}
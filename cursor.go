@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// cursorSecret signs ?cursor= tokens, configurable through CURSOR_SECRET.
+// Without one a random key is made at startup, so tokens stop working when
+// the server restarts.
+var cursorSecret []byte
+
+// listCursor is the position after the last todo of a page: its sort value
+// and _id, under the sort the token was issued for.
+type listCursor struct {
+	SortField string
+	SortDesc  bool
+	Value     bson.RawValue
+	ID        primitive.ObjectID
+}
+
+// cursorToken is the signed part of a token. The sort value is stored as
+// Extended JSON so it keeps its BSON type.
+type cursorToken struct {
+	Sort  string          `json:"s"`
+	Desc  bool            `json:"d,omitempty"`
+	Value json.RawMessage `json:"v"`
+	ID    string          `json:"id"`
+}
+
+var errBadCursor = errors.New("cursor is invalid or has expired")
+
+func initCursorSecret(secret string) {
+	if secret != "" {
+		cursorSecret = []byte(secret)
+		return
+	}
+	cursorSecret = make([]byte, 32)
+	if _, err := rand.Read(cursorSecret); err != nil {
+		panic(err)
+	}
+}
+
+func signCursor(payload []byte) []byte {
+	mac := hmac.New(sha256.New, cursorSecret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// cursorAfter returns the cursor pointing past t under the sort of o.
+func cursorAfter(t todoModel, o listOptions) (string, error) {
+	raw, err := bson.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	// A missing field sorts like null, so it is encoded as one.
+	wrapped := bson.D{{Key: "v", Value: nil}}
+	if v, err := bson.Raw(raw).LookupErr(o.SortField); err == nil {
+		wrapped[0].Value = v
+	}
+	value, err := bson.MarshalExtJSON(wrapped, true, false)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(cursorToken{Sort: o.SortField, Desc: o.SortDesc, Value: value, ID: t.ID.Hex()})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(signCursor(payload)), nil
+}
+
+// parseCursor reads a token issued by cursorAfter. It fails for a token that
+// was tampered with, signed with another key or issued under a different
+// sort than o, since its position means nothing there.
+func parseCursor(token string, o listOptions) (*listCursor, error) {
+	enc := base64.RawURLEncoding
+	body, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errBadCursor
+	}
+	payload, err := enc.DecodeString(body)
+	if err != nil {
+		return nil, errBadCursor
+	}
+	mac, err := enc.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, signCursor(payload)) {
+		return nil, errBadCursor
+	}
+
+	var ct cursorToken
+	if err := json.Unmarshal(payload, &ct); err != nil {
+		return nil, errBadCursor
+	}
+	if ct.Sort != o.SortField || ct.Desc != o.SortDesc {
+		return nil, fmt.Errorf("cursor was issued for a different sort")
+	}
+	id, err := primitive.ObjectIDFromHex(ct.ID)
+	if err != nil {
+		return nil, errBadCursor
+	}
+	var wrapped bson.Raw
+	if err := bson.UnmarshalExtJSON(ct.Value, true, &wrapped); err != nil {
+		return nil, errBadCursor
+	}
+	v, err := wrapped.LookupErr("v")
+	if err != nil {
+		return nil, errBadCursor
+	}
+	return &listCursor{SortField: ct.Sort, SortDesc: ct.Desc, Value: v, ID: id}, nil
+}
+
+// cond matches the todos after the cursor under its sort, with _id breaking
+// ties. Nulls and missing values sort first, and comparison operators never
+// match them, so they get branches of their own.
+func (c listCursor) cond() bson.M {
+	field := c.SortField
+	cmp, idCmp := "$gt", "$gt"
+	if c.SortDesc {
+		cmp, idCmp = "$lt", "$lt"
+	}
+	tie := bson.M{"_id": bson.M{idCmp: c.ID}}
+
+	if c.Value.Type == bson.TypeNull {
+		sameNull := bson.M{"$and": bson.A{bson.M{field: nil}, tie}}
+		if c.SortDesc {
+			return sameNull
+		}
+		return bson.M{"$or": bson.A{sameNull, bson.M{field: bson.M{"$ne": nil}}}}
+	}
+
+	branches := bson.A{
+		bson.M{field: bson.M{cmp: c.Value}},
+		bson.M{"$and": bson.A{bson.M{field: c.Value}, tie}},
+	}
+	if c.SortDesc {
+		branches = append(branches, bson.M{field: nil})
+	}
+	return bson.M{"$or": branches}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const base64URLAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+func testCursor(t *testing.T) (string, todoModel) {
+	t.Helper()
+	initCursorSecret("test secret")
+	tm := todoModel{ID: primitive.NewObjectID(), Title: "write tests", CreateAt: time.Date(2024, 3, 24, 18, 25, 59, 0, time.UTC)}
+	token, err := cursorAfter(tm, listOptions{SortField: "createAt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token, tm
+}
+
+func TestParseCursorRoundTrip(t *testing.T) {
+	token, tm := testCursor(t)
+	c, err := parseCursor(token, listOptions{SortField: "createAt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != tm.ID || !c.Value.Time().Equal(tm.CreateAt) {
+		t.Errorf("parseCursor = %s at %v; want %s at %v", c.ID.Hex(), c.Value.Time(), tm.ID.Hex(), tm.CreateAt)
+	}
+}
+
+// TestParseCursorTampered flips every character of a token, in both its
+// payload and its signature, and expects each to be refused.
+func TestParseCursorTampered(t *testing.T) {
+	token, _ := testCursor(t)
+	for i := range token {
+		if token[i] == '.' {
+			continue
+		}
+		// Flipping the top bit of the six keeps the character in the
+		// alphabet and changes the decoded bytes.
+		b := []byte(token)
+		b[i] = base64URLAlphabet[strings.IndexByte(base64URLAlphabet, b[i])^0x20]
+		if _, err := parseCursor(string(b), listOptions{SortField: "createAt"}); !errors.Is(err, errBadCursor) {
+			t.Errorf("token with byte %d flipped: err = %v; want errBadCursor", i, err)
+		}
+	}
+}
+
+func TestParseCursorRefused(t *testing.T) {
+	token, _ := testCursor(t)
+	body, sig, _ := strings.Cut(token, ".")
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"no signature", body},
+		{"empty signature", body + "."},
+		{"signature only", "." + sig},
+		{"not base64", "!!!." + sig},
+		{"truncated signature", body + "." + sig[:len(sig)-4]},
+	}
+	for _, tt := range tests {
+		if _, err := parseCursor(tt.token, listOptions{SortField: "createAt"}); !errors.Is(err, errBadCursor) {
+			t.Errorf("%s: err = %v; want errBadCursor", tt.name, err)
+		}
+	}
+
+	initCursorSecret("another secret")
+	if _, err := parseCursor(token, listOptions{SortField: "createAt"}); !errors.Is(err, errBadCursor) {
+		t.Errorf("token signed with another key: err = %v; want errBadCursor", err)
+	}
+}
+
+func TestParseCursorOtherSort(t *testing.T) {
+	token, _ := testCursor(t)
+	for _, o := range []listOptions{{SortField: "createAt", SortDesc: true}, {SortField: "dueDate"}} {
+		if _, err := parseCursor(token, o); err == nil {
+			t.Errorf("token for createAt accepted under %+v", o)
+		}
+	}
+}
+
+// A tampered ?cursor= fails parseListOptions, which getTodos answers with
+// 400.
+func TestParseListOptionsTamperedCursor(t *testing.T) {
+	token, _ := testCursor(t)
+	b := []byte(token)
+	b[0] = base64URLAlphabet[strings.IndexByte(base64URLAlphabet, b[0])^0x20]
+	if _, err := parseListOptions(url.Values{"cursor": {string(b)}}); err == nil {
+		t.Error("parseListOptions accepted a tampered cursor")
+	}
+	if _, err := parseListOptions(url.Values{"cursor": {token}}); err != nil {
+		t.Errorf("parseListOptions refused a valid cursor: %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// maxBodyBytes caps every JSON request body.
+var maxBodyBytes int64 = 1 << 20
+
+// Error codes reported by decodeJSON in the "code" field of the envelope.
+const (
+	codeEmptyBody    = "empty_body"
+	codeBodyTooLarge = "body_too_large"
+	codeInvalidJSON  = "invalid_json"
+	codeInvalidType  = "invalid_type"
+	codeUnknownField = "unknown_field"
+)
+
+// requireJSON rejects requests whose Content-Type is not application/json,
+// or application/vnd.api+json, with 415, before the handler tries to decode
+// the body.
+func requireJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" && mediaType != jsonAPIMediaType {
+			respond(w, r, http.StatusUnsupportedMediaType, renderer.M{
+				"message": "Content-Type must be application/json",
+				"code":    "unsupported_media_type",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeJSON decodes the request body into v. On failure it writes a 400
+// (or 413) describing exactly what was wrong and returns false, so callers
+// just return.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if isJSONAPIRequest(r) {
+		if status, m := jsonAPIBody(r); m != nil {
+			respond(w, r, status, m)
+			return false
+		}
+	}
+	if m := decodeJSONBody(r.Body, v); m != nil {
+		status := http.StatusBadRequest
+		if m["code"] == codeBodyTooLarge {
+			status = http.StatusRequestEntityTooLarge
+		}
+		respond(w, r, status, m)
+		return false
+	}
+	return true
+}
+
+// decodeJSONBody does the work for decodeJSON and returns the error envelope,
+// or nil on success. It never panics on any input.
+func decodeJSONBody(body io.Reader, v interface{}) renderer.M {
+	if body == nil {
+		return renderer.M{"message": "Request body is empty", "code": codeEmptyBody}
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxBodyBytes+1))
+	if err != nil {
+		return renderer.M{"message": "Failed to read request body", "code": codeInvalidJSON, "error": err.Error()}
+	}
+	if int64(len(data)) > maxBodyBytes {
+		return renderer.M{
+			"message": fmt.Sprintf("Request body exceeds %d bytes", maxBodyBytes),
+			"code":    codeBodyTooLarge,
+		}
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return renderer.M{"message": "Request body is empty", "code": codeEmptyBody}
+	}
+
+	data = snakeCasedBody(data)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(v)
+	if err == nil && dec.More() {
+		return renderer.M{
+			"message": "Request body must contain a single JSON value",
+			"code":    codeInvalidJSON,
+			"offset":  dec.InputOffset(),
+			"snippet": snippet(data, dec.InputOffset()),
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return renderer.M{
+			"message": fmt.Sprintf("Malformed JSON at byte %d", syntaxErr.Offset),
+			"code":    codeInvalidJSON,
+			"error":   syntaxErr.Error(),
+			"offset":  syntaxErr.Offset,
+			"snippet": snippet(data, syntaxErr.Offset),
+		}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return renderer.M{
+			"message": "Malformed JSON: unexpected end of body",
+			"code":    codeInvalidJSON,
+			"offset":  len(data),
+			"snippet": snippet(data, int64(len(data))),
+		}
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "(root)"
+		}
+		return renderer.M{
+			"message":  fmt.Sprintf("Field %s must be %s, got %s", field, typeErr.Type, typeErr.Value),
+			"code":     codeInvalidType,
+			"field":    field,
+			"expected": typeErr.Type.String(),
+			"offset":   typeErr.Offset,
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return renderer.M{
+			"message": fmt.Sprintf("Unknown field %s", field),
+			"code":    codeUnknownField,
+			"field":   field,
+		}
+	}
+	return renderer.M{"message": "Invalid request payload", "code": codeInvalidJSON, "error": err.Error()}
+}
+
+// snippet returns up to 20 bytes either side of offset for error messages.
+func snippet(data []byte, offset int64) string {
+	const radius = 20
+	start, end := offset-radius, offset+radius
+	if start < 0 {
+		start = 0
+	}
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	if start > end {
+		start = end
+	}
+	return strings.ToValidUTF8(string(data[start:end]), "?")
+}
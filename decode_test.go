@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSONBodyCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{"valid", `{"title": "write tests"}`, ""},
+		{"empty", "", codeEmptyBody},
+		{"blank", " \n\t", codeEmptyBody},
+		{"syntax", `{"title": }`, codeInvalidJSON},
+		{"truncated", `{"title": "write`, codeInvalidJSON},
+		{"trailing value", `{"title": "a"} {"title": "b"}`, codeInvalidJSON},
+		{"wrong type", `{"title": 1}`, codeInvalidType},
+		{"unknown field", `{"titel": "a"}`, codeUnknownField},
+		{"too large", `"` + strings.Repeat("a", int(maxBodyBytes)) + `"`, codeBodyTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v todo
+			m := decodeJSONBody(strings.NewReader(tt.body), &v)
+			if tt.code == "" {
+				if m != nil {
+					t.Fatalf("decodeJSONBody = %v; want success", m)
+				}
+				return
+			}
+			if m == nil || m["code"] != tt.code {
+				t.Fatalf("decodeJSONBody = %v; want code %s", m, tt.code)
+			}
+		})
+	}
+}
+
+// FuzzDecodeJSONBody checks that no body makes decodeJSONBody panic, and
+// that every failure carries one of the documented codes.
+func FuzzDecodeJSONBody(f *testing.F) {
+	for _, seed := range []string{
+		``,
+		`{}`,
+		`{"title": "a", "tags": ["x"], "due_date": "2024-03-24T00:00:00Z"}`,
+		`{"title": 1}`,
+		`{"nope": true}`,
+		`{"title": "a"} []`,
+		`{"metadata": {"k": {"deep": [1, 2]}}}`,
+		`[`,
+		"\xff\xfe",
+	} {
+		f.Add([]byte(seed))
+	}
+	codes := map[interface{}]bool{
+		codeEmptyBody:    true,
+		codeBodyTooLarge: true,
+		codeInvalidJSON:  true,
+		codeInvalidType:  true,
+		codeUnknownField: true,
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		var v todo
+		m := decodeJSONBody(bytes.NewReader(body), &v)
+		if m != nil && !codes[m["code"]] {
+			t.Fatalf("decodeJSONBody(%q) = %v; want a documented code", body, m)
+		}
+	})
+}
+
+// TestDecodeJSONBodyNaming expects the fields of a camelCase response to be
+// accepted back under JSON_NAMING=camel, and only then.
+func TestDecodeJSONBodyNaming(t *testing.T) {
+	defer func(n string) { jsonNaming = n }(jsonNaming)
+	body := `{"title": "write tests", "dueDate": "2024-05-01T09:30:00Z", "metadata": {"sourceId": "a1"}}`
+
+	jsonNaming = namingCamel
+	for _, b := range []string{body, `{"title": "write tests", "due_date": "2024-05-01T09:30:00Z", "metadata": {"sourceId": "a1"}}`} {
+		var v todo
+		if m := decodeJSONBody(strings.NewReader(b), &v); m != nil {
+			t.Fatalf("camel: decodeJSONBody(%s) = %v; want success", b, m)
+		}
+		if v.DueDate == nil || v.Metadata["sourceId"] != "a1" {
+			t.Errorf("camel: decoded due date %v, metadata %v; want both kept", v.DueDate, v.Metadata)
+		}
+	}
+	var v todo
+	if m := decodeJSONBody(strings.NewReader(`{"title": "a"} {}`), &v); m == nil || m["code"] != codeInvalidJSON {
+		t.Errorf("camel: trailing value = %v; want %s", m, codeInvalidJSON)
+	}
+
+	jsonNaming = namingSnake
+	if m := decodeJSONBody(strings.NewReader(body), &v); m == nil || m["code"] != codeUnknownField || m["field"] != "dueDate" {
+		t.Errorf("snake: decodeJSONBody = %v; want unknown field dueDate", m)
+	}
+}
+
+func TestCamelToSnake(t *testing.T) {
+	for in, want := range map[string]string{
+		"title":          "title",
+		"dueDate":        "due_date",
+		"fieldUpdatedAt": "field_updated_at",
+		"due_date":       "due_date",
+		"_meta":          "_meta",
+	} {
+		if got := camelToSnake(in); got != want {
+			t.Errorf("camelToSnake(%q) = %q; want %q", in, got, want)
+		}
+		if got := camelToSnake(snakeToCamel(want)); got != want {
+			t.Errorf("camelToSnake(snakeToCamel(%q)) = %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	dedupeCollection   = "dedupe_keys"
+	maxDedupeKeyLength = 200
+)
+
+// dedupeKeyTTL is how long a create's dedupe key keeps matching,
+// configurable through DEDUPE_KEY_TTL. Dedupe keys mean "created
+// recently", not "ever existed".
+var dedupeKeyTTL = 24 * time.Hour
+
+// errDedupeInFlight means another create with the same key has claimed it
+// but not inserted its todo yet.
+var errDedupeInFlight = errors.New("a todo with this dedupe key is still being created")
+
+type dedupeReservation struct {
+	Key      string             `bson:"_id"`
+	TodoID   primitive.ObjectID `bson:"todoId"`
+	ExpireAt time.Time          `bson:"expireAt"`
+}
+
+// dedupeKey reads the client's content key from If-None-Match. It
+// reports false after answering 400 for a header that carries no key.
+func dedupeKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	raw := strings.TrimSpace(r.Header.Get("If-None-Match"))
+	if raw == "" {
+		return "", true
+	}
+	key := strings.Trim(strings.TrimPrefix(raw, "W/"), `"`)
+	if key == "" || key == "*" || strings.Contains(key, ",") || len(key) > maxDedupeKeyLength {
+		respond(w, r, http.StatusBadRequest, renderer.M{
+			"message": "If-None-Match must carry a single dedupe key",
+			"field":   "If-None-Match",
+		})
+		return "", false
+	}
+	// Keys only deduplicate within a demo session.
+	if s := demoSessionOf(r.Context()).ID; s != "" {
+		key = s + ":" + key
+	}
+	return key, true
+}
+
+// claimDedupeKey reserves key for the todo about to be inserted as id. If
+// the key was claimed within dedupeKeyTTL, the todo it points to is
+// returned instead and nothing should be inserted. The unique _id of the
+// reservation is what makes concurrent creates with one key race-free.
+func claimDedupeKey(ctx context.Context, key string, id primitive.ObjectID) (*todoModel, error) {
+	collection := db.Collection(dedupeCollection)
+	_, err := collection.InsertOne(ctx, dedupeReservation{Key: key, TodoID: id, ExpireAt: time.Now().Add(dedupeKeyTTL)})
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+
+	var res dedupeReservation
+	if err := collection.FindOne(ctx, bson.M{"_id": key}).Decode(&res); err != nil {
+		return nil, err
+	}
+	// The TTL monitor only runs once a minute, so take over a reservation
+	// that expired but hasn't been removed yet.
+	if now := time.Now(); res.ExpireAt.Before(now) {
+		upd, err := collection.ReplaceOne(ctx,
+			bson.M{"_id": key, "todoId": res.TodoID},
+			dedupeReservation{Key: key, TodoID: id, ExpireAt: now.Add(dedupeKeyTTL)})
+		if err != nil {
+			return nil, err
+		}
+		if upd.ModifiedCount == 1 {
+			return nil, nil
+		}
+		return nil, errDedupeInFlight
+	}
+	var existing todoModel
+	err = db.Collection(collectionName).FindOne(ctx, bson.M{"_id": res.TodoID}).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return nil, errDedupeInFlight
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// lookupDedupeKey is claimDedupeKey without the claim, for dry runs: it
+// returns the todo a live reservation of key points to, or nil when a
+// create with key would go ahead.
+func lookupDedupeKey(ctx context.Context, key string) (*todoModel, error) {
+	var res dedupeReservation
+	err := db.Collection(dedupeCollection).FindOne(ctx, bson.M{"_id": key}).Decode(&res)
+	if err == mongo.ErrNoDocuments || (err == nil && res.ExpireAt.Before(time.Now())) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var existing todoModel
+	err = db.Collection(collectionName).FindOne(ctx, bson.M{"_id": res.TodoID}).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return nil, errDedupeInFlight
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// releaseDedupeKey drops key's reservation for id, after its create failed.
+func releaseDedupeKey(ctx context.Context, key string, id primitive.ObjectID) {
+	if _, err := db.Collection(dedupeCollection).DeleteOne(ctx, bson.M{"_id": key, "todoId": id}); err != nil {
+		log.Printf("releasing dedupe key: %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const demoCookie = "demo_session"
+
+// Demo mode, turned on with DEMO_MODE, serves a public playground: every
+// visitor gets a session of their own and only ever sees its todos and
+// templates, through the same handlers as usual.
+var (
+	demoMode bool
+	// demoMaxTodos caps the todos of one session, through DEMO_MAX_TODOS.
+	// Templates are capped the same.
+	demoMaxTodos = 50
+	// demoSessionTTL is how long an idle session's data is kept, through
+	// DEMO_SESSION_TTL.
+	demoSessionTTL = 24 * time.Hour
+	// demoSecret signs session cookies, through DEMO_SECRET. Without one a
+	// random key is made at startup, which starts everyone afresh when the
+	// server restarts.
+	demoSecret []byte
+)
+
+// demoSeed is what a new visitor finds on the home page.
+var demoSeed = []string{
+	"Tick this todo off",
+	"Add a todo of your own above",
+	"Everything here is forgotten after a day without visits",
+}
+
+var errDemoFull = errors.New("the demo holds a limited number of items per visitor")
+
+type demoSession struct {
+	ID string
+	// New is set on the request that created the session.
+	New bool
+}
+
+func initDemoSecret(secret string) {
+	if secret != "" {
+		demoSecret = []byte(secret)
+		return
+	}
+	demoSecret = make([]byte, 32)
+	if _, err := rand.Read(demoSecret); err != nil {
+		panic(err)
+	}
+}
+
+// demoSessionOf returns the demo session of the request behind ctx. It is
+// empty outside demo mode and for background work.
+func demoSessionOf(ctx context.Context) demoSession {
+	s, _ := ctx.Value(demoSessionKey).(demoSession)
+	return s
+}
+
+func withDemoSession(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, demoSessionKey, demoSession{ID: id})
+}
+
+// scoped restricts filter to the demo session behind ctx. Without one it
+// returns filter unchanged.
+func scoped(ctx context.Context, filter bson.M) bson.M {
+	id := demoSessionOf(ctx).ID
+	if id == "" {
+		return filter
+	}
+	out := bson.M{"sessionId": id}
+	for k, v := range filter {
+		out[k] = v
+	}
+	return out
+}
+
+// scopedPipeline starts pipeline with a match on the demo session behind
+// ctx, if any.
+func scopedPipeline(ctx context.Context, pipeline mongo.Pipeline) mongo.Pipeline {
+	id := demoSessionOf(ctx).ID
+	if id == "" {
+		return pipeline
+	}
+	return append(mongo.Pipeline{{{Key: "$match", Value: bson.M{"sessionId": id}}}}, pipeline...)
+}
+
+// demoRoom fails with errDemoFull unless the demo session behind ctx has
+// room for n more documents in collection.
+func demoRoom(ctx context.Context, collection string, n int) error {
+	left, err := demoRoomLeft(ctx, collection)
+	if err != nil {
+		return err
+	}
+	if left >= 0 && n > left {
+		return errDemoFull
+	}
+	return nil
+}
+
+// demoRoomLeft is how many more documents the demo session behind ctx may
+// add to collection, or -1 outside a session.
+func demoRoomLeft(ctx context.Context, collection string) (int, error) {
+	id := demoSessionOf(ctx).ID
+	if id == "" {
+		return -1, nil
+	}
+	count, err := db.Collection(collection).CountDocuments(ctx, bson.M{"sessionId": id})
+	if err != nil {
+		return 0, err
+	}
+	return max(demoMaxTodos-int(count), 0), nil
+}
+
+// demoExpiry is when a document written now in the session behind ctx is
+// collected, or nil outside a session.
+func demoExpiry(ctx context.Context) (string, *time.Time) {
+	id := demoSessionOf(ctx).ID
+	if id == "" {
+		return "", nil
+	}
+	at := time.Now().Add(demoSessionTTL)
+	return id, &at
+}
+
+// demoFull answers a write refused by demoRoom.
+func demoFull(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusForbidden, renderer.M{"message": errDemoFull.Error(), "code": "demo_limit"})
+}
+
+// demoSessions gives every request a demo session, from its cookie or a new
+// one. The cookie records when the session's expiry was last pushed back,
+// so that only happens about once an hour rather than on every request.
+func demoSessions(next http.Handler) http.Handler {
+	refreshEvery := demoSessionTTL / 24
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		s, refreshed, ok := readDemoCookie(r)
+		if !ok {
+			s = demoSession{ID: newDemoSessionID(), New: true}
+		}
+		r = r.WithContext(context.WithValue(r.Context(), demoSessionKey, s))
+
+		if s.New || now.Sub(refreshed) > refreshEvery {
+			http.SetCookie(w, &http.Cookie{
+				Name:     demoCookie,
+				Value:    signDemoCookie(s.ID, now),
+				Path:     "/",
+				MaxAge:   int(demoSessionTTL.Seconds()),
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+			ctx, cancel := dbContext(r)
+			switch {
+			case s.New && r.Method == http.MethodGet && r.URL.Path == "/":
+				seedDemo(ctx)
+			case !s.New:
+				extendDemoSession(ctx, s.ID, now.Add(demoSessionTTL))
+			}
+			cancel()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newDemoSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func demoCookieMAC(payload string) string {
+	mac := hmac.New(sha256.New, demoSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signDemoCookie encodes the session and when it was refreshed as
+// <id>.<unix seconds>.<mac>.
+func signDemoCookie(id string, refreshed time.Time) string {
+	payload := id + "." + strconv.FormatInt(refreshed.Unix(), 10)
+	return payload + "." + demoCookieMAC(payload)
+}
+
+func readDemoCookie(r *http.Request) (demoSession, time.Time, bool) {
+	c, err := r.Cookie(demoCookie)
+	if err != nil {
+		return demoSession{}, time.Time{}, false
+	}
+	i := strings.LastIndexByte(c.Value, '.')
+	if i < 0 || !hmac.Equal([]byte(c.Value[i+1:]), []byte(demoCookieMAC(c.Value[:i]))) {
+		return demoSession{}, time.Time{}, false
+	}
+	id, ts, _ := strings.Cut(c.Value[:i], ".")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || id == "" {
+		return demoSession{}, time.Time{}, false
+	}
+	return demoSession{ID: id}, time.Unix(sec, 0), true
+}
+
+// seedDemo fills a new session with the example todos.
+func seedDemo(ctx context.Context) {
+	for _, title := range demoSeed {
+		if _, err := insertTodo(ctx, todo{Title: title}); err != nil {
+			log.Printf("demo: seeding session: %v", err)
+			return
+		}
+	}
+}
+
+// extendDemoSession pushes back when the session's documents expire.
+func extendDemoSession(ctx context.Context, id string, until time.Time) {
+	for _, name := range []string{collectionName, templateCollection} {
+		_, err := db.Collection(name).UpdateMany(ctx, bson.M{"sessionId": id}, bson.M{"$set": bson.M{"sessionExpireAt": until}})
+		if err != nil {
+			log.Printf("demo: extending session: %v", err)
+		}
+	}
+}
+
+// ensureDemoIndexes lets MongoDB drop a session's todos and templates once
+// it has been idle for demoSessionTTL, and serves the per-session queries.
+func ensureDemoIndexes(ctx context.Context) {
+	for _, name := range []string{collectionName, templateCollection} {
+		_, err := db.Collection(name).Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "sessionId", Value: 1}},
+				Options: options.Index().SetSparse(true),
+			},
+			{
+				Keys:    bson.D{{Key: "sessionExpireAt", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		})
+		if err != nil {
+			log.Printf("Failed to create demo indexes on %s: %v", name, err)
+		}
+	}
+}
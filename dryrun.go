@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// dryRunRoutes are the writes that can be dry run. Each of them plans its
+// change with the same code as the real request and only swaps the final
+// database write for a preview.
+var dryRunRoutes = map[string]bool{
+	"POST /todos":        true,
+	"POST /todos/":       true,
+	"PUT /todos/{id}":    true,
+	"PATCH /todos/{id}":  true,
+	"DELETE /todos/{id}": true,
+}
+
+// legacyRoutes only redirect to /todos, where the dry run is checked again.
+var legacyRoutes = map[string]bool{"/todo": true, "/todo/*": true}
+
+// isDryRun reports whether a write asked to be validated and previewed
+// without being applied, with ?dry_run=true or Prefer: handling=dry-run.
+func isDryRun(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	if ok, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); ok {
+		return true
+	}
+	return hasPreference(r, "handling=dry-run")
+}
+
+// guardDryRun rejects dry runs of writes that don't support them, which
+// would otherwise go ahead and write.
+func guardDryRun(routes chi.Routes) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isDryRun(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rctx := chi.NewRouteContext()
+			if routes.Match(rctx, r.Method, r.URL.Path) && !dryRunRoutes[r.Method+" "+rctx.RoutePattern()] && !legacyRoutes[rctx.RoutePattern()] {
+				respond(w, r, http.StatusBadRequest, renderer.M{
+					"message": "Dry run is not supported for this request",
+					"code":    "dry_run_unsupported",
+				})
+				return
+			}
+			if hasPreference(r, "handling=dry-run") {
+				w.Header().Set("Preference-Applied", "handling=dry-run")
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// dryRunResult marks a response to a dry run, whatever its outcome.
+func dryRunResult(status int, m renderer.M) renderer.M {
+	out := make(renderer.M, len(m)+2)
+	for k, v := range m {
+		out[k] = v
+	}
+	out["dry_run"] = true
+	out["would_have_succeeded"] = status < http.StatusBadRequest
+	return out
+}
+
+// previewWrites applies fw to a copy of t in memory. Expressions computed
+// by the database, such as completedAt, are left as they were.
+func previewWrites(t todoModel, fw *fieldWrites) (todoModel, error) {
+	raw, err := bson.Marshal(t)
+	if err != nil {
+		return t, err
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return t, err
+	}
+	for _, api := range fw.order {
+		for _, field := range fw.docs[api] {
+			if v := fw.vals[field]; v != nil {
+				doc[field] = v
+			} else {
+				delete(doc, field)
+			}
+		}
+	}
+	if raw, err = bson.Marshal(doc); err != nil {
+		return t, err
+	}
+	var out todoModel
+	err = bson.Unmarshal(raw, &out)
+	return out, err
+}
+
+// fieldDiff lists the API fields written by fw whose value differs between
+// before and after, as {field, from, to}.
+func fieldDiff(before, after todo, fw *fieldWrites) ([]renderer.M, error) {
+	b, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := []renderer.M{}
+	for _, api := range fw.order {
+		if !reflect.DeepEqual(b[api], a[api]) {
+			changes = append(changes, renderer.M{"field": api, "from": b[api], "to": a[api]})
+		}
+	}
+	return changes, nil
+}
+
+func jsonFields(t todo) (map[string]interface{}, error) {
+	raw, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	err = json.Unmarshal(raw, &m)
+	return m, err
+}
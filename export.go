@@ -0,0 +1,605 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	exportJobCollection = "exportJobs"
+	// exportBucket is the GridFS bucket export files are written to.
+	exportBucket = "exports"
+)
+
+// Background job statuses.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+var (
+	// exportTTL is how long a job and its file are kept after it finishes,
+	// through EXPORT_TTL.
+	exportTTL = 24 * time.Hour
+	// exportHeartbeat is how often a running export records its progress.
+	exportHeartbeat = time.Second
+)
+
+// Background jobs, exports and imports, record their progress at least
+// every few seconds. A running job whose last record is older than
+// jobStaleAfter is taken to have lost its worker, and is started again up
+// to jobMaxAttempts times before it is failed.
+const (
+	jobStaleAfter  = 30 * time.Second
+	jobMaxAttempts = 3
+)
+
+var errExportCancelled = errors.New("export job was cancelled")
+
+// exportJob is a stored export. Attempts doubles as the claim on a running
+// job: only the worker that started the current attempt may update it. Each
+// attempt writes a file of its own, FileID, so an attempt that is given up
+// on can't clobber the next one's.
+type exportJob struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id" xml:"id"`
+	Status      string             `bson:"status" json:"status" xml:"status"`
+	Format      string             `bson:"format" json:"format" xml:"format"`
+	Query       string             `bson:"query" json:"query" xml:"query"`
+	Total       int64              `bson:"total" json:"total" xml:"total"`
+	Exported    int64              `bson:"exported" json:"exported" xml:"exported"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty" xml:"error,omitempty"`
+	Attempts    int                `bson:"attempts" json:"-" xml:"-"`
+	FileID      primitive.ObjectID `bson:"fileId,omitempty" json:"-" xml:"-"`
+	HeartbeatAt *time.Time         `bson:"heartbeatAt,omitempty" json:"-" xml:"-"`
+	CreateAt    time.Time          `bson:"createAt" json:"created_at" xml:"created_at"`
+	StartedAt   *time.Time         `bson:"startedAt,omitempty" json:"started_at,omitempty" xml:"started_at,omitempty"`
+	FinishedAt  *time.Time         `bson:"finishedAt,omitempty" json:"finished_at,omitempty" xml:"finished_at,omitempty"`
+	ExpireAt    time.Time          `bson:"expireAt" json:"expires_at" xml:"expires_at"`
+	SessionID   string             `bson:"sessionId,omitempty" json:"-" xml:"-"`
+	DownloadURL string             `bson:"-" json:"download_url,omitempty" xml:"download_url,omitempty"`
+}
+
+// expired reports whether a finished job is past its expiry, though not yet
+// swept.
+func (j exportJob) expired() bool {
+	return j.FinishedAt != nil && j.ExpireAt.Before(time.Now())
+}
+
+func (j exportJob) withDownloadURL() exportJob {
+	if j.Status == jobDone {
+		j.DownloadURL = "/export-jobs/" + j.ID.Hex() + "/download"
+	}
+	return j
+}
+
+func (j exportJob) fileName() string {
+	return "todos-" + j.ID.Hex() + "." + j.Format
+}
+
+func (j exportJob) contentType() string {
+	if j.Format == "ndjson" {
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
+
+var (
+	// exportWake nudges the worker when a job is queued so it doesn't wait
+	// for its next poll.
+	exportWake = make(chan struct{}, 1)
+	// exportRuns holds the cancel func of each job running in this
+	// process, so DELETE stops it straight away. Jobs running on another
+	// replica notice at their next heartbeat instead.
+	exportRuns sync.Map
+)
+
+func newExportBucket() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(db, options.GridFSBucket().SetName(exportBucket))
+}
+
+// exportParams are the query parameters of POST /todos/export-jobs besides
+// the list filters.
+var exportParams = []string{"sort", "time_format", "format"}
+
+// parseExportQuery checks q the way the worker will read it, returning the
+// format. Exports always hold every match, so paging doesn't apply.
+func parseExportQuery(q url.Values) (string, error) {
+	if unknown := unknownParams(q, filterParams, exportParams); strictQueryParams && len(unknown) > 0 {
+		return "", fmt.Errorf("unknown query parameters: %v", unknown)
+	}
+	for _, name := range listParams {
+		if name != "sort" && q.Has(name) {
+			return "", fmt.Errorf("%s does not apply to exports", name)
+		}
+	}
+	filter, err := parseTodoFilter(q)
+	if err != nil {
+		return "", err
+	}
+	if filter.Near != nil {
+		return "", fmt.Errorf("near is not supported by exports")
+	}
+	if _, err := parseListOptions(q); err != nil {
+		return "", err
+	}
+	if _, err := parseTimeFormat(q); err != nil {
+		return "", err
+	}
+	ndjson, err := parseListFormat(q)
+	if err != nil {
+		return "", err
+	}
+	if ndjson {
+		return "ndjson", nil
+	}
+	return "json", nil
+}
+
+// createExportJob queues an export of the todos GET /todos would list with
+// the same query, without paging.
+func createExportJob(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	settings, err := loadSettings(ctx, r)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch settings", "error": err.Error()})
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("sort") == "" && settings.DefaultSort != "" {
+		q.Set("sort", settings.DefaultSort)
+	}
+	format, err := parseExportQuery(q)
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid export", "error": err.Error()})
+		return
+	}
+	q.Del("format")
+
+	now := time.Now()
+	job := exportJob{
+		ID:        primitive.NewObjectID(),
+		Status:    jobQueued,
+		Format:    format,
+		Query:     q.Encode(),
+		CreateAt:  now,
+		ExpireAt:  now.Add(exportTTL),
+		SessionID: demoSessionOf(ctx).ID,
+	}
+	if _, err := db.Collection(exportJobCollection).InsertOne(ctx, job); err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to create export", "error": err.Error()})
+		return
+	}
+	select {
+	case exportWake <- struct{}{}:
+	default:
+	}
+
+	w.Header().Set("Location", "/export-jobs/"+job.ID.Hex())
+	respond(w, r, http.StatusAccepted, renderer.M{"message": "Export queued", "data": job})
+}
+
+func exportJobHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(requireFlag(flagExports))
+	rg.Get("/{id}", getExportJob)
+	rg.With(expensive).Get("/{id}/download", downloadExport)
+	rg.Delete("/{id}", deleteExportJob)
+	return rg
+}
+
+// findExportJob loads the job of the route's {id}, answering the request
+// itself when it can't.
+func findExportJob(w http.ResponseWriter, r *http.Request) (exportJob, bool) {
+	var job exportJob
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid ID"})
+		return job, false
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	err = db.Collection(exportJobCollection).FindOne(ctx, scoped(ctx, bson.M{"_id": id})).Decode(&job)
+	if err == mongo.ErrNoDocuments || err == nil && job.expired() {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Export not found"})
+		return job, false
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch export", "error": err.Error()})
+		return job, false
+	}
+	return job, true
+}
+
+func getExportJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := findExportJob(w, r)
+	if !ok {
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{"data": job.withDownloadURL()})
+}
+
+// downloadExport streams the file of a finished job.
+func downloadExport(w http.ResponseWriter, r *http.Request) {
+	job, ok := findExportJob(w, r)
+	if !ok {
+		return
+	}
+	if job.Status != jobDone {
+		respond(w, r, http.StatusConflict, renderer.M{"message": "Export is " + job.Status, "code": "export_not_ready"})
+		return
+	}
+
+	bucket, err := newExportBucket()
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to open export", "error": err.Error()})
+		return
+	}
+	ds, err := bucket.OpenDownloadStream(job.FileID)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to open export", "error": err.Error()})
+		return
+	}
+	defer ds.Close()
+
+	// Like NDJSON lists, large files outlive the server's WriteTimeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", job.contentType())
+	w.Header().Set("Content-Disposition", `attachment; filename="`+job.fileName()+`"`)
+	w.Header().Set("Content-Length", strconv.FormatInt(ds.GetFile().Length, 10))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, ds); err != nil {
+		log.Printf("exports: download of %s: %v", job.ID.Hex(), err)
+	}
+}
+
+// deleteExportJob cancels a job that is still queued or running and removes
+// it along with its file.
+func deleteExportJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := findExportJob(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	if err := removeExportJob(ctx, job); err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to delete export", "error": err.Error()})
+		return
+	}
+	if stop, ok := exportRuns.Load(job.ID); ok {
+		stop.(context.CancelFunc)()
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeExportJob deletes a job and its file. A worker still running the
+// job stops at its next heartbeat and drops what it has written.
+func removeExportJob(ctx context.Context, job exportJob) error {
+	if _, err := db.Collection(exportJobCollection).DeleteOne(ctx, bson.M{"_id": job.ID}); err != nil {
+		return err
+	}
+	return deleteExportFile(ctx, job.FileID)
+}
+
+// deleteExportFile removes export file id, including the chunks of an
+// upload that never finished.
+func deleteExportFile(ctx context.Context, id primitive.ObjectID) error {
+	if id.IsZero() {
+		return nil
+	}
+	bucket, err := newExportBucket()
+	if err != nil {
+		return err
+	}
+	if err := bucket.DeleteContext(ctx, id); err != nil && err != gridfs.ErrFileNotFound {
+		return err
+	}
+	return nil
+}
+
+func ensureExportIndexes(ctx context.Context) {
+	_, err := db.Collection(exportJobCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createAt", Value: 1}}},
+		{Keys: bson.D{{Key: "expireAt", Value: 1}}},
+	})
+	if err != nil {
+		log.Printf("Failed to create export job indexes: %v", err)
+	}
+}
+
+// runExports runs queued export jobs one at a time until ctx is cancelled.
+// Each pass also restarts jobs whose worker died and removes expired jobs
+// with their files; a TTL index would leave the files behind.
+func runExports(ctx context.Context) {
+	t := time.NewTicker(5 * time.Second)
+	defer t.Stop()
+	for {
+		recoverJobs(ctx, exportJobCollection, exportTTL)
+		for ctx.Err() == nil && flagExports.on() {
+			job, ok := claimExport(ctx)
+			if !ok {
+				break
+			}
+			runExport(ctx, job)
+		}
+		sweepExports(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-exportWake:
+		}
+	}
+}
+
+// recoverJobs puts the jobs in collection left running by a worker that
+// stopped heartbeating back in the queue, or fails them once they have used
+// up their attempts. Failed jobs are kept for ttl.
+func recoverJobs(parent context.Context, collection string, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(parent, 10*time.Second)
+	defer cancel()
+
+	coll := db.Collection(collection)
+	stale := bson.M{"status": jobRunning, "heartbeatAt": bson.M{"$lt": time.Now().Add(-jobStaleAfter)}}
+	now := time.Now()
+	_, err := coll.UpdateMany(ctx, bson.M{"$and": bson.A{stale, bson.M{"attempts": bson.M{"$gte": jobMaxAttempts}}}},
+		bson.M{"$set": bson.M{"status": jobFailed, "error": "the job was interrupted too many times", "finishedAt": now, "expireAt": now.Add(ttl)}})
+	if err == nil {
+		_, err = coll.UpdateMany(ctx, stale, bson.M{"$set": bson.M{"status": jobQueued}})
+	}
+	if err != nil && parent.Err() == nil {
+		log.Printf("%s: recovering jobs: %v", collection, err)
+	}
+}
+
+// claimExport takes the oldest queued job. Starting an attempt bumps
+// Attempts, which fences off any worker still holding an earlier one, and
+// drops the file an interrupted attempt left behind.
+func claimExport(parent context.Context) (exportJob, bool) {
+	ctx, cancel := context.WithTimeout(parent, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	fileID := primitive.NewObjectID()
+	var job exportJob
+	err := db.Collection(exportJobCollection).FindOneAndUpdate(ctx,
+		bson.M{"status": jobQueued},
+		bson.M{
+			"$set": bson.M{"status": jobRunning, "fileId": fileID, "startedAt": now, "heartbeatAt": now, "exported": 0},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "createAt", Value: 1}}),
+	).Decode(&job)
+	if err != nil {
+		if err != mongo.ErrNoDocuments && parent.Err() == nil {
+			log.Printf("exports: claiming job: %v", err)
+		}
+		return job, false
+	}
+	if err := deleteExportFile(ctx, job.FileID); err != nil {
+		log.Printf("exports: job %s: removing earlier file: %v", job.ID.Hex(), err)
+	}
+	job.Status, job.FileID, job.StartedAt, job.HeartbeatAt = jobRunning, fileID, &now, &now
+	job.Attempts++
+	return job, true
+}
+
+// runExport writes the file of job and records how it ended. A job stopped
+// by shutdown goes back in the queue for the next start.
+func runExport(parent context.Context, job exportJob) {
+	ctx, stop := context.WithCancel(withDemoSession(parent, job.SessionID))
+	exportRuns.Store(job.ID, stop)
+	defer func() {
+		exportRuns.Delete(job.ID)
+		stop()
+	}()
+
+	err := writeExport(ctx, job)
+
+	fin, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	now := time.Now()
+	set := bson.M{"finishedAt": now, "expireAt": now.Add(exportTTL)}
+	switch {
+	case err == nil:
+		set["status"] = jobDone
+	case parent.Err() != nil:
+		// Shutting down isn't the job's fault, so it doesn't use up an
+		// attempt.
+		set = bson.M{"status": jobQueued, "attempts": job.Attempts - 1}
+	case ctx.Err() != nil || errors.Is(err, errExportCancelled):
+		log.Printf("exports: job %s cancelled", job.ID.Hex())
+		deleteExportFile(fin, job.FileID)
+		return
+	default:
+		log.Printf("exports: job %s: %v", job.ID.Hex(), err)
+		set["status"] = jobFailed
+		set["error"] = err.Error()
+	}
+
+	res, uerr := db.Collection(exportJobCollection).UpdateOne(fin,
+		bson.M{"_id": job.ID, "status": jobRunning, "attempts": job.Attempts},
+		bson.M{"$set": set})
+	if uerr != nil {
+		log.Printf("exports: job %s: recording result: %v", job.ID.Hex(), uerr)
+		return
+	}
+	if res.MatchedCount == 0 || err != nil {
+		// Deleted while finishing, or not finished: the file isn't wanted.
+		deleteExportFile(fin, job.FileID)
+	}
+}
+
+// writeExport streams the todos matched by job into its GridFS file,
+// recording progress every exportHeartbeat. It fails with
+// errExportCancelled once the job is deleted or claimed again.
+func writeExport(ctx context.Context, job exportJob) error {
+	q, err := url.ParseQuery(job.Query)
+	if err != nil {
+		return err
+	}
+	filter, err := parseTodoFilter(q)
+	if err != nil {
+		return err
+	}
+	filter.Session = job.SessionID
+	opts, err := parseListOptions(q)
+	if err != nil {
+		return err
+	}
+	tf, err := parseTimeFormat(q)
+	if err != nil {
+		return err
+	}
+
+	coll := db.Collection(collectionName)
+	total, err := coll.CountDocuments(ctx, filter.query())
+	if err != nil {
+		return err
+	}
+	claim := bson.M{"_id": job.ID, "status": jobRunning, "attempts": job.Attempts}
+	progress := func(exported int64) error {
+		res, err := db.Collection(exportJobCollection).UpdateOne(ctx, claim,
+			bson.M{"$set": bson.M{"total": total, "exported": exported, "heartbeatAt": time.Now()}})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return errExportCancelled
+		}
+		return nil
+	}
+	if err := progress(0); err != nil {
+		return err
+	}
+
+	bucket, err := newExportBucket()
+	if err != nil {
+		return err
+	}
+	us, err := bucket.OpenUploadStreamWithID(job.FileID, job.fileName())
+	if err != nil {
+		return err
+	}
+	cur, err := findTodos(ctx, coll, filter, opts, q.Get("sort") != "")
+	if err != nil {
+		us.Abort()
+		return err
+	}
+	defer cur.Close(context.WithoutCancel(ctx))
+
+	n, err := encodeExport(ctx, us, cur, job.Format, tf, progress)
+	if err == nil {
+		err = us.Close()
+	}
+	if err != nil {
+		us.Abort()
+		return err
+	}
+	return progress(n)
+}
+
+// encodeExport writes every todo of cur to w as a JSON array or as NDJSON,
+// calling progress with the count so far every exportHeartbeat.
+func encodeExport(ctx context.Context, w io.Writer, cur *mongo.Cursor, format string, tf timeFormat, progress func(int64) error) (int64, error) {
+	ndjson := format == "ndjson"
+	if !ndjson {
+		if _, err := io.WriteString(w, "["); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int64
+	last := time.Now()
+	for cur.Next(ctx) {
+		var t todoModel
+		if err := cur.Decode(&t); err != nil {
+			return n, err
+		}
+		b, err := json.Marshal(namedJSON(t.toTodo().withTimeFormat(tf)))
+		if err != nil {
+			return n, err
+		}
+		switch {
+		case ndjson:
+			b = append(b, '\n')
+		case n > 0:
+			b = append([]byte{','}, b...)
+		}
+		if _, err := w.Write(b); err != nil {
+			return n, err
+		}
+		n++
+		if time.Since(last) > exportHeartbeat {
+			if err := progress(n); err != nil {
+				return n, err
+			}
+			last = time.Now()
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return n, err
+	}
+	if !ndjson {
+		if _, err := io.WriteString(w, "]"); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// sweepExports removes jobs past their expiry, with their files. A job
+// still queued when its expiry passes is dropped too.
+func sweepExports(parent context.Context) {
+	ctx, cancel := context.WithTimeout(parent, 30*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "fileId": 1})
+	cur, err := db.Collection(exportJobCollection).Find(ctx,
+		bson.M{"status": bson.M{"$ne": jobRunning}, "expireAt": bson.M{"$lt": time.Now()}}, opts)
+	if err != nil {
+		if parent.Err() == nil {
+			log.Printf("exports: sweeping: %v", err)
+		}
+		return
+	}
+	var expired []exportJob
+	if err := cur.All(ctx, &expired); err != nil {
+		if parent.Err() == nil {
+			log.Printf("exports: sweeping: %v", err)
+		}
+		return
+	}
+	for _, job := range expired {
+		if err := removeExportJob(ctx, job); err != nil {
+			log.Printf("exports: removing job %s: %v", job.ID.Hex(), err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// feedLimit is the number of todos included in the RSS feed.
+var feedLimit = 20
+
+type (
+	rssFeed struct {
+		XMLName xml.Name   `xml:"rss"`
+		Version string     `xml:"version,attr"`
+		Channel rssChannel `xml:"channel"`
+	}
+	rssChannel struct {
+		Title         string    `xml:"title"`
+		Link          string    `xml:"link"`
+		Description   string    `xml:"description"`
+		LastBuildDate string    `xml:"lastBuildDate"`
+		Items         []rssItem `xml:"item"`
+	}
+	rssItem struct {
+		Title       string  `xml:"title"`
+		Link        string  `xml:"link"`
+		Description string  `xml:"description"`
+		PubDate     string  `xml:"pubDate"`
+		GUID        rssGUID `xml:"guid"`
+	}
+	rssGUID struct {
+		Value       string `xml:",chardata"`
+		IsPermaLink bool   `xml:"isPermaLink,attr"`
+	}
+)
+
+// fetchFeed serves the most recent todos as an RSS 2.0 feed.
+func fetchFeed(w http.ResponseWriter, r *http.Request) {
+	collection := db.Collection(collectionName)
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(feedLimit)).
+		SetProjection(projectionOf(todoHeadline{}))
+	cur, err := collection.Find(ctx, scoped(ctx, bson.M{}), opts)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch todo", "error": err.Error()})
+		return
+	}
+	defer cur.Close(ctx)
+
+	var todos []todoHeadline
+	if err := cur.All(ctx, &todos); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "Failed to decode todos", "error": err.Error()})
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := scheme + "://" + r.Host
+
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         "Daily Todo Lists",
+			Link:          base + "/",
+			Description:   fmt.Sprintf("The %d most recent todos", feedLimit),
+			LastBuildDate: time.Now().Format(time.RFC1123Z),
+		},
+	}
+	for _, t := range todos {
+		state := "Open"
+		if t.Completed {
+			state = "Completed"
+		}
+		link := base + "/todos/" + t.ID.Hex()
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       t.Title,
+			Link:        link,
+			Description: fmt.Sprintf("%s, created %s", state, t.CreateAt.Format("Jan 2, 2006 15:04")),
+			PubDate:     t.CreateAt.Format(time.RFC1123Z),
+			GUID:        rssGUID{Value: link},
+		})
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "Failed to build feed", "error": err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
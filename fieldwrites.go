@@ -0,0 +1,122 @@
+package main
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fieldWrites collects the field changes of one update and compiles them
+// into an update pipeline that also stamps fieldUpdatedAt.<api field> for
+// every field whose value actually changes, and updatedAt if any does, in
+// the same atomic write.
+type fieldWrites struct {
+	now   time.Time
+	order []string            // API field names in the order first written
+	docs  map[string][]string // API field -> document fields
+	vals  map[string]interface{}
+	exprs bson.D
+	// status is the status setStatus moves the todo to, if it was called.
+	status string
+}
+
+func newFieldWrites(now time.Time) *fieldWrites {
+	return &fieldWrites{now: now, docs: map[string][]string{}, vals: map[string]interface{}{}}
+}
+
+// set writes value to the document field behind the API field api. A nil
+// value removes the document field.
+func (fw *fieldWrites) set(api, field string, value interface{}) {
+	if _, ok := fw.docs[api]; !ok {
+		fw.order = append(fw.order, api)
+	}
+	fw.docs[api] = append(fw.docs[api], field)
+	fw.vals[field] = value
+}
+
+// expr sets field to an aggregation expression evaluated against the
+// document as it was before this update. It is not tracked in
+// fieldUpdatedAt.
+func (fw *fieldWrites) expr(field string, expression interface{}) {
+	fw.exprs = append(fw.exprs, bson.E{Key: field, Value: expression})
+}
+
+func (fw *fieldWrites) pipeline() mongo.Pipeline {
+	// Stage one compares old values, so it must run before they change.
+	stamps := bson.D{}
+	var unchanged bson.A
+	for _, api := range fw.order {
+		var same bson.A
+		for _, field := range fw.docs[api] {
+			same = append(same, bson.M{"$eq": bson.A{
+				bson.M{"$ifNull": bson.A{"$" + field, nil}},
+				bson.M{"$literal": fw.vals[field]},
+			}})
+		}
+		key := "fieldUpdatedAt." + api
+		stamps = append(stamps, bson.E{Key: key, Value: bson.M{
+			"$cond": bson.A{bson.M{"$and": same}, "$" + key, fw.now},
+		}})
+		unchanged = append(unchanged, same...)
+	}
+	if len(unchanged) > 0 {
+		stamps = append(stamps, bson.E{Key: "updatedAt", Value: bson.M{
+			"$cond": bson.A{bson.M{"$and": unchanged}, "$updatedAt", fw.now},
+		}})
+	}
+
+	sets := append(bson.D{}, fw.exprs...)
+	var unsets bson.A
+	for _, api := range fw.order {
+		for _, field := range fw.docs[api] {
+			if v := fw.vals[field]; v != nil {
+				sets = append(sets, bson.E{Key: field, Value: bson.M{"$literal": v}})
+			} else {
+				unsets = append(unsets, field)
+			}
+		}
+	}
+
+	p := mongo.Pipeline{}
+	if len(stamps) > 0 {
+		p = append(p, bson.D{{Key: "$set", Value: stamps}})
+	}
+	if len(sets) > 0 {
+		p = append(p, bson.D{{Key: "$set", Value: sets}})
+	}
+	if len(unsets) > 0 {
+		p = append(p, bson.D{{Key: "$unset", Value: unsets}})
+	}
+	return p
+}
+
+// setCompleted records the completed flag. completedAt is stamped only on
+// the first completion, so re-saving a done todo keeps its original time,
+// and cleared when the todo is reopened. Completing also clears a stored
+// status, while reopening leaves it alone.
+func (fw *fieldWrites) setCompleted(completed bool) {
+	fw.writeCompleted(completed)
+	if completed {
+		fw.stampStatus(statusDone)
+	} else {
+		// Only a done todo changes status, to todo, when reopened.
+		fw.expr("statusChangedAt", bson.M{"$cond": bson.A{"$completed", fw.now, "$statusChangedAt"}})
+	}
+}
+
+func (fw *fieldWrites) writeCompleted(completed bool) {
+	fw.set("completed", "completed", completed)
+	if completed {
+		fw.set("status", "status", nil)
+		fw.expr("completedAt", bson.M{"$ifNull": bson.A{"$completedAt", fw.now}})
+	} else {
+		fw.expr("completedAt", "$$REMOVE")
+	}
+}
+
+// stampStatus sets statusChangedAt if the todo's status is not s already.
+func (fw *fieldWrites) stampStatus(s string) {
+	current := bson.M{"$cond": bson.A{"$completed", statusDone, bson.M{"$ifNull": bson.A{"$status", statusTodo}}}}
+	fw.expr("statusChangedAt", bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{current, s}}, "$statusChangedAt", fw.now}})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// todoFilter holds the list filters accepted by fetchTodos.
+type todoFilter struct {
+	Completed *bool
+	HasDue    *bool
+	Tag       string
+	// EstimateLTE matches todos estimated at most this many minutes.
+	// Todos without an estimate never match.
+	EstimateLTE int
+	// Meta holds one condition per ?meta.<key>= parameter.
+	Meta []bson.M
+	// Search is the ?q= search, if any.
+	Search *todoSearch
+	// Priority is empty or one of priorities.
+	Priority string
+	// Buckets restricts the todos to these buckets; nil means any.
+	Buckets []string
+	// Statuses restricts the todos to these statuses; nil means any.
+	Statuses []string
+	// Stale keeps only the stale todos, or only the others.
+	Stale *bool
+	// Near is applied by a $geoNear stage rather than query(), since it
+	// also orders the results and reports their distance.
+	Near *nearFilter
+	// DueBefore keeps todos due before this instant; see applyView.
+	DueBefore *time.Time
+	// After continues a listing from a ?cursor= position.
+	After *listCursor
+	// Session restricts the todos to a demo session.
+	Session string
+}
+
+// filterParams are the query parameters read by parseTodoFilter.
+var filterParams = []string{"completed", "has_due", "tag", "estimate_lte", "priority", "bucket", "status", "stale", "meta.*", "q", "search_mode", "near", "radius"}
+
+func parseTodoFilter(q url.Values) (todoFilter, error) {
+	var f todoFilter
+	var err error
+
+	if f.Completed, err = parseBoolParam(q, "completed"); err != nil {
+		return f, err
+	}
+	if f.HasDue, err = parseBoolParam(q, "has_due"); err != nil {
+		return f, err
+	}
+	f.Tag = normalizeTag(q.Get("tag"))
+	if s := q.Get("estimate_lte"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return f, fmt.Errorf("estimate_lte must be a positive integer")
+		}
+		f.EstimateLTE = n
+	}
+	if f.Priority = q.Get("priority"); f.Priority != "" && !priorities[f.Priority] {
+		return f, fmt.Errorf("priority must be low, medium or high")
+	}
+	if f.Buckets, err = parseBuckets(q.Get("bucket")); err != nil {
+		return f, err
+	}
+	if f.Statuses, err = parseStatuses(q.Get("status")); err != nil {
+		return f, err
+	}
+	if f.Stale, err = parseBoolParam(q, "stale"); err != nil {
+		return f, err
+	}
+	if f.Stale != nil && staleAfterDays <= 0 {
+		return f, fmt.Errorf("stale requires STALE_AFTER_DAYS to be set")
+	}
+	if f.Meta, err = parseMetaFilters(q); err != nil {
+		return f, err
+	}
+	if f.Search, err = parseSearch(q); err != nil {
+		return f, err
+	}
+	if near := q.Get("near"); near != "" {
+		if f.Near, err = parseNear(near, q.Get("radius")); err != nil {
+			return f, err
+		}
+		// $geoNear can't take a $text query, so search by title instead.
+		if f.Search != nil && f.Search.Mode == searchModeText {
+			f.Search.Mode = searchModeRegex
+		}
+	} else if q.Get("radius") != "" {
+		return f, fmt.Errorf("radius requires near")
+	}
+	return f, nil
+}
+
+// query translates the filter into a Mongo filter document. Every condition
+// is ANDed so operators like $or never clobber each other.
+func (f todoFilter) query() bson.M {
+	var conds []bson.M
+
+	if f.Completed != nil {
+		conds = append(conds, bson.M{"completed": *f.Completed})
+	}
+	if f.HasDue != nil {
+		if *f.HasDue {
+			conds = append(conds, bson.M{"dueDate": bson.M{"$exists": true, "$ne": nil}})
+		} else {
+			// {field: nil} matches both a missing field and an explicit null.
+			conds = append(conds, bson.M{"dueDate": nil})
+		}
+	}
+	if f.Tag != "" {
+		conds = append(conds, bson.M{"tags": f.Tag})
+	}
+	if f.EstimateLTE > 0 {
+		conds = append(conds, bson.M{"estimate": bson.M{"$lte": f.EstimateLTE}})
+	}
+	if f.Priority != "" {
+		conds = append(conds, bson.M{"priority": f.Priority})
+	}
+	if f.Buckets != nil {
+		conds = append(conds, bucketCond(f.Buckets))
+	}
+	if f.Statuses != nil {
+		conds = append(conds, statusCond(f.Statuses))
+	}
+	if f.Stale != nil {
+		conds = append(conds, staleCond(*f.Stale, time.Now()))
+	}
+	conds = append(conds, f.Meta...)
+	if f.Search != nil {
+		conds = append(conds, f.Search.cond())
+	}
+	if f.DueBefore != nil {
+		conds = append(conds, bson.M{"dueDate": bson.M{"$lt": *f.DueBefore}})
+	}
+	if f.After != nil {
+		conds = append(conds, f.After.cond())
+	}
+	if f.Session != "" {
+		conds = append(conds, bson.M{"sessionId": f.Session})
+	}
+
+	switch len(conds) {
+	case 0:
+		return bson.M{}
+	case 1:
+		return conds[0]
+	}
+	return bson.M{"$and": conds}
+}
+
+// empty reports whether the filter would match every todo.
+func (f todoFilter) empty() bool {
+	return f.Completed == nil && f.HasDue == nil && f.Tag == "" && f.EstimateLTE == 0 && f.Priority == "" && f.Buckets == nil && f.Statuses == nil && f.Stale == nil && len(f.Meta) == 0 && f.Search == nil && f.Near == nil && f.DueBefore == nil && f.After == nil
+}
+
+// viewToday is the ?view= of GET /todos listing what is on today's plate.
+const viewToday = "today"
+
+// applyView narrows f to a ?view= of GET /todos. "today" keeps the open
+// todos due by the end of today in loc, overdue ones included, whatever
+// ?completed= says.
+func (f *todoFilter) applyView(view string, now time.Time, loc *time.Location) error {
+	switch view {
+	case "":
+		return nil
+	case viewToday:
+		now = now.In(loc)
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+		open := false
+		f.Completed, f.DueBefore = &open, &tomorrow
+		return nil
+	}
+	return fmt.Errorf("view must be %s", viewToday)
+}
+
+// bulkFilter is the filter object in the body of bulk updates.
+type bulkFilter struct {
+	Tag       string `json:"tag"`
+	Completed *bool  `json:"completed"`
+	HasDue    *bool  `json:"has_due"`
+	Priority  string `json:"priority"`
+}
+
+func (b bulkFilter) todoFilter() (todoFilter, renderer.M) {
+	if b.Priority != "" {
+		if m := validatePriority(b.Priority); m != nil {
+			m["field"] = "filter.priority"
+			return todoFilter{}, m
+		}
+	}
+	return todoFilter{
+		Completed: b.Completed,
+		HasDue:    b.HasDue,
+		Tag:       normalizeTag(b.Tag),
+		Priority:  b.Priority,
+	}, nil
+}
+
+func parseBoolParam(q url.Values, name string) (*bool, error) {
+	raw := q.Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false", name)
+	}
+	return &v, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestApplyViewToday(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	// 23:30 UTC is already the next morning in Tokyo.
+	now := time.Date(2024, 3, 24, 23, 30, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		loc  *time.Location
+		want time.Time
+	}{
+		{time.UTC, time.Date(2024, 3, 25, 0, 0, 0, 0, time.UTC)},
+		{tokyo, time.Date(2024, 3, 26, 0, 0, 0, 0, tokyo)},
+	} {
+		completed := true
+		f := todoFilter{Completed: &completed}
+		if err := f.applyView(viewToday, now, tc.loc); err != nil {
+			t.Fatal(err)
+		}
+		if f.Completed == nil || *f.Completed {
+			t.Errorf("%s: completed = %v; want false", tc.loc, f.Completed)
+		}
+		if f.DueBefore == nil || !f.DueBefore.Equal(tc.want) {
+			t.Errorf("%s: due before %v; want %v", tc.loc, f.DueBefore, tc.want)
+		}
+	}
+
+	var f todoFilter
+	if err := f.applyView("", now, time.UTC); err != nil || !f.empty() {
+		t.Errorf("no view: %v, filter %+v; want it left alone", err, f)
+	}
+	if err := f.applyView("week", now, time.UTC); err == nil {
+		t.Error("view=week was accepted")
+	}
+}
+
+// TestTodayViewEstimate expects GET /todos?view=today to ask for the open
+// todos due by tonight and to report their total estimate in its meta.
+func TestTodayViewEstimate(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("today", func(mt *mtest.T) {
+		useMockDB(mt)
+		defer func(on bool) { listCountsEnabled = on }(listCountsEnabled)
+		listCountsEnabled = false
+
+		ns := mt.DB.Name() + "." + collectionName
+		cursor := func(docs ...bson.D) bson.D {
+			return mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, docs...)
+		}
+		todo := bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "title", Value: "write tests"}, {Key: "estimate", Value: 30}}
+		mt.AddMockResponses(
+			cursor(),
+			cursor(),
+			cursor(todo),
+			cursor(todo),
+			cursor(bson.D{{Key: "_id", Value: nil}, {Key: "minutes", Value: 510}}),
+		)
+
+		w := httptest.NewRecorder()
+		testRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos?view=today&limit=1", nil))
+		if w.Code != http.StatusOK {
+			mt.Fatalf("GET /todos?view=today: %d %s", w.Code, w.Body)
+		}
+		var res struct {
+			Meta map[string]interface{} `json:"meta"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			mt.Fatal(err)
+		}
+		if got := res.Meta["estimate_minutes"]; got != 510.0 {
+			mt.Errorf("estimate_minutes = %v; want 510", got)
+		}
+
+		var finds int
+		for _, ev := range mt.GetAllStartedEvents() {
+			// The single-document finds are listLastModified's.
+			if _, single := ev.Command.Lookup("singleBatch").BooleanOK(); ev.CommandName != "find" || single {
+				continue
+			}
+			finds++
+			filter := ev.Command.Lookup("filter").String()
+			for _, want := range []string{`{"completed": false}`, `{"dueDate": {"$lt": `} {
+				if !strings.Contains(filter, want) {
+					mt.Errorf("find filter %s lacks %s", filter, want)
+				}
+			}
+		}
+		if finds != 2 {
+			mt.Errorf("%d list finds; want the page for the ETag, then the list", finds)
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const featureFlagCollection = "feature_flags"
+
+// A featureFlag switches an optional part of the server on or off while it
+// runs. Its default comes from FEATURE_<NAME>; PUT /admin/flags/{name}
+// overrides it in Mongo, where every instance picks the override up within
+// flagPollInterval. Flags are checked on each request and each worker run,
+// so a switch needs no restart.
+type featureFlag struct {
+	name        string
+	description string
+	// features are the capabilities the flag turns off.
+	features []string
+
+	def        bool
+	enabled    atomic.Bool
+	overridden atomic.Bool
+}
+
+var (
+	flagImports   = newFeatureFlag("imports", "CSV imports and import jobs, and the worker running them.", "csv_import", "import_jobs")
+	flagExports   = newFeatureFlag("exports", "Export jobs and the worker running them.", "export_jobs")
+	flagFeed      = newFeatureFlag("feed", "The RSS feed.", "rss")
+	flagSync      = newFeatureFlag("sync", "The change feed at GET /todos/changes.", "sync")
+	flagScheduler = newFeatureFlag("scheduler", "Creating todos from scheduled templates.")
+
+	featureFlags = map[string]*featureFlag{}
+
+	// flagPollInterval is how often overrides are reloaded, through
+	// FEATURE_FLAG_POLL_INTERVAL.
+	flagPollInterval = 10 * time.Second
+)
+
+type featureFlagModel struct {
+	Name      string    `bson:"_id"`
+	Enabled   bool      `bson:"enabled"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+func newFeatureFlag(name, description string, features ...string) *featureFlag {
+	f := &featureFlag{name: name, description: description, features: features, def: true}
+	f.enabled.Store(true)
+	featureFlags[name] = f
+	newGaugeFunc("feature_flag_"+name, "1 while the "+name+" feature flag is on.", func() float64 {
+		if f.on() {
+			return 1
+		}
+		return 0
+	})
+	return f
+}
+
+func (f *featureFlag) on() bool { return f.enabled.Load() }
+
+// loadFlagDefaults reads each flag's default from its FEATURE_<NAME>.
+func loadFlagDefaults() {
+	for name, f := range featureFlags {
+		f.def = envBool("FEATURE_"+strings.ToUpper(name), true)
+		f.enabled.Store(f.def)
+	}
+}
+
+// requireFlag answers 404 with code feature_disabled while f is off, as if
+// the routes behind it didn't exist.
+func requireFlag(f *featureFlag) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !f.on() {
+				respond(w, r, http.StatusNotFound, renderer.M{
+					"message": "This feature is turned off",
+					"code":    "feature_disabled",
+					"feature": f.name,
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// runFlagPoller reloads the overrides every flagPollInterval, so a switch
+// made on another instance reaches this one.
+func runFlagPoller(ctx context.Context) {
+	t := time.NewTicker(flagPollInterval)
+	defer t.Stop()
+	for {
+		if err := reloadFlags(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("feature flags: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// reloadFlags applies the stored overrides. A flag without one goes back
+// to its default.
+func reloadFlags(parent context.Context) error {
+	ctx, cancel := context.WithTimeout(parent, 5*time.Second)
+	defer cancel()
+	cur, err := db.Collection(featureFlagCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var stored []featureFlagModel
+	if err := cur.All(ctx, &stored); err != nil {
+		return err
+	}
+	overrides := map[string]bool{}
+	for _, m := range stored {
+		overrides[m.Name] = m.Enabled
+	}
+	for name, f := range featureFlags {
+		v, ok := overrides[name]
+		if !ok {
+			v = f.def
+		}
+		f.enabled.Store(v)
+		f.overridden.Store(ok)
+	}
+	return nil
+}
+
+// flagStates maps each flag to whether it is on, for the capabilities
+// document.
+func flagStates() map[string]bool {
+	res := map[string]bool{}
+	for name, f := range featureFlags {
+		res[name] = f.on()
+	}
+	return res
+}
+
+func (f *featureFlag) status() renderer.M {
+	return renderer.M{
+		"name":        f.name,
+		"description": f.description,
+		"enabled":     f.on(),
+		"default":     f.def,
+		"overridden":  f.overridden.Load(),
+	}
+}
+
+func getFlags(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(featureFlags))
+	for name := range featureFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := make([]renderer.M, len(names))
+	for i, name := range names {
+		res[i] = featureFlags[name].status()
+	}
+	respond(w, r, http.StatusOK, renderer.M{"data": res})
+}
+
+// flagOf finds the flag of the route's {name}, answering the request itself
+// when there is none.
+func flagOf(w http.ResponseWriter, r *http.Request) (*featureFlag, bool) {
+	f, ok := featureFlags[chi.URLParam(r, "name")]
+	if !ok {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "No such feature flag"})
+	}
+	return f, ok
+}
+
+// putFlag switches a flag on every instance until it is reset.
+func putFlag(w http.ResponseWriter, r *http.Request) {
+	f, ok := flagOf(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Enabled == nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Enabled field is required", "field": "enabled"})
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	_, err := db.Collection(featureFlagCollection).ReplaceOne(ctx, bson.M{"_id": f.name},
+		featureFlagModel{Name: f.name, Enabled: *req.Enabled, UpdatedAt: time.Now()},
+		options.Replace().SetUpsert(true))
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to save feature flag", "error": err.Error()})
+		return
+	}
+	f.enabled.Store(*req.Enabled)
+	f.overridden.Store(true)
+	respond(w, r, http.StatusOK, renderer.M{"message": "Feature flag updated", "data": f.status()})
+}
+
+// deleteFlag drops a flag's override, putting it back to its default.
+func deleteFlag(w http.ResponseWriter, r *http.Request) {
+	f, ok := flagOf(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	if _, err := db.Collection(featureFlagCollection).DeleteOne(ctx, bson.M{"_id": f.name}); err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to reset feature flag", "error": err.Error()})
+		return
+	}
+	f.enabled.Store(f.def)
+	f.overridden.Store(false)
+	respond(w, r, http.StatusOK, renderer.M{"message": "Feature flag reset to its default", "data": f.status()})
+}
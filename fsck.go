@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const fsckBatchSize = 500
+
+// Kinds of problem reported by fsck.
+const (
+	fsckMissingCreatedAt  = "missing_created_at"
+	fsckEmptyTitle        = "empty_title"
+	fsckDuplicateShortID  = "duplicate_short_id"
+	fsckOrphanedShortID   = "orphaned_short_id"
+	fsckOrphanedDedupeKey = "orphaned_dedupe_key"
+)
+
+type (
+	fsckIssue struct {
+		Kind     string `json:"kind"`
+		ID       string `json:"id"`
+		Detail   string `json:"detail"`
+		Repaired bool   `json:"repaired"`
+	}
+	fsckReport struct {
+		Scanned    int64       `json:"scanned"`
+		Repair     bool        `json:"repair"`
+		Issues     []fsckIssue `json:"issues"`
+		Repaired   int         `json:"repaired"`
+		Unrepaired int         `json:"unrepaired"`
+	}
+)
+
+// runFsck checks the collections for documents older code could leave
+// behind and, with --repair, fixes what can be fixed safely. Without
+// --repair nothing is written. The JSON report goes to stdout and a
+// summary to stderr; the exit code is non-zero while problems remain.
+func runFsck(args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repair := fs.Bool("repair", false, "fix the problems that can be fixed")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	setup()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	rep := &fsckReport{Repair: *repair, Issues: []fsckIssue{}}
+	checks := []func(context.Context, *fsckReport) error{
+		fsckTodos,
+		fsckDuplicateShortIDs,
+		fsckShortIDReservations,
+		fsckDedupeKeys,
+	}
+	for _, check := range checks {
+		if err := check(ctx, rep); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return exitError
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(rep)
+
+	counts := map[string]int{}
+	for _, is := range rep.Issues {
+		counts[is.Kind]++
+	}
+	fmt.Fprintf(os.Stderr, "scanned %d todos, found %d problems, repaired %d\n", rep.Scanned, len(rep.Issues), rep.Repaired)
+	for _, kind := range []string{fsckMissingCreatedAt, fsckEmptyTitle, fsckDuplicateShortID, fsckOrphanedShortID, fsckOrphanedDedupeKey} {
+		if counts[kind] > 0 {
+			fmt.Fprintf(os.Stderr, "  %-20s %d\n", kind, counts[kind])
+		}
+	}
+	if rep.Unrepaired > 0 {
+		if !*repair {
+			fmt.Fprintln(os.Stderr, "run with --repair to fix what can be fixed")
+		}
+		return exitProblems
+	}
+	return exitOK
+}
+
+// add records an issue, running fix first when repairing. fix is nil for
+// problems that need a person to look at them.
+func (rep *fsckReport) add(ctx context.Context, is fsckIssue, fix func(context.Context) error) error {
+	if rep.Repair && fix != nil {
+		if err := fix(ctx); err != nil {
+			return fmt.Errorf("repairing %s %s: %w", is.Kind, is.ID, err)
+		}
+		is.Repaired = true
+		rep.Repaired++
+	} else {
+		rep.Unrepaired++
+	}
+	rep.Issues = append(rep.Issues, is)
+	return nil
+}
+
+// fsckTodos scans every todo for a missing creation time, which is
+// backfilled from the ObjectID, and an empty title, which is only reported.
+func fsckTodos(ctx context.Context, rep *fsckReport) error {
+	collection := db.Collection(collectionName)
+	opts := options.Find().
+		SetProjection(bson.M{"title": 1, "createAt": 1}).
+		SetBatchSize(fsckBatchSize)
+	cur, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var t struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			Title    string             `bson:"title"`
+			CreateAt *time.Time         `bson:"createAt"`
+		}
+		if err := cur.Decode(&t); err != nil {
+			return err
+		}
+		rep.Scanned++
+
+		if t.CreateAt == nil || t.CreateAt.IsZero() {
+			created := t.ID.Timestamp()
+			err := rep.add(ctx, fsckIssue{
+				Kind:   fsckMissingCreatedAt,
+				ID:     t.ID.Hex(),
+				Detail: "backfill from the ObjectID: " + created.Format(time.RFC3339),
+			}, func(ctx context.Context) error {
+				_, err := collection.UpdateOne(ctx, bson.M{"_id": t.ID}, bson.M{"$set": bson.M{"createAt": created}})
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if t.Title == "" {
+			rep.add(ctx, fsckIssue{Kind: fsckEmptyTitle, ID: t.ID.Hex(), Detail: "needs a title; review by hand"}, nil)
+		}
+	}
+	return cur.Err()
+}
+
+// fsckDuplicateShortIDs finds todos sharing a short ID, possible in data
+// written before the unique index existed. The oldest todo keeps it and
+// the others get fresh ones.
+func fsckDuplicateShortIDs(ctx context.Context, rep *fsckReport) error {
+	collection := db.Collection(collectionName)
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"shortId": bson.M{"$exists": true, "$ne": ""}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$group", Value: bson.M{"_id": "$shortId", "ids": bson.M{"$push": "$_id"}}}},
+		{{Key: "$match", Value: bson.M{"ids.1": bson.M{"$exists": true}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var groups []struct {
+		ShortID string               `bson:"_id"`
+		IDs     []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cur.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		for _, id := range g.IDs[1:] {
+			err := rep.add(ctx, fsckIssue{
+				Kind:   fsckDuplicateShortID,
+				ID:     id.Hex(),
+				Detail: fmt.Sprintf("shares short ID %s with %s", g.ShortID, g.IDs[0].Hex()),
+			}, func(ctx context.Context) error {
+				fresh, err := reserveShortID(ctx)
+				if err != nil {
+					return err
+				}
+				_, err = collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"shortId": fresh}})
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fsckShortIDReservations finds reservations held for todos that no longer
+// exist but were never released, so they would never expire. Repairing
+// starts their retention countdown.
+func fsckShortIDReservations(ctx context.Context, rep *fsckReport) error {
+	cur, err := db.Collection(shortIDCollection).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"expireAt": nil}}},
+		{{Key: "$lookup", Value: bson.M{"from": collectionName, "localField": "_id", "foreignField": "shortId", "as": "todos"}}},
+		{{Key: "$match", Value: bson.M{"todos": bson.M{"$size": 0}}}},
+		{{Key: "$project", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	var orphans []shortIDReservation
+	if err := cur.All(ctx, &orphans); err != nil {
+		return err
+	}
+
+	for _, o := range orphans {
+		err := rep.add(ctx, fsckIssue{
+			Kind:   fsckOrphanedShortID,
+			ID:     o.ID,
+			Detail: "reserved for a todo that no longer exists",
+		}, func(ctx context.Context) error {
+			return releaseShortID(ctx, o.ID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fsckDedupeKeys finds dedupe keys pointing at todos that don't exist,
+// which would answer creates with 409 until they expire. Keys claimed in
+// the last minute are skipped, as their create may still be running.
+func fsckDedupeKeys(ctx context.Context, rep *fsckReport) error {
+	collection := db.Collection(dedupeCollection)
+	claimedBefore := time.Now().Add(-time.Minute).Add(dedupeKeyTTL)
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"expireAt": bson.M{"$lt": claimedBefore}}}},
+		{{Key: "$lookup", Value: bson.M{"from": collectionName, "localField": "todoId", "foreignField": "_id", "as": "todos"}}},
+		{{Key: "$match", Value: bson.M{"todos": bson.M{"$size": 0}}}},
+		{{Key: "$project", Value: bson.M{"todos": 0}}},
+	})
+	if err != nil {
+		return err
+	}
+	var orphans []dedupeReservation
+	if err := cur.All(ctx, &orphans); err != nil {
+		return err
+	}
+
+	for _, o := range orphans {
+		err := rep.add(ctx, fsckIssue{
+			Kind:   fsckOrphanedDedupeKey,
+			ID:     o.Key,
+			Detail: "points at missing todo " + o.TodoID.Hex(),
+		}, func(ctx context.Context) error {
+			_, err := collection.DeleteOne(ctx, bson.M{"_id": o.Key, "todoId": o.TodoID})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// defaultNearRadius applies when ?near= is given without ?radius=.
+const defaultNearRadius = 1000.0
+
+type (
+	// geoPoint is a GeoJSON Point as stored in Mongo. Coordinates are
+	// [longitude, latitude], in that order.
+	geoPoint struct {
+		Type        string     `bson:"type"`
+		Coordinates [2]float64 `bson:"coordinates"`
+	}
+	// todoLocation is the API shape of a todo's location.
+	todoLocation struct {
+		Lat   *float64 `json:"lat" xml:"lat" schema:"required,min=-90,max=90"`
+		Lng   *float64 `json:"lng" xml:"lng" schema:"required,min=-180,max=180"`
+		Label string   `json:"label,omitempty" xml:"label,omitempty"`
+	}
+	// nearFilter is a parsed ?near=lat,lng&radius= query.
+	nearFilter struct {
+		Lat, Lng float64
+		Radius   float64 // meters
+	}
+)
+
+func newGeoPoint(lat, lng float64) *geoPoint {
+	return &geoPoint{Type: "Point", Coordinates: [2]float64{lng, lat}}
+}
+
+func (l *todoLocation) validate() error {
+	if l.Lat == nil || l.Lng == nil {
+		return fmt.Errorf("location needs both lat and lng")
+	}
+	return validateLatLng(*l.Lat, *l.Lng)
+}
+
+func validateLatLng(lat, lng float64) error {
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("latitude must be between -90 and 90")
+	}
+	if lng < -180 || lng > 180 {
+		return fmt.Errorf("longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// parseNear parses near as "lat,lng" and radius as a distance with an m or
+// km unit, e.g. "500m" or "1.5km".
+func parseNear(near, radius string) (*nearFilter, error) {
+	parts := strings.Split(near, ",")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("near must be lat,lng")
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("near latitude is not a number")
+	}
+	lng, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("near longitude is not a number")
+	}
+	if err := validateLatLng(lat, lng); err != nil {
+		return nil, err
+	}
+
+	f := &nearFilter{Lat: lat, Lng: lng, Radius: defaultNearRadius}
+	if radius == "" {
+		return f, nil
+	}
+
+	var scale float64
+	var num string
+	switch {
+	case strings.HasSuffix(radius, "km"):
+		scale, num = 1000, strings.TrimSuffix(radius, "km")
+	case strings.HasSuffix(radius, "m"):
+		scale, num = 1, strings.TrimSuffix(radius, "m")
+	default:
+		return nil, fmt.Errorf("radius needs a unit, e.g. 500m or 2km")
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("radius must be a positive distance")
+	}
+	f.Radius = n * scale
+	return f, nil
+}
+
+// pipeline returns the aggregation that finds todos within the radius of
+// the point, nearest first, restricted by query. $geoNear rather than
+// $nearSphere so the distance can be reported per result. Todos without a
+// location never match.
+func (f *nearFilter) pipeline(query bson.M, opts listOptions, explicitSort bool) mongo.Pipeline {
+	p := mongo.Pipeline{{{Key: "$geoNear", Value: bson.M{
+		"near":          newGeoPoint(f.Lat, f.Lng),
+		"distanceField": "distance",
+		"maxDistance":   f.Radius,
+		"spherical":     true,
+		"query":         query,
+	}}}}
+	if explicitSort {
+		p = append(p, bson.D{{Key: "$sort", Value: opts.sort()}})
+	}
+	if opts.Limit > 0 {
+		p = append(p,
+			bson.D{{Key: "$skip", Value: opts.skip()}},
+			bson.D{{Key: "$limit", Value: opts.Limit}})
+	}
+	if opts.fields != nil {
+		p = append(p, bson.D{{Key: "$project", Value: opts.fields}})
+	}
+	return p
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// healthHandler reports whether Mongo answers a ping, along with the age of
+// the last collection sample so a stuck sampler is visible. With stale
+// reads enabled it also says whether GET /todos is serving the snapshot.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	status, code := "ok", http.StatusOK
+	dbStatus := "ok"
+	if err := client.Ping(ctx, nil); err != nil {
+		status, code = "degraded", http.StatusServiceUnavailable
+		dbStatus = err.Error()
+	}
+
+	sampler := renderer.M{"interval_seconds": samplerInterval.Seconds()}
+	if age := sampleAge(); age >= 0 {
+		sampler["last_success_age_seconds"] = age.Seconds()
+	} else {
+		sampler["last_success_age_seconds"] = nil
+	}
+
+	res := renderer.M{
+		"status":  status,
+		"db":      dbStatus,
+		"sampler": sampler,
+	}
+	res["shedding"] = sheddingStatus()
+	res["maintenance"] = maintenanceStatus()
+	if staleSnapshots {
+		snapshot := renderer.M{"serving_stale": code != http.StatusOK && freshSnapshot() != nil, "age_seconds": nil}
+		if s := lastSnapshot.Load(); s != nil {
+			snapshot["age_seconds"] = time.Since(s.TakenAt).Seconds()
+		}
+		res["snapshot"] = snapshot
+	}
+	respond(w, r, code, res)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type contextKey int
+
+const (
+	todoIDKey contextKey = iota
+	demoSessionKey
+)
+
+// todoIDCtx validates the {id} of a route before its handler runs and
+// stores the todo's ObjectID in the request context. Short IDs are resolved
+// to the ObjectID they alias, so handlers only ever deal with _id.
+func todoIDCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter, ok := todoIDFilter(chi.URLParam(r, "id"))
+		if !ok {
+			respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid ID"})
+			return
+		}
+
+		id, ok := filter["_id"].(primitive.ObjectID)
+		if !ok {
+			var err error
+			id, err = resolveShortID(r, filter)
+			if err == mongo.ErrNoDocuments {
+				respond(w, r, http.StatusNotFound, renderer.M{"message": "Todo not found"})
+				return
+			}
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch todo", "error": err.Error()})
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), todoIDKey, id)))
+	})
+}
+
+func resolveShortID(r *http.Request, filter bson.M) (primitive.ObjectID, error) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	var t struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"_id": 1})
+	err := db.Collection(collectionName).FindOne(ctx, scoped(ctx, filter), opts).Decode(&t)
+	return t.ID, err
+}
+
+// todoID returns the ObjectID stored by todoIDCtx.
+func todoID(r *http.Request) primitive.ObjectID {
+	id, _ := r.Context().Value(todoIDKey).(primitive.ObjectID)
+	return id
+}
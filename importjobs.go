@@ -0,0 +1,621 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	importJobCollection = "importJobs"
+	// importErrorCollection holds the rows of each job's error report.
+	importErrorCollection = "importJobErrors"
+	// importBucket is the GridFS bucket uploaded files are kept in until
+	// their job expires.
+	importBucket = "imports"
+)
+
+var (
+	// importJobMaxBytes caps a file uploaded for an import job, through
+	// IMPORT_JOB_MAX_BYTES. Jobs have no row limit.
+	importJobMaxBytes int64 = 100 << 20
+	// importBatchSize is how many rows an import job validates and inserts
+	// at a time, through IMPORT_BATCH_SIZE.
+	importBatchSize = 100
+	// importJobTTL is how long a job, its file and its error report are
+	// kept after it finishes, through IMPORT_JOB_TTL.
+	importJobTTL = 24 * time.Hour
+)
+
+var (
+	errImportCancelled = errors.New("import job was cancelled")
+	// errImportLost means the job was deleted, or claimed by another
+	// worker after this one was taken for dead.
+	errImportLost = errors.New("import job is no longer held by this worker")
+)
+
+// importJob is a stored import. Processed counts the rows of the file
+// that are committed: their todos are inserted and their errors recorded.
+// A job started again after a crash carries on from there.
+type importJob struct {
+	ID              primitive.ObjectID `bson:"_id" json:"id" xml:"id"`
+	Status          string             `bson:"status" json:"status" xml:"status"`
+	FileID          primitive.ObjectID `bson:"fileId" json:"-" xml:"-"`
+	Processed       int64              `bson:"processed" json:"processed" xml:"processed"`
+	Inserted        int64              `bson:"inserted" json:"inserted" xml:"inserted"`
+	Skipped         int64              `bson:"skipped" json:"skipped" xml:"skipped"`
+	Error           string             `bson:"error,omitempty" json:"error,omitempty" xml:"error,omitempty"`
+	CancelRequested bool               `bson:"cancelRequested,omitempty" json:"-" xml:"-"`
+	Attempts        int                `bson:"attempts" json:"-" xml:"-"`
+	HeartbeatAt     *time.Time         `bson:"heartbeatAt,omitempty" json:"-" xml:"-"`
+	CreateAt        time.Time          `bson:"createAt" json:"created_at" xml:"created_at"`
+	StartedAt       *time.Time         `bson:"startedAt,omitempty" json:"started_at,omitempty" xml:"started_at,omitempty"`
+	FinishedAt      *time.Time         `bson:"finishedAt,omitempty" json:"finished_at,omitempty" xml:"finished_at,omitempty"`
+	ExpireAt        time.Time          `bson:"expireAt" json:"expires_at" xml:"expires_at"`
+	SessionID       string             `bson:"sessionId,omitempty" json:"-" xml:"-"`
+	ReportURL       string             `bson:"-" json:"report_url,omitempty" xml:"report_url,omitempty"`
+}
+
+// importError is one row of a job's error report. Its _id is derived from
+// the row, so recording a batch's errors again after a crash replaces them.
+type importError struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	JobID    primitive.ObjectID `bson:"jobId"`
+	Row      int                `bson:"row"`
+	Field    string             `bson:"field,omitempty"`
+	Reason   string             `bson:"reason"`
+	ExpireAt time.Time          `bson:"expireAt"`
+}
+
+func (j importJob) finished() bool {
+	return j.FinishedAt != nil
+}
+
+func (j importJob) withReportURL() importJob {
+	if j.finished() {
+		j.ReportURL = "/import-jobs/" + j.ID.Hex() + "/report"
+	}
+	return j
+}
+
+// importRowID derives an ObjectID from record n of job, kind telling the
+// todo of a row from its error. Inserting under it again is a duplicate key
+// rather than a second copy.
+func importRowID(job primitive.ObjectID, kind byte, n int64) primitive.ObjectID {
+	b := make([]byte, 0, 21)
+	b = append(b, job[:]...)
+	b = append(b, kind)
+	b = binary.BigEndian.AppendUint64(b, uint64(n))
+	sum := sha256.Sum256(b)
+	var id primitive.ObjectID
+	copy(id[:], sum[:])
+	return id
+}
+
+var importWake = make(chan struct{}, 1)
+
+func newImportBucket() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(db, options.GridFSBucket().SetName(importBucket))
+}
+
+// createImportJob stores an uploaded CSV, sent like to POST
+// /todos/import.csv, and queues a job to import it.
+func createImportJob(w http.ResponseWriter, r *http.Request) {
+	if !importHasRoom(w, r) {
+		return
+	}
+
+	body, ok := csvUpload(w, r, importJobMaxBytes)
+	if !ok {
+		return
+	}
+	defer body.Close()
+	// A large upload outlives the server's ReadTimeout.
+	http.NewResponseController(w).SetReadDeadline(time.Time{})
+
+	bucket, err := newImportBucket()
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to store import", "error": err.Error()})
+		return
+	}
+	fileID := primitive.NewObjectID()
+	if err := bucket.UploadFromStreamWithID(fileID, "import.csv", body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			csvReadFailed(w, r, err, importJobMaxBytes)
+			return
+		}
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to store import", "error": err.Error()})
+		return
+	}
+
+	// The upload may have taken a while, so the database work gets a fresh
+	// deadline.
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	now := time.Now()
+	job := importJob{
+		ID:        primitive.NewObjectID(),
+		Status:    jobQueued,
+		FileID:    fileID,
+		CreateAt:  now,
+		ExpireAt:  now.Add(importJobTTL),
+		SessionID: demoSessionOf(ctx).ID,
+	}
+	if _, err := db.Collection(importJobCollection).InsertOne(ctx, job); err != nil {
+		deleteImportFile(ctx, fileID)
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to create import", "error": err.Error()})
+		return
+	}
+	select {
+	case importWake <- struct{}{}:
+	default:
+	}
+
+	w.Header().Set("Location", "/import-jobs/"+job.ID.Hex())
+	respond(w, r, http.StatusAccepted, renderer.M{"message": "Import queued", "data": job})
+}
+
+// importHasRoom refuses an upload up front when the demo session is
+// already at its quota.
+func importHasRoom(w http.ResponseWriter, r *http.Request) bool {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	left, err := demoRoomLeft(ctx, collectionName)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to create import", "error": err.Error()})
+		return false
+	}
+	if left == 0 {
+		demoFull(w, r)
+		return false
+	}
+	return true
+}
+
+func importJobHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(requireFlag(flagImports))
+	rg.Get("/{id}", getImportJob)
+	rg.With(expensive).Get("/{id}/report", importReport)
+	rg.Delete("/{id}", deleteImportJob)
+	return rg
+}
+
+// findImportJob loads the job of the route's {id}, answering the request
+// itself when it can't.
+func findImportJob(w http.ResponseWriter, r *http.Request) (importJob, bool) {
+	var job importJob
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid ID"})
+		return job, false
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	err = db.Collection(importJobCollection).FindOne(ctx, scoped(ctx, bson.M{"_id": id})).Decode(&job)
+	if err == mongo.ErrNoDocuments || err == nil && job.finished() && job.ExpireAt.Before(time.Now()) {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Import not found"})
+		return job, false
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch import", "error": err.Error()})
+		return job, false
+	}
+	return job, true
+}
+
+func getImportJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := findImportJob(w, r)
+	if !ok {
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{"data": job.withReportURL()})
+}
+
+// importReport streams a finished job's skipped rows as CSV, in file order.
+func importReport(w http.ResponseWriter, r *http.Request) {
+	job, ok := findImportJob(w, r)
+	if !ok {
+		return
+	}
+	if !job.finished() {
+		respond(w, r, http.StatusConflict, renderer.M{"message": "Import is " + job.Status, "code": "import_not_finished"})
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "row", Value: 1}})
+	cur, err := db.Collection(importErrorCollection).Find(ctx, bson.M{"jobId": job.ID}, opts)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch import report", "error": err.Error()})
+		return
+	}
+	defer cur.Close(ctx)
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="import-`+job.ID.Hex()+`-errors.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"row", "field", "reason"})
+	for cur.Next(ctx) {
+		var e importError
+		if err := cur.Decode(&e); err != nil {
+			log.Printf("imports: report of %s: %v", job.ID.Hex(), err)
+			break
+		}
+		cw.Write([]string{strconv.Itoa(e.Row), e.Field, e.Reason})
+	}
+	cw.Flush()
+}
+
+// deleteImportJob cancels a job still queued or running, keeping the todos
+// it has already inserted. A running job stops after the batch in hand. A
+// finished job is removed along with its file and report.
+func deleteImportJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := findImportJob(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	coll := db.Collection(importJobCollection)
+	var (
+		res *mongo.UpdateResult
+		err error
+	)
+	now := time.Now()
+	switch job.Status {
+	case jobQueued:
+		res, err = coll.UpdateOne(ctx, bson.M{"_id": job.ID, "status": jobQueued},
+			bson.M{"$set": bson.M{"status": jobCancelled, "finishedAt": now, "expireAt": now.Add(importJobTTL)}})
+	case jobRunning:
+		res, err = coll.UpdateOne(ctx, bson.M{"_id": job.ID, "status": jobRunning},
+			bson.M{"$set": bson.M{"cancelRequested": true}})
+	default:
+		if err := removeImportJob(ctx, job); err != nil {
+			respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to delete import", "error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to cancel import", "error": err.Error()})
+		return
+	}
+	if res.MatchedCount == 0 {
+		respond(w, r, http.StatusConflict, renderer.M{"message": "Import changed while cancelling it, try again", "code": "import_changed"})
+		return
+	}
+	respond(w, r, http.StatusAccepted, renderer.M{"message": "Import cancelled"})
+}
+
+// removeImportJob deletes a job with its file and error report.
+func removeImportJob(ctx context.Context, job importJob) error {
+	if _, err := db.Collection(importJobCollection).DeleteOne(ctx, bson.M{"_id": job.ID}); err != nil {
+		return err
+	}
+	if _, err := db.Collection(importErrorCollection).DeleteMany(ctx, bson.M{"jobId": job.ID}); err != nil {
+		return err
+	}
+	return deleteImportFile(ctx, job.FileID)
+}
+
+func deleteImportFile(ctx context.Context, id primitive.ObjectID) error {
+	bucket, err := newImportBucket()
+	if err != nil {
+		return err
+	}
+	if err := bucket.DeleteContext(ctx, id); err != nil && err != gridfs.ErrFileNotFound {
+		return err
+	}
+	return nil
+}
+
+func ensureImportIndexes(ctx context.Context) {
+	_, err := db.Collection(importJobCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createAt", Value: 1}}},
+		{Keys: bson.D{{Key: "expireAt", Value: 1}}},
+	})
+	if err != nil {
+		log.Printf("Failed to create import job indexes: %v", err)
+	}
+	_, err = db.Collection(importErrorCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "jobId", Value: 1}, {Key: "row", Value: 1}},
+	})
+	if err != nil {
+		log.Printf("Failed to create import error indexes: %v", err)
+	}
+}
+
+// runImports runs queued import jobs one at a time until ctx is cancelled,
+// like runExports.
+func runImports(ctx context.Context) {
+	t := time.NewTicker(5 * time.Second)
+	defer t.Stop()
+	for {
+		recoverJobs(ctx, importJobCollection, importJobTTL)
+		// Queued imports wait out maintenance or the imports flag being off;
+		// one already running finishes.
+		for ctx.Err() == nil && !maintenance.Load() && flagImports.on() {
+			job, ok := claimImport(ctx)
+			if !ok {
+				break
+			}
+			runImport(ctx, job)
+		}
+		sweepImports(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-importWake:
+		}
+	}
+}
+
+// claimImport takes the oldest queued job. Like exports, starting an
+// attempt bumps Attempts, which fences off any worker still holding an
+// earlier one.
+func claimImport(parent context.Context) (importJob, bool) {
+	ctx, cancel := context.WithTimeout(parent, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var job importJob
+	err := db.Collection(importJobCollection).FindOneAndUpdate(ctx,
+		bson.M{"status": jobQueued},
+		bson.M{
+			"$set": bson.M{"status": jobRunning, "heartbeatAt": now},
+			"$min": bson.M{"startedAt": now},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "createAt", Value: 1}}).SetReturnDocument(options.After),
+	).Decode(&job)
+	if err != nil {
+		if err != mongo.ErrNoDocuments && parent.Err() == nil {
+			log.Printf("imports: claiming job: %v", err)
+		}
+		return job, false
+	}
+	return job, true
+}
+
+// runImport imports the rest of job's file and records how it ended. A job
+// stopped by shutdown goes back in the queue and resumes after its last
+// committed batch.
+func runImport(parent context.Context, job importJob) {
+	ctx := withDemoSession(parent, job.SessionID)
+	err := processImport(ctx, job)
+
+	fin, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	now := time.Now()
+	set := bson.M{"finishedAt": now, "expireAt": now.Add(importJobTTL)}
+	switch {
+	case err == nil:
+		set["status"] = jobDone
+	case errors.Is(err, errImportLost):
+		return
+	case parent.Err() != nil:
+		set = bson.M{"status": jobQueued, "attempts": job.Attempts - 1}
+	case errors.Is(err, errImportCancelled):
+		set["status"] = jobCancelled
+	default:
+		log.Printf("imports: job %s: %v", job.ID.Hex(), err)
+		set["status"] = jobFailed
+		set["error"] = err.Error()
+	}
+
+	_, err = db.Collection(importJobCollection).UpdateOne(fin,
+		bson.M{"_id": job.ID, "status": jobRunning, "attempts": job.Attempts},
+		bson.M{"$set": set})
+	if err != nil {
+		log.Printf("imports: job %s: recording result: %v", job.ID.Hex(), err)
+	}
+}
+
+// processImport reads job's file from the first uncommitted row and
+// imports it importBatchSize rows at a time. In a demo session it stops
+// with errDemoFull as soon as the session's quota is reached, keeping the
+// rows before.
+func processImport(ctx context.Context, job importJob) error {
+	bucket, err := newImportBucket()
+	if err != nil {
+		return err
+	}
+	ds, err := bucket.OpenDownloadStream(job.FileID)
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+
+	cr := csv.NewReader(ds)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return fmt.Errorf("the CSV is empty")
+	}
+	if err != nil {
+		return err
+	}
+	cols, err := parseCSVHeader(header)
+	if err != nil {
+		return err
+	}
+
+	n := int64(0)
+	for ; n < job.Processed; n++ {
+		if _, err := cr.Read(); err != nil {
+			return fmt.Errorf("reading past committed rows: %w", err)
+		}
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		left, err := demoRoomLeft(ctx, collectionName)
+		if err != nil {
+			return err
+		}
+
+		var (
+			valid   []todo
+			rows    []int
+			ids     []primitive.ObjectID
+			skipped []csvSkippedRow
+			full    bool
+			start   = n
+			eof     bool
+		)
+		for n-start < int64(importBatchSize) {
+			record, err := cr.Read()
+			if err == io.EOF {
+				eof = true
+				break
+			}
+			if err != nil {
+				return err
+			}
+			line, _ := cr.FieldPos(0)
+			t, m := csvTodo(cols, record)
+			if m != nil {
+				skipped = append(skipped, csvSkipped(line, m))
+				n++
+				continue
+			}
+			if left >= 0 && len(valid) == left {
+				full = true
+				break
+			}
+			valid = append(valid, t)
+			rows = append(rows, line)
+			ids = append(ids, importRowID(job.ID, 't', n))
+			n++
+		}
+		if n == start && !full {
+			return nil
+		}
+
+		inserted, failed, err := insertImported(ctx, valid, rows, ids)
+		if err != nil {
+			return err
+		}
+		skipped = append(skipped, failed...)
+		if err := recordImportErrors(ctx, job, skipped); err != nil {
+			return err
+		}
+		if err := commitImportBatch(ctx, job, n, inserted, len(skipped)); err != nil {
+			return err
+		}
+		switch {
+		case full:
+			return errDemoFull
+		case eof:
+			return nil
+		}
+	}
+}
+
+// recordImportErrors stores the skipped rows of a batch in the job's error
+// report.
+func recordImportErrors(ctx context.Context, job importJob, skipped []csvSkippedRow) error {
+	if len(skipped) == 0 {
+		return nil
+	}
+	expireAt := time.Now().Add(importJobTTL)
+	models := make([]mongo.WriteModel, len(skipped))
+	for i, s := range skipped {
+		e := importError{
+			ID:       importRowID(job.ID, 'e', int64(s.Row)),
+			JobID:    job.ID,
+			Row:      s.Row,
+			Field:    s.Field,
+			Reason:   s.Reason,
+			ExpireAt: expireAt,
+		}
+		models[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": e.ID}).SetReplacement(e).SetUpsert(true)
+	}
+	_, err := db.Collection(importErrorCollection).BulkWrite(ctx, models)
+	return err
+}
+
+// commitImportBatch records a batch as done, up to row n of the file. It
+// fails with errImportCancelled once a cancel has been asked for, which
+// the next batch would otherwise ignore.
+func commitImportBatch(ctx context.Context, job importJob, n int64, inserted, skipped int) error {
+	var after importJob
+	err := db.Collection(importJobCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": job.ID, "status": jobRunning, "attempts": job.Attempts},
+		bson.M{
+			"$set": bson.M{"processed": n, "heartbeatAt": time.Now()},
+			"$inc": bson.M{"inserted": inserted, "skipped": skipped},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&after)
+	if err == mongo.ErrNoDocuments {
+		return errImportLost
+	}
+	if err != nil {
+		return err
+	}
+	if after.CancelRequested {
+		return errImportCancelled
+	}
+	return nil
+}
+
+// sweepImports removes jobs past their expiry, with their files and
+// reports. The todos they imported stay.
+func sweepImports(parent context.Context) {
+	ctx, cancel := context.WithTimeout(parent, 30*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "fileId": 1})
+	cur, err := db.Collection(importJobCollection).Find(ctx,
+		bson.M{"status": bson.M{"$ne": jobRunning}, "expireAt": bson.M{"$lt": time.Now()}}, opts)
+	if err != nil {
+		if parent.Err() == nil {
+			log.Printf("imports: sweeping: %v", err)
+		}
+		return
+	}
+	var expired []importJob
+	if err := cur.All(ctx, &expired); err != nil {
+		if parent.Err() == nil {
+			log.Printf("imports: sweeping: %v", err)
+		}
+		return
+	}
+	for _, job := range expired {
+		if err := removeImportJob(ctx, job); err != nil {
+			log.Printf("imports: removing job %s: %v", job.ID.Hex(), err)
+		}
+	}
+}
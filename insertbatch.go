@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Creates may be batched under load: rather than one InsertOne each, they
+// are queued and written together with InsertMany once createBatchSize are
+// waiting or createBatchInterval has passed since the first of them. A
+// create that can't be queued within createBatchMaxWait, because the queue
+// is full, is inserted on its own, so no request waits on the batcher for
+// longer than that plus the interval and the insert itself.
+var (
+	// createBatchSize is how many creates are written at once, through
+	// CREATE_BATCH_SIZE. 0 turns batching off.
+	createBatchSize int
+	// createBatchInterval is the longest a queued create waits for others,
+	// through CREATE_BATCH_INTERVAL.
+	createBatchInterval = 5 * time.Millisecond
+	// createBatchMaxWait is the longest a create waits to be queued,
+	// through CREATE_BATCH_MAX_WAIT.
+	createBatchMaxWait = 50 * time.Millisecond
+
+	// createQueue is nil while batching is off.
+	createQueue chan *pendingCreate
+
+	createBatches       = newCounter("todo_create_batches_total", "InsertMany calls made by the create batcher.")
+	createBatchedTodos  = newCounter("todo_create_batched_total", "Todos inserted by the create batcher.")
+	createBatchFallback = newCounter("todo_create_batch_fallback_total", "Creates inserted on their own because the batch queue was full.")
+)
+
+// pendingCreate is a queued create. The batcher gives tm its position and
+// sends the outcome on done.
+type pendingCreate struct {
+	tm   *todoModel
+	done chan error
+}
+
+func initCreateBatching() {
+	if createBatchSize > 0 {
+		createQueue = make(chan *pendingCreate, createBatchSize*4)
+	}
+}
+
+// insertBatched inserts tm through the batcher, or on its own if it can't
+// be queued in time. It reports false if batching is off or doesn't apply:
+// demo sessions each have their own positions and quota, so their todos are
+// always inserted one at a time.
+func insertBatched(ctx context.Context, tm *todoModel) (bool, error) {
+	if createQueue == nil || tm.SessionID != "" {
+		return false, nil
+	}
+	p := &pendingCreate{tm: tm, done: make(chan error, 1)}
+	wait := time.NewTimer(createBatchMaxWait)
+	defer wait.Stop()
+	select {
+	case createQueue <- p:
+	case <-wait.C:
+		createBatchFallback.Inc()
+		return false, nil
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+	select {
+	case err := <-p.done:
+		return true, err
+	case <-ctx.Done():
+		// The todo may still be written by the batch it is in, which will
+		// say so on done.
+		return true, &queuedCreateError{err: ctx.Err(), done: p.done}
+	}
+}
+
+// queuedCreateError is returned by insertBatched when the caller gave up
+// waiting on a create that was already queued. Its batch still reports the
+// outcome on done.
+type queuedCreateError struct {
+	err  error
+	done <-chan error
+}
+
+func (e *queuedCreateError) Error() string { return e.err.Error() }
+func (e *queuedCreateError) Unwrap() error { return e.err }
+
+// settleQueuedCreate finishes a create whose request gave up after it was
+// queued, once its batch reports: a todo that was written is announced and
+// recorded like any other, and only one that wasn't gives back its short ID.
+func settleQueuedCreate(tm *todoModel, done <-chan error) {
+	if err := <-done; err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if rerr := releaseShortID(ctx, tm.ShortID); rerr != nil {
+			log.Printf("releasing short ID %s: %v", tm.ShortID, rerr)
+		}
+		return
+	}
+	todosChanged()
+	recordChange(auditCreate, nil, tm)
+}
+
+// runCreateBatcher collects queued creates into batches and writes them.
+// What is queued when it stops is still written.
+func runCreateBatcher(ctx context.Context) {
+	var (
+		batch []*pendingCreate
+		timer = time.NewTimer(0)
+	)
+	<-timer.C
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case p := <-createQueue:
+					batch = append(batch, p)
+				default:
+					flushCreates(batch)
+					return
+				}
+			}
+		case p := <-createQueue:
+			batch = append(batch, p)
+			if len(batch) == 1 {
+				timer.Reset(createBatchInterval)
+			}
+			if len(batch) < createBatchSize {
+				continue
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+		flushCreates(batch)
+		batch = nil
+	}
+}
+
+// flushCreates writes a batch with one unordered InsertMany, so one bad
+// document doesn't hold back the rest, and tells each waiting create how
+// its own document fared.
+func flushCreates(batch []*pendingCreate) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pos, err := edgePosition(ctx, false)
+	if err != nil {
+		for _, p := range batch {
+			p.done <- err
+		}
+		return
+	}
+	docs := make([]interface{}, len(batch))
+	for i, p := range batch {
+		p.tm.Position = pos + float64(i)
+		docs[i] = *p.tm
+	}
+
+	createBatches.Inc()
+	_, err = db.Collection(collectionName).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	failed := map[int]error{}
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && bwe.WriteConcernError == nil {
+		for _, we := range bwe.WriteErrors {
+			failed[we.Index] = mongo.WriteException{WriteErrors: mongo.WriteErrors{we.WriteError}}
+		}
+		err = nil
+	}
+	for i, p := range batch {
+		switch {
+		case err != nil:
+			p.done <- err
+		case failed[i] != nil:
+			p.done <- failed[i]
+		default:
+			createBatchedTodos.Inc()
+			p.done <- nil
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// withCreateQueue turns batching on with a queue nothing drains, so the
+// test plays the batcher.
+func withCreateQueue(t *testing.T) {
+	prev := createQueue
+	createQueue = make(chan *pendingCreate, 1)
+	t.Cleanup(func() { createQueue = prev })
+}
+
+// A create whose request gives up once queued reports a queuedCreateError,
+// carrying the batch's eventual verdict, rather than a plain failure.
+func TestInsertBatchedGivesUpWhenQueued(t *testing.T) {
+	withCreateQueue(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		p := <-createQueue
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		p.done <- errors.New("batch failed")
+	}()
+
+	batched, err := insertBatched(ctx, &todoModel{ID: primitive.NewObjectID()})
+	var qe *queuedCreateError
+	if !batched || !errors.As(err, &qe) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("insertBatched = %v, %v; want a queuedCreateError wrapping context.Canceled", batched, err)
+	}
+	select {
+	case err := <-qe.done:
+		if err == nil || err.Error() != "batch failed" {
+			t.Errorf("verdict = %v; want the batch's error", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no verdict from the batch")
+	}
+}
+
+// A create whose request gives up before it is queued was never handed to
+// a batch, so its error is the plain context error.
+func TestInsertBatchedGivesUpBeforeQueued(t *testing.T) {
+	withCreateQueue(t)
+	createQueue <- &pendingCreate{}
+	defer func(d time.Duration) { createBatchMaxWait = d }(createBatchMaxWait)
+	createBatchMaxWait = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := insertBatched(ctx, &todoModel{ID: primitive.NewObjectID()})
+	var qe *queuedCreateError
+	if errors.As(err, &qe) || !errors.Is(err, context.Canceled) {
+		t.Errorf("insertBatched = %v; want context.Canceled alone", err)
+	}
+}
+
+// A queued create written after its request gave up is recorded like any
+// other.
+func TestSettleQueuedCreateWritten(t *testing.T) {
+	drainAudit()
+	tm := todoModel{ID: primitive.NewObjectID(), ShortID: "abc2345"}
+	done := make(chan error, 1)
+	done <- nil
+	settleQueuedCreate(&tm, done)
+
+	entries := drainAudit()
+	if len(entries) != 1 || entries[0].Type != auditCreate || entries[0].TodoID != tm.ID {
+		t.Errorf("history = %+v; want the create of %s", entries, tm.ID.Hex())
+	}
+}
+
+// A queued create its batch failed to write gives back its short ID, and
+// only then.
+func TestSettleQueuedCreateFailed(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("failed", func(mt *mtest.T) {
+		useMockDB(mt)
+		drainAudit()
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		tm := todoModel{ID: primitive.NewObjectID(), ShortID: "abc2345"}
+		done := make(chan error, 1)
+		done <- errors.New("batch failed")
+		settleQueuedCreate(&tm, done)
+
+		started := mt.GetAllStartedEvents()
+		if len(started) != 1 || started[0].CommandName != "update" || started[0].Command.Lookup("update").StringValue() != shortIDCollection {
+			mt.Errorf("commands = %v; want the short ID released", started)
+		}
+		if entries := drainAudit(); len(entries) != 0 {
+			mt.Errorf("history = %+v; want nothing for an unwritten todo", entries)
+		}
+	})
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+)
+
+// jsonAPIMediaType is the JSON:API media type. Clients that send it in
+// Accept get every response as a JSON:API document; clients that send it
+// as Content-Type may send bodies as one.
+const jsonAPIMediaType = "application/vnd.api+json"
+
+// jsonAPIResource is a value that serializes as a JSON:API resource
+// object rather than being left in meta.
+type jsonAPIResource interface {
+	jsonAPIType() string
+	jsonAPIID() string
+}
+
+// jsonAPIRelated is a resource with relationships. The keys name both the
+// relationship and the field it replaces in attributes.
+type jsonAPIRelated interface {
+	jsonAPIRelationships() map[string]interface{}
+}
+
+type jsonAPIIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func (t todo) jsonAPIType() string { return "todos" }
+func (t todo) jsonAPIID() string   { return t.ID }
+
+// jsonAPIRelationships links a todo to its tags and to the list, or
+// bucket, it is in.
+func (t todo) jsonAPIRelationships() map[string]interface{} {
+	tags := make([]jsonAPIIdentifier, len(t.Tags))
+	for i, tag := range t.Tags {
+		tags[i] = jsonAPIIdentifier{Type: "tags", ID: tag}
+	}
+	return map[string]interface{}{
+		"tags":   renderer.M{"data": tags},
+		"bucket": renderer.M{"data": jsonAPIIdentifier{Type: "buckets", ID: t.Bucket}},
+	}
+}
+
+func (t todoTemplate) jsonAPIType() string { return "templates" }
+func (t todoTemplate) jsonAPIID() string   { return t.ID }
+
+func (t tagCount) jsonAPIType() string { return "tags" }
+func (t tagCount) jsonAPIID() string   { return t.Tag }
+
+func (j exportJob) jsonAPIType() string { return "export-jobs" }
+func (j exportJob) jsonAPIID() string   { return j.ID.Hex() }
+
+func (j importJob) jsonAPIType() string { return "import-jobs" }
+func (j importJob) jsonAPIID() string   { return j.ID.Hex() }
+
+// wantsJSONAPI reports whether the Accept header asks for JSON:API.
+func wantsJSONAPI(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == jsonAPIMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func isJSONAPIRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == jsonAPIMediaType
+}
+
+// respondJSONAPI writes the envelope v as a JSON:API document.
+func respondJSONAPI(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	doc, err := jsonAPIDocument(r, status, v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		doc = renderer.M{"errors": []renderer.M{{"status": "500", "title": "Failed to encode JSON:API", "detail": err.Error()}}}
+	}
+	w.Header().Set("Content-Type", jsonAPIMediaType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(doc)
+}
+
+// jsonAPIDocument reshapes a response envelope. Errors become an errors
+// array. Otherwise data becomes resource objects if it holds resources, and
+// every other key of the envelope, paging included, goes in meta.
+func jsonAPIDocument(r *http.Request, status int, v interface{}) (renderer.M, error) {
+	m, ok := v.(renderer.M)
+	if !ok {
+		m = renderer.M{"data": v}
+	}
+	if status >= http.StatusBadRequest {
+		return renderer.M{"errors": []renderer.M{jsonAPIError(r, status, m)}}, nil
+	}
+
+	doc := renderer.M{}
+	meta := renderer.M{}
+	for k, val := range m {
+		switch k {
+		case "data":
+			data, ok, err := jsonAPIData(val)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				doc["data"] = data
+				continue
+			}
+			meta[k] = val
+		case "meta":
+			if inner, ok := val.(renderer.M); ok {
+				for ik, iv := range inner {
+					meta[ik] = iv
+				}
+				continue
+			}
+			meta[k] = val
+		default:
+			meta[k] = val
+		}
+	}
+	if len(meta) > 0 {
+		doc["meta"] = namedJSON(meta)
+	}
+	if _, ok := doc["data"]; !ok && len(meta) == 0 {
+		doc["meta"] = renderer.M{}
+	}
+	return doc, nil
+}
+
+// jsonAPIData converts a resource, or a slice of them, to resource
+// objects. It reports false for anything else.
+func jsonAPIData(v interface{}) (interface{}, bool, error) {
+	if res, ok := v.(jsonAPIResource); ok {
+		obj, err := jsonAPIObject(res)
+		return obj, err == nil, err
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice || !rv.Type().Elem().Implements(reflect.TypeOf((*jsonAPIResource)(nil)).Elem()) {
+		return nil, false, nil
+	}
+	out := make([]renderer.M, rv.Len())
+	for i := range out {
+		obj, err := jsonAPIObject(rv.Index(i).Interface().(jsonAPIResource))
+		if err != nil {
+			return nil, false, err
+		}
+		out[i] = obj
+	}
+	return out, true, nil
+}
+
+// jsonAPIObject builds the resource object of res. Its attributes are its
+// usual JSON fields, named as JSON_NAMING says, less the id and whatever
+// its relationships stand for.
+func jsonAPIObject(res jsonAPIResource) (renderer.M, error) {
+	b, err := json.Marshal(namedJSON(res))
+	if err != nil {
+		return nil, err
+	}
+	attrs := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &attrs); err != nil {
+		return nil, err
+	}
+	delete(attrs, "id")
+
+	obj := renderer.M{"type": res.jsonAPIType(), "id": res.jsonAPIID(), "attributes": attrs}
+	if rel, ok := res.(jsonAPIRelated); ok {
+		rels := rel.jsonAPIRelationships()
+		for name := range rels {
+			delete(attrs, name)
+		}
+		obj["relationships"] = rels
+	}
+	return obj, nil
+}
+
+// jsonAPIError converts an error envelope. A field names the member of the
+// body at fault, or the query parameter for requests without one.
+func jsonAPIError(r *http.Request, status int, m renderer.M) renderer.M {
+	e := renderer.M{"status": strconv.Itoa(status)}
+	meta := renderer.M{}
+	for k, v := range m {
+		switch k {
+		case "message":
+			e["title"] = v
+		case "error":
+			e["detail"] = v
+		case "code":
+			e["code"] = v
+		case "field":
+			field, _ := v.(string)
+			switch {
+			case r.Method == http.MethodGet || r.Method == http.MethodDelete:
+				e["source"] = renderer.M{"parameter": field}
+			case field == "tags" || field == "bucket":
+				e["source"] = renderer.M{"pointer": "/data/relationships/" + field}
+			default:
+				e["source"] = renderer.M{"pointer": "/data/attributes/" + strings.ReplaceAll(field, ".", "/")}
+			}
+		default:
+			meta[k] = v
+		}
+	}
+	if len(meta) > 0 {
+		e["meta"] = namedJSON(meta)
+	}
+	return e
+}
+
+// jsonAPIResourceBody is the data of a JSON:API request document.
+type jsonAPIResourceBody struct {
+	Type          string                     `json:"type"`
+	ID            string                     `json:"id"`
+	Attributes    map[string]json.RawMessage `json:"attributes"`
+	Relationships map[string]struct {
+		Data json.RawMessage `json:"data"`
+	} `json:"relationships"`
+}
+
+// unwrapJSONAPI turns a JSON:API request document into the plain JSON body
+// handlers decode: attributes become fields, and each relationship a field
+// holding the id, or ids, it links to. data may also be an array, for
+// batch creates. A body that isn't valid JSON is returned unchanged for
+// decodeJSONBody to report.
+func unwrapJSONAPI(r *http.Request, data []byte) ([]byte, int, renderer.M) {
+	var doc struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return data, 0, nil
+	}
+	bad := func(msg string) ([]byte, int, renderer.M) {
+		return nil, http.StatusBadRequest, renderer.M{"message": msg, "code": "invalid_jsonapi"}
+	}
+
+	var resources []jsonAPIResourceBody
+	many := bytes.HasPrefix(bytes.TrimSpace(doc.Data), []byte("["))
+	if many {
+		if err := json.Unmarshal(doc.Data, &resources); err != nil {
+			return bad("data must be an array of resource objects")
+		}
+	} else {
+		var one jsonAPIResourceBody
+		if len(doc.Data) == 0 || json.Unmarshal(doc.Data, &one) != nil || one.Type == "" {
+			return bad("A JSON:API body needs a data member holding a resource object with a type")
+		}
+		resources = []jsonAPIResourceBody{one}
+	}
+
+	urlID := chi.URLParam(r, "id")
+	flat := make([]map[string]json.RawMessage, len(resources))
+	for i, res := range resources {
+		// The spec answers an id naming another resource with 409.
+		if res.ID != "" && urlID != "" && res.ID != urlID && res.ID != todoID(r).Hex() {
+			return nil, http.StatusConflict, renderer.M{"message": "The id in the body does not match the URL", "field": "id", "code": "id_mismatch"}
+		}
+		obj := map[string]json.RawMessage{}
+		for k, v := range res.Attributes {
+			obj[k] = v
+		}
+		for name, rel := range res.Relationships {
+			linked, ok := jsonAPILinkedIDs(rel.Data)
+			if !ok {
+				return bad("Relationship " + name + " must hold resource identifiers")
+			}
+			obj[name] = linked
+		}
+		flat[i] = obj
+	}
+	var out []byte
+	var err error
+	if many {
+		out, err = json.Marshal(flat)
+	} else {
+		out, err = json.Marshal(flat[0])
+	}
+	if err != nil {
+		return bad(err.Error())
+	}
+	return out, 0, nil
+}
+
+// jsonAPILinkedIDs reduces a relationship's data, null, an identifier or an
+// array of them, to null, an id or an array of ids.
+func jsonAPILinkedIDs(data json.RawMessage) (json.RawMessage, bool) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return json.RawMessage("null"), true
+	}
+	if trimmed[0] == '[' {
+		var ids []jsonAPIIdentifier
+		if json.Unmarshal(trimmed, &ids) != nil {
+			return nil, false
+		}
+		out := make([]string, len(ids))
+		for i, id := range ids {
+			out[i] = id.ID
+		}
+		b, _ := json.Marshal(out)
+		return b, true
+	}
+	var id jsonAPIIdentifier
+	if json.Unmarshal(trimmed, &id) != nil {
+		return nil, false
+	}
+	b, _ := json.Marshal(id.ID)
+	return b, true
+}
+
+// jsonAPIBody replaces a JSON:API request body with its plain JSON form.
+// The original is read no further than decodeJSONBody would, so an
+// oversized body still gets its 413.
+func jsonAPIBody(r *http.Request) (int, renderer.M) {
+	if r.Body == nil {
+		return 0, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	if err != nil || int64(len(data)) > maxBodyBytes {
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), r.Body))
+		return 0, nil
+	}
+	out, status, m := unwrapJSONAPI(r, data)
+	if m != nil {
+		return status, m
+	}
+	r.Body = io.NopCloser(bytes.NewReader(out))
+	return 0, nil
+}
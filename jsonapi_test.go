@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// respondBoth renders an envelope once as plain JSON and once as JSON:API,
+// and decodes both.
+func respondBoth(t *testing.T, method string, status int, v interface{}) (plain, api map[string]interface{}) {
+	t.Helper()
+	rnd = renderer.New()
+	for _, accept := range []string{"application/json", jsonAPIMediaType} {
+		r := httptest.NewRequest(method, "/todos", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		respond(w, r, status, v)
+		if w.Code != status {
+			t.Fatalf("Accept %s: status %d; want %d", accept, w.Code, status)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("Accept %s: %v in %s", accept, err, w.Body)
+		}
+		if accept == jsonAPIMediaType {
+			if ct := w.Header().Get("Content-Type"); ct != jsonAPIMediaType {
+				t.Errorf("Content-Type = %q; want %s", ct, jsonAPIMediaType)
+			}
+			api = doc
+		} else {
+			plain = doc
+		}
+	}
+	return plain, api
+}
+
+// TestResponseShapes renders the envelopes of the endpoints both ways: a
+// list with paging, one todo, each other kind of resource, a bare message
+// and errors.
+func TestResponseShapes(t *testing.T) {
+	td := todo{ID: "65f0c0ffee0000000000beef", Title: "write tests", Tags: []string{"work"}, Bucket: bucketInbox, Status: statusTodo}
+	jobID := primitive.NewObjectID()
+
+	t.Run("list", func(t *testing.T) {
+		plain, api := respondBoth(t, http.MethodGet, http.StatusOK, renderer.M{
+			"data":   []todo{td},
+			"paging": renderer.M{"page": 1, "limit": 20},
+			"meta":   renderer.M{"counts": renderer.M{"open": 1}},
+		})
+		items, _ := plain["data"].([]interface{})
+		if len(items) != 1 || items[0].(map[string]interface{})["id"] != td.ID || plain["paging"] == nil {
+			t.Errorf("plain = %v; want the todo with its id and paging", plain)
+		}
+		objs, _ := api["data"].([]interface{})
+		if len(objs) != 1 {
+			t.Fatalf("JSON:API data = %v; want one resource", api["data"])
+		}
+		checkTodoResource(t, objs[0], td)
+		meta, _ := api["meta"].(map[string]interface{})
+		if meta["paging"] == nil || meta["counts"] == nil {
+			t.Errorf("JSON:API meta = %v; want paging and counts", meta)
+		}
+	})
+
+	t.Run("one todo", func(t *testing.T) {
+		plain, api := respondBoth(t, http.MethodPost, http.StatusCreated, renderer.M{"message": "Todo created successfully", "data": td})
+		if data, _ := plain["data"].(map[string]interface{}); data["title"] != td.Title || plain["message"] == nil {
+			t.Errorf("plain = %v; want the todo and the message", plain)
+		}
+		checkTodoResource(t, api["data"], td)
+		if meta, _ := api["meta"].(map[string]interface{}); meta["message"] != "Todo created successfully" {
+			t.Errorf("JSON:API meta = %v; want the message", meta)
+		}
+	})
+
+	for _, tc := range []struct {
+		name, typ, id string
+		data          interface{}
+	}{
+		{"templates", "templates", "weekly", []todoTemplate{{ID: "weekly", Name: "weekly review", Title: "review the week"}}},
+		{"tags", "tags", "work", []tagCount{{Tag: "work", Count: 3}}},
+		{"export job", "export-jobs", jobID.Hex(), exportJob{ID: jobID, Status: "done"}},
+		{"import job", "import-jobs", jobID.Hex(), importJob{ID: jobID, Status: "done"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			plain, api := respondBoth(t, http.MethodGet, http.StatusOK, renderer.M{"data": tc.data})
+			if plain["data"] == nil || plain["errors"] != nil {
+				t.Errorf("plain = %v; want data", plain)
+			}
+			obj := api["data"]
+			if objs, ok := obj.([]interface{}); ok {
+				if len(objs) != 1 {
+					t.Fatalf("JSON:API data = %v; want one resource", objs)
+				}
+				obj = objs[0]
+			}
+			res, _ := obj.(map[string]interface{})
+			attrs, _ := res["attributes"].(map[string]interface{})
+			if res["type"] != tc.typ || res["id"] != tc.id || attrs == nil || attrs["id"] != nil {
+				t.Errorf("JSON:API resource = %v; want %s %s with attributes but no id among them", res, tc.typ, tc.id)
+			}
+		})
+	}
+
+	t.Run("message", func(t *testing.T) {
+		plain, api := respondBoth(t, http.MethodDelete, http.StatusOK, renderer.M{"message": "Todo deleted successfully"})
+		if plain["message"] != "Todo deleted successfully" {
+			t.Errorf("plain = %v; want the message", plain)
+		}
+		if meta, _ := api["meta"].(map[string]interface{}); api["data"] != nil || meta["message"] != "Todo deleted successfully" {
+			t.Errorf("JSON:API = %v; want the message in meta and no data", api)
+		}
+	})
+
+	for _, tc := range []struct {
+		method string
+		field  string
+		source map[string]interface{}
+	}{
+		{http.MethodPost, "title", map[string]interface{}{"pointer": "/data/attributes/title"}},
+		{http.MethodPut, "tags", map[string]interface{}{"pointer": "/data/relationships/tags"}},
+		{http.MethodGet, "limit", map[string]interface{}{"parameter": "limit"}},
+	} {
+		t.Run("error on "+tc.field, func(t *testing.T) {
+			plain, api := respondBoth(t, tc.method, http.StatusBadRequest, renderer.M{"message": "Invalid " + tc.field, "field": tc.field, "code": "invalid"})
+			if plain["message"] != "Invalid "+tc.field || plain["field"] != tc.field || plain["code"] != "invalid" {
+				t.Errorf("plain = %v; want the message, field and code", plain)
+			}
+			errs, _ := api["errors"].([]interface{})
+			if len(errs) != 1 {
+				t.Fatalf("JSON:API = %v; want one error", api)
+			}
+			e := errs[0].(map[string]interface{})
+			if e["status"] != "400" || e["title"] != "Invalid "+tc.field || e["code"] != "invalid" {
+				t.Errorf("JSON:API error = %v", e)
+			}
+			if src, _ := e["source"].(map[string]interface{}); len(src) != 1 || src[firstKey(tc.source)] != tc.source[firstKey(tc.source)] {
+				t.Errorf("source = %v; want %v", e["source"], tc.source)
+			}
+		})
+	}
+}
+
+func firstKey(m map[string]interface{}) string {
+	for k := range m {
+		return k
+	}
+	return ""
+}
+
+func checkTodoResource(t *testing.T, v interface{}, td todo) {
+	t.Helper()
+	res, _ := v.(map[string]interface{})
+	attrs, _ := res["attributes"].(map[string]interface{})
+	if res["type"] != "todos" || res["id"] != td.ID || attrs["title"] != td.Title {
+		t.Errorf("resource = %v; want todo %s", res, td.ID)
+	}
+	for _, moved := range []string{"id", "tags", "bucket"} {
+		if _, ok := attrs[moved]; ok {
+			t.Errorf("attributes hold %s", moved)
+		}
+	}
+	rels, _ := res["relationships"].(map[string]interface{})
+	tags, _ := rels["tags"].(map[string]interface{})
+	linked, _ := tags["data"].([]interface{})
+	if len(linked) != 1 || linked[0].(map[string]interface{})["id"] != "work" {
+		t.Errorf("relationships = %v; want the work tag linked", rels)
+	}
+}
+
+// TestJSONAPIRequestBody decodes a todo sent as plain JSON and as a JSON:API
+// document into the same value, and refuses an id the URL contradicts.
+func TestJSONAPIRequestBody(t *testing.T) {
+	rnd = renderer.New()
+	var got []todo
+	r := chi.NewRouter()
+	r.Put("/todos/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var td todo
+		if decodeJSON(w, r, &td) {
+			got = append(got, td)
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+
+	const id = "65f0c0ffee0000000000beef"
+	for _, tc := range []struct {
+		contentType, body string
+		status            int
+	}{
+		{"application/json", `{"title": "write tests", "tags": ["work"], "bucket": "inbox"}`, http.StatusOK},
+		{jsonAPIMediaType, `{"data": {"type": "todos", "id": "` + id + `", "attributes": {"title": "write tests"},
+			"relationships": {"tags": {"data": [{"type": "tags", "id": "work"}]}, "bucket": {"data": {"type": "buckets", "id": "inbox"}}}}}`, http.StatusOK},
+		{jsonAPIMediaType, `{"data": {"type": "todos", "id": "65f0c0ffee0000000000cafe", "attributes": {"title": "write tests"}}}`, http.StatusConflict},
+		{jsonAPIMediaType, `{"title": "write tests"}`, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/todos/"+id, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s %s: %d %s; want %d", tc.contentType, tc.body, w.Code, w.Body, tc.status)
+		}
+	}
+	if len(got) != 2 {
+		t.Fatalf("decoded %d bodies; want 2", len(got))
+	}
+	for _, td := range got {
+		if td.Title != "write tests" || len(td.Tags) != 1 || td.Tags[0] != "work" || td.Bucket != bucketInbox {
+			t.Errorf("decoded %+v; want the title, tag and bucket", td)
+		}
+	}
+}
+
+// TestListShapes serves GET /todos through the routes with each Accept.
+func TestListShapes(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, accept := range []string{"application/json", jsonAPIMediaType} {
+		mt.Run(accept, func(mt *mtest.T) {
+			useMockDB(mt)
+			defer func(on bool) { listCountsEnabled = on }(listCountsEnabled)
+			listCountsEnabled = false
+
+			id := primitive.NewObjectID()
+			ns := mt.DB.Name() + "." + collectionName
+			todo := bson.D{{Key: "_id", Value: id}, {Key: "title", Value: "write tests"}}
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, todo),
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, todo),
+			)
+
+			r := httptest.NewRequest(http.MethodGet, "/todos?limit=10", nil)
+			r.Header.Set("Accept", accept)
+			w := httptest.NewRecorder()
+			testRouter().ServeHTTP(w, r)
+			var doc struct {
+				Data []map[string]interface{} `json:"data"`
+				Meta map[string]interface{}   `json:"meta"`
+				// Paging is top level in plain JSON only.
+				Paging map[string]interface{} `json:"paging"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil || w.Code != http.StatusOK || len(doc.Data) != 1 {
+				mt.Fatalf("GET /todos: %d %s", w.Code, w.Body)
+			}
+			item := doc.Data[0]
+			if accept == jsonAPIMediaType {
+				if item["type"] != "todos" || item["id"] != id.Hex() || item["attributes"] == nil || doc.Paging != nil || doc.Meta["paging"] == nil {
+					mt.Errorf("JSON:API list = %s", w.Body)
+				}
+				return
+			}
+			if item["id"] != id.Hex() || item["title"] != "write tests" || item["type"] != nil || doc.Paging == nil {
+				mt.Errorf("plain list = %s", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// appCtx is cancelled once the server has stopped serving requests.
+// Background workers derive from it so they stop with the process instead
+// of leaking.
+var (
+	appCtx, stopApp = context.WithCancel(context.Background())
+	workers         sync.WaitGroup
+
+	// shuttingDown is closed as soon as shutdown begins. Ordinary requests
+	// are left to finish; long-lived ones, such as NDJSON streams, watch it
+	// to wind up early rather than hold Shutdown open until it times out.
+	shuttingDown = make(chan struct{})
+)
+
+// beginShutdown closes shuttingDown. It is registered with the server's
+// RegisterOnShutdown, which calls it once.
+func beginShutdown() { close(shuttingDown) }
+
+// goWorker runs fn in a tracked goroutine that drainWorkers waits for.
+func goWorker(name string, fn func(ctx context.Context)) {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		fn(appCtx)
+		log.Printf("worker %s stopped", name)
+	}()
+}
+
+// drainWorkers cancels appCtx and waits up to timeout for every worker
+// started with goWorker to return.
+func drainWorkers(timeout time.Duration) {
+	stopApp()
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Println("Timed out waiting for background workers to stop")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestDrainWorkersLeavesNoGoroutines starts background workers the way
+// serve does and expects the goroutine count back at its baseline once
+// they are drained.
+func TestDrainWorkersLeavesNoGoroutines(t *testing.T) {
+	withCreateQueue(t)
+	prevCtx, prevStop := appCtx, stopApp
+	appCtx, stopApp = context.WithCancel(context.Background())
+	defer func() { appCtx, stopApp = prevCtx, prevStop }()
+
+	baseline := runtime.NumGoroutine()
+	goWorker("load shedder", runShedder)
+	goWorker("create batcher", runCreateBatcher)
+	goWorker("idle", func(ctx context.Context) { <-ctx.Done() })
+	if n := runtime.NumGoroutine(); n < baseline+3 {
+		t.Fatalf("%d goroutines running with workers started, %d before", n, baseline)
+	}
+
+	start := time.Now()
+	drainWorkers(5 * time.Second)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("drainWorkers took %v; want the workers to stop promptly", d)
+	}
+
+	// A worker has called Done just before its goroutine exits, so give
+	// the scheduler a moment.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		buf := make([]byte, 1<<16)
+		t.Errorf("%d goroutines after drainWorkers, %d before:\n%s", n, baseline, buf[:runtime.Stack(buf, true)])
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Link previews: a todo created with ?enrich=true whose title holds a URL
+// gets the page's title and favicon fetched in the background and stored
+// as its linkPreview. The create doesn't wait for it, and a page that
+// can't be fetched leaves the todo as it was.
+var (
+	// linkPreviewsEnabled allows ?enrich=true, through LINK_PREVIEWS.
+	linkPreviewsEnabled bool
+	// linkPreviewTimeout bounds a whole fetch, redirects included, through
+	// LINK_PREVIEW_TIMEOUT.
+	linkPreviewTimeout = 5 * time.Second
+
+	linkPreviewQueue = make(chan linkPreviewJob, 100)
+
+	linkPreviewsStored  = newCounter("link_previews_stored_total", "Link previews fetched and stored on a todo.")
+	linkPreviewsFailed  = newCounter("link_previews_failed_total", "Link previews that couldn't be fetched.")
+	linkPreviewsDropped = newCounter("link_previews_dropped_total", "Link previews skipped because the queue was full.")
+)
+
+const (
+	// linkPreviewMaxBytes is how much of a page is read looking for its
+	// title and favicon.
+	linkPreviewMaxBytes = 256 << 10
+	// linkPreviewMaxRedirects is how many redirects a fetch follows.
+	linkPreviewMaxRedirects = 3
+)
+
+var (
+	urlRe     = regexp.MustCompile(`https?://[^\s<>"']+`)
+	titleRe   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	linkTagRe = regexp.MustCompile(`(?is)<link\b[^>]*>`)
+	attrRe    = regexp.MustCompile(`(?is)\b(rel|href)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+)
+
+type (
+	linkPreview struct {
+		URL        string    `bson:"url" json:"url" xml:"url"`
+		Title      string    `bson:"title,omitempty" json:"title,omitempty" xml:"title,omitempty"`
+		FaviconURL string    `bson:"faviconUrl,omitempty" json:"favicon_url,omitempty" xml:"favicon_url,omitempty"`
+		FetchedAt  time.Time `bson:"fetchedAt" json:"fetched_at" xml:"fetched_at"`
+	}
+	linkPreviewJob struct {
+		id    primitive.ObjectID
+		title string
+		url   string
+	}
+)
+
+// wantsEnrich reports whether a create asked for a link preview and may
+// have one.
+func wantsEnrich(r *http.Request) bool {
+	ok, _ := strconv.ParseBool(r.URL.Query().Get("enrich"))
+	return ok && linkPreviewsEnabled
+}
+
+// firstURL returns the first http or https URL in s, without the trailing
+// punctuation of the sentence around it, or "" if there is none.
+func firstURL(s string) string {
+	return strings.TrimRight(urlRe.FindString(s), ".,;:!?)]}")
+}
+
+// enqueueLinkPreview asks for a preview of the URL in tm's title, if any.
+// It never blocks: when the queue is full the todo goes without.
+func enqueueLinkPreview(tm todoModel) {
+	u := firstURL(tm.Title)
+	if u == "" {
+		return
+	}
+	select {
+	case linkPreviewQueue <- linkPreviewJob{id: tm.ID, title: tm.Title, url: u}:
+	default:
+		linkPreviewsDropped.Inc()
+	}
+}
+
+// runLinkPreviews fetches queued previews one at a time.
+func runLinkPreviews(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-linkPreviewQueue:
+			if err := storeLinkPreview(ctx, job); err != nil {
+				linkPreviewsFailed.Inc()
+				log.Printf("link preview for todo %s: %v", job.id.Hex(), err)
+			}
+		}
+	}
+}
+
+// storeLinkPreview fetches a preview and stores it, unless the title was
+// changed in the meantime. updatedAt is bumped so clients following
+// GET /todos/changes see the preview arrive.
+func storeLinkPreview(parent context.Context, job linkPreviewJob) error {
+	ctx, cancel := context.WithTimeout(parent, linkPreviewTimeout)
+	defer cancel()
+	lp, err := fetchLinkPreview(ctx, job.url)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel = context.WithTimeout(parent, 5*time.Second)
+	defer cancel()
+	now := time.Now()
+	lp.FetchedAt = now
+	res, err := db.Collection(collectionName).UpdateOne(ctx,
+		bson.M{"_id": job.id, "title": job.title},
+		bson.M{"$set": bson.M{"linkPreview": lp, "updatedAt": now}})
+	if err == nil && res.MatchedCount > 0 {
+		todosChanged()
+		linkPreviewsStored.Inc()
+	}
+	return err
+}
+
+// fetchLinkPreview reads the title and favicon of the page at raw.
+func fetchLinkPreview(ctx context.Context, raw string) (linkPreview, error) {
+	lp := linkPreview{URL: raw}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, raw, nil)
+	if err != nil {
+		return lp, err
+	}
+	if err := checkPreviewURL(req.URL); err != nil {
+		return lp, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "go-todo link preview")
+
+	resp, err := previewClient.Do(req)
+	if err != nil {
+		return lp, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return lp, fmt.Errorf("status %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return lp, fmt.Errorf("content type %s isn't HTML", ct)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, linkPreviewMaxBytes))
+	if err != nil {
+		return lp, err
+	}
+	page := string(body)
+
+	if m := titleRe.FindStringSubmatch(page); m != nil {
+		lp.Title = truncateRunes(strings.Join(strings.Fields(html.UnescapeString(m[1])), " "), maxTitleLength)
+	}
+	favicon := "/favicon.ico"
+	if href := iconHref(page); href != "" {
+		favicon = href
+	}
+	// The page's own URL, after redirects, is what its links are relative to.
+	if u, err := resp.Request.URL.Parse(favicon); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		lp.FaviconURL = u.String()
+	}
+	return lp, nil
+}
+
+// iconHref returns the href of the page's first icon link.
+func iconHref(page string) string {
+	for _, tag := range linkTagRe.FindAllString(page, -1) {
+		var rel, href string
+		for _, m := range attrRe.FindAllStringSubmatch(tag, -1) {
+			v := html.UnescapeString(m[2] + m[3] + m[4])
+			if strings.EqualFold(m[1], "rel") {
+				rel = strings.ToLower(v)
+			} else {
+				href = v
+			}
+		}
+		for _, r := range strings.Fields(rel) {
+			if r == "icon" && href != "" {
+				return href
+			}
+		}
+	}
+	return ""
+}
+
+func truncateRunes(s string, n int) string {
+	if n <= 0 {
+		return s
+	}
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// previewClient fetches previews without a proxy, following at most
+// linkPreviewMaxRedirects redirects, each to a URL checkPreviewURL
+// accepts. Its dialer refuses addresses that aren't public, checked after
+// name resolution so a name can't point the fetch at the internal network.
+var previewClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 3 * time.Second,
+			Control: refusePrivateAddrs,
+		}).DialContext,
+		TLSHandshakeTimeout:   3 * time.Second,
+		ResponseHeaderTimeout: 3 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) > linkPreviewMaxRedirects {
+			return errors.New("too many redirects")
+		}
+		return checkPreviewURL(req.URL)
+	},
+}
+
+var errPrivateAddr = errors.New("refusing to fetch from a non-public address")
+
+// checkPreviewURL accepts http and https URLs on their default ports,
+// without credentials.
+func checkPreviewURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.User != nil {
+		return errors.New("URLs with credentials aren't fetched")
+	}
+	if p := u.Port(); p != "" && p != "80" && p != "443" {
+		return fmt.Errorf("port %s isn't fetched", p)
+	}
+	return nil
+}
+
+// refusePrivateAddrs is a dialer Control refusing to connect anywhere but
+// the public internet.
+func refusePrivateAddrs(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddr(ap.Addr()) {
+		return errPrivateAddr
+	}
+	return nil
+}
+
+// sharedAddrSpace is carrier-grade NAT, 100.64.0.0/10, which
+// netip.Addr.IsPrivate doesn't cover.
+var sharedAddrSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func isPublicAddr(a netip.Addr) bool {
+	a = a.Unmap()
+	return a.IsGlobalUnicast() && !a.IsPrivate() && !a.IsLoopback() &&
+		!a.IsLinkLocalUnicast() && !sharedAddrSpace.Contains(a)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// listETagFields are the fields listETag reads of each todo, so the page
+// can be read narrowed to them before the full list is.
+var listETagFields = bson.M{"completed": 1, "createAt": 1, "updatedAt": 1}
+
+// listETag is the weak validator of a GET /todos page: a hash of the query
+// and Accept header, which shape the representation, of the IDs, update
+// times and staleness of the todos on the page, and of the search mode. It
+// changes whenever a todo on the page does, even when many share a
+// timestamp, and whenever the page gains or loses one. summaries is what
+// the rest of the response, such as counts over todos beyond the page,
+// depends on, empty when there is nothing beyond the page.
+func listETag(r *http.Request, page []todoModel, searchMode, summaries string) string {
+	h := sha256.New()
+	h.Write([]byte(r.URL.RawQuery + "\x00" + r.Header.Get("Accept") + "\x00" + jsonNaming + "\x00"))
+	now := time.Now()
+	for _, t := range page {
+		h.Write(t.ID[:])
+		binary.Write(h, binary.BigEndian, lastWritten(t).UnixNano())
+		binary.Write(h, binary.BigEndian, t.isStale(now))
+	}
+	h.Write([]byte(searchMode + "\x00" + summaries))
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// lastWritten is when t was last written. Todos saved before updatedAt was
+// recorded fall back to their creation.
+func lastWritten(t todoModel) time.Time {
+	if t.UpdatedAt != nil {
+		return *t.UpdatedAt
+	}
+	return t.CreateAt
+}
+
+// listLastModified is when any todo of the caller was last written or
+// deleted. It covers every todo rather than those on the page, since a
+// todo leaving the page, by being deleted or no longer matching, changes
+// the page without changing anything left on it.
+func listLastModified(ctx context.Context) (time.Time, error) {
+	var latest time.Time
+	for coll, field := range map[string]string{collectionName: "updatedAt", tombstoneCollection: "deletedAt"} {
+		var doc bson.M
+		opts := options.FindOne().SetSort(bson.D{{Key: field, Value: -1}}).SetProjection(bson.M{field: 1})
+		err := db.Collection(coll).FindOne(ctx, scoped(ctx, bson.M{field: bson.M{"$exists": true}}), opts).Decode(&doc)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		if dt, ok := doc[field].(interface{ Time() time.Time }); ok && dt.Time().After(latest) {
+			latest = dt.Time()
+		}
+	}
+	return latest, nil
+}
+
+// shownLastModified is the Last-Modified given for lastModified: whole
+// seconds, and nothing until the second it names is over, as a write later
+// in that second would otherwise go unnoticed by If-Modified-Since.
+func shownLastModified(lastModified time.Time) time.Time {
+	lastModified = lastModified.Truncate(time.Second)
+	if !time.Now().Truncate(time.Second).After(lastModified) {
+		return time.Time{}
+	}
+	return lastModified
+}
+
+// setListValidators sets the validators of a list response.
+func setListValidators(w http.ResponseWriter, etag string, lastModified time.Time) {
+	w.Header().Set("ETag", etag)
+	if lm := shownLastModified(lastModified); !lm.IsZero() {
+		w.Header().Set("Last-Modified", lm.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Add("Vary", "Accept")
+}
+
+// notModified reports whether the request's conditional headers let a list
+// be answered with 304. As RFC 9110 requires, If-Modified-Since is only
+// looked at without If-None-Match, so the more precise ETag wins when a
+// client sends both.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	lastModified = shownLastModified(lastModified)
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !lastModified.IsZero() && !lastModified.After(ims)
+}
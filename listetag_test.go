@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestNotModified(t *testing.T) {
+	const etag = `W/"abc"`
+	modified := time.Date(2024, 3, 24, 18, 25, 59, 500e6, time.UTC)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	at := modified.Format(http.TimeFormat)
+	after := modified.Add(time.Hour).Format(http.TimeFormat)
+	later := time.Now().Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name         string
+		inm, ims     string
+		lastModified time.Time
+		want         bool
+	}{
+		{"no conditions", "", "", modified, false},
+		{"etag matches", etag, "", modified, true},
+		{"strong form of etag matches", `"abc"`, "", modified, true},
+		{"etag in a list", `"x", W/"abc"`, "", modified, true},
+		{"any etag", "*", "", modified, true},
+		{"etag differs", `W/"xyz"`, "", modified, false},
+		{"modified since", "", before, modified, false},
+		{"not modified since", "", after, modified, true},
+		{"not modified since that second", "", at, modified, true},
+		{"unparsable date", "", "yesterday", modified, false},
+		{"etag differs, date matches", `W/"xyz"`, after, modified, false},
+		{"etag matches, date doesn't", etag, before, modified, true},
+		{"no last modified", "", after, time.Time{}, false},
+		{"no last modified, etag matches", etag, after, time.Time{}, true},
+		{"modified this second", "", later, time.Now(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/todos", nil)
+			if tt.inm != "" {
+				r.Header.Set("If-None-Match", tt.inm)
+			}
+			if tt.ims != "" {
+				r.Header.Set("If-Modified-Since", tt.ims)
+			}
+			if got := notModified(r, etag, tt.lastModified); got != tt.want {
+				t.Errorf("notModified = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetListValidators(t *testing.T) {
+	modified := time.Date(2024, 3, 24, 18, 25, 59, 500e6, time.UTC)
+	tests := []struct {
+		name         string
+		lastModified time.Time
+		want         string
+	}{
+		{"past", modified, "Sun, 24 Mar 2024 18:25:59 GMT"},
+		{"zero", time.Time{}, ""},
+		// The second isn't over, so a later write in it could be missed.
+		{"this second", time.Now(), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			setListValidators(w, `W/"abc"`, tt.lastModified)
+			if got := w.Header().Get("Last-Modified"); got != tt.want {
+				t.Errorf("Last-Modified = %q; want %q", got, tt.want)
+			}
+			if got := w.Header().Get("ETag"); got != `W/"abc"` {
+				t.Errorf("ETag = %q; want %q", got, `W/"abc"`)
+			}
+		})
+	}
+}
+
+// TestListNotModifiedSkipsList lists once, then again with the ETag it got,
+// and expects the 304 to come from the validators alone: the page read
+// narrowed to what the ETag hashes, without the full list or its counts.
+func TestListNotModifiedSkipsList(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("revalidate", func(mt *mtest.T) {
+		useMockDB(mt)
+
+		ns := mt.DB.Name() + "." + collectionName
+		cursor := func(docs ...bson.D) bson.D {
+			return mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, docs...)
+		}
+		updated := time.Now().Add(-time.Hour)
+		todo := bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "title", Value: "write tests"}, {Key: "updatedAt", Value: updated}}
+		stamp := bson.D{{Key: "_id", Value: todo[0].Value}, {Key: "updatedAt", Value: updated}}
+		lastModified := bson.D{{Key: "updatedAt", Value: updated}}
+		mt.AddMockResponses(
+			cursor(lastModified), cursor(), cursor(stamp),
+			cursor(todo), cursor(bson.D{{Key: "open", Value: bson.A{bson.D{{Key: "n", Value: 1}}}}}),
+		)
+
+		w := httptest.NewRecorder()
+		testRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos?limit=10", nil))
+		etag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || etag == "" {
+			mt.Fatalf("GET /todos: %d with ETag %q", w.Code, etag)
+		}
+
+		mt.ClearEvents()
+		mt.AddMockResponses(cursor(lastModified), cursor(), cursor(stamp))
+		r := httptest.NewRequest(http.MethodGet, "/todos?limit=10", nil)
+		r.Header.Set("If-None-Match", etag)
+		w = httptest.NewRecorder()
+		testRouter().ServeHTTP(w, r)
+		if w.Code != http.StatusNotModified || w.Header().Get("ETag") != etag {
+			mt.Fatalf("revalidating GET /todos: %d with ETag %q; want 304 with %q", w.Code, w.Header().Get("ETag"), etag)
+		}
+		var commands []string
+		for _, ev := range mt.GetAllStartedEvents() {
+			commands = append(commands, ev.CommandName)
+			if _, single := ev.Command.Lookup("singleBatch").BooleanOK(); single {
+				continue
+			}
+			var projection bson.M
+			if doc, ok := ev.Command.Lookup("projection").DocumentOK(); !ok || bson.Unmarshal(doc, &projection) != nil || len(projection) != len(listETagFields) {
+				mt.Errorf("page read for the ETag projects %v; want only what the ETag hashes", ev.Command.Lookup("projection"))
+			}
+		}
+		if len(commands) != 3 {
+			mt.Errorf("304 ran %q; want the two last-modified finds and the narrowed page only", commands)
+		}
+	})
+}
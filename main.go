@@ -1,10 +1,10 @@
-
 package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
@@ -35,19 +35,131 @@ const (
 type (
 	todoModel struct {
 		ID        primitive.ObjectID `bson:"_id,omitempty"`
+		ShortID   string             `bson:"shortId,omitempty"`
 		Title     string             `bson:"title"`
 		Completed bool               `bson:"completed"`
+		Status    string             `bson:"status,omitempty"`
 		CreateAt  time.Time          `bson:"createAt"`
+		DueDate   *time.Time         `bson:"dueDate,omitempty"`
+		Tags      []string           `bson:"tags,omitempty"`
+		Position  float64            `bson:"position"`
+		Estimate  *int               `bson:"estimate,omitempty"`
+		Priority  string             `bson:"priority,omitempty"`
+		Bucket    string             `bson:"bucket,omitempty"`
+		Metadata  metadata           `bson:"metadata,omitempty"`
+
+		CompletedAt     *time.Time `bson:"completedAt,omitempty"`
+		StatusChangedAt *time.Time `bson:"statusChangedAt,omitempty"`
+		UpdatedAt       *time.Time `bson:"updatedAt,omitempty"`
+		TriagedAt       *time.Time `bson:"triagedAt,omitempty"`
+		ReopenCount     int        `bson:"reopenCount,omitempty"`
+		ReopenedAt      *time.Time `bson:"reopenedAt,omitempty"`
+		LastSurfacedAt  *time.Time `bson:"lastSurfacedAt,omitempty"`
+
+		Location      *geoPoint    `bson:"location,omitempty"`
+		LocationLabel string       `bson:"locationLabel,omitempty"`
+		LinkPreview   *linkPreview `bson:"linkPreview,omitempty"`
+		// Distance is only populated by $geoNear queries and never stored.
+		Distance *float64 `bson:"distance,omitempty"`
+		// Score is only populated by text searches and never stored.
+		Score *float64 `bson:"score,omitempty"`
+
+		// FieldUpdatedAt maps API field names to when each last changed.
+		FieldUpdatedAt map[string]time.Time `bson:"fieldUpdatedAt,omitempty"`
+
+		// SessionID and SessionExpireAt are only set in demo mode.
+		SessionID       string     `bson:"sessionId,omitempty"`
+		SessionExpireAt *time.Time `bson:"sessionExpireAt,omitempty"`
 	}
 	todo struct {
-		ID        string    `json:"id"`
-		Title     string    `json:"title"`
-		Completed bool      `json:"completed"`
-		CreatedAt time.Time `json:"create_at"`
+		ID        string     `json:"id" xml:"id" schema:"readonly"`
+		ShortID   string     `json:"short_id,omitempty" xml:"short_id,omitempty" schema:"readonly"`
+		Title     string     `json:"title" xml:"title" schema:"required"`
+		Completed bool       `json:"completed" xml:"completed"`
+		Status    string     `json:"status" xml:"status" schema:"enum=todo|in_progress|done|blocked"`
+		CreatedAt time.Time  `json:"create_at" xml:"create_at" schema:"readonly"`
+		DueDate   *time.Time `json:"due_date,omitempty" xml:"due_date,omitempty"`
+		Tags      []string   `json:"tags,omitempty" xml:"tags>tag,omitempty"`
+		Position  float64    `json:"position" xml:"position" schema:"readonly"`
+		Estimate  *int       `json:"estimate,omitempty" xml:"estimate,omitempty" schema:"min=1,max=6000"`
+		Priority  string     `json:"priority,omitempty" xml:"priority,omitempty" schema:"enum=low|medium|high"`
+		Bucket    string     `json:"bucket" xml:"bucket" schema:"enum=inbox|active|someday"`
+		Metadata  metadata   `json:"metadata,omitempty" xml:"metadata,omitempty"`
+
+		CompletedAt     *time.Time `json:"completed_at,omitempty" xml:"completed_at,omitempty" schema:"readonly"`
+		StatusChangedAt *time.Time `json:"status_changed_at,omitempty" xml:"status_changed_at,omitempty" schema:"readonly"`
+		UpdatedAt       *time.Time `json:"updated_at,omitempty" xml:"updated_at,omitempty" schema:"readonly"`
+		TriagedAt       *time.Time `json:"triaged_at,omitempty" xml:"triaged_at,omitempty" schema:"readonly"`
+		ReopenCount     int        `json:"reopen_count" xml:"reopen_count" schema:"readonly"`
+		ReopenedAt      *time.Time `json:"reopened_at,omitempty" xml:"reopened_at,omitempty" schema:"readonly"`
+		LastSurfacedAt  *time.Time `json:"last_surfaced_at,omitempty" xml:"last_surfaced_at,omitempty" schema:"readonly"`
+		Stale           bool       `json:"stale" xml:"stale" schema:"readonly"`
+
+		Location    *todoLocation `json:"location,omitempty" xml:"location,omitempty"`
+		LinkPreview *linkPreview  `json:"link_preview,omitempty" xml:"link_preview,omitempty" schema:"readonly"`
+		Distance    *float64      `json:"distance_m,omitempty" xml:"distance_m,omitempty" schema:"readonly"`
+		Score       *float64      `json:"score,omitempty" xml:"score,omitempty" schema:"readonly"`
+
+		FieldUpdatedAt fieldTimes `json:"field_updated_at,omitempty" xml:"field_updated_at,omitempty" schema:"readonly"`
+
+		timeFormat timeFormat
 	}
 )
 
-func init() {
+// toTodo converts a stored document into its API representation.
+func (t todoModel) toTodo() todo {
+	out := todo{
+		ID:        t.ID.Hex(),
+		ShortID:   t.ShortID,
+		Title:     t.Title,
+		Completed: t.Completed,
+		Status:    todoStatus(t.Completed, t.Status),
+		CreatedAt: t.CreateAt,
+		DueDate:   t.DueDate,
+		Tags:      t.Tags,
+		Position:  t.Position,
+		Estimate:  t.Estimate,
+		Priority:  t.Priority,
+		Bucket:    t.Bucket,
+		Metadata:  t.Metadata,
+
+		CompletedAt:     t.CompletedAt,
+		StatusChangedAt: t.StatusChangedAt,
+		UpdatedAt:       t.UpdatedAt,
+		TriagedAt:       t.TriagedAt,
+		ReopenCount:     t.ReopenCount,
+		ReopenedAt:      t.ReopenedAt,
+		LastSurfacedAt:  t.LastSurfacedAt,
+		Stale:           t.isStale(time.Now()),
+
+		FieldUpdatedAt: t.FieldUpdatedAt,
+	}
+	if out.Bucket == "" {
+		out.Bucket = bucketInbox
+	}
+	if t.Location != nil {
+		lng, lat := t.Location.Coordinates[0], t.Location.Coordinates[1]
+		out.Location = &todoLocation{Lat: &lat, Lng: &lng, Label: t.LocationLabel}
+	}
+	// A preview is of the URL the title held when it was fetched, so it is
+	// dropped once the title no longer has that URL.
+	if t.LinkPreview != nil && strings.Contains(t.Title, t.LinkPreview.URL) {
+		out.LinkPreview = t.LinkPreview
+	}
+	if t.Distance != nil {
+		d := math.Round(*t.Distance)
+		out.Distance = &d
+	}
+	if t.Score != nil {
+		s := round2(*t.Score)
+		out.Score = &s
+	}
+	return out
+}
+
+// setup loads the environment and connects to Mongo. It only runs for the
+// serve command so the CLI subcommands work without a database.
+func setup() {
 	// Load environment variables from .env file
 	err := godotenv.Load()
 	if err != nil {
@@ -59,158 +171,983 @@ func init() {
 		log.Fatal("MONGO_URI environment variable is not set")
 	}
 
+	maxBodyBytes = int64(envInt("MAX_BODY_BYTES", 1<<20))
+	feedLimit = envInt("FEED_LIMIT", 20)
+	maxBatchSize = envInt("BATCH_MAX_ITEMS", 100)
+	importMaxRows = envInt("IMPORT_MAX_ROWS", 1000)
+	importMaxBytes = int64(envInt("IMPORT_MAX_BYTES", 5<<20))
+	shortIDRetention = envDuration("SHORT_ID_RETENTION", 90*24*time.Hour)
+	samplerInterval = envDuration("SAMPLER_INTERVAL", time.Minute)
+	scheduleCatchUp = envDuration("SCHEDULE_CATCH_UP", time.Hour)
+	dedupeKeyTTL = envDuration("DEDUPE_KEY_TTL", 24*time.Hour)
+	maxInFlight = envInt("MAX_IN_FLIGHT", 0)
+	shedLimits.Store(&shedThresholds{
+		CheapP99MS: int(envDuration("SHED_CHEAP_P99", 0).Milliseconds()),
+		InFlight:   envInt("SHED_IN_FLIGHT", 0),
+	})
+	adminToken = envString("ADMIN_TOKEN", "")
+	setMaintenance(envBool("MAINTENANCE_MODE", false))
+	maintenanceRetryAfter = envDuration("MAINTENANCE_RETRY_AFTER", time.Minute)
+	tombstoneRetention = envDuration("TOMBSTONE_RETENTION", 30*24*time.Hour)
+	exportTTL = envDuration("EXPORT_TTL", 24*time.Hour)
+	importJobMaxBytes = int64(envInt("IMPORT_JOB_MAX_BYTES", 100<<20))
+	importBatchSize = envInt("IMPORT_BATCH_SIZE", 100)
+	importJobTTL = envDuration("IMPORT_JOB_TTL", 24*time.Hour)
+	strictQueryParams = envBool("STRICT_QUERY_PARAMS", false)
+	listCountsEnabled = envBool("LIST_COUNTS", true)
+	loadFlagDefaults()
+	staleAfterDays = envInt("STALE_AFTER_DAYS", 30)
+	resurfacePerDay = envInt("RESURFACE_PER_DAY", 0)
+	linkPreviewsEnabled = envBool("LINK_PREVIEWS", false)
+	linkPreviewTimeout = envDuration("LINK_PREVIEW_TIMEOUT", 5*time.Second)
+	flagPollInterval = envDuration("FEATURE_FLAG_POLL_INTERVAL", 10*time.Second)
+	createBatchSize = envInt("CREATE_BATCH_SIZE", 0)
+	createBatchInterval = envDuration("CREATE_BATCH_INTERVAL", 5*time.Millisecond)
+	createBatchMaxWait = envDuration("CREATE_BATCH_MAX_WAIT", 50*time.Millisecond)
+	initCreateBatching()
+	if _, ok := os.LookupEnv("STRICT_WARNINGS"); ok {
+		strictWarnings = map[string]bool{}
+		for _, code := range envList("STRICT_WARNINGS") {
+			strictWarnings[code] = true
+		}
+	}
+	demoMode = envBool("DEMO_MODE", false)
+	demoMaxTodos = envInt("DEMO_MAX_TODOS", 50)
+	demoSessionTTL = envDuration("DEMO_SESSION_TTL", 24*time.Hour)
+	initDemoSecret(envString("DEMO_SECRET", ""))
+	// A snapshot holds every session's todos, so it can't be served in
+	// demo mode.
+	staleSnapshots = envBool("STALE_SNAPSHOT", false) && !demoMode
+	snapshotInterval = envDuration("SNAPSHOT_INTERVAL", time.Minute)
+	snapshotMaxAge = envDuration("SNAPSHOT_MAX_AGE", 24*time.Hour)
+	switch jsonNaming = envString("JSON_NAMING", namingSnake); jsonNaming {
+	case namingSnake, namingCamel:
+	default:
+		log.Fatalf("JSON_NAMING must be snake or camel, got %q", jsonNaming)
+	}
+	searchLanguage = envString("SEARCH_LANGUAGE", "english")
+	initCursorSecret(envString("CURSOR_SECRET", ""))
+	auditRetention = envDuration("AUDIT_RETENTION", 90*24*time.Hour)
+	readCacheTTL = envDuration("READ_CACHE_TTL", 5*time.Second)
+	presenceTTL = envDuration("PRESENCE_TTL", 30*time.Second)
+	defaultPageSize = envInt("DEFAULT_PAGE_SIZE", 0)
+	maxPageSize = envInt("MAX_PAGE_SIZE", 0)
+	maxTitleLength = envInt("MAX_TITLE_LENGTH", 200)
+	maxTags = envInt("MAX_TAGS", 20)
+	maxTagLength = envInt("MAX_TAG_LENGTH", 32)
+	homePageSize = envInt("HOME_PAGE_SIZE", 50)
+	homeMaxAge = envDuration("HOME_MAX_AGE", 10*time.Second)
 	rnd = renderer.New()
+	if err := loadTemplates(); err != nil {
+		log.Fatalf("parsing templates: %v", err)
+	}
 
-	clientOptions := options.Client().ApplyURI(mongoURI)
-	client, err := mongo.Connect(context.Background(), clientOptions)
+	slowQueryThreshold = time.Duration(envInt("SLOW_QUERY_MS", 200)) * time.Millisecond
+	clientOptions := options.Client().ApplyURI(mongoURI).SetMonitor(slowQueryMonitor())
+	startupDBTimeout = envDuration("STARTUP_DB_TIMEOUT", 30*time.Second)
+	client, err = connectMongo(clientOptions)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	db = client.Database(dbName)
+	ensureIndexes()
+}
+
+// ensureIndexes creates the indexes queries rely on. Creating an index that
+// already exists is a no-op.
+func ensureIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := db.Collection(collectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "location", Value: "2dsphere"}}},
+		{Keys: bson.D{{Key: "position", Value: 1}}},
+		{Keys: bson.D{{Key: "completedAt", Value: 1}}},
+		{Keys: bson.D{{Key: "completed", Value: 1}, {Key: "createAt", Value: 1}}},
+		{Keys: bson.D{{Key: "updatedAt", Value: 1}}},
+		{Keys: bson.D{{Key: "lastSurfacedAt", Value: 1}}},
+		{Keys: bson.D{{Key: "estimate", Value: 1}}},
+		{Keys: bson.D{{Key: "bucket", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "shortId", Value: 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to create indexes: %v", err)
+	}
+
+	_, err = db.Collection(shortIDCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expireAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		log.Printf("Failed to create short ID indexes: %v", err)
+	}
+
+	_, err = db.Collection(dedupeCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "todoId", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expireAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to create dedupe key indexes: %v", err)
+	}
+
+	_, err = db.Collection(templateCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "schedule.nextRunAt", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		log.Printf("Failed to create template indexes: %v", err)
+	}
+
+	_, err = db.Collection(auditCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "todoId", Value: 1}, {Key: "at", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expireAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to create audit indexes: %v", err)
+	}
+
+	_, err = db.Collection(presenceCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "todoId", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expireAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to create presence indexes: %v", err)
+	}
+
+	ensureTombstoneIndexes(ctx)
+	ensureExportIndexes(ctx)
+	ensureImportIndexes(ctx)
+	if demoMode {
+		ensureDemoIndexes(ctx)
+	}
+	ensureTextIndex(ctx)
+}
+
+// dbContext bounds a handler's database work to 5 seconds. It keeps the
+// request's values, which the query monitor reads the route from, but not
+// its cancellation, so a client hanging up never aborts a write halfway.
+func dbContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
 }
 
+// homeHandler renders the home page. A request from htmx, such as a pager
+// link, gets only the todo-list fragment of it.
 func homeHandler(w http.ResponseWriter, r *http.Request) {
-	err := rnd.Template(w, http.StatusOK, []string{"./static/home.tpl"}, nil)
-	checkErr(err)
+	varyOnHTMX(w)
+	// The ETag is taken before reading, so a write landing meanwhile
+	// makes the next request miss rather than pin an older page.
+	etag := homeETag(r)
+	if homeMaxAge > 0 && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		setHomeCaching(w, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	summary, err := loadSummary(ctx)
+	if err != nil {
+		log.Printf("home: counting todos: %v", err)
+		renderErrorPage(w, r, http.StatusInternalServerError, "We couldn't load your todos. Please try again.")
+		return
+	}
+	list, err := loadTodoList(ctx, homePageNumber(r), summary.Total)
+	if err != nil {
+		log.Printf("home: fetching todos: %v", err)
+		renderErrorPage(w, r, http.StatusInternalServerError, "We couldn't load your todos. Please try again.")
+		return
+	}
+
+	setHomeCaching(w, etag)
+	if isHTMX(r) {
+		renderPage(w, r, http.StatusOK, "todo-list", list)
+		return
+	}
+	renderPage(w, r, http.StatusOK, "home.tpl", homePage{Summary: summary, List: list})
+}
+
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	if wantsHTML(r) {
+		renderErrorPage(w, r, http.StatusNotFound, "The page you were looking for doesn't exist.")
+		return
+	}
+	respond(w, r, http.StatusNotFound, renderer.M{"message": "Not found"})
 }
 
 func fetchTodos(w http.ResponseWriter, r *http.Request) {
+	if strictQueryParams {
+		if unknown := unknownParams(r.URL.Query(), filterParams, listParams, []string{"time_format", "format", "view"}); len(unknown) > 0 {
+			respond(w, r, http.StatusBadRequest, renderer.M{
+				"message": "Unknown query parameters",
+				"error":   "unknown query parameters: " + strings.Join(unknown, ", "),
+				"params":  unknown,
+			})
+			return
+		}
+	}
+
 	collection := db.Collection(collectionName)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := dbContext(r)
 	defer cancel()
 
-	cur, err := collection.Find(ctx, bson.M{})
+	if staleSnapshots && dbBreaker.open() && serveSnapshot(w, r) {
+		return
+	}
+
+	settings, err := loadSettings(ctx, r)
 	if err != nil {
-		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
-			"message": "Failed to fetch todo",
-			"error":   err.Error(),
-		})
+		dbBreaker.record(err)
+		if isDBUnavailable(err) && serveSnapshot(w, r) {
+			return
+		}
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch settings", "error": err.Error()})
 		return
 	}
-	defer cur.Close(ctx)
+	q := settings.withDefaults(r.URL.Query())
 
-	var todos []todoModel
-	if err := cur.All(ctx, &todos); err != nil {
-		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
-			"message": "Failed to decode todos",
-			"error":   err.Error(),
-		})
+	filter, err := parseTodoFilter(q)
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid filter", "error": err.Error()})
+		return
+	}
+	filter.Session = demoSessionOf(ctx).ID
+	// Due today means today in the caller's timezone.
+	loc := time.UTC
+	if settings.Timezone != "" {
+		if l, err := time.LoadLocation(settings.Timezone); err == nil {
+			loc = l
+		}
+	}
+	view := q.Get("view")
+	if err := filter.applyView(view, time.Now(), loc); err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid view", "error": err.Error()})
+		return
+	}
+	opts, err := parseListOptions(q)
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid list options", "error": err.Error()})
+		return
+	}
+	tf, err := parseTimeFormat(q)
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid time format", "error": err.Error()})
+		return
+	}
+	ndjson, err := parseListFormat(q)
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid format", "error": err.Error()})
 		return
 	}
 
-	var todoList []todo
-	for _, t := range todos {
-		todoList = append(todoList, todo{
-			ID:        t.ID.Hex(),
-			Title:     t.Title,
-			Completed: t.Completed,
-			CreatedAt: t.CreateAt,
-		})
+	// Cursors follow the ?sort= order, so they don't apply to lists ordered
+	// by distance or by search relevance.
+	explicitSort := r.URL.Query().Get("sort") != ""
+	cursorable := filter.Near == nil && (filter.Search == nil || explicitSort)
+	if opts.Cursor != nil {
+		if !cursorable {
+			respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid list options", "error": "cursor requires sort when listing by distance or search relevance"})
+			return
+		}
+		filter.After = opts.Cursor
 	}
+	opts.probe = cursorable && !ndjson && opts.Limit > 0
+
+	find := func(ctx context.Context, opts listOptions) (*mongo.Cursor, error) {
+		if filter.Near != nil {
+			return collection.Aggregate(ctx, filter.Near.pipeline(filter.query(), opts, q.Get("sort") != ""))
+		}
+		return findTodos(ctx, collection, filter, opts, explicitSort)
+	}
+
+	if ndjson {
+		cur, err := find(ctx, opts)
+		if listFailed(w, r, err) {
+			return
+		}
+		defer cur.Close(ctx)
+		streamNDJSON(w, r, cur, tf)
+		return
+	}
+
+	// The validators come first, from the page narrowed to what the ETag
+	// hashes, so a 304 skips the full list and its counts.
+	etag, lastModified, err := func() (string, time.Time, error) {
+		lastModified, err := listLastModified(ctx)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		narrow := opts
+		narrow.fields = listETagFields
+		cur, err := find(ctx, narrow)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		defer cur.Close(ctx)
+		page, _, err := decodeTodos(ctx, cur)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		var searchMode, summaries string
+		if filter.Search != nil {
+			searchMode = filter.Search.Mode
+		}
+		if listCountsEnabled || view == viewToday {
+			// Counts and totals cover todos beyond the page, which any
+			// write or deletion may change, as may the day turning.
+			summaries = lastModified.Format(time.RFC3339Nano) + " " + time.Now().In(loc).Format(time.DateOnly)
+		}
+		return listETag(r, page, searchMode, summaries), lastModified, nil
+	}()
+	if listFailed(w, r, err) {
+		return
+	}
+	if notModified(r, etag, lastModified) {
+		setListValidators(w, etag, lastModified)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	list, err := coalescedList(r, q, func() (listResult, error) {
+		ctx, cancel := dbContext(r)
+		defer cancel()
+
+		cur, err := find(ctx, opts)
+		if err != nil {
+			return listResult{}, err
+		}
+		defer cur.Close(ctx)
+
+		var res listResult
+		if res.Todos, res.Skipped, err = decodeTodos(ctx, cur); err != nil {
+			return listResult{}, err
+		}
+		if filter.Search != nil {
+			res.SearchMode = filter.Search.Mode
+		}
+		if listCountsEnabled {
+			if res.Counts, err = countTodos(ctx, collection, filter, loc); err != nil {
+				return listResult{}, err
+			}
+		}
+		if view == viewToday {
+			minutes, err := sumEstimates(ctx, collection, filter)
+			if err != nil {
+				return listResult{}, err
+			}
+			res.EstimateMinutes = &minutes
+		}
+		return res, nil
+	})
+	if r.Context().Err() != nil || listFailed(w, r, err) {
+		return
+	}
+
+	page := list.Todos
+	meta := opts.meta(w)
+	// A skipped document still counts towards the probe: the page is full
+	// and another follows even if the probe itself didn't decode.
+	more := opts.probe && len(page)+list.Skipped > opts.Limit
+	if opts.probe && len(page) > opts.Limit {
+		page = page[:opts.Limit]
+	}
+	if more && len(page) > 0 {
+		next, err := cursorAfter(page[len(page)-1], opts)
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch todo", "error": err.Error()})
+			return
+		}
+		meta["next_cursor"] = next
+	}
+
+	todoList := []todo{}
+	for _, t := range page {
+		todoList = append(todoList, t.toTodo().withTimeFormat(tf))
+	}
+
+	res := renderer.M{"data": todoList, "paging": meta}
+	if list.SearchMode != "" {
+		res["search_mode"] = list.SearchMode
+	}
+	extra := renderer.M{}
+	if list.Skipped > 0 {
+		extra["skipped"] = list.Skipped
+	}
+	if list.Counts != nil {
+		extra["counts"] = list.Counts
+	}
+	if list.EstimateMinutes != nil {
+		extra["estimate_minutes"] = *list.EstimateMinutes
+	}
+	if len(extra) > 0 {
+		res["meta"] = extra
+	}
+
+	setListValidators(w, etag, lastModified)
+	respond(w, r, http.StatusOK, res)
+}
+
+// decodeTodos reads every document of cur. One that doesn't decode, such as
+// a legacy record of another shape, is logged and skipped rather than
+// failing the list.
+func decodeTodos(ctx context.Context, cur *mongo.Cursor) ([]todoModel, int, error) {
+	var todos []todoModel
+	skipped := 0
+	for cur.Next(ctx) {
+		var t todoModel
+		if err := cur.Decode(&t); err != nil {
+			logSkippedTodo(cur, err)
+			skipped++
+			continue
+		}
+		todos = append(todos, t)
+	}
+	return todos, skipped, cur.Err()
+}
+
+func logSkippedTodo(cur *mongo.Cursor, err error) {
+	log.Printf("Skipping todo %v that failed to decode: %v", cur.Current.Lookup("_id"), err)
+}
 
-	rnd.JSON(w, http.StatusOK, renderer.M{"data": todoList})
+// listFailed answers a failed list query, from the stale snapshot when the
+// database is unreachable and one can be served. It reports whether err
+// was non-nil.
+func listFailed(w http.ResponseWriter, r *http.Request, err error) bool {
+	dbBreaker.record(err)
+	if err == nil {
+		return false
+	}
+	if isDBUnavailable(err) && serveSnapshot(w, r) {
+		return true
+	}
+	respond(w, r, http.StatusInternalServerError, renderer.M{
+		"message": "Failed to fetch todo",
+		"error":   err.Error(),
+	})
+	return true
 }
 func createTodos(w http.ResponseWriter, r *http.Request) {
 	var t todo
-	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "Invalid request payload"})
+	if !decodeJSON(w, r, &t) {
+		return
+	}
+
+	var notes normalizations
+	if m := validateTodo(&t, &notes); m != nil {
+		respond(w, r, http.StatusBadRequest, m)
+		return
+	}
+	if t.Bucket == "" {
+		notes.note("bucket", "defaulted to "+bucketInbox)
+	}
+
+	key, ok := dedupeKey(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	id := primitive.NewObjectID()
+	dryRun := isDryRun(r)
+	if key != "" {
+		var existing *todoModel
+		var err error
+		if dryRun {
+			existing, err = lookupDedupeKey(ctx, key)
+		} else {
+			existing, err = claimDedupeKey(ctx, key, id)
+		}
+		if err == errDedupeInFlight {
+			w.Header().Set("Retry-After", "1")
+			respond(w, r, http.StatusConflict, renderer.M{"message": err.Error()})
+			return
+		}
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to save todo", "error": err.Error()})
+			return
+		}
+		if existing != nil {
+			w.Header().Set("Location", "/todos/"+existing.ID.Hex())
+			respond(w, r, http.StatusOK, renderer.M{
+				"message":  "Todo already exists",
+				"Todo ID":  existing.ID.Hex(),
+				"short_id": existing.ShortID,
+				"data":     existing.toTodo(),
+			})
+			return
+		}
+	}
+
+	warnings, ok := checkWarnings(w, r, ctx, warningInput{Title: &t.Title, DueDate: t.DueDate, Tags: t.Tags, Bucket: t.Bucket})
+	if !ok {
+		if key != "" && !dryRun {
+			releaseDedupeKey(ctx, key, id)
+		}
+		return
+	}
+
+	if dryRun {
+		// The preview has no ID, short ID or position yet; those are only
+		// assigned by the insert.
+		preview := newTodoModel(id, t).toTodo()
+		preview.ID = ""
+		respond(w, r, http.StatusOK, withWarnings(r, withNormalizations(renderer.M{"message": "Todo would be saved", "data": preview}, notes), warnings))
 		return
 	}
 
-	if t.Title == "" {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "Title field is required"})
+	tm, err := insertTodoWithID(ctx, id, t)
+	if err != nil {
+		if key != "" {
+			releaseDedupeKey(ctx, key, id)
+		}
+		if err == errDemoFull {
+			demoFull(w, r)
+			return
+		}
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to save todo", "error": err.Error()})
 		return
 	}
 
+	if wantsEnrich(r) {
+		enqueueLinkPreview(tm)
+	}
+
+	w.Header().Set("Location", "/todos/"+tm.ID.Hex())
+	res := renderer.M{"message": "Todo successfully saved", "Todo ID": tm.ID.Hex(), "short_id": tm.ShortID, "data": tm.toTodo()}
+	respond(w, r, http.StatusOK, withWarnings(r, withNormalizations(res, notes), warnings))
+}
+
+// insertTodo stores a validated todo, giving it a short ID and placing it
+// at the bottom of the list.
+func insertTodo(ctx context.Context, t todo) (todoModel, error) {
+	return insertTodoWithID(ctx, primitive.NewObjectID(), t)
+}
+
+func insertTodoWithID(ctx context.Context, id primitive.ObjectID, t todo) (tm todoModel, err error) {
+	tm = newTodoModel(id, t)
+	tm.SessionID, tm.SessionExpireAt = demoExpiry(ctx)
+
+	if err = demoRoom(ctx, collectionName, 1); err != nil {
+		return tm, err
+	}
+	if tm.ShortID, err = reserveShortID(ctx); err != nil {
+		return tm, err
+	}
+	// A todo that wasn't saved gives its short ID back. It is released like
+	// a deleted todo's rather than freed at once, since an insert that
+	// failed on a timeout may still have reached the server. One still
+	// queued for a batch waits for the batch's verdict instead.
+	defer func() {
+		if err == nil {
+			return
+		}
+		var qe *queuedCreateError
+		if errors.As(err, &qe) {
+			go settleQueuedCreate(&tm, qe.done)
+			return
+		}
+		rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if rerr := releaseShortID(rctx, tm.ShortID); rerr != nil {
+			log.Printf("releasing short ID %s: %v", tm.ShortID, rerr)
+		}
+	}()
+	batched, err := insertBatched(ctx, &tm)
+	if !batched {
+		if tm.Position, err = edgePosition(ctx, false); err != nil {
+			return tm, err
+		}
+		_, err = db.Collection(collectionName).InsertOne(ctx, tm)
+	}
+	if err != nil {
+		return tm, err
+	}
+	todosChanged()
+	recordChange(auditCreate, nil, &tm)
+	return tm, nil
+}
+
+// newTodoModel builds the document for a new todo, before it is given a
+// short ID and a position.
+func newTodoModel(id primitive.ObjectID, t todo) todoModel {
 	tm := todoModel{
-		ID:        primitive.NewObjectID(),
+		ID:        id,
 		Title:     t.Title,
-		Completed: false,
+		Completed: t.Status == statusDone,
 		CreateAt:  time.Now(),
+		DueDate:   t.DueDate,
+		Tags:      t.Tags,
+		Estimate:  t.Estimate,
+		Priority:  t.Priority,
+		Bucket:    t.Bucket,
+		Metadata:  t.Metadata,
+	}
+	if tm.Bucket == "" {
+		tm.Bucket = bucketInbox
+	}
+	if s, ok := storedStatus(t.Status).(string); ok {
+		tm.Status = s
+	}
+	tm.StatusChangedAt = &tm.CreateAt
+	tm.UpdatedAt = &tm.CreateAt
+	if tm.Completed {
+		tm.CompletedAt = &tm.CreateAt
+	}
+	if t.Location != nil {
+		tm.Location = newGeoPoint(*t.Location.Lat, *t.Location.Lng)
+		tm.LocationLabel = t.Location.Label
+	}
+	return tm
+}
+
+func getTodo(w http.ResponseWriter, r *http.Request) {
+	idFilter := scoped(r.Context(), bson.M{"_id": todoID(r)})
+	tf, err := parseTimeFormat(r.URL.Query())
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid time format", "error": err.Error()})
+		return
 	}
 
 	collection := db.Collection(collectionName)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := dbContext(r)
 	defer cancel()
 
-	_, err := collection.InsertOne(ctx, tm)
+	var t todoModel
+	err = collection.FindOne(ctx, idFilter).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Todo not found"})
+		return
+	}
 	if err != nil {
-		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "Failed to save todo", "error": err.Error()})
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch todo", "error": err.Error()})
 		return
 	}
 
-	rnd.JSON(w, http.StatusOK, renderer.M{"message": "Todo successfully saved", "Todo ID": tm.ID.Hex()})
+	res := renderer.M{"data": t.toTodo().withTimeFormat(tf)}
+	if editors := currentEditors(ctx, r, t.ID); editors != nil {
+		res["currently_editing"] = editors
+	}
+	respond(w, r, http.StatusOK, res)
 }
 
 func deleteTodo(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimSpace(chi.URLParam(r, "id"))
-	if !primitive.IsValidObjectID(id) {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "Invalid ID"})
-		return
-	}
+	idFilter := scoped(r.Context(), bson.M{"_id": todoID(r)})
 
-	objectID, _ := primitive.ObjectIDFromHex(id)
 	collection := db.Collection(collectionName)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := dbContext(r)
 	defer cancel()
 
-	res, err := collection.DeleteOne(ctx, bson.M{"_id": objectID})
-	if err != nil {
-		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "Failed to delete TODO", "error": err.Error()})
+	if isDryRun(r) {
+		var t todoModel
+		err := collection.FindOne(ctx, idFilter).Decode(&t)
+		if err == mongo.ErrNoDocuments {
+			respond(w, r, http.StatusNotFound, renderer.M{"message": "Todo not found"})
+			return
+		}
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to delete TODO", "error": err.Error()})
+			return
+		}
+		respond(w, r, http.StatusOK, renderer.M{"message": "Todo would be deleted", "data": t.toTodo()})
 		return
 	}
 
-	if res.DeletedCount == 0 {
-		rnd.JSON(w, http.StatusNotFound, renderer.M{"message": "Todo not found"})
+	var deleted todoModel
+	err := collection.FindOneAndDelete(ctx, idFilter).Decode(&deleted)
+	if err == mongo.ErrNoDocuments {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Todo not found"})
 		return
 	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to delete TODO", "error": err.Error()})
+		return
+	}
+
+	todosChanged()
+	recordChange(auditDelete, &deleted, nil)
+	recordTombstone(ctx, deleted)
+	if err := releaseShortID(ctx, deleted.ShortID); err != nil {
+		log.Printf("releasing short ID %s: %v", deleted.ShortID, err)
+	}
+	// A deleted todo can't be the answer to a later create.
+	if _, err := db.Collection(dedupeCollection).DeleteMany(ctx, bson.M{"todoId": deleted.ID}); err != nil {
+		log.Printf("releasing dedupe keys of %s: %v", deleted.ID.Hex(), err)
+	}
 
-	rnd.JSON(w, http.StatusOK, renderer.M{"message": "Successfully deleted TODO"})
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func updateTodo(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimSpace(chi.URLParam(r, "id"))
-	if !primitive.IsValidObjectID(id) {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "Invalid ID"})
+	idFilter := scoped(r.Context(), bson.M{"_id": todoID(r)})
+
+	var t todo
+	if !decodeJSON(w, r, &t) {
 		return
 	}
 
-	var t todo
-	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "Invalid request payload"})
+	// A body echoed from GET carries the id, which is fine as long as it
+	// is this todo's. A different one means the client thinks it is
+	// updating another todo, or moving this one.
+	if t.ID != "" && t.ID != todoID(r).Hex() {
+		respond(w, r, http.StatusBadRequest, renderer.M{
+			"message": "The id in the body does not match the URL; a todo's id can't be changed",
+			"field":   "id",
+			"code":    "id_mismatch",
+		})
 		return
 	}
 
-	if t.Title == "" {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "Title field is required"})
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	// completed and status that disagree are settled against the stored
+	// todo, which only a PUT has.
+	var notes normalizations
+	if statuses[t.Status] && t.Completed != (t.Status == statusDone) {
+		var stored todoModel
+		err := db.Collection(collectionName).FindOne(ctx, idFilter, options.FindOne().SetProjection(bson.M{"completed": 1})).Decode(&stored)
+		if err == mongo.ErrNoDocuments {
+			respond(w, r, http.StatusNotFound, renderer.M{"message": "Todo not found"})
+			return
+		}
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to update todo", "error": err.Error()})
+			return
+		}
+		if m := reconcileStatus(&t, stored.Completed, &notes); m != nil {
+			respond(w, r, http.StatusBadRequest, m)
+			return
+		}
+	}
+	if m := validateTodo(&t, &notes); m != nil {
+		respond(w, r, http.StatusBadRequest, m)
+		return
+	}
+
+	warnings, ok := checkWarnings(w, r, ctx, warningInput{ID: todoID(r), Title: &t.Title, DueDate: t.DueDate, Tags: t.Tags})
+	if !ok {
+		return
+	}
+
+	fw := newFieldWrites(time.Now())
+	fw.set("title", "title", t.Title)
+	if t.Status != "" {
+		fw.setStatus(t.Status)
+	} else {
+		fw.setCompleted(t.Completed)
+	}
+	if t.DueDate != nil {
+		fw.set("due_date", "dueDate", *t.DueDate)
+	}
+	if t.Location != nil {
+		fw.set("location", "location", newGeoPoint(*t.Location.Lat, *t.Location.Lng))
+		fw.set("location", "locationLabel", t.Location.Label)
+	}
+	if t.Tags != nil {
+		fw.set("tags", "tags", t.Tags)
+	}
+	if t.Estimate != nil {
+		fw.set("estimate", "estimate", *t.Estimate)
+	}
+	if t.Priority != "" {
+		fw.set("priority", "priority", t.Priority)
+	}
+	if t.Metadata != nil {
+		fw.set("metadata", "metadata", t.Metadata)
+	}
+	applyUpdate(w, r, ctx, idFilter, fw, warnings, notes)
+}
+
+// applyUpdate finishes a validated PUT or PATCH by writing fw to the todo
+// and answering with the stored result. A dry run instead previews fw
+// against the current document and reports the fields that would change,
+// so both share everything up to the write.
+func applyUpdate(w http.ResponseWriter, r *http.Request, ctx context.Context, idFilter bson.M, fw *fieldWrites, warnings []warning, notes normalizations) {
+	var (
+		t   todoModel
+		res = renderer.M{"message": "Successfully updated TODO"}
+		err error
+	)
+	if isDryRun(r) {
+		var current todoModel
+		if err = db.Collection(collectionName).FindOne(ctx, idFilter).Decode(&current); err == nil {
+			if t, err = previewWrites(current, fw); err == nil {
+				var changes []renderer.M
+				changes, err = fieldDiff(current.toTodo(), t.toTodo(), fw)
+				res = renderer.M{"message": "Todo would be updated", "changes": changes}
+			}
+		}
+	} else {
+		t, err = updateTodoLimited(ctx, idFilter, fw)
+	}
+	var limitErr *wipLimitError
+	if errors.As(err, &limitErr) {
+		respond(w, r, http.StatusConflict, limitErr.response())
+		return
+	}
+	if err == mongo.ErrNoDocuments {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Todo not found"})
+		return
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to update todo", "error": err.Error()})
 		return
 	}
 
-	objectID, _ := primitive.ObjectIDFromHex(id)
+	res["data"] = t.toTodo()
+	respond(w, r, http.StatusOK, withWarnings(r, withNormalizations(res, notes), warnings))
+}
+
+// reopenTodo marks a completed todo as open again, recording when it was
+// reopened and how many times that has happened.
+func reopenTodo(w http.ResponseWriter, r *http.Request) {
+	idFilter := scoped(r.Context(), bson.M{"_id": todoID(r)})
+
 	collection := db.Collection(collectionName)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := dbContext(r)
 	defer cancel()
 
-	update := bson.M{"$set": bson.M{"title": t.Title, "completed": t.Completed}}
-	_, err := collection.UpdateOne(ctx, bson.M{"_id": objectID}, update)
-	if err != nil {
-		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "Failed to update todo", "error": err.Error()})
+	now := time.Now()
+	update := bson.M{
+		"$set":   bson.M{"completed": false, "reopenedAt": now, "statusChangedAt": now, "updatedAt": now, "fieldUpdatedAt.completed": now},
+		"$unset": bson.M{"completedAt": ""},
+		"$inc":   bson.M{"reopenCount": 1},
+	}
+	openFilter := bson.M{"completed": true}
+	for k, v := range idFilter {
+		openFilter[k] = v
+	}
+	_, err := updateTodoAudited(ctx, openFilter, update)
+	if err != nil && err != mongo.ErrNoDocuments {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to reopen todo", "error": err.Error()})
 		return
 	}
 
-	rnd.JSON(w, http.StatusOK, renderer.M{"message": "Successfully updated TODO"})
+	if err == mongo.ErrNoDocuments {
+		n, err := collection.CountDocuments(ctx, idFilter)
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to reopen todo", "error": err.Error()})
+			return
+		}
+		if n == 0 {
+			respond(w, r, http.StatusNotFound, renderer.M{"message": "Todo not found"})
+			return
+		}
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Todo is already open"})
+		return
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{"message": "Successfully reopened TODO"})
 }
 
 func main() {
+	cmd := "serve"
+	if len(os.Args) > 1 {
+		cmd = os.Args[1]
+	}
+
+	switch cmd {
+	case "serve":
+		serve()
+	case "fsck":
+		os.Exit(runFsck(os.Args[2:]))
+	case "export-archive":
+		os.Exit(runExportArchive(os.Args[2:]))
+	case "import-archive":
+		os.Exit(runImportArchive(os.Args[2:]))
+	case "anonymize-dump":
+		os.Exit(runAnonymizeDump(os.Args[2:]))
+	case "help", "-h", "--help":
+		cliUsage()
+	default:
+		os.Exit(runCLI(cmd, os.Args[2:]))
+	}
+}
+
+func serve() {
+	setup()
+
 	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, os.Interrupt)
 
+	goWorker("collection sampler", runSampler)
+	goWorker("template scheduler", runScheduler)
+	goWorker("audit log", runAuditLog)
+	goWorker("exports", runExports)
+	goWorker("imports", runImports)
+	goWorker("load shedder", runShedder)
+	goWorker("feature flags", runFlagPoller)
+	if linkPreviewsEnabled {
+		goWorker("link previews", runLinkPreviews)
+	}
+	if resurfacePerDay > 0 && staleAfterDays > 0 {
+		goWorker("resurfacer", runResurfacer)
+	}
+	if createQueue != nil {
+		goWorker("create batcher", runCreateBatcher)
+	}
+	if staleSnapshots {
+		goWorker("snapshot", runSnapshots)
+	}
+
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
+	r.Use(securityHeaders(loadSecurityConfig()))
+	r.Use(limitConcurrency(maxInFlight))
+	r.Use(trackLatency)
+	r.Use(corsMiddleware(loadCORSConfig()))
+	r.Use(refuseWritesInMaintenance)
+	r.Use(guardDryRun(r))
+	if staleSnapshots {
+		r.Use(failFastWhenDown)
+	}
+	if demoMode {
+		r.Use(demoSessions)
+	}
+	var limiter *rateLimiter
+	if limit := envInt("RATE_LIMIT", 300); limit > 0 {
+		limiter = newRateLimiter(limit, envDuration("RATE_LIMIT_WINDOW", time.Minute))
+		goWorker("rate limiter sweep", limiter.run)
+		r.Use(limiter.middleware)
+	}
 
-	r.Get("/", homeHandler)
-	r.Mount("/todo", todoHandlers())
+	r.NotFound(notFoundHandler)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Compress(5))
+		r.Get("/", homeHandler)
+		r.Mount("/partials", partialHandlers())
+		r.Get("/static/*", serveAsset)
+	})
+	r.Get("/metrics", metricsHandler)
+	r.Get("/healthz", healthHandler)
+	r.Get("/capabilities", capabilitiesHandler(r, limiter))
+	r.Mount("/todos", todoHandlers())
+	r.Mount("/templates", templateHandlers())
+	r.Mount("/tags", tagHandlers())
+	r.Mount("/limits", wipLimitHandlers())
+	r.Mount("/export-jobs", exportJobHandlers())
+	r.Mount("/import-jobs", importJobHandlers())
+	if adminToken != "" {
+		r.Mount("/admin", adminHandlers())
+	}
+	r.Get("/settings", getSettings)
+	r.With(requireJSON).Put("/settings", putSettings)
+	mountLegacyRedirects(r)
 
 	srv := &http.Server{
 		Addr:         port,
@@ -219,6 +1156,7 @@ func main() {
 		WriteTimeout: 60 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	srv.RegisterOnShutdown(beginShutdown)
 
 	go func() {
 		log.Println("Listening on Port", port)
@@ -229,28 +1167,84 @@ func main() {
 
 	<-stopChan
 	log.Println("Shutting down server...")
+	// Requests are drained first, so none is cut off and every create
+	// queued for a batch is queued before the batcher stops; then the
+	// workers, and only then is the database let go.
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server shutdown failed:%+v", err)
 	}
+	drainWorkers(5 * time.Second)
+	dctx, dcancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer dcancel()
+	if err := client.Disconnect(dctx); err != nil {
+		log.Printf("Mongo disconnect failed: %v", err)
+	}
 	log.Println("Server Gracefully stopped!!")
 }
 
+// mountLegacyRedirects routes the old singular /todo paths to
+// redirectToTodos.
+func mountLegacyRedirects(r chi.Router) {
+	r.Handle("/todo", http.HandlerFunc(redirectToTodos))
+	r.Handle("/todo/*", http.HandlerFunc(redirectToTodos))
+}
+
+// redirectToTodos sends requests for the old singular /todo routes to
+// /todos. A 308 keeps the method and body, so a replayed POST or PUT reaches
+// the same handler it used to.
+func redirectToTodos(w http.ResponseWriter, r *http.Request) {
+	target := "/todos" + strings.TrimPrefix(r.URL.Path, "/todo")
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, http.StatusPermanentRedirect)
+}
+
 func todoHandlers() http.Handler {
 	rg := chi.NewRouter()
+	rg.Use(causalConsistency)
 
 	rg.Group(func(r chi.Router) {
-		r.Get("/", fetchTodos)
-		r.Post("/", createTodos)
-		r.Put("/{id}", updateTodo)
+		r.With(expensiveWhen(isCostlyList)).Get("/", fetchTodos)
+		r.With(requireFlag(flagFeed)).Get("/feed.xml", fetchFeed)
+		r.Get("/schema", fetchSchema)
+		r.With(expensive).Get("/stats", fetchTodoStats)
+		r.Get("/completed-recent", fetchRecentlyCompleted)
+		r.With(requireFlag(flagSync)).Get("/changes", fetchChanges)
+		r.Get("/stale", fetchStaleTodos)
+		r.Post("/stale/reset", resetStaleTodos)
+		r.Get("/next", fetchNextTodo)
+		r.Get("/oldest", fetchOldestTodo)
+		r.With(requireFlag(flagImports), expensive).Post("/import.csv", importTodosCSV)
+		r.With(requireFlag(flagExports), expensive).Post("/export-jobs", createExportJob)
+		r.With(requireFlag(flagImports), expensive).Post("/import-jobs", createImportJob)
+	})
+
+	rg.Group(func(r chi.Router) {
+		r.Use(todoIDCtx)
+		r.Get("/{id}", getTodo)
+		r.Get("/{id}/history", todoHistory)
 		r.Delete("/{id}", deleteTodo)
+		r.Post("/{id}/reopen", reopenTodo)
+		r.Post("/{id}/editing", markEditing)
+		r.Delete("/{id}/editing", stopEditing)
+		r.With(requireJSON).Post("/{id}/triage", triageTodo)
+		r.Post("/{id}/move-to-top", moveToTop)
+		r.Post("/{id}/move-to-bottom", moveToBottom)
+		r.With(requireJSON).Put("/{id}", updateTodo)
+		r.With(requireJSON).Patch("/{id}", patchTodo)
 	})
-	return rg
-}
 
-func checkErr(err error) {
-	if err != nil {
-		log.Fatal(err)
-	}
+	rg.Group(func(r chi.Router) {
+		r.Use(requireJSON)
+		r.Post("/", createTodos)
+		r.Post("/validate", validateTodoPayload)
+		r.Patch("/batch", batchPatchTodos)
+		r.Post("/toggle-by-filter", toggleByFilter)
+		r.Post("/bulk-priority", bulkPriority)
+		r.Post("/swap", swapTodos)
+	})
+	return rg
 }
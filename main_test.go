@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// useTestDB points db at a fresh database on the server in TEST_MONGO_URI,
+// dropped when the test ends. Without TEST_MONGO_URI the test is skipped.
+func useTestDB(t testing.TB) {
+	t.Helper()
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Ping(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	prevClient, prevDB := client, db
+	client, db = c, c.Database("todo_test_"+primitive.NewObjectID().Hex())
+	rnd = renderer.New()
+	ensureIndexes()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := db.Drop(ctx); err != nil {
+			t.Logf("dropping %s: %v", db.Name(), err)
+		}
+		c.Disconnect(ctx)
+		client, db = prevClient, prevDB
+	})
+}
+
+// useMockDB points client and db at mt's mock deployment until the subtest
+// ends. Responses are queued with mt.AddMockResponses.
+func useMockDB(mt *mtest.T) {
+	prevClient, prevDB := client, db
+	client, db, rnd = mt.Client, mt.DB, renderer.New()
+	mt.Cleanup(func() { client, db = prevClient, prevDB })
+}
+
+// testRouter serves the todo routes the way serve mounts them, without the
+// middleware.
+func testRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Mount("/todos", todoHandlers())
+	return r
+}
+
+// TestLegacyRedirects follows the old /todo paths to a stand-in for the
+// /todos routes, expecting the query kept and a POST to arrive with its
+// method and body.
+func TestLegacyRedirects(t *testing.T) {
+	type received struct{ method, uri, body string }
+	got := make(chan received, 1)
+	r := chi.NewRouter()
+	r.Handle("/todos*", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got <- received{r.Method, r.URL.RequestURI(), string(b)}
+		w.WriteHeader(http.StatusOK)
+	}))
+	mountLegacyRedirects(r)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		method, path, body string
+		want               received
+	}{
+		{http.MethodGet, "/todo?completed=false&sort=-created_at", "", received{http.MethodGet, "/todos?completed=false&sort=-created_at", ""}},
+		{http.MethodGet, "/todo/65f0c0ffee0000000000beef?time_format=epoch", "", received{http.MethodGet, "/todos/65f0c0ffee0000000000beef?time_format=epoch", ""}},
+		{http.MethodPost, "/todo?dry_run=true", `{"title": "write tests"}`, received{http.MethodPost, "/todos?dry_run=true", `{"title": "write tests"}`}},
+		{http.MethodPut, "/todo/65f0c0ffee0000000000beef", `{"title": "write more tests"}`, received{http.MethodPut, "/todos/65f0c0ffee0000000000beef", `{"title": "write more tests"}`}},
+	} {
+		req, err := http.NewRequest(tc.method, srv.URL+tc.path, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: %d", tc.method, tc.path, res.StatusCode)
+		}
+		if r := <-got; r != tc.want {
+			t.Errorf("%s %s arrived as %+v; want %+v", tc.method, tc.path, r, tc.want)
+		}
+	}
+
+	// The redirect itself is a 308, which clients must follow with the
+	// same method and body.
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/todo?dry_run=true", strings.NewReader("{}")))
+	if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "/todos?dry_run=true" {
+		t.Errorf("POST /todo: %d to %q; want 308 to /todos?dry_run=true", w.Code, w.Header().Get("Location"))
+	}
+}
+
+// TestListUnpaged lists without a limit and expects every todo back.
+func TestListUnpaged(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("unpaged", func(mt *mtest.T) {
+		useMockDB(mt)
+		defer func(on bool) { listCountsEnabled = on }(listCountsEnabled)
+		listCountsEnabled = false
+
+		ns := mt.DB.Name() + "." + collectionName
+		todos := []bson.D{
+			{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "title", Value: "write tests"}},
+			{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "title", Value: "write more tests"}},
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, todos...),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, todos...),
+		)
+
+		w := httptest.NewRecorder()
+		testRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos", nil))
+		var res struct {
+			Data []todo `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			mt.Fatal(err)
+		}
+		if w.Code != http.StatusOK || len(res.Data) != 2 {
+			mt.Errorf("GET /todos: %d with %d todos; want both", w.Code, len(res.Data))
+		}
+	})
+}
+
+// TestListEmpty expects an empty collection listed as an empty array, not
+// null, in either shape.
+func TestListEmpty(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, accept := range []string{"application/json", jsonAPIMediaType} {
+		mt.Run(accept, func(mt *mtest.T) {
+			useMockDB(mt)
+			ns := mt.DB.Name() + "." + collectionName
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+			)
+
+			r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+			r.Header.Set("Accept", accept)
+			w := httptest.NewRecorder()
+			testRouter().ServeHTTP(w, r)
+			var res map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK {
+				mt.Fatalf("GET /todos: %d %s", w.Code, w.Body)
+			}
+			if got := string(res["data"]); got != "[]" {
+				mt.Errorf("data = %s; want []", got)
+			}
+		})
+	}
+}
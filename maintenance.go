@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// maintenance rejects writes while set, through MAINTENANCE_MODE or PUT
+// /admin/maintenance, so data can be worked on without stopping the
+// server. maintenanceSince is when it was turned on, in Unix nanoseconds.
+var (
+	maintenance      atomic.Bool
+	maintenanceSince atomic.Int64
+
+	// maintenanceRetryAfter is the Retry-After of refused writes, through
+	// MAINTENANCE_RETRY_AFTER.
+	maintenanceRetryAfter = time.Minute
+)
+
+var _ = newGaugeFunc("maintenance_mode", "1 while writes are refused for maintenance.", func() float64 {
+	if maintenance.Load() {
+		return 1
+	}
+	return 0
+})
+
+func setMaintenance(on bool) {
+	if on && !maintenance.Load() {
+		maintenanceSince.Store(time.Now().UnixNano())
+	}
+	maintenance.Store(on)
+}
+
+// refuseWritesInMaintenance answers every request other than a read with
+// 503 during maintenance. Dry runs and POST /todos/validate write nothing,
+// so they are still served, and the admin API stays open so maintenance
+// can be turned off again.
+func refuseWritesInMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if maintenance.Load() && !writesNothing(r) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(maintenanceRetryAfter.Seconds())))
+				respond(w, r, http.StatusServiceUnavailable, renderer.M{
+					"message": "The server is in maintenance and only serves reads; try again later",
+					"code":    "maintenance",
+				})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maintenanceStatus describes maintenance mode, for /healthz and the admin
+// API.
+func maintenanceStatus() renderer.M {
+	res := renderer.M{"enabled": maintenance.Load(), "since": nil}
+	if ns := maintenanceSince.Load(); ns != 0 && maintenance.Load() {
+		res["since"] = time.Unix(0, ns)
+	}
+	return res
+}
+
+func getMaintenance(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, renderer.M{"data": maintenanceStatus()})
+}
+
+// putMaintenance turns maintenance mode on or off until the next restart.
+func putMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Enabled == nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Enabled field is required", "field": "enabled"})
+		return
+	}
+	setMaintenance(*req.Enabled)
+	msg := "Maintenance mode turned off"
+	if *req.Enabled {
+		msg = "Maintenance mode turned on"
+	}
+	respond(w, r, http.StatusOK, renderer.M{"message": msg, "data": maintenanceStatus()})
+}
+
+func writesNothing(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/admin/") || r.URL.Path == "/todos/validate" || isDryRun(r)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Metadata limits. Keys become document field names, so they may not
+// contain dots or dollar signs.
+const (
+	maxMetadataBytes     = 4096
+	maxMetadataDepth     = 4
+	maxMetadataKeyLength = 64
+)
+
+// metadata is free-form key-value data attached to a todo.
+type metadata map[string]interface{}
+
+func (m metadata) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return xmlMap(m).MarshalXML(e, start)
+}
+
+func validateMetadata(m metadata) renderer.M {
+	fail := func(err string) renderer.M {
+		return renderer.M{"message": "Invalid metadata", "field": "metadata", "error": err}
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fail(err.Error())
+	}
+	if len(b) > maxMetadataBytes {
+		return fail(fmt.Sprintf("metadata may be at most %d bytes of JSON", maxMetadataBytes))
+	}
+	if err := checkMetadataValue(map[string]interface{}(m), 1); err != nil {
+		return fail(err.Error())
+	}
+	return nil
+}
+
+func checkMetadataValue(v interface{}, depth int) error {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if depth > maxMetadataDepth {
+			return fmt.Errorf("metadata may nest at most %d levels deep", maxMetadataDepth)
+		}
+		for k, child := range val {
+			if err := checkMetadataKey(k); err != nil {
+				return err
+			}
+			if err := checkMetadataValue(child, depth+1); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if depth > maxMetadataDepth {
+			return fmt.Errorf("metadata may nest at most %d levels deep", maxMetadataDepth)
+		}
+		for _, child := range val {
+			if err := checkMetadataValue(child, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkMetadataKey(k string) error {
+	switch {
+	case k == "":
+		return fmt.Errorf("metadata keys may not be empty")
+	case len(k) > maxMetadataKeyLength:
+		return fmt.Errorf("metadata key %q is longer than %d characters", k, maxMetadataKeyLength)
+	case strings.ContainsAny(k, ".$"):
+		return fmt.Errorf("metadata key %q may not contain '.' or '$'", k)
+	}
+	return nil
+}
+
+// parseMetaFilters reads ?meta.<key>=<value> parameters. A nested key is
+// addressed as meta.a.b. The value matches either as a string or, when it
+// parses as one, as a number or boolean, since the query string can't say
+// which was stored.
+func parseMetaFilters(q url.Values) ([]bson.M, error) {
+	var conds []bson.M
+	for name, values := range q {
+		path, ok := strings.CutPrefix(name, "meta.")
+		if !ok {
+			continue
+		}
+		for _, part := range strings.Split(path, ".") {
+			if err := checkMetadataKey(part); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		raw := values[0]
+		candidates := bson.A{raw}
+		if n, err := strconv.ParseFloat(raw, 64); err == nil {
+			candidates = append(candidates, n)
+		}
+		if b, err := strconv.ParseBool(raw); err == nil {
+			candidates = append(candidates, b)
+		}
+		conds = append(conds, bson.M{"metadata." + path: bson.M{"$in": candidates}})
+	}
+	return conds, nil
+}
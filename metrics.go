@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// A small Prometheus text-format registry. The app only needs a handful of
+// counters and gauges, which doesn't justify the full client library.
+
+type metric interface {
+	metricName() string
+	writeTo(w io.Writer)
+}
+
+var (
+	metricsMu sync.Mutex
+	registry  []metric
+)
+
+func register(m metric) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	registry = append(registry, m)
+}
+
+type counter struct {
+	name, help string
+	value      atomic.Uint64
+}
+
+func newCounter(name, help string) *counter {
+	c := &counter{name: name, help: help}
+	register(c)
+	return c
+}
+
+func (c *counter) Inc()               { c.value.Add(1) }
+func (c *counter) Add(n uint64)       { c.value.Add(n) }
+func (c *counter) Value() uint64      { return c.value.Load() }
+func (c *counter) metricName() string { return c.name }
+
+func (c *counter) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.value.Load())
+}
+
+type gauge struct {
+	name, help string
+	bits       atomic.Uint64
+}
+
+func newGauge(name, help string) *gauge {
+	g := &gauge{name: name, help: help}
+	register(g)
+	return g
+}
+
+func (g *gauge) Set(v float64)       { g.bits.Store(math.Float64bits(v)) }
+func (g *gauge) Value() float64      { return math.Float64frombits(g.bits.Load()) }
+func (g *gauge) metricName() string  { return g.name }
+func (g *gauge) writeTo(w io.Writer) { writeGauge(w, g.name, g.help, g.Value()) }
+
+// gaugeFunc reports whatever fn returns at scrape time.
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func newGaugeFunc(name, help string, fn func() float64) *gaugeFunc {
+	g := &gaugeFunc{name: name, help: help, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *gaugeFunc) metricName() string  { return g.name }
+func (g *gaugeFunc) writeTo(w io.Writer) { writeGauge(w, g.name, g.help, g.fn()) }
+
+func writeGauge(w io.Writer, name, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metricsMu.Lock()
+	ms := append([]metric(nil), registry...)
+	metricsMu.Unlock()
+	sort.Slice(ms, func(i, j int) bool { return ms[i].metricName() < ms[j].metricName() })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range ms {
+		m.writeTo(w)
+	}
+}
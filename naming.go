@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// JSON field naming of responses, configurable through JSON_NAMING.
+const (
+	namingSnake = "snake"
+	namingCamel = "camel"
+)
+
+var jsonNaming = namingSnake
+
+// camelCased marshals a value with every object key converted from
+// snake_case to camelCase, along with the "field" values of errors and
+// warnings, which name a field. The contents of metadata objects belong to
+// the caller and are left untouched.
+type camelCased struct{ v interface{} }
+
+// namedJSON returns v ready to be encoded with the configured naming.
+func namedJSON(v interface{}) interface{} {
+	if jsonNaming != namingCamel {
+		return v
+	}
+	return camelCased{v}
+}
+
+func (c camelCased) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(c.v)
+	if err != nil {
+		return nil, err
+	}
+	return jsonRenamer{key: snakeToCamel, fields: true}.rename(b)
+}
+
+// snakeCasedBody returns a request body with its object keys converted
+// from camelCase to snake_case, outside metadata, so a client under
+// JSON_NAMING=camel can send back the fields it was given. Keys already in
+// snake_case are kept. A body that isn't valid JSON is returned as it is,
+// for the decoder to report.
+func snakeCasedBody(data []byte) []byte {
+	if jsonNaming != namingCamel {
+		return data
+	}
+	out, err := jsonRenamer{key: camelToSnake}.rename(data)
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+// jsonRenamer rewrites the object keys of JSON documents.
+type jsonRenamer struct {
+	key func(string) string
+	// fields renames the "field" values of errors and warnings too.
+	fields bool
+}
+
+func (rn jsonRenamer) rename(b []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var buf bytes.Buffer
+	if err := rn.value(dec, &buf, false, false); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("trailing data after JSON value")
+	}
+	return buf.Bytes(), nil
+}
+
+// value copies the next JSON value from dec to buf. Keys are kept as they
+// are when raw is set, and a string value is renamed when isField is set.
+func (rn jsonRenamer) value(dec *json.Decoder, buf *bytes.Buffer, raw, isField bool) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			buf.WriteByte('[')
+			for i := 0; dec.More(); i++ {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				if err := rn.value(dec, buf, raw, false); err != nil {
+					return err
+				}
+			}
+			buf.WriteByte(']')
+		} else {
+			buf.WriteByte('{')
+			for i := 0; dec.More(); i++ {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				keyTok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key := keyTok.(string)
+				name := key
+				if !raw {
+					name = rn.key(key)
+				}
+				writeJSONString(buf, name)
+				buf.WriteByte(':')
+				if err := rn.value(dec, buf, raw || name == "metadata", rn.fields && !raw && key == "field"); err != nil {
+					return err
+				}
+			}
+			buf.WriteByte('}')
+		}
+		// Consume the closing delimiter.
+		_, err = dec.Token()
+		return err
+	case string:
+		if isField {
+			t = rn.key(t)
+		}
+		writeJSONString(buf, t)
+	case json.Number:
+		buf.WriteString(t.String())
+	case bool:
+		fmt.Fprint(buf, t)
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	buf.Write(b)
+}
+
+// snakeToCamel turns due_date into dueDate. A leading underscore, as in
+// _meta, is kept.
+func snakeToCamel(s string) string {
+	lead := len(s) - len(strings.TrimLeft(s, "_"))
+	parts := strings.Split(s[lead:], "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return s[:lead] + strings.Join(parts, "")
+}
+
+// camelToSnake turns dueDate into due_date, and leaves due_date as it is.
+func camelToSnake(s string) string {
+	var b strings.Builder
+	for i, c := range s {
+		if 'A' <= c && c <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			c += 'a' - 'A'
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// jsonFieldName returns the response name of a snake_case field name.
+func jsonFieldName(s string) string {
+	if jsonNaming == namingCamel {
+		return snakeToCamel(s)
+	}
+	return s
+}
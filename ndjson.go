@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NDJSON output flushes after this many lines or this much time, whichever
+// comes first, so consumers see progress without a write per document.
+const (
+	ndjsonFlushLines    = 500
+	ndjsonFlushInterval = time.Second
+)
+
+// parseListFormat reads ?format=, which is json (the default) or ndjson.
+func parseListFormat(q url.Values) (ndjson bool, err error) {
+	switch q.Get("format") {
+	case "", "json":
+		return false, nil
+	case "ndjson":
+		return true, nil
+	}
+	return false, fmt.Errorf("format must be json or ndjson")
+}
+
+// ndjsonMeta is the last line of an NDJSON stream. Consumers should treat
+// a stream without it, or with Truncated set, as incomplete.
+type ndjsonMeta struct {
+	Meta struct {
+		Count     int64  `json:"count"`
+		Skipped   int64  `json:"skipped,omitempty"`
+		Truncated bool   `json:"truncated"`
+		Error     string `json:"error,omitempty"`
+	} `json:"_meta"`
+}
+
+// streamNDJSON writes one todo per line straight from cur, never holding
+// more than one document in memory, then a trailing ndjsonMeta line. It
+// stops as soon as the client goes away, and ends early, marked truncated,
+// when the server shuts down.
+func streamNDJSON(w http.ResponseWriter, r *http.Request, cur *mongo.Cursor, tf timeFormat) {
+	// Large streams outlive the server's WriteTimeout; the client
+	// disconnecting is what ends them early.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	ctx := r.Context()
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	flush := func() error {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	var meta ndjsonMeta
+	lastFlush := time.Now()
+	for cur.Next(ctx) {
+		select {
+		case <-shuttingDown:
+			meta.Meta.Error = "server shutting down"
+		default:
+		}
+		if meta.Meta.Error != "" {
+			break
+		}
+		var t todoModel
+		if err := cur.Decode(&t); err != nil {
+			logSkippedTodo(cur, err)
+			meta.Meta.Skipped++
+			continue
+		}
+		if err := enc.Encode(namedJSON(t.toTodo().withTimeFormat(tf))); err != nil {
+			return
+		}
+		meta.Meta.Count++
+		if meta.Meta.Count%ndjsonFlushLines == 0 || time.Since(lastFlush) > ndjsonFlushInterval {
+			if err := flush(); err != nil {
+				return
+			}
+			lastFlush = time.Now()
+		}
+	}
+	if ctx.Err() != nil {
+		log.Printf("ndjson: client went away after %d todos", meta.Meta.Count)
+		return
+	}
+	if err := cur.Err(); err != nil && meta.Meta.Error == "" {
+		meta.Meta.Error = err.Error()
+	}
+	meta.Meta.Truncated = meta.Meta.Error != ""
+
+	enc.Encode(meta)
+	flush()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// discardWriter is a ResponseWriter keeping nothing but the line count and
+// the last line, calling sample every sampleEvery lines.
+type discardWriter struct {
+	header      http.Header
+	lines       int
+	line, last  []byte
+	sampleEvery int
+	sample      func()
+}
+
+func (w *discardWriter) Header() http.Header { return w.header }
+func (w *discardWriter) WriteHeader(int)     {}
+func (w *discardWriter) Flush()              {}
+
+func (w *discardWriter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if c != '\n' {
+			w.line = append(w.line, c)
+			continue
+		}
+		w.last = append(w.last[:0], w.line...)
+		w.line = w.line[:0]
+		if w.lines++; w.lines%w.sampleEvery == 0 {
+			w.sample()
+		}
+	}
+	return len(p), nil
+}
+
+// liveHeap returns the bytes of heap still reachable after a collection.
+func liveHeap() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// TestStreamNDJSONBoundedMemory streams 100k todos and expects the live
+// heap to stay flat throughout, where buffering the output would grow it
+// by tens of megabytes.
+func TestStreamNDJSONBoundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams 100k documents")
+	}
+	const n = 100_000
+	created := time.Date(2024, 3, 24, 18, 25, 59, 0, time.UTC)
+	docs := make([]interface{}, n)
+	for i := range docs {
+		b, err := bson.Marshal(bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "title", Value: "synthetic todo " + strconv.Itoa(i)},
+			{Key: "completed", Value: i%3 == 0},
+			{Key: "createAt", Value: created},
+			{Key: "tags", Value: bson.A{"bulk", "load"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs[i] = b
+	}
+	cur, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs = nil
+
+	base := liveHeap()
+	var peak uint64
+	w := &discardWriter{header: http.Header{}, sampleEvery: 10_000, sample: func() {
+		peak = max(peak, liveHeap())
+	}}
+	streamNDJSON(w, httptest.NewRequest(http.MethodGet, "/todos?format=ndjson", nil), cur, timeFormatRFC3339)
+
+	if w.lines != n+1 {
+		t.Fatalf("streamed %d lines; want %d todos and the meta line", w.lines, n+1)
+	}
+	var meta ndjsonMeta
+	if err := json.Unmarshal(w.last, &meta); err != nil || meta.Meta.Count != n || meta.Meta.Truncated {
+		t.Errorf("meta line %s: %+v, %v; want a complete count of %d", w.last, meta, err, n)
+	}
+	if !bytes.HasPrefix(w.last, []byte(`{"_meta"`)) {
+		t.Errorf("last line = %s; want the meta line", w.last)
+	}
+	t.Logf("live heap %d KiB before streaming, at most %d KiB during", base>>10, peak>>10)
+	if peak > base+4<<20 {
+		t.Errorf("live heap grew from %d KiB to %d KiB while streaming; want it bounded", base>>10, peak>>10)
+	}
+}
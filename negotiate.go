@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// respond writes v as JSON, as a JSON:API document when the request's
+// Accept header lists application/vnd.api+json, or as XML when it prefers
+// application/xml or text/xml. JSON stays the default, including for
+// requests without an Accept header. JSON keys follow JSON_NAMING.
+func respond(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Add("Vary", "Accept")
+	if m, ok := v.(renderer.M); ok && isDryRun(r) {
+		v = dryRunResult(status, m)
+	}
+	if wantsJSONAPI(r) {
+		respondJSONAPI(w, r, status, v)
+		return
+	}
+	if !wantsXML(r) {
+		rnd.JSON(w, status, namedJSON(v))
+		return
+	}
+
+	if m, ok := v.(renderer.M); ok {
+		v = xmlMap(m)
+	}
+	b, err := xml.Marshal(v)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, namedJSON(renderer.M{"message": "Failed to encode XML", "error": err.Error()}))
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+}
+
+// wantsXML reports whether the Accept header ranks XML above JSON. A
+// wildcard counts for JSON, so "*/*" alone keeps the default.
+func wantsXML(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+
+	var xmlQ, jsonQ, wildQ float64
+	jsonSeen := false
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/xml", "text/xml":
+			xmlQ = max(xmlQ, q)
+		case "application/json":
+			jsonQ, jsonSeen = max(jsonQ, q), true
+		case "*/*", "application/*":
+			wildQ = max(wildQ, q)
+		}
+	}
+	if !jsonSeen {
+		jsonQ = wildQ
+	}
+	return xmlQ > jsonQ
+}
+
+// xmlMap marshals a response envelope as a <response> element with one
+// child per key, in sorted order so the output is stable. Slices get one
+// child element per item.
+type xmlMap renderer.M
+
+func (m xmlMap) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if start.Name.Local == "" || start.Name.Local == "xmlMap" {
+		start.Name.Local = "response"
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := encodeXMLValue(e, xmlName(k), m[k]); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func encodeXMLValue(e *xml.Encoder, name string, v interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	switch val := v.(type) {
+	case renderer.M:
+		return xmlMap(val).MarshalXML(e, start)
+	case map[string]interface{}:
+		return xmlMap(val).MarshalXML(e, start)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() == reflect.Uint8 {
+		return e.EncodeElement(v, start)
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for i := 0; i < rv.Len(); i++ {
+		item := rv.Index(i).Interface()
+		if err := encodeXMLValue(e, xmlItemName(item), item); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// xmlItemName names the element of one slice item.
+func xmlItemName(item interface{}) string {
+	switch item.(type) {
+	case todo:
+		return "todo"
+	case todoTemplate:
+		return "template"
+	case tagCount:
+		return "tag"
+	case batchPatchResult:
+		return "result"
+	case historyEntry:
+		return "change"
+	}
+	return "item"
+}
+
+// xmlName turns an envelope key such as "Todo ID" into a valid element
+// name.
+func xmlName(key string) string {
+	return strings.ReplaceAll(strings.TrimSpace(key), " ", "_")
+}
+
+// fieldTimes maps API field names to timestamps. encoding/xml can't marshal
+// maps, so in XML each entry becomes <field name="title">time</field>.
+type fieldTimes map[string]time.Time
+
+func (f fieldTimes) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		el := xml.StartElement{
+			Name: xml.Name{Local: "field"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: name}},
+		}
+		if err := e.EncodeElement(f[name], el); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fetchNextTodo answers with the single open todo to work on now: highest
+// priority first, then earliest due date, with undated todos after dated
+// ones, then the oldest. Someday todos are never picked. It answers 204
+// when there is nothing to do.
+func fetchNextTodo(w http.ResponseWriter, r *http.Request) {
+	tf, err := parseTimeFormat(r.URL.Query())
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid time format", "error": err.Error()})
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	match := bson.M{"$and": bson.A{bson.M{"completed": false}, bucketCond(defaultBuckets)}}
+	cur, err := db.Collection(collectionName).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: scoped(ctx, match)}},
+		{{Key: "$addFields", Value: bson.M{
+			"_rank": bson.M{"$switch": bson.M{
+				"branches": bson.A{
+					bson.M{"case": bson.M{"$eq": bson.A{"$priority", priorityHigh}}, "then": 3},
+					bson.M{"case": bson.M{"$eq": bson.A{"$priority", priorityMedium}}, "then": 2},
+					bson.M{"case": bson.M{"$eq": bson.A{"$priority", priorityLow}}, "then": 1},
+				},
+				"default": 0,
+			}},
+			"_undated": bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$dueDate", nil}}, nil}},
+		}}},
+		{{Key: "$sort", Value: bson.D{
+			{Key: "_rank", Value: -1},
+			{Key: "_undated", Value: 1},
+			{Key: "dueDate", Value: 1},
+			{Key: "createAt", Value: 1},
+			{Key: "_id", Value: 1},
+		}}},
+		{{Key: "$limit", Value: 1}},
+	})
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch todo", "error": err.Error()})
+		return
+	}
+	var todos []todoModel
+	if err := cur.All(ctx, &todos); err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to decode todos", "error": err.Error()})
+		return
+	}
+
+	if len(todos) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{"data": todos[0].toTodo().withTimeFormat(tf)})
+}
+
+// fetchOldestTodo answers with the open todo that has waited longest since
+// it was created, in any bucket, or 204 when every todo is done.
+func fetchOldestTodo(w http.ResponseWriter, r *http.Request) {
+	tf, err := parseTimeFormat(r.URL.Query())
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid time format", "error": err.Error()})
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	var tm todoModel
+	opts := options.FindOne().SetSort(bson.D{{Key: "createAt", Value: 1}, {Key: "_id", Value: 1}})
+	err = db.Collection(collectionName).FindOne(ctx, scoped(ctx, bson.M{"completed": false}), opts).Decode(&tm)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch todo", "error": err.Error()})
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{"data": tm.toTodo().withTimeFormat(tf)})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Page sizes of list endpoints, configurable through DEFAULT_PAGE_SIZE and
+// MAX_PAGE_SIZE. Zero means unlimited: without a default every match is
+// returned, as before paging existed.
+var (
+	defaultPageSize = 0
+	maxPageSize     = 0
+)
+
+// paging is the ?page= and ?limit= of a list request, shared by every list
+// endpoint so they size and clamp pages the same way.
+type paging struct {
+	Page  int
+	Limit int
+	// Requested is the limit the client asked for, when MAX_PAGE_SIZE
+	// clamped it.
+	Requested int
+	// probe fetches one todo past the page, to learn whether another page
+	// follows.
+	probe bool
+}
+
+// parsePaging reads ?page= and ?limit=. A missing limit falls back to
+// defaultPageSize, and one above maxPageSize is clamped to it rather than
+// rejected, which would break naive clients.
+func parsePaging(q url.Values) (paging, error) {
+	p := paging{Page: 1, Limit: defaultPageSize}
+
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("limit must be a positive integer")
+		}
+		p.Limit = n
+	}
+	if maxPageSize > 0 {
+		if p.Limit > maxPageSize {
+			p.Requested = p.Limit
+		}
+		if p.Limit == 0 || p.Limit > maxPageSize {
+			p.Limit = maxPageSize
+		}
+	}
+
+	if s := q.Get("page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("page must be a positive integer")
+		}
+		if p.Limit == 0 {
+			return p, fmt.Errorf("page requires limit")
+		}
+		p.Page = n
+	}
+	return p, nil
+}
+
+func (p paging) skip() int {
+	return (p.Page - 1) * p.Limit
+}
+
+func (p paging) apply(opts *options.FindOptions) *options.FindOptions {
+	if p.Limit > 0 {
+		limit := p.Limit
+		if p.probe {
+			limit++
+		}
+		opts.SetLimit(int64(limit)).SetSkip(int64(p.skip()))
+	}
+	return opts
+}
+
+// meta describes the effective paging for the response, so clients can
+// learn the server's limits. A clamped limit is also flagged with a
+// Warning header.
+func (p paging) meta(w http.ResponseWriter) renderer.M {
+	m := renderer.M{
+		"page":          p.Page,
+		"limit":         p.Limit,
+		"default_limit": defaultPageSize,
+		"max_limit":     maxPageSize,
+	}
+	if p.Requested > 0 {
+		m["clamped"] = true
+		m["requested_limit"] = p.Requested
+		w.Header().Add("Warning", fmt.Sprintf(`199 - "limit clamped to %d"`, p.Limit))
+	}
+	return m
+}
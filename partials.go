@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// homePageSize is how many todos the home page lists at a time,
+// configurable through HOME_PAGE_SIZE. 0 lists them all.
+var homePageSize = 50
+
+// homeMaxAge is how long a browser may reuse the home page before asking
+// again, configurable through HOME_MAX_AGE. 0 turns off caching of it.
+var homeMaxAge = 10 * time.Second
+
+// bootID tells apart the data versions of different runs of the server,
+// which all count from zero. It also retires pages linking to assets or
+// rendered from templates that a restart replaced.
+var bootID = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+type (
+	homeSummary struct {
+		Total     int64
+		Completed int64
+		LastAdded time.Time
+		// OOB marks the summary for an out-of-band swap when it rides along
+		// with a fragment that targets something else.
+		OOB bool
+	}
+	todoListView struct {
+		Todos    []todo
+		Page     int
+		PrevPage int
+		NextPage int
+	}
+	// rowUpdate is the answer to a create or toggle: the row plus the
+	// summary, whose counts just changed.
+	rowUpdate struct {
+		Todo    todo
+		Summary homeSummary
+	}
+)
+
+func (s homeSummary) Pending() int64 { return s.Total - s.Completed }
+
+// isHTMX reports whether the request was made by htmx, which wants a
+// fragment rather than a full page.
+func isHTMX(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true" && r.Header.Get("HX-History-Restore-Request") != "true"
+}
+
+// varyOnHTMX marks the response as depending on HX-Request, so caches don't
+// hand a fragment to a full page load or the other way round.
+func varyOnHTMX(w http.ResponseWriter) {
+	w.Header().Add("Vary", "HX-Request")
+}
+
+// homeETag validates GET / and its list fragment. It is derived from
+// dataVersion rather than the page, so a reload is answered without a
+// query. Like the read cache, it misses writes made by other instances.
+func homeETag(r *http.Request) string {
+	kind := "page"
+	if isHTMX(r) {
+		kind = "list"
+	}
+	if s := demoSessionOf(r.Context()).ID; s != "" {
+		kind += "-" + s
+	}
+	return fmt.Sprintf(`W/"%s-%d-%s"`, bootID, dataVersion.Load(), kind)
+}
+
+// setHomeCaching adds the validator and lifetime of a home page response.
+func setHomeCaching(w http.ResponseWriter, etag string) {
+	if homeMaxAge <= 0 {
+		w.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(homeMaxAge.Seconds())))
+}
+
+// etagMatches reports whether an If-None-Match header names etag. ETags are
+// compared weakly, as If-None-Match requires.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func partialHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Get("/todo-list", todoListPartial)
+	rg.Post("/todo", createTodoPartial)
+	rg.With(todoIDCtx).Post("/todo/{id}/toggle", toggleTodoPartial)
+	return rg
+}
+
+// homePageNumber reads ?page=, starting at 1. Anything unusable shows the
+// first page rather than an error.
+func homePageNumber(r *http.Request) int {
+	n, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// loadTodoList fetches one page of the home page list, oldest first. A page
+// past the end shows the last one.
+func loadTodoList(ctx context.Context, page int, total int64) (todoListView, error) {
+	view := todoListView{Page: page}
+	opts := options.Find().
+		SetSort(listOptions{SortField: "createAt"}.sort()).
+		SetProjection(projectionOf(todoHeadline{}))
+	if homePageSize > 0 {
+		pages := int((total + int64(homePageSize) - 1) / int64(homePageSize))
+		if pages < 1 {
+			pages = 1
+		}
+		if view.Page > pages {
+			view.Page = pages
+		}
+		if view.Page > 1 {
+			view.PrevPage = view.Page - 1
+		}
+		if view.Page < pages {
+			view.NextPage = view.Page + 1
+		}
+		opts.SetSkip(int64((view.Page - 1) * homePageSize)).SetLimit(int64(homePageSize))
+	}
+
+	cur, err := db.Collection(collectionName).Find(ctx, scoped(ctx, bson.M{}), opts)
+	if err != nil {
+		return view, err
+	}
+	var todos []todoHeadline
+	if err := cur.All(ctx, &todos); err != nil {
+		return view, err
+	}
+	for _, t := range todos {
+		view.Todos = append(view.Todos, t.toTodo())
+	}
+	return view, nil
+}
+
+// loadSummary counts the todos for the header of the home page.
+func loadSummary(ctx context.Context) (homeSummary, error) {
+	cur, err := db.Collection(collectionName).Aggregate(ctx, scopedPipeline(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":       nil,
+			"total":     bson.M{"$sum": 1},
+			"completed": bson.M{"$sum": bson.M{"$cond": bson.A{"$completed", 1, 0}}},
+			"lastAdded": bson.M{"$max": "$createAt"},
+		}}},
+	}))
+	if err != nil {
+		return homeSummary{}, err
+	}
+	var rows []struct {
+		Total     int64     `bson:"total"`
+		Completed int64     `bson:"completed"`
+		LastAdded time.Time `bson:"lastAdded"`
+	}
+	if err := cur.All(ctx, &rows); err != nil || len(rows) == 0 {
+		return homeSummary{}, err
+	}
+	return homeSummary{Total: rows[0].Total, Completed: rows[0].Completed, LastAdded: rows[0].LastAdded}, nil
+}
+
+// todoListPartial renders one page of the list as the todo-list fragment,
+// for the pager links.
+func todoListPartial(w http.ResponseWriter, r *http.Request) {
+	varyOnHTMX(w)
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	summary, err := loadSummary(ctx)
+	if err != nil {
+		partialFailed(w, "counting todos", err, "We couldn't load your todos. Please try again.")
+		return
+	}
+	list, err := loadTodoList(ctx, homePageNumber(r), summary.Total)
+	if err != nil {
+		partialFailed(w, "fetching todos", err, "We couldn't load your todos. Please try again.")
+		return
+	}
+	renderPage(w, r, http.StatusOK, "todo-list", list)
+}
+
+// createTodoPartial adds a todo from the form on the home page and answers
+// with its row.
+func createTodoPartial(w http.ResponseWriter, r *http.Request) {
+	varyOnHTMX(w)
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	t := todo{Title: r.PostFormValue("title")}
+	if m := validateTodo(&t, nil); m != nil {
+		msg, _ := m["message"].(string)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	tm, err := insertTodo(ctx, t)
+	if err == errDemoFull {
+		http.Error(w, errDemoFull.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		partialFailed(w, "saving todo", err, "We couldn't save your todo. Please try again.")
+		return
+	}
+	renderRowUpdate(w, r, ctx, http.StatusCreated, tm)
+}
+
+// toggleTodoPartial flips a todo between done and not done and answers
+// with its row. The flip happens in the database, so two quick clicks
+// always end where they started.
+func toggleTodoPartial(w http.ResponseWriter, r *http.Request) {
+	varyOnHTMX(w)
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	now := time.Now()
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"completed":                bson.M{"$not": bson.A{"$completed"}},
+			"fieldUpdatedAt.completed": now,
+			"statusChangedAt":          now,
+			"updatedAt":                now,
+			// A done todo has no stored status, so it only needs clearing.
+			"status": "$$REMOVE",
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"completedAt": bson.M{"$cond": bson.A{"$completed", bson.M{"$ifNull": bson.A{"$completedAt", now}}, "$$REMOVE"}},
+		}}},
+	}
+	tm, err := updateTodoAudited(ctx, bson.M{"_id": todoID(r)}, update)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Todo not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		partialFailed(w, "toggling todo", err, "We couldn't update your todo. Please try again.")
+		return
+	}
+	renderRowUpdate(w, r, ctx, http.StatusOK, tm)
+}
+
+// renderRowUpdate renders the todo's row followed by the summary as an
+// out-of-band swap. If the counts can't be loaded the row goes out alone.
+func renderRowUpdate(w http.ResponseWriter, r *http.Request, ctx context.Context, status int, tm todoModel) {
+	summary, err := loadSummary(ctx)
+	if err != nil {
+		renderPage(w, r, status, "todo-row", tm.toTodo())
+		return
+	}
+	summary.OOB = true
+	renderPage(w, r, status, "todo-row-update", rowUpdate{Todo: tm.toTodo(), Summary: summary})
+}
+
+// partialFailed logs err and answers a fragment request that failed. htmx
+// doesn't swap error responses, so a short message is enough.
+func partialFailed(w http.ResponseWriter, op string, err error, message string) {
+	log.Printf("partials: %s: %v", op, err)
+	http.Error(w, message, http.StatusInternalServerError)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxBatchSize caps the number of items in one PATCH /todos/batch request.
+var maxBatchSize = 100
+
+// nullable distinguishes a field left out of a PATCH body from one
+// explicitly set to null, which clears it.
+type nullable[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (n *nullable[T]) UnmarshalJSON(b []byte) error {
+	n.Set = true
+	if string(b) == "null" {
+		n.Value = nil
+		return nil
+	}
+	var v T
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	n.Value = &v
+	return nil
+}
+
+// todoPatch is a partial update: only the fields present in the body change.
+type todoPatch struct {
+	Title     *string                `json:"title"`
+	Completed *bool                  `json:"completed"`
+	Status    *string                `json:"status"`
+	DueDate   nullable[time.Time]    `json:"due_date"`
+	Location  nullable[todoLocation] `json:"location"`
+	Tags      *[]string              `json:"tags"`
+	Estimate  nullable[int]          `json:"estimate"`
+	Priority  nullable[string]       `json:"priority"`
+	Metadata  nullable[metadata]     `json:"metadata"`
+}
+
+// validate checks p, normalizing its title and tags in place and noting the
+// changes in n.
+func (p *todoPatch) validate(n *normalizations) renderer.M {
+	if p.Title == nil && p.Completed == nil && p.Status == nil && !p.DueDate.Set && !p.Location.Set && p.Tags == nil && !p.Estimate.Set && !p.Priority.Set && !p.Metadata.Set {
+		return renderer.M{"message": "Nothing to update"}
+	}
+	if p.Title != nil {
+		if *p.Title == "" {
+			return renderer.M{"message": "Title field is required", "field": "title"}
+		}
+		*p.Title = normalizeTitle(*p.Title, n)
+		if m := validateTitle(*p.Title); m != nil {
+			return m
+		}
+	}
+	if p.Status != nil {
+		if m := validateStatus(*p.Status); m != nil {
+			return m
+		}
+		if p.Completed != nil && *p.Completed != (*p.Status == statusDone) {
+			return renderer.M{"message": "Completed and status disagree", "field": "status"}
+		}
+	}
+	if p.Location.Value != nil {
+		if err := p.Location.Value.validate(); err != nil {
+			return renderer.M{"message": "Invalid location", "field": "location", "error": err.Error()}
+		}
+	}
+	if p.Tags != nil {
+		*p.Tags = normalizeTags(*p.Tags, n)
+		if m := validateTags(*p.Tags); m != nil {
+			return m
+		}
+	}
+	if p.Estimate.Value != nil {
+		if m := validateEstimate(*p.Estimate.Value); m != nil {
+			return m
+		}
+	}
+	if p.Priority.Value != nil {
+		if m := validatePriority(*p.Priority.Value); m != nil {
+			return m
+		}
+	}
+	if p.Metadata.Value != nil {
+		if m := validateMetadata(*p.Metadata.Value); m != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+func (p *todoPatch) writes(now time.Time) *fieldWrites {
+	fw := newFieldWrites(now)
+	if p.Title != nil {
+		fw.set("title", "title", *p.Title)
+	}
+	if p.Status != nil {
+		fw.setStatus(*p.Status)
+	} else if p.Completed != nil {
+		fw.setCompleted(*p.Completed)
+	}
+	if p.DueDate.Set {
+		if p.DueDate.Value != nil {
+			fw.set("due_date", "dueDate", *p.DueDate.Value)
+		} else {
+			fw.set("due_date", "dueDate", nil)
+		}
+	}
+	if p.Location.Set {
+		if l := p.Location.Value; l != nil {
+			fw.set("location", "location", newGeoPoint(*l.Lat, *l.Lng))
+			fw.set("location", "locationLabel", l.Label)
+		} else {
+			fw.set("location", "location", nil)
+			fw.set("location", "locationLabel", nil)
+		}
+	}
+	if p.Tags != nil {
+		fw.set("tags", "tags", *p.Tags)
+	}
+	if p.Estimate.Set {
+		if p.Estimate.Value != nil {
+			fw.set("estimate", "estimate", *p.Estimate.Value)
+		} else {
+			fw.set("estimate", "estimate", nil)
+		}
+	}
+	if p.Priority.Set {
+		if p.Priority.Value != nil {
+			fw.set("priority", "priority", *p.Priority.Value)
+		} else {
+			fw.set("priority", "priority", nil)
+		}
+	}
+	if p.Metadata.Set {
+		if p.Metadata.Value != nil {
+			fw.set("metadata", "metadata", *p.Metadata.Value)
+		} else {
+			fw.set("metadata", "metadata", nil)
+		}
+	}
+	return fw
+}
+
+// applyPatch writes p to the todo and returns the updated document, or
+// mongo.ErrNoDocuments when it does not exist.
+func applyPatch(ctx context.Context, idFilter bson.M, p *todoPatch) (todoModel, error) {
+	return updateTodoLimited(ctx, idFilter, p.writes(time.Now()))
+}
+
+func patchTodo(w http.ResponseWriter, r *http.Request) {
+	idFilter := scoped(r.Context(), bson.M{"_id": todoID(r)})
+
+	var p todoPatch
+	if !decodeJSON(w, r, &p) {
+		return
+	}
+	var notes normalizations
+	if m := p.validate(&notes); m != nil {
+		respond(w, r, http.StatusBadRequest, m)
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	in := warningInput{ID: todoID(r), Title: p.Title, DueDate: p.DueDate.Value}
+	if p.Tags != nil {
+		in.Tags = *p.Tags
+	}
+	warnings, ok := checkWarnings(w, r, ctx, in)
+	if !ok {
+		return
+	}
+
+	applyUpdate(w, r, ctx, idFilter, p.writes(time.Now()), warnings, notes)
+}
+
+type (
+	batchPatchItem struct {
+		ID  string          `json:"id"`
+		Set json.RawMessage `json:"set"`
+	}
+	batchPatchResult struct {
+		Index  int    `json:"index" xml:"index"`
+		ID     string `json:"id" xml:"id"`
+		Status int    `json:"status" xml:"status"`
+		Error  xmlMap `json:"error,omitempty" xml:"error,omitempty"`
+		Data   *todo  `json:"data,omitempty" xml:"data,omitempty"`
+		// Normalizations lists what was changed in the item's set.
+		Normalizations normalizations `json:"normalizations,omitempty" xml:"normalizations>normalization,omitempty"`
+	}
+)
+
+// batchPatchTodos applies many independent patches in one request. Items
+// run in order, so repeating an ID applies its patches in sequence, and
+// each one succeeds or fails on its own. The response is 200 when every
+// item succeeded and 207 otherwise.
+func batchPatchTodos(w http.ResponseWriter, r *http.Request) {
+	var items []batchPatchItem
+	if !decodeJSON(w, r, &items) {
+		return
+	}
+	if len(items) == 0 {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Batch is empty"})
+		return
+	}
+	if len(items) > maxBatchSize {
+		respond(w, r, http.StatusBadRequest, renderer.M{
+			"message": fmt.Sprintf("Batch may contain at most %d items", maxBatchSize),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 30*time.Second)
+	defer cancel()
+
+	results := make([]batchPatchResult, len(items))
+	allOK := true
+	for i, item := range items {
+		results[i] = patchBatchItem(ctx, i, item)
+		if results[i].Status != http.StatusOK {
+			allOK = false
+		}
+	}
+
+	status := http.StatusOK
+	if !allOK {
+		status = http.StatusMultiStatus
+	}
+	respond(w, r, status, renderer.M{"data": results})
+}
+
+func patchBatchItem(ctx context.Context, index int, item batchPatchItem) batchPatchResult {
+	res := batchPatchResult{Index: index, ID: item.ID}
+	fail := func(status int, m renderer.M) batchPatchResult {
+		res.Status, res.Error = status, xmlMap(m)
+		return res
+	}
+
+	idFilter, ok := todoIDFilter(item.ID)
+	if !ok {
+		return fail(http.StatusBadRequest, renderer.M{"message": "Invalid ID"})
+	}
+
+	var p todoPatch
+	if m := decodeJSONBody(bytes.NewReader(item.Set), &p); m != nil {
+		return fail(http.StatusBadRequest, m)
+	}
+	var notes normalizations
+	if m := p.validate(&notes); m != nil {
+		return fail(http.StatusBadRequest, m)
+	}
+
+	t, err := applyPatch(ctx, idFilter, &p)
+	var limitErr *wipLimitError
+	if errors.As(err, &limitErr) {
+		return fail(http.StatusConflict, limitErr.response())
+	}
+	if err == mongo.ErrNoDocuments {
+		return fail(http.StatusNotFound, renderer.M{"message": "Todo not found"})
+	}
+	if err != nil {
+		return fail(http.StatusInternalServerError, renderer.M{"message": "Failed to update todo", "error": err.Error()})
+	}
+
+	out := t.toTodo()
+	res.Status, res.Data, res.Normalizations = http.StatusOK, &out, notes
+	return res
+}
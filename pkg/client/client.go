@@ -0,0 +1,390 @@
+// Package client is a small typed HTTP client for the todo REST API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the server answers 404 for a todo.
+	ErrNotFound = errors.New("todo not found")
+	// ErrValidation matches any *ValidationError via errors.Is.
+	ErrValidation = errors.New("validation failed")
+)
+
+// Todo mirrors the JSON representation served by the API.
+type Todo struct {
+	ID        string `json:"id"`
+	ShortID   string `json:"short_id,omitempty"`
+	Title     string `json:"title"`
+	Completed bool   `json:"completed"`
+	// Status is "todo", "in_progress", "done" or "blocked". It is "done"
+	// exactly when Completed is set.
+	Status    string     `json:"status,omitempty"`
+	CreatedAt time.Time  `json:"create_at"`
+	DueDate   *time.Time `json:"due_date,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	Position  float64    `json:"position"`
+	// Estimate is the expected effort in minutes.
+	Estimate *int `json:"estimate,omitempty"`
+	// Priority is "low", "medium", "high" or empty.
+	Priority string `json:"priority,omitempty"`
+	// Score is the search relevance, set only by text searches.
+	Score *float64 `json:"score,omitempty"`
+	// Metadata is free-form data attached by the caller.
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ReopenCount int        `json:"reopen_count"`
+	ReopenedAt  *time.Time `json:"reopened_at,omitempty"`
+
+	Location *Location `json:"location,omitempty"`
+	// DistanceM is only set on results of a List with Near.
+	DistanceM *float64 `json:"distance_m,omitempty"`
+
+	// FieldUpdatedAt maps field names to when each last changed.
+	FieldUpdatedAt map[string]time.Time `json:"field_updated_at,omitempty"`
+}
+
+// Location is an optional point attached to a todo.
+type Location struct {
+	Lat   float64 `json:"lat"`
+	Lng   float64 `json:"lng"`
+	Label string  `json:"label,omitempty"`
+}
+
+// ListOptions are the filters and paging accepted by List. Zero and nil
+// fields are not sent.
+type ListOptions struct {
+	Completed *bool
+	HasDue    *bool
+	// Status is a comma separated list of statuses.
+	Status string
+
+	// Near is "lat,lng"; Radius is a distance such as "500m" or "2km".
+	Near   string
+	Radius string
+
+	// EstimateLTE keeps todos estimated at most this many minutes.
+	EstimateLTE int
+
+	// Query searches titles, best matches first.
+	Query string
+
+	Sort  string // e.g. "created_at" or "-due_date"
+	Page  int
+	Limit int
+}
+
+func (o ListOptions) values() url.Values {
+	q := url.Values{}
+	if o.Completed != nil {
+		q.Set("completed", strconv.FormatBool(*o.Completed))
+	}
+	if o.HasDue != nil {
+		q.Set("has_due", strconv.FormatBool(*o.HasDue))
+	}
+	if o.Status != "" {
+		q.Set("status", o.Status)
+	}
+	if o.Near != "" {
+		q.Set("near", o.Near)
+	}
+	if o.Radius != "" {
+		q.Set("radius", o.Radius)
+	}
+	if o.EstimateLTE > 0 {
+		q.Set("estimate_lte", strconv.Itoa(o.EstimateLTE))
+	}
+	if o.Query != "" {
+		q.Set("q", o.Query)
+	}
+	if o.Sort != "" {
+		q.Set("sort", o.Sort)
+	}
+	if o.Page > 0 {
+		q.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	return q
+}
+
+// APIError is returned for any non-2xx response without a more specific
+// error type.
+type APIError struct {
+	StatusCode int
+	Message    string
+	Detail     string
+}
+
+func (e *APIError) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("%s (%d): %s", e.Message, e.StatusCode, e.Detail)
+	}
+	return fmt.Sprintf("%s (%d)", e.Message, e.StatusCode)
+}
+
+// ValidationError is returned when the server rejects a request body or
+// query: 400 for invalid input, 415 for a body that isn't JSON and 422 for
+// a write refused under strict handling. Field names the offending field
+// when the server reports one; Code is the server's machine-readable
+// reason, such as "strict_warning".
+type ValidationError struct {
+	StatusCode int
+	Message    string
+	Field      string
+	Code       string
+	Detail     string
+	// Warnings are the soft checks that refused a strict write.
+	Warnings []Warning
+}
+
+// Warning is a soft check the server reports on a write.
+type Warning struct {
+	Code    string `json:"code"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s: %s", e.Field, e.Message)
+	}
+	return e.Message
+}
+
+// Is makes errors.Is(err, ErrValidation) true for every ValidationError.
+func (e *ValidationError) Is(target error) bool { return target == ErrValidation }
+
+// Client talks to a todo server rooted at BaseURL. Requests answered with
+// 429 or 503 are retried up to MaxRetries times, honoring Retry-After.
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+	MaxRetries int
+}
+
+// New returns a Client for baseURL. An empty token sends no Authorization
+// header; timeout bounds each individual HTTP attempt.
+func New(baseURL, token string, timeout time.Duration) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: timeout},
+		MaxRetries: 3,
+	}
+}
+
+// List returns the todos matching opts.
+func (c *Client) List(ctx context.Context, opts ListOptions) ([]Todo, error) {
+	path := "/todos"
+	if q := opts.values().Encode(); q != "" {
+		path += "?" + q
+	}
+
+	var out struct {
+		Data []Todo `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Data, nil
+}
+
+// Get returns a single todo. id may be the ObjectID or the short ID.
+func (c *Client) Get(ctx context.Context, id string) (*Todo, error) {
+	var out struct {
+		Data Todo `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/todos/"+url.PathEscape(id), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// Create stores a new todo from the writable fields of t and returns its
+// ID. Fields the server assigns, such as the ID and position, are ignored.
+func (c *Client) Create(ctx context.Context, t Todo) (string, error) {
+	var out struct {
+		ID string `json:"Todo ID"`
+	}
+	body := Todo{
+		Title:     t.Title,
+		Completed: t.Completed,
+		Status:    t.Status,
+		DueDate:   t.DueDate,
+		Tags:      t.Tags,
+		Estimate:  t.Estimate,
+		Priority:  t.Priority,
+		Metadata:  t.Metadata,
+		Location:  t.Location,
+	}
+	if err := c.do(ctx, http.MethodPost, "/todos", body, &out); err != nil {
+		return "", err
+	}
+	return out.ID, nil
+}
+
+// Update replaces the title, completed state and due date of t.ID.
+func (c *Client) Update(ctx context.Context, t Todo) error {
+	return c.do(ctx, http.MethodPut, "/todos/"+url.PathEscape(t.ID), t, nil)
+}
+
+// Patch changes only the given fields, e.g. {"completed": true}, and
+// returns the updated todo. A nil value clears due_date or location.
+func (c *Client) Patch(ctx context.Context, id string, fields map[string]interface{}) (*Todo, error) {
+	var out struct {
+		Data Todo `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodPatch, "/todos/"+url.PathEscape(id), fields, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// Toggle flips the completed state of a todo and returns the new state.
+func (c *Client) Toggle(ctx context.Context, id string) (*Todo, error) {
+	t, err := c.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	t.Completed = !t.Completed
+	// The server refuses a status that disagrees with an unchanged
+	// completed, so it must follow.
+	t.Status = "todo"
+	if t.Completed {
+		t.Status = "done"
+	}
+	if err := c.Update(ctx, *t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reopen marks a completed todo as open again. Reopening an open todo is
+// reported as a *ValidationError.
+func (c *Client) Reopen(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/todos/"+url.PathEscape(id)+"/reopen", nil, nil)
+}
+
+// Delete removes a todo.
+func (c *Client) Delete(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/todos/"+url.PathEscape(id), nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var payload []byte
+	if in != nil {
+		var err error
+		if payload, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := c.send(ctx, method, path, payload)
+		if err != nil {
+			return err
+		}
+
+		retryable := res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable
+		if !retryable || attempt >= c.MaxRetries {
+			defer res.Body.Close()
+			return decodeResponse(res, out)
+		}
+
+		wait := retryAfter(res.Header.Get("Retry-After"), attempt)
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, payload []byte) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+func decodeResponse(res *http.Response, out interface{}) error {
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		if out == nil || res.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		return json.NewDecoder(res.Body).Decode(out)
+	}
+
+	var env struct {
+		Message  string    `json:"message"`
+		Error    string    `json:"error"`
+		Field    string    `json:"field"`
+		Code     string    `json:"code"`
+		Warnings []Warning `json:"warnings"`
+	}
+	json.NewDecoder(res.Body).Decode(&env)
+	if env.Message == "" {
+		env.Message = http.StatusText(res.StatusCode)
+	}
+
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusBadRequest, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+		return &ValidationError{
+			StatusCode: res.StatusCode,
+			Message:    env.Message,
+			Field:      env.Field,
+			Code:       env.Code,
+			Detail:     env.Error,
+			Warnings:   env.Warnings,
+		}
+	}
+	return &APIError{StatusCode: res.StatusCode, Message: env.Message, Detail: env.Error}
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an
+// HTTP date, falling back to exponential backoff when it is absent.
+func retryAfter(h string, attempt int) time.Duration {
+	if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return (500 * time.Millisecond) << attempt
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecodeResponseErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		validation bool
+		field      string
+		code       string
+	}{
+		{"invalid input", http.StatusBadRequest, `{"message":"Title field is required","field":"title"}`, true, "title", ""},
+		{"not json", http.StatusUnsupportedMediaType, `{"message":"Content-Type must be application/json","code":"unsupported_media_type"}`, true, "", "unsupported_media_type"},
+		{"strict warning", http.StatusUnprocessableEntity, `{"message":"Rejected by strict handling","code":"strict_warning","warnings":[{"code":"due_date_past","field":"due_date","message":"Due date is in the past"}]}`, true, "", "strict_warning"},
+		{"validate endpoint", http.StatusUnprocessableEntity, `{"message":"Title field is required","field":"title","valid":false}`, true, "title", ""},
+		{"server error", http.StatusInternalServerError, `{"message":"Failed to save todo"}`, false, "", ""},
+		{"conflict", http.StatusConflict, `{"message":"Busy"}`, false, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rec.WriteHeader(tt.status)
+			rec.WriteString(tt.body)
+			err := decodeResponse(rec.Result(), nil)
+
+			var ve *ValidationError
+			if got := errors.As(err, &ve); got != tt.validation {
+				t.Fatalf("err = %#v; want a ValidationError: %v", err, tt.validation)
+			}
+			if !tt.validation {
+				var ae *APIError
+				if !errors.As(err, &ae) || ae.StatusCode != tt.status {
+					t.Errorf("err = %#v; want an APIError with %d", err, tt.status)
+				}
+				return
+			}
+			if ve.StatusCode != tt.status || ve.Field != tt.field || ve.Code != tt.code || !errors.Is(err, ErrValidation) {
+				t.Errorf("err = %+v; want status %d, field %q, code %q", ve, tt.status, tt.field, tt.code)
+			}
+		})
+	}
+}
+
+func TestDecodeResponseStrictWarnings(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.WriteHeader(http.StatusUnprocessableEntity)
+	rec.WriteString(`{"message":"Rejected by strict handling","code":"strict_warning","warnings":[{"code":"due_date_past","field":"due_date","message":"Due date is in the past"}]}`)
+
+	var ve *ValidationError
+	if err := decodeResponse(rec.Result(), nil); !errors.As(err, &ve) {
+		t.Fatalf("err = %v; want a ValidationError", err)
+	}
+	if len(ve.Warnings) != 1 || ve.Warnings[0].Code != "due_date_past" || ve.Warnings[0].Field != "due_date" {
+		t.Errorf("Warnings = %+v; want due_date_past on due_date", ve.Warnings)
+	}
+}
+
+func TestDecodeResponseNotFound(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.WriteHeader(http.StatusNotFound)
+	rec.WriteString(`{"message":"Todo not found"}`)
+	if err := decodeResponse(rec.Result(), nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v; want ErrNotFound", err)
+	}
+}
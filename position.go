@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// edgePosition returns a position just before the first todo (top) or just
+// after the last one. Positions are floats so items can later be placed
+// between neighbours without renumbering.
+func edgePosition(ctx context.Context, top bool) (float64, error) {
+	dir, step := -1, 1.0
+	if top {
+		dir, step = 1, -1.0
+	}
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: "position", Value: dir}}).
+		SetProjection(bson.M{"position": 1})
+
+	var edge struct {
+		Position float64 `bson:"position"`
+	}
+	err := db.Collection(collectionName).FindOne(ctx, scoped(ctx, bson.M{"position": bson.M{"$exists": true}}), opts).Decode(&edge)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return edge.Position + step, nil
+}
+
+func moveToTop(w http.ResponseWriter, r *http.Request)    { moveTodo(w, r, true) }
+func moveToBottom(w http.ResponseWriter, r *http.Request) { moveTodo(w, r, false) }
+
+func moveTodo(w http.ResponseWriter, r *http.Request, top bool) {
+	idFilter := scoped(r.Context(), bson.M{"_id": todoID(r)})
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	pos, err := edgePosition(ctx, top)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to move todo", "error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"position": pos, "updatedAt": now, "fieldUpdatedAt.position": now}}
+	t, err := updateTodoAudited(ctx, idFilter, update)
+	if err == mongo.ErrNoDocuments {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Todo not found"})
+		return
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to move todo", "error": err.Error()})
+		return
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{"message": "Successfully moved TODO", "data": t.toTodo()})
+}
+
+// errSwapNotFound aborts the swap transaction when a todo is missing.
+var errSwapNotFound = errors.New("todo not found")
+
+// swapTodos exchanges the positions of two todos in one transaction, so no
+// reader sees both at the same position.
+func swapTodos(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDA string `json:"id_a"`
+		IDB string `json:"id_b"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	filterA, okA := todoIDFilter(req.IDA)
+	filterB, okB := todoIDFilter(req.IDB)
+	switch {
+	case !okA:
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid ID", "field": "id_a"})
+		return
+	case !okB:
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid ID", "field": "id_b"})
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	filterA, filterB = scoped(ctx, filterA), scoped(ctx, filterB)
+
+	sess := mongo.SessionFromContext(ctx)
+	if sess == nil {
+		var err error
+		if sess, err = client.StartSession(); err != nil {
+			respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to swap todos", "error": err.Error()})
+			return
+		}
+		defer sess.EndSession(ctx)
+	}
+
+	now := time.Now()
+	var a, b todoModel
+	var missing string
+	_, err := sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		collection := db.Collection(collectionName)
+		if err := collection.FindOne(sc, filterA).Decode(&a); err != nil {
+			if err == mongo.ErrNoDocuments {
+				missing = "id_a"
+				return nil, errSwapNotFound
+			}
+			return nil, err
+		}
+		if err := collection.FindOne(sc, filterB).Decode(&b); err != nil {
+			if err == mongo.ErrNoDocuments {
+				missing = "id_b"
+				return nil, errSwapNotFound
+			}
+			return nil, err
+		}
+		if a.ID == b.ID {
+			return nil, nil
+		}
+		for _, u := range []struct {
+			id  primitive.ObjectID
+			pos float64
+		}{{a.ID, b.Position}, {b.ID, a.Position}} {
+			update := bson.M{"$set": bson.M{"position": u.pos, "updatedAt": now, "fieldUpdatedAt.position": now}}
+			if _, err := collection.UpdateByID(sc, u.id, update); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if errors.Is(err, errSwapNotFound) {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Todo not found", "field": missing})
+		return
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to swap todos", "error": err.Error()})
+		return
+	}
+	if a.ID == b.ID {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Cannot swap a todo with itself", "field": "id_b"})
+		return
+	}
+
+	// The changes are recorded only once the transaction has committed, as
+	// it may have been retried.
+	todosChanged()
+	afterA, afterB := swappedCopy(a, b.Position, now), swappedCopy(b, a.Position, now)
+	recordChange(auditUpdate, &a, &afterA)
+	recordChange(auditUpdate, &b, &afterB)
+	respond(w, r, http.StatusOK, renderer.M{"message": "Successfully swapped TODOs", "data": []todo{afterA.toTodo(), afterB.toTodo()}})
+}
+
+func swappedCopy(t todoModel, pos float64, now time.Time) todoModel {
+	t.Position = pos
+	t.UpdatedAt = &now
+	fields := make(map[string]time.Time, len(t.FieldUpdatedAt)+1)
+	for k, v := range t.FieldUpdatedAt {
+		fields[k] = v
+	}
+	fields["position"] = now
+	t.FieldUpdatedAt = fields
+	return t
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	presenceCollection = "presence"
+	maxPresenceName    = 64
+)
+
+// presenceTTL is how long an editing heartbeat counts, configurable through
+// PRESENCE_TTL. Clients should send one well within it.
+var presenceTTL = 30 * time.Second
+
+type (
+	presenceModel struct {
+		ID       string             `bson:"_id"`
+		TodoID   primitive.ObjectID `bson:"todoId"`
+		Actor    string             `bson:"actor"`
+		Name     string             `bson:"name"`
+		Since    time.Time          `bson:"since"`
+		ExpireAt time.Time          `bson:"expireAt"`
+	}
+	editor struct {
+		Name      string    `json:"name" xml:"name"`
+		Since     time.Time `json:"since" xml:"since"`
+		ExpiresAt time.Time `json:"expires_at" xml:"expires_at"`
+	}
+)
+
+// presenceActor identifies the caller: by bearer token when there is one,
+// otherwise by address and display name, which is the best an anonymous
+// caller offers.
+func presenceActor(r *http.Request, name string) string {
+	if key, ok := settingsKey(r); ok {
+		return key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	sum := sha256.Sum256([]byte(host + "\x00" + name))
+	return hex.EncodeToString(sum[:])
+}
+
+// markEditing records a heartbeat saying the caller is editing the todo and
+// answers with everyone else editing it. Presence lives in Mongo so every
+// replica sees it. It is advisory: writes never look at it.
+func markEditing(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Name = normalizeText(req.Name)
+	if textLength(req.Name) > maxPresenceName {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid name", "field": "name", "error": "name is too long"})
+		return
+	}
+	if req.Name == "" {
+		req.Name = "Someone"
+	}
+
+	id := todoID(r)
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	err := db.Collection(collectionName).FindOne(ctx, scoped(ctx, bson.M{"_id": id}), options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if err == mongo.ErrNoDocuments {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Todo not found"})
+		return
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to record presence", "error": err.Error()})
+		return
+	}
+
+	actor := presenceActor(r, req.Name)
+	now := time.Now()
+	expires := now.Add(presenceTTL)
+	_, err = db.Collection(presenceCollection).UpdateOne(ctx,
+		bson.M{"_id": id.Hex() + ":" + actor},
+		bson.M{
+			"$set":         bson.M{"todoId": id, "actor": actor, "name": req.Name, "expireAt": expires},
+			"$setOnInsert": bson.M{"since": now},
+		},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to record presence", "error": err.Error()})
+		return
+	}
+
+	others, err := editorsOf(ctx, id, actor)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch presence", "error": err.Error()})
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{"message": "Presence recorded", "expires_at": expires, "currently_editing": others})
+}
+
+// stopEditing drops the caller's presence before it would expire.
+func stopEditing(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Name = normalizeText(req.Name); req.Name == "" {
+		req.Name = "Someone"
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	key := todoID(r).Hex() + ":" + presenceActor(r, req.Name)
+	if _, err := db.Collection(presenceCollection).DeleteOne(ctx, bson.M{"_id": key}); err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to clear presence", "error": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// editorsOf lists who is editing the todo, leaving out actor. The TTL index
+// only sweeps about once a minute, so expired entries are filtered here.
+func editorsOf(ctx context.Context, id primitive.ObjectID, actor string) ([]editor, error) {
+	filter := bson.M{"todoId": id, "actor": bson.M{"$ne": actor}, "expireAt": bson.M{"$gt": time.Now()}}
+	cur, err := db.Collection(presenceCollection).Find(ctx, filter, options.Find().SetSort(bson.M{"since": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var entries []presenceModel
+	if err := cur.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	editors := []editor{}
+	for _, e := range entries {
+		editors = append(editors, editor{Name: e.Name, Since: e.Since, ExpiresAt: e.ExpireAt})
+	}
+	return editors, nil
+}
+
+// currentEditors is editorsOf for GET /todos/{id}, where presence is
+// an extra: a failure is logged and the field left out.
+func currentEditors(ctx context.Context, r *http.Request, id primitive.ObjectID) []editor {
+	actor := ""
+	if key, ok := settingsKey(r); ok {
+		actor = key
+	}
+	editors, err := editorsOf(ctx, id, actor)
+	if err != nil {
+		log.Printf("presence: %s: %v", id.Hex(), err)
+		return nil
+	}
+	return editors
+}
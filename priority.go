@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Todo priorities. A todo without one has no priority rather than a
+// default, so filters only match what the user chose.
+const (
+	priorityLow    = "low"
+	priorityMedium = "medium"
+	priorityHigh   = "high"
+)
+
+var priorities = map[string]bool{priorityLow: true, priorityMedium: true, priorityHigh: true}
+
+func validatePriority(p string) renderer.M {
+	if !priorities[p] {
+		return renderer.M{"message": "Priority must be low, medium or high", "field": "priority"}
+	}
+	return nil
+}
+
+// bulkPriorityRequest sets the priority of every todo matching Filter,
+// which may not be empty.
+type bulkPriorityRequest struct {
+	Filter   bulkFilter `json:"filter"`
+	Priority string     `json:"priority"`
+}
+
+func bulkPriority(w http.ResponseWriter, r *http.Request) {
+	var req bulkPriorityRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Priority == "" {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Priority field is required", "field": "priority"})
+		return
+	}
+	if m := validatePriority(req.Priority); m != nil {
+		respond(w, r, http.StatusBadRequest, m)
+		return
+	}
+	filter, m := req.Filter.todoFilter()
+	if m != nil {
+		respond(w, r, http.StatusBadRequest, m)
+		return
+	}
+	if filter.empty() {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Filter is required", "field": "filter"})
+		return
+	}
+
+	// Todos that already have the priority are left alone, so
+	// ModifiedCount only counts real changes.
+	query := bson.M{"$and": bson.A{filter.query(), bson.M{"priority": bson.M{"$ne": req.Priority}}}}
+
+	fw := newFieldWrites(time.Now())
+	fw.set("priority", "priority", req.Priority)
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	modified, err := updateManyAudited(ctx, query, fw.pipeline())
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to update todos", "error": err.Error()})
+		return
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{"message": "Successfully updated TODOs", "modified": modified})
+}
+
+// priorityCount is one bar of the priority distribution. Priority is nil
+// for todos without one.
+type priorityCount struct {
+	Priority *string `bson:"_id" json:"priority" xml:"priority"`
+	Count    int64   `bson:"count" json:"count" xml:"count"`
+}
+
+// countByPriority counts todos per priority, highest first and then those
+// without one. Every priority is listed, with 0 when unused, so a chart
+// always has the same bars.
+func countByPriority(ctx context.Context) ([]priorityCount, error) {
+	cur, err := db.Collection(collectionName).Aggregate(ctx, scopedPipeline(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$priority", "count": bson.M{"$sum": 1}}}},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var found []priorityCount
+	if err := cur.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	counts := map[string]int64{}
+	for _, c := range found {
+		key := ""
+		if c.Priority != nil {
+			key = *c.Priority
+		}
+		counts[key] += c.Count
+	}
+
+	stats := []priorityCount{}
+	for _, p := range []string{priorityHigh, priorityMedium, priorityLow} {
+		p := p
+		stats = append(stats, priorityCount{Priority: &p, Count: counts[p]})
+	}
+	return append(stats, priorityCount{Count: counts[""]}), nil
+}
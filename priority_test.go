@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestBulkPriorityRecordsHistory(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("bulk priority", func(mt *mtest.T) {
+		useMockDB(mt)
+		drainAudit()
+
+		ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+		ns := mt.DB.Name() + "." + collectionName
+		doc := func(id primitive.ObjectID, priority string) bson.D {
+			return bson.D{{Key: "_id", Value: id}, {Key: "title", Value: "t"}, {Key: "tags", Value: bson.A{"work"}}, {Key: "priority", Value: priority}}
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, doc(ids[0], priorityLow), doc(ids[1], priorityMedium)),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 2}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, doc(ids[0], priorityHigh), doc(ids[1], priorityHigh)),
+		)
+
+		r := httptest.NewRequest(http.MethodPost, "/todos/bulk-priority", strings.NewReader(`{"filter":{"tag":"work"},"priority":"high"}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		testRouter().ServeHTTP(w, r)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"modified":2`) {
+			mt.Fatalf("bulk priority: %d %s", w.Code, w.Body)
+		}
+
+		entries := drainAudit()
+		if len(entries) != 2 {
+			mt.Fatalf("history has %d entries; want one per todo", len(entries))
+		}
+		for i, e := range entries {
+			if e.TodoID != ids[i] || e.Type != auditUpdate || e.After.Priority != priorityHigh {
+				mt.Errorf("entry %d = %s of %s to %q; want update of %s to high", i, e.Type, e.TodoID.Hex(), e.After.Priority, ids[i].Hex())
+			}
+		}
+	})
+}
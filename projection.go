@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Narrow views of a todo for paths that only need a few of its fields.
+// They are fetched with projectionOf, so the projection always matches the
+// struct: a field added to one is fetched, and a field not in one can't be
+// read by mistake as it would be from a half-filled todoModel.
+type (
+	// todoHeadline is what the home page rows and the RSS feed show.
+	todoHeadline struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		Title     string             `bson:"title"`
+		Completed bool               `bson:"completed"`
+		CreateAt  time.Time          `bson:"createAt"`
+	}
+	// todoDue is what triage checks its warnings against.
+	todoDue struct {
+		ID      primitive.ObjectID `bson:"_id"`
+		DueDate *time.Time         `bson:"dueDate,omitempty"`
+	}
+)
+
+// toTodo fills in the fields of a todo that a headline has.
+func (h todoHeadline) toTodo() todo {
+	return todo{
+		ID:        h.ID.Hex(),
+		Title:     h.Title,
+		Completed: h.Completed,
+		CreatedAt: h.CreateAt,
+	}
+}
+
+// projectionOf returns a projection of the fields v, a struct, decodes.
+func projectionOf(v interface{}) bson.M {
+	t := reflect.TypeOf(v)
+	p := bson.M{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("bson"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		p[name] = 1
+	}
+	return p
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// narrowViews are the projected structs read in place of todoModel.
+var narrowViews = []interface{}{todoHeadline{}, todoDue{}}
+
+// bsonFields maps the bson names of a struct's fields to the fields.
+func bsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("bson"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// TestNarrowViewsMatchTodoModel guards the projected structs against
+// drifting from todoModel: every field must be stored under the same name
+// with the same type, and be what projectionOf fetches.
+func TestNarrowViewsMatchTodoModel(t *testing.T) {
+	full := bsonFields(reflect.TypeOf(todoModel{}))
+	for _, v := range narrowViews {
+		vt := reflect.TypeOf(v)
+		fields := bsonFields(vt)
+		for name, f := range fields {
+			ff, ok := full[name]
+			if !ok {
+				t.Errorf("%s.%s reads %q, which todoModel doesn't store", vt.Name(), f.Name, name)
+				continue
+			}
+			if f.Type != ff.Type {
+				t.Errorf("%s.%s is %s; todoModel.%s is %s", vt.Name(), f.Name, f.Type, ff.Name, ff.Type)
+			}
+		}
+		p := projectionOf(v)
+		if len(p) != len(fields) {
+			t.Errorf("projectionOf(%s) = %v; want exactly its %d fields", vt.Name(), p, len(fields))
+		}
+		for name := range fields {
+			if p[name] != 1 {
+				t.Errorf("projectionOf(%s) lacks %s", vt.Name(), name)
+			}
+		}
+	}
+}
+
+// TestNarrowViewsDecodeLikeTodoModel stores a full todo, reads it back
+// through each view's projection, and expects every field the view has to
+// hold what the full todo does; a headline also converts to the same todo
+// fields the full one does.
+func TestNarrowViewsDecodeLikeTodoModel(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	due := now.Add(24 * time.Hour)
+	tm := todoModel{
+		ID:        primitive.NewObjectID(),
+		ShortID:   "abc123",
+		Title:     "write tests",
+		Completed: true,
+		Status:    statusDone,
+		CreateAt:  now,
+		DueDate:   &due,
+		Tags:      []string{"work"},
+		Metadata:  metadata{"source": "test"},
+	}
+	b, err := bson.Marshal(tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	fullValues := reflect.ValueOf(tm)
+	fullFields := bsonFields(reflect.TypeOf(tm))
+
+	for _, v := range narrowViews {
+		// What Mongo returns for the projection.
+		projected := bson.M{}
+		for name := range projectionOf(v) {
+			if val, ok := doc[name]; ok {
+				projected[name] = val
+			}
+		}
+		raw, err := bson.Marshal(projected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		view := reflect.New(reflect.TypeOf(v))
+		if err := bson.Unmarshal(raw, view.Interface()); err != nil {
+			t.Fatal(err)
+		}
+		for name, f := range bsonFields(reflect.TypeOf(v)) {
+			got := view.Elem().FieldByIndex(f.Index).Interface()
+			want := fullValues.FieldByIndex(fullFields[name].Index).Interface()
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s.%s = %v; todoModel has %v", view.Elem().Type().Name(), f.Name, got, want)
+			}
+		}
+
+		if h, ok := view.Elem().Interface().(todoHeadline); ok {
+			got, want := reflect.ValueOf(h.toTodo()), reflect.ValueOf(tm.toTodo())
+			for i := 0; i < got.NumField(); i++ {
+				if !got.Field(i).IsZero() && !reflect.DeepEqual(got.Field(i).Interface(), want.Field(i).Interface()) {
+					t.Errorf("headline todo %s = %v; full todo has %v", got.Type().Field(i).Name, got.Field(i), want.Field(i))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sortFields maps the public sort keys accepted by ?sort= to document fields.
+var sortFields = map[string]string{
+	"created_at": "createAt",
+	"title":      "title",
+	"due_date":   "dueDate",
+	"completed":  "completed",
+	"position":   "position",
+	"estimate":   "estimate",
+}
+
+// listParams are the query parameters read by parseListOptions.
+var listParams = []string{"sort", "page", "limit", "cursor"}
+
+// strictQueryParams makes list endpoints reject query parameters they don't
+// know instead of ignoring them, configurable through STRICT_QUERY_PARAMS.
+var strictQueryParams = false
+
+// unknownParams returns the parameters of q that are in none of the known
+// sets, sorted. A known name ending in ".*" allows any parameter with that
+// prefix.
+func unknownParams(q url.Values, known ...[]string) []string {
+	allowed := map[string]bool{}
+	var prefixes []string
+	for _, set := range known {
+		for _, name := range set {
+			if prefix, ok := strings.CutSuffix(name, "*"); ok {
+				prefixes = append(prefixes, prefix)
+			} else {
+				allowed[name] = true
+			}
+		}
+	}
+	var unknown []string
+	for name := range q {
+		if allowed[name] || hasAnyPrefix(name, prefixes) {
+			continue
+		}
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// listOptions holds the ordering and paging parameters of a list request.
+type listOptions struct {
+	SortField string
+	SortDesc  bool
+	// Cursor is the ?cursor= position to continue after, if any.
+	Cursor *listCursor
+	paging
+	// fields, when set, narrows the todos read to these fields.
+	fields bson.M
+}
+
+// parseListOptions reads ?sort=[-]field, ?page=, ?limit= and ?cursor=.
+func parseListOptions(q url.Values) (listOptions, error) {
+	o := listOptions{SortField: "createAt"}
+
+	if s := q.Get("sort"); s != "" {
+		key := strings.TrimPrefix(s, "-")
+		field, ok := sortFields[key]
+		if !ok {
+			return o, fmt.Errorf("cannot sort by %q", key)
+		}
+		o.SortField = field
+		o.SortDesc = strings.HasPrefix(s, "-")
+	}
+
+	var err error
+	if o.paging, err = parsePaging(q); err != nil {
+		return o, err
+	}
+
+	if token := q.Get("cursor"); token != "" {
+		if q.Has("page") {
+			return o, fmt.Errorf("cursor and page cannot be combined")
+		}
+		if o.Cursor, err = parseCursor(token, o); err != nil {
+			return o, err
+		}
+	}
+	return o, nil
+}
+
+// sort returns the sort document. _id is always appended as a tiebreaker so
+// documents sharing a timestamp keep a stable order across pages.
+func (o listOptions) sort() bson.D {
+	dir := 1
+	if o.SortDesc {
+		dir = -1
+	}
+	return bson.D{{Key: o.SortField, Value: dir}, {Key: "_id", Value: dir}}
+}
+
+func (o listOptions) findOptions() *options.FindOptions {
+	opts := o.apply(options.Find().SetSort(o.sort()))
+	if o.fields != nil {
+		opts.SetProjection(o.fields)
+	}
+	return opts
+}
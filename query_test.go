@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestListSortEndsWithID(t *testing.T) {
+	for key, field := range sortFields {
+		for _, prefix := range []string{"", "-"} {
+			o, err := parseListOptions(url.Values{"sort": {prefix + key}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := 1
+			if prefix == "-" {
+				dir = -1
+			}
+			want := bson.D{{Key: field, Value: dir}, {Key: "_id", Value: dir}}
+			if got := o.sort(); !equalSort(got, want) {
+				t.Errorf("sort=%s%s: sort() = %v; want %v", prefix, key, got, want)
+			}
+		}
+	}
+}
+
+func equalSort(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sortLikeMongo orders todos the way Mongo would under doc, for the fields
+// this test uses. Keys past the last one in doc are left in storage order,
+// as Mongo leaves them in no particular one.
+func sortLikeMongo(todos []todoModel, doc bson.D) {
+	sort.SliceStable(todos, func(i, j int) bool {
+		for _, e := range doc {
+			var c int
+			switch e.Key {
+			case "createAt":
+				c = todos[i].CreateAt.Compare(todos[j].CreateAt)
+			case "_id":
+				c = bytes.Compare(todos[i].ID[:], todos[j].ID[:])
+			}
+			if c != 0 {
+				return c*e.Value.(int) < 0
+			}
+		}
+		return false
+	})
+}
+
+// TestStablePagingWithTiedTimestamps pages through todos created in the
+// same millisecond, met in a different order by each query, and expects
+// every todo exactly once and the same pages each time.
+func TestStablePagingWithTiedTimestamps(t *testing.T) {
+	created := time.Date(2024, 3, 24, 18, 25, 59, 0, time.UTC)
+	todos := make([]todoModel, 7)
+	for i := range todos {
+		todos[i] = todoModel{ID: primitive.NewObjectID(), Title: "bulk " + strconv.Itoa(i), CreateAt: created}
+	}
+
+	for _, sortParam := range []string{"created_at", "-created_at"} {
+		var first []primitive.ObjectID
+		for run := 0; run < 5; run++ {
+			var seen []primitive.ObjectID
+			for page := 1; page <= 3; page++ {
+				o, err := parseListOptions(url.Values{"sort": {sortParam}, "limit": {"3"}, "page": {strconv.Itoa(page)}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Each page is its own query, free to meet the todos in
+				// another order.
+				sorted := append([]todoModel(nil), todos...)
+				rand.New(rand.NewSource(int64(run*10+page))).Shuffle(len(sorted), func(i, j int) {
+					sorted[i], sorted[j] = sorted[j], sorted[i]
+				})
+				sortLikeMongo(sorted, o.sort())
+				end := min(o.skip()+o.Limit, len(sorted))
+				for _, tm := range sorted[o.skip():end] {
+					seen = append(seen, tm.ID)
+				}
+			}
+
+			unique := map[primitive.ObjectID]bool{}
+			for _, id := range seen {
+				unique[id] = true
+			}
+			if len(seen) != len(todos) || len(unique) != len(todos) {
+				t.Fatalf("sort=%s: pages held %d todos, %d distinct; want each of %d once", sortParam, len(seen), len(unique), len(todos))
+			}
+			if first == nil {
+				first = seen
+				continue
+			}
+			for i := range seen {
+				if seen[i] != first[i] {
+					t.Fatalf("sort=%s: order changed between runs: %v, then %v", sortParam, first, seen)
+				}
+			}
+		}
+	}
+}
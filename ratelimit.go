@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// rateLimiter is a fixed-window limiter keyed by client. Every response it
+// sees carries the client's remaining budget so SDKs can back off before
+// they hit the hard limit.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	count int
+	reset time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, buckets: map[string]*rateBucket{}}
+}
+
+// take counts one request for key and reports whether it is allowed, how
+// many requests remain, and when the window resets.
+func (l *rateLimiter) take(key string, now time.Time) (ok bool, remaining int, reset time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.buckets[key]
+	if b == nil || !now.Before(b.reset) {
+		b = &rateBucket{reset: now.Add(l.window)}
+		l.buckets[key] = b
+	}
+	if b.count >= l.limit {
+		return false, 0, b.reset
+	}
+	b.count++
+	return true, l.limit - b.count, b.reset
+}
+
+// sweep drops buckets whose window has passed.
+func (l *rateLimiter) sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if !now.Before(b.reset) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// run sweeps expired buckets once per window until ctx is done.
+func (l *rateLimiter) run(ctx context.Context) {
+	t := time.NewTicker(l.window)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			l.sweep(now)
+		}
+	}
+}
+
+// rateLimitKey identifies the caller by remote address. In demo mode an
+// established session is its own caller; a request starting a new one
+// counts against its address, so dropping the cookie doesn't reset the
+// budget. A bearer token only counts when it is ADMIN_TOKEN: any other is
+// unverified, and keying on it would let a client get a fresh budget by
+// sending a new one with each request.
+func rateLimitKey(r *http.Request) string {
+	if isAdmin(r) {
+		return "admin"
+	}
+	if s := demoSessionOf(r.Context()); s.ID != "" && !s.New {
+		return "session:" + s.ID
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
+
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		ok, remaining, reset := l.take(rateLimitKey(r), now)
+
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(l.limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+		if !ok {
+			retry := int(math.Ceil(reset.Sub(now).Seconds()))
+			h.Set("Retry-After", strconv.Itoa(retry))
+			respond(w, r, http.StatusTooManyRequests, renderer.M{
+				"message": "Rate limit exceeded",
+				"code":    "rate_limited",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+func TestRateLimitKey(t *testing.T) {
+	defer func(tok string) { adminToken = tok }(adminToken)
+	adminToken = "admin-secret"
+
+	tests := []struct {
+		name    string
+		auth    string
+		session demoSession
+		want    string
+	}{
+		{"address", "", demoSession{}, "addr:192.0.2.1"},
+		{"unverified token", "Bearer made-up", demoSession{}, "addr:192.0.2.1"},
+		{"another unverified token", "Bearer made-up-2", demoSession{}, "addr:192.0.2.1"},
+		{"admin token", "Bearer admin-secret", demoSession{}, "admin"},
+		{"demo session", "", demoSession{ID: "s1"}, "session:s1"},
+		{"demo session with a token", "Bearer made-up", demoSession{ID: "s1"}, "session:s1"},
+		{"new demo session", "", demoSession{ID: "s1", New: true}, "addr:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+			r.RemoteAddr = "192.0.2.1:5555"
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			if tt.session.ID != "" {
+				r = r.WithContext(context.WithValue(r.Context(), demoSessionKey, tt.session))
+			}
+			if got := rateLimitKey(r); got != tt.want {
+				t.Errorf("rateLimitKey = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRateLimitIgnoresRotatedTokens sends a new made-up token with every
+// request and expects them all to share the address's budget.
+func TestRateLimitIgnoresRotatedTokens(t *testing.T) {
+	l := newRateLimiter(3, time.Minute)
+	h := l.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rnd = renderer.New()
+	var codes []int
+	for i := 0; i < 4; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+		r.RemoteAddr = "192.0.2.1:5555"
+		r.Header.Set("Authorization", "Bearer token-"+string(rune('a'+i)))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		codes = append(codes, w.Code)
+	}
+	if codes[2] != http.StatusOK || codes[3] != http.StatusTooManyRequests {
+		t.Errorf("codes = %v; want the fourth request limited", codes)
+	}
+}
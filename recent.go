@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultRecentWindow = 24 * time.Hour
+	maxRecentWindow     = 365 * 24 * time.Hour
+)
+
+// fetchRecentlyCompleted lists the todos completed within ?since (a Go
+// duration such as "24h" or "90m"), most recently completed first.
+func fetchRecentlyCompleted(w http.ResponseWriter, r *http.Request) {
+	window := defaultRecentWindow
+	if s := r.URL.Query().Get("since"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 || d > maxRecentWindow {
+			respond(w, r, http.StatusBadRequest, renderer.M{
+				"message": "Invalid since",
+				"error":   fmt.Sprintf("since must be a positive duration up to %s, such as 24h", maxRecentWindow),
+			})
+			return
+		}
+		window = d
+	}
+	tf, err := parseTimeFormat(r.URL.Query())
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid time format", "error": err.Error()})
+		return
+	}
+	page, err := parsePaging(r.URL.Query())
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid list options", "error": err.Error()})
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	filter := bson.M{"completed": true, "completedAt": bson.M{"$gte": time.Now().Add(-window)}}
+	opts := page.apply(options.Find().SetSort(bson.D{{Key: "completedAt", Value: -1}, {Key: "_id", Value: -1}}))
+	cur, err := db.Collection(collectionName).Find(ctx, scoped(ctx, filter), opts)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch todo", "error": err.Error()})
+		return
+	}
+	defer cur.Close(ctx)
+
+	var todos []todoModel
+	if err := cur.All(ctx, &todos); err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to decode todos", "error": err.Error()})
+		return
+	}
+
+	list := []todo{}
+	for _, t := range todos {
+		list = append(list, t.toTodo().withTimeFormat(tf))
+	}
+	respond(w, r, http.StatusOK, renderer.M{"data": list, "paging": page.meta(w)})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// An open todo nobody has written for staleAfterDays is stale. Unlike the
+// in-progress todos of GET /todos/stale, which are stuck, these are
+// forgotten: they sit at the bottom of the list or in someday and rot.
+// The resurfacer brings up to resurfacePerDay of them a day back to the
+// top of the inbox, and a todo it brought back isn't brought back again
+// until someone writes it.
+var (
+	// staleAfterDays is through STALE_AFTER_DAYS. 0 turns staleness off.
+	staleAfterDays = 30
+	// resurfacePerDay is through RESURFACE_PER_DAY. 0 turns the resurfacer
+	// off.
+	resurfacePerDay int
+
+	todosResurfaced = newCounter("todos_resurfaced_total", "Stale todos moved back to the top of the inbox.")
+)
+
+// resurfaceInterval is how often the resurfacer runs. The daily allowance
+// is spread over the runs, so a day's worth doesn't land at once.
+const resurfaceInterval = time.Hour
+
+func staleCutoff(now time.Time) time.Time {
+	return now.AddDate(0, 0, -staleAfterDays)
+}
+
+// isStale reports whether t is stale at now.
+func (t todoModel) isStale(now time.Time) bool {
+	return staleAfterDays > 0 && !t.Completed && lastWritten(t).Before(staleCutoff(now))
+}
+
+// staleCond matches the stale todos, or with stale false every other one.
+// Todos saved before updatedAt was recorded count from their creation.
+func staleCond(stale bool, now time.Time) bson.M {
+	cutoff := staleCutoff(now)
+	if stale {
+		return bson.M{"completed": false, "$or": bson.A{
+			bson.M{"updatedAt": bson.M{"$lt": cutoff}},
+			bson.M{"updatedAt": nil, "createAt": bson.M{"$lt": cutoff}},
+		}}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"completed": true},
+		bson.M{"updatedAt": bson.M{"$gte": cutoff}},
+		bson.M{"updatedAt": nil, "createAt": bson.M{"$gte": cutoff}},
+	}}
+}
+
+// runResurfacer resurfaces stale todos every resurfaceInterval.
+func runResurfacer(ctx context.Context) {
+	t := time.NewTicker(resurfaceInterval)
+	defer t.Stop()
+	for {
+		if err := resurfaceStale(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("resurfacing stale todos: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// resurfaceStale moves the longest forgotten stale todos to the top of the
+// inbox, as many as this run's share of resurfacePerDay allows, less any
+// resurfaced over the last day.
+func resurfaceStale(parent context.Context) error {
+	// Resurfacing writes, so it waits out maintenance.
+	if maintenance.Load() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(parent, 30*time.Second)
+	defer cancel()
+	coll := db.Collection(collectionName)
+
+	now := time.Now()
+	recent, err := coll.CountDocuments(ctx, bson.M{"lastSurfacedAt": bson.M{"$gt": now.Add(-24 * time.Hour)}})
+	if err != nil {
+		return err
+	}
+	perRun := (resurfacePerDay*int(resurfaceInterval) + int(24*time.Hour) - 1) / int(24*time.Hour)
+	limit := min(int64(perRun), int64(resurfacePerDay)-recent)
+	if limit <= 0 {
+		return nil
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "updatedAt", Value: 1}, {Key: "createAt", Value: 1}}).
+		SetLimit(limit).
+		SetProjection(bson.M{"_id": 1, "sessionId": 1})
+	cur, err := coll.Find(ctx, resurfaceableCond(now), opts)
+	if err != nil {
+		return err
+	}
+	var found []todoModel
+	if err := cur.All(ctx, &found); err != nil {
+		return err
+	}
+
+	for _, t := range found {
+		// Positions are per demo session, like the todo's own list.
+		sctx := withDemoSession(ctx, t.SessionID)
+		pos, err := edgePosition(sctx, true)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		update := bson.M{"$set": bson.M{
+			"bucket":                  bucketInbox,
+			"position":                pos,
+			"lastSurfacedAt":          now,
+			"updatedAt":               now,
+			"fieldUpdatedAt.bucket":   now,
+			"fieldUpdatedAt.position": now,
+		}}
+		// Checked again, in case the todo was written since it was found.
+		filter := bson.M{"$and": bson.A{bson.M{"_id": t.ID}, resurfaceableCond(now)}}
+		_, err = updateTodoAudited(sctx, filter, update)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		todosResurfaced.Inc()
+	}
+	return nil
+}
+
+// resurfaceableCond matches the stale todos not resurfaced since they were
+// last written. Resurfacing stamps lastSurfacedAt and updatedAt alike, so
+// a todo left alone after being resurfaced stays excluded.
+func resurfaceableCond(now time.Time) bson.M {
+	return bson.M{"$and": bson.A{
+		staleCond(true, now),
+		bson.M{"$or": bson.A{
+			bson.M{"lastSurfacedAt": nil},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$lastSurfacedAt", "$updatedAt"}}},
+		}},
+	}}
+}
+
+// addOpenAge fills in how many of the s.Open open todos are stale and how
+// old the median one is.
+func (s *todoStats) addOpenAge(ctx context.Context, now time.Time) error {
+	coll := db.Collection(collectionName)
+	s.StaleAfterDays = staleAfterDays
+	if staleAfterDays > 0 {
+		var err error
+		if s.Stale, err = coll.CountDocuments(ctx, scoped(ctx, staleCond(true, now))); err != nil {
+			return err
+		}
+	}
+	if s.Open == 0 {
+		return nil
+	}
+
+	// The median is the middle todo by creation, or the mean of the middle
+	// two.
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createAt", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip((s.Open - 1) / 2).
+		SetLimit(2 - s.Open%2).
+		SetProjection(bson.M{"createAt": 1})
+	cur, err := coll.Find(ctx, scoped(ctx, bson.M{"completed": false}), opts)
+	if err != nil {
+		return err
+	}
+	var middle []todoModel
+	if err := cur.All(ctx, &middle); err != nil {
+		return err
+	}
+	if len(middle) == 0 {
+		return fmt.Errorf("open todos changed while computing the median")
+	}
+	var sum float64
+	for _, t := range middle {
+		sum += now.Sub(t.CreateAt).Seconds()
+	}
+	median := round2(sum / float64(len(middle)))
+	s.MedianOpenAgeSeconds = &median
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// samplerInterval is how often collection sizes are sampled.
+var samplerInterval = time.Minute
+
+var (
+	todosTotal     = newGauge("todos_total", "Estimated number of todos in the collection.")
+	todosCompleted = newGauge("todos_completed", "Number of completed todos.")
+	todosPending   = newGauge("todos_pending", "Number of todos not yet completed.")
+	pendingMinutes = newGauge("todos_pending_estimate_minutes", "Total estimated minutes of todos not yet completed.")
+	samplerErrors  = newCounter("collection_sampler_errors_total", "Failed collection size samples.")
+
+	// lastSample is the Unix nano time of the last successful sample.
+	lastSample atomic.Int64
+)
+
+// runSampler refreshes the collection gauges every samplerInterval until
+// ctx is cancelled. A failed sample is counted and retried on the next
+// tick; it never stops the loop.
+func runSampler(ctx context.Context) {
+	sampleCollection(ctx)
+
+	t := time.NewTicker(samplerInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			sampleCollection(ctx)
+		}
+	}
+}
+
+func sampleCollection(parent context.Context) {
+	ctx, cancel := context.WithTimeout(parent, 10*time.Second)
+	defer cancel()
+
+	if err := takeSample(ctx); err != nil {
+		if parent.Err() == nil {
+			samplerErrors.Inc()
+			log.Printf("collection sampler: %v", err)
+		}
+		return
+	}
+	lastSample.Store(time.Now().UnixNano())
+}
+
+func takeSample(ctx context.Context) error {
+	collection := db.Collection(collectionName)
+
+	total, err := collection.EstimatedDocumentCount(ctx)
+	if err != nil {
+		return err
+	}
+
+	cur, err := collection.Aggregate(ctx, bson.A{
+		// $sum skips todos without an estimate, counting them as zero.
+		bson.M{"$group": bson.M{
+			"_id":      "$completed",
+			"count":    bson.M{"$sum": 1},
+			"estimate": bson.M{"$sum": "$estimate"},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	var groups []struct {
+		Completed bool  `bson:"_id"`
+		Count     int64 `bson:"count"`
+		Estimate  int64 `bson:"estimate"`
+	}
+	if err := cur.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	var completed, pending, minutes int64
+	for _, g := range groups {
+		if g.Completed {
+			completed += g.Count
+		} else {
+			pending += g.Count
+			minutes += g.Estimate
+		}
+	}
+
+	todosTotal.Set(float64(total))
+	todosCompleted.Set(float64(completed))
+	todosPending.Set(float64(pending))
+	pendingMinutes.Set(float64(minutes))
+	return nil
+}
+
+// sampleAge reports how long ago the last successful sample was taken, or
+// -1 if there has not been one.
+func sampleAge() time.Duration {
+	last := lastSample.Load()
+	if last == 0 {
+		return -1
+	}
+	return time.Since(time.Unix(0, last))
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// scheduleCatchUp is how late a scheduled run may still fire. Runs missed
+// by more than this, e.g. while the server was down, are skipped.
+var scheduleCatchUp = time.Hour
+
+// templateSchedule makes the server instantiate a template by itself,
+// daily, weekly on Weekday or monthly on Day, at At in Timezone. A Day past
+// the end of a short month runs on its last day.
+type templateSchedule struct {
+	Every    string `bson:"every" json:"every" xml:"every"`
+	Weekday  string `bson:"weekday,omitempty" json:"weekday,omitempty" xml:"weekday,omitempty"`
+	Day      int    `bson:"day,omitempty" json:"day,omitempty" xml:"day,omitempty"`
+	At       string `bson:"at" json:"at" xml:"at"`
+	Timezone string `bson:"timezone,omitempty" json:"timezone,omitempty" xml:"timezone,omitempty"`
+
+	NextRunAt time.Time  `bson:"nextRunAt" json:"next_run_at" xml:"next_run_at"`
+	LastRunAt *time.Time `bson:"lastRunAt,omitempty" json:"last_run_at,omitempty" xml:"last_run_at,omitempty"`
+}
+
+var weekdays = map[string]time.Weekday{}
+
+func init() {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		weekdays[strings.ToLower(d.String())] = d
+	}
+}
+
+func (s *templateSchedule) validate() renderer.M {
+	s.Weekday = strings.ToLower(strings.TrimSpace(s.Weekday))
+	switch s.Every {
+	case "daily":
+	case "weekly":
+		if _, ok := weekdays[s.Weekday]; !ok {
+			return renderer.M{"message": "Weekly schedules need a weekday such as \"friday\"", "field": "weekday"}
+		}
+	case "monthly":
+		if s.Day < 1 || s.Day > 31 {
+			return renderer.M{"message": "Monthly schedules need a day between 1 and 31", "field": "day"}
+		}
+	default:
+		return renderer.M{"message": "Every must be daily, weekly or monthly", "field": "every"}
+	}
+	if _, err := time.Parse("15:04", s.At); err != nil {
+		return renderer.M{"message": "At must be a time such as \"09:00\"", "field": "at"}
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return renderer.M{"message": "Invalid timezone", "field": "timezone", "error": err.Error()}
+	}
+	return nil
+}
+
+// next returns the first run strictly after after. The schedule must be
+// valid.
+func (s templateSchedule) next(after time.Time) time.Time {
+	loc, _ := time.LoadLocation(s.Timezone)
+	at, _ := time.Parse("15:04", s.At)
+	local := after.In(loc)
+	y, m, d := local.Date()
+
+	// Building each candidate with time.Date keeps the wall clock time
+	// fixed across DST changes.
+	candidate := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, at.Hour(), at.Minute(), 0, 0, loc)
+	}
+	switch s.Every {
+	case "weekly":
+		d += (int(weekdays[s.Weekday]) - int(local.Weekday()) + 7) % 7
+		for ; ; d += 7 {
+			if c := candidate(y, m, d); c.After(after) {
+				return c
+			}
+		}
+	case "monthly":
+		for ; ; m++ {
+			last := time.Date(y, m+1, 0, 0, 0, 0, 0, loc).Day()
+			if c := candidate(y, m, min(s.Day, last)); c.After(after) {
+				return c
+			}
+		}
+	default:
+		for ; ; d++ {
+			if c := candidate(y, m, d); c.After(after) {
+				return c
+			}
+		}
+	}
+}
+
+// putSchedule attaches or replaces a template's schedule. Without a
+// timezone the caller's settings timezone is used, then UTC.
+func putSchedule(w http.ResponseWriter, r *http.Request) {
+	filter, ok := templateFilter(w, r)
+	if !ok {
+		return
+	}
+
+	var s templateSchedule
+	if !decodeJSON(w, r, &s) {
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	if s.Timezone == "" {
+		settings, err := loadSettings(ctx, r)
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch settings", "error": err.Error()})
+			return
+		}
+		s.Timezone = settings.Timezone
+	}
+	if m := s.validate(); m != nil {
+		respond(w, r, http.StatusBadRequest, m)
+		return
+	}
+	s.NextRunAt = s.next(time.Now())
+	s.LastRunAt = nil
+
+	res, err := db.Collection(templateCollection).UpdateOne(ctx, filter, bson.M{"$set": bson.M{"schedule": s}})
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to save schedule", "error": err.Error()})
+		return
+	}
+	if res.MatchedCount == 0 {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Template not found"})
+		return
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{"message": "Schedule saved", "data": s})
+}
+
+func deleteSchedule(w http.ResponseWriter, r *http.Request) {
+	filter, ok := templateFilter(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	res, err := db.Collection(templateCollection).UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"schedule": ""}})
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to delete schedule", "error": err.Error()})
+		return
+	}
+	if res.MatchedCount == 0 {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Template not found"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runScheduler creates the todos of due schedules every minute until ctx
+// is cancelled. The first pass runs at startup so runs missed while the
+// server was down are caught up straight away.
+func runScheduler(ctx context.Context) {
+	runDueSchedules(ctx)
+
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			runDueSchedules(ctx)
+		}
+	}
+}
+
+func runDueSchedules(parent context.Context) {
+	// Scheduled todos are writes too.
+	if maintenance.Load() || !flagScheduler.on() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(parent, 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	cur, err := db.Collection(templateCollection).Find(ctx, bson.M{"schedule.nextRunAt": bson.M{"$lte": now}})
+	if err != nil {
+		if parent.Err() == nil {
+			log.Printf("scheduler: %v", err)
+		}
+		return
+	}
+	var due []templateModel
+	if err := cur.All(ctx, &due); err != nil {
+		if parent.Err() == nil {
+			log.Printf("scheduler: %v", err)
+		}
+		return
+	}
+
+	for _, tm := range due {
+		if err := runSchedule(ctx, tm, now); err != nil {
+			log.Printf("scheduler: template %s: %v", tm.ID.Hex(), err)
+		}
+	}
+}
+
+// runSchedule claims the due run of tm and creates its todo. The claim
+// moves nextRunAt forward only if it still holds the value we read, so
+// when several replicas see the same run exactly one of them creates it.
+// However many runs were missed, at most one todo is created.
+func runSchedule(ctx context.Context, tm templateModel, now time.Time) error {
+	s := tm.Schedule
+	late := now.Sub(s.NextRunAt) > scheduleCatchUp
+
+	set := bson.M{"schedule.nextRunAt": s.next(now)}
+	if !late {
+		set["schedule.lastRunAt"] = now
+	}
+	res, err := db.Collection(templateCollection).UpdateOne(ctx,
+		bson.M{"_id": tm.ID, "schedule.nextRunAt": s.NextRunAt},
+		bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount == 0 {
+		return nil
+	}
+	if late {
+		log.Printf("scheduler: template %s: skipped run due %s, outside the catch-up window",
+			tm.ID.Hex(), s.NextRunAt.Format(time.RFC3339))
+		return nil
+	}
+
+	loc, _ := time.LoadLocation(s.Timezone)
+	t, warnings := tm.newTodo(now.In(loc))
+	if m := validateTodo(&t, nil); m != nil {
+		return fmt.Errorf("%v", m["message"])
+	}
+	created, err := insertTodo(withDemoSession(ctx, tm.SessionID), t)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		log.Printf("scheduler: template %s: %s", tm.ID.Hex(), w.Message)
+	}
+	log.Printf("scheduler: created todo %s from template %s", created.ID.Hex(), tm.ID.Hex())
+	return nil
+}
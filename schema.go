@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// fieldSchema describes one field of the todo API representation. It is
+// generated from the json and schema struct tags on todo, so adding a field
+// to the struct is enough to publish it.
+type fieldSchema struct {
+	Name        string             `json:"name"`
+	Type        string             `json:"type"`
+	Format      string             `json:"format,omitempty"`
+	Required    bool               `json:"required"`
+	ReadOnly    bool               `json:"read_only"`
+	Nullable    bool               `json:"nullable"`
+	Constraints map[string]float64 `json:"constraints,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Fields      []fieldSchema      `json:"fields,omitempty"`
+}
+
+var todoSchema = schemaFields(reflect.TypeOf(todo{}))
+
+func fetchSchema(w http.ResponseWriter, r *http.Request) {
+	rnd.JSON(w, http.StatusOK, namedJSON(renderer.M{"data": renderer.M{"name": "todo", "fields": namedSchema(todoSchema)}}))
+}
+
+// namedSchema renames fields to match the configured JSON naming.
+func namedSchema(fields []fieldSchema) []fieldSchema {
+	out := make([]fieldSchema, len(fields))
+	for i, f := range fields {
+		f.Name = jsonFieldName(f.Name)
+		f.Fields = namedSchema(f.Fields)
+		out[i] = f
+	}
+	return out
+}
+
+// schemaFields walks the exported fields of t. The schema tag is a comma
+// separated list of required, readonly, enum=a|b|c and key=number
+// constraints such as min=-90 or maxLength=200.
+func schemaFields(t reflect.Type) []fieldSchema {
+	var fields []fieldSchema
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if !sf.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+
+		f := fieldSchema{Name: name}
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			f.Nullable = true
+			ft = ft.Elem()
+		}
+		f.Type, f.Format = schemaType(ft)
+		if ft.Kind() == reflect.Struct && ft != reflect.TypeOf(time.Time{}) {
+			f.Fields = schemaFields(ft)
+		}
+
+		for _, opt := range strings.Split(sf.Tag.Get("schema"), ",") {
+			key, val, hasVal := strings.Cut(strings.TrimSpace(opt), "=")
+			switch {
+			case key == "required":
+				f.Required = true
+			case key == "readonly":
+				f.ReadOnly = true
+			case key == "enum":
+				f.Enum = strings.Split(val, "|")
+			case hasVal:
+				n, err := strconv.ParseFloat(val, 64)
+				if err != nil {
+					panic("schema tag on " + t.Name() + "." + sf.Name + ": " + err.Error())
+				}
+				if f.Constraints == nil {
+					f.Constraints = map[string]float64{}
+				}
+				f.Constraints[key] = n
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func schemaType(t reflect.Type) (typ, format string) {
+	if t == reflect.TypeOf(time.Time{}) {
+		return "string", "date-time"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string", ""
+	case reflect.Bool:
+		return "boolean", ""
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer", ""
+	case reflect.Float32, reflect.Float64:
+		return "number", ""
+	case reflect.Slice, reflect.Array:
+		return "array", ""
+	}
+	return "object", ""
+}
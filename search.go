@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	searchModeText  = "text"
+	searchModeRegex = "regex"
+	searchModeWord  = "word"
+
+	textIndexName = "title_text"
+	maxSearchLen  = 200
+)
+
+// searchLanguage picks the stemming rules of the text index and of $text
+// queries, configurable through SEARCH_LANGUAGE. "none" disables stemming
+// and stop words.
+var searchLanguage = "english"
+
+// textIndexReady reports whether the text index exists. Without it, as on
+// a fresh standalone dev database where creating it failed, searches fall
+// back to matching the title with regular expressions.
+var textIndexReady atomic.Bool
+
+// todoSearch is a ?q= full-text search.
+type todoSearch struct {
+	Query string
+	Mode  string
+}
+
+// parseSearch reads ?q= and ?search_mode=, which is text (the default),
+// regex or word. Text mode ranks by relevance and falls back to regex when
+// the text index is missing.
+func parseSearch(q url.Values) (*todoSearch, error) {
+	query := normalizeText(strings.TrimSpace(q.Get("q")))
+	mode := q.Get("search_mode")
+	switch mode {
+	case "", searchModeText:
+		mode = searchModeText
+	case searchModeRegex, searchModeWord:
+	default:
+		return nil, fmt.Errorf("search_mode must be text, regex or word")
+	}
+	if query == "" {
+		if q.Has("search_mode") {
+			return nil, fmt.Errorf("search_mode requires q")
+		}
+		return nil, nil
+	}
+	if len(query) > maxSearchLen {
+		return nil, fmt.Errorf("q may be at most %d bytes", maxSearchLen)
+	}
+	if mode == searchModeText && !textIndexReady.Load() {
+		mode = searchModeRegex
+	}
+	return &todoSearch{Query: query, Mode: mode}, nil
+}
+
+// cond returns the filter condition for the search.
+func (s todoSearch) cond() bson.M {
+	if s.Mode == searchModeText {
+		return bson.M{"$text": bson.M{"$search": s.Query, "$language": searchLanguage}}
+	}
+	var conds []bson.M
+	for _, term := range searchTerms(s.Query) {
+		pattern := regexp.QuoteMeta(term)
+		if s.Mode == searchModeWord {
+			// Lookarounds rather than \b, which never matches between
+			// the end of a term like "c++" and the space after it.
+			pattern = `(?<!\w)` + pattern + `(?!\w)`
+		}
+		conds = append(conds, bson.M{"title": bson.M{"$regex": pattern, "$options": "i"}})
+	}
+	switch len(conds) {
+	case 0:
+		return bson.M{}
+	case 1:
+		return conds[0]
+	}
+	return bson.M{"$and": conds}
+}
+
+// searchTerms splits a query into words and "quoted phrases", the same
+// way $text reads it. The regex fallback requires every term to appear.
+func searchTerms(query string) []string {
+	var terms []string
+	for i, part := range strings.Split(query, `"`) {
+		if i%2 == 1 {
+			if phrase := strings.TrimSpace(part); phrase != "" {
+				terms = append(terms, phrase)
+			}
+			continue
+		}
+		terms = append(terms, strings.Fields(part)...)
+	}
+	return terms
+}
+
+// rankByScore makes opts return the text score of each match, besides any
+// fields it already narrows to, and, unless the caller chose a sort, order
+// by it, best first.
+func rankByScore(opts *options.FindOptions, sorted bool) {
+	score := bson.M{"$meta": "textScore"}
+	projection := bson.M{"score": score}
+	if fields, ok := opts.Projection.(bson.M); ok {
+		for k, v := range fields {
+			projection[k] = v
+		}
+	}
+	opts.SetProjection(projection)
+	if !sorted {
+		opts.SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: -1}})
+	}
+}
+
+// findTodos runs a plain list query. A text search is ranked by relevance
+// unless the request sorted explicitly; a default sort from the caller's
+// settings doesn't count. If the text index turns out to be missing, the
+// search is retried in regex mode.
+func findTodos(ctx context.Context, collection *mongo.Collection, f todoFilter, o listOptions, sorted bool) (*mongo.Cursor, error) {
+	opts := o.findOptions()
+	if f.Search == nil || f.Search.Mode != searchModeText {
+		return collection.Find(ctx, f.query(), opts)
+	}
+	rankByScore(opts, sorted)
+	cur, err := collection.Find(ctx, f.query(), opts)
+	if isTextIndexMissing(err) {
+		log.Printf("Text index missing, falling back to regex search")
+		textIndexReady.Store(false)
+		f.Search.Mode = searchModeRegex
+		return collection.Find(ctx, f.query(), o.findOptions())
+	}
+	return cur, err
+}
+
+// isTextIndexMissing reports whether err is Mongo refusing a $text query
+// because the collection has no text index.
+func isTextIndexMissing(err error) bool {
+	var se mongo.ServerError
+	return errors.As(err, &se) && se.HasErrorCode(27)
+}
+
+// ensureTextIndex creates the text index on titles. It is a separate step
+// from ensureIndexes because its failure only degrades search.
+func ensureTextIndex(ctx context.Context) {
+	_, err := db.Collection(collectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "title", Value: "text"}},
+		Options: options.Index().SetName(textIndexName).SetDefaultLanguage(searchLanguage),
+	})
+	if err != nil {
+		log.Printf("Failed to create text index, search falls back to regex: %v", err)
+		return
+	}
+	textIndexReady.Store(true)
+}
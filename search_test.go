@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSearchTerms(t *testing.T) {
+	for q, want := range map[string][]string{
+		"milk eggs":             {"milk", "eggs"},
+		`buy "oat milk" today`:  {"buy", "oat milk", "today"},
+		`"unterminated phrase`:  {"unterminated phrase"},
+		`  spaced   out  `:      {"spaced", "out"},
+		`"" empty`:              {"empty"},
+		`c++ "node.js" (draft)`: {"c++", "node.js", "(draft)"},
+	} {
+		if got := searchTerms(q); !reflect.DeepEqual(got, want) {
+			t.Errorf("searchTerms(%q) = %q; want %q", q, got, want)
+		}
+	}
+}
+
+type searchResult struct {
+	Data []struct {
+		Title string   `json:"title"`
+		Score *float64 `json:"score"`
+	} `json:"data"`
+	SearchMode string `json:"search_mode"`
+}
+
+func search(t *testing.T, h http.Handler, q string) searchResult {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos?q="+url.QueryEscape(q), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /todos?q=%s: %d %s", q, w.Code, w.Body)
+	}
+	var res searchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// TestSearchRanking expects a multi-word text search to list the todos
+// matching more of its words first, with their scores. The titles are of
+// one length, so the number of words matched decides.
+func TestSearchRanking(t *testing.T) {
+	useTestDB(t)
+	if !textIndexReady.Load() {
+		t.Fatal("text index was not created")
+	}
+	now := time.Now()
+	var docs []interface{}
+	for _, title := range []string{"pick up eggs today", "pick up milk today", "pick up milk and eggs", "call mom today"} {
+		docs = append(docs, todoModel{ID: primitive.NewObjectID(), Title: title, CreateAt: now, UpdatedAt: &now})
+	}
+	if _, err := db.Collection(collectionName).InsertMany(context.Background(), docs); err != nil {
+		t.Fatal(err)
+	}
+
+	res := search(t, testRouter(), "milk eggs")
+	if res.SearchMode != searchModeText {
+		t.Errorf("search_mode = %q; want text", res.SearchMode)
+	}
+	var titles []string
+	for i, d := range res.Data {
+		titles = append(titles, d.Title)
+		if d.Score == nil {
+			t.Fatalf("%q has no score", d.Title)
+		}
+		if i > 0 && *d.Score > *res.Data[i-1].Score {
+			t.Errorf("%q scored %v, above %q before it", d.Title, *d.Score, res.Data[i-1].Title)
+		}
+	}
+	if len(titles) != 3 || titles[0] != "pick up milk and eggs" {
+		t.Errorf("results = %q; want the todo with both words first and call mom left out", titles)
+	}
+
+	res = search(t, testRouter(), `"milk and eggs"`)
+	if len(res.Data) != 1 || res.Data[0].Title != "pick up milk and eggs" {
+		t.Errorf("phrase search = %+v; want only the todo with the phrase", res.Data)
+	}
+}
+
+// TestSearchFallsBackToRegex answers a text search the way Mongo does
+// without a text index, and expects the list retried with every word
+// matched by regex, and later searches to go straight to regex.
+func TestSearchFallsBackToRegex(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("fallback", func(mt *mtest.T) {
+		useMockDB(mt)
+		defer func(ready bool) { textIndexReady.Store(ready) }(textIndexReady.Load())
+		defer func(on bool) { listCountsEnabled = on }(listCountsEnabled)
+		textIndexReady.Store(true)
+		listCountsEnabled = false
+
+		ns := mt.DB.Name() + "." + collectionName
+		cursor := func(docs ...bson.D) bson.D {
+			return mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, docs...)
+		}
+		match := bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "title", Value: "buy milk and eggs"}}
+		mt.AddMockResponses(
+			cursor(),
+			cursor(),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 27, Name: "IndexNotFound", Message: "text index required for $text query"}),
+			cursor(match),
+			cursor(match),
+		)
+
+		res := search(mt.T, testRouter(), "milk eggs")
+		if res.SearchMode != searchModeRegex {
+			mt.Errorf("search_mode = %q; want regex", res.SearchMode)
+		}
+		if len(res.Data) != 1 || res.Data[0].Score != nil {
+			mt.Errorf("data = %+v; want the match without a score", res.Data)
+		}
+
+		var finds []string
+		for _, ev := range mt.GetAllStartedEvents() {
+			// The single-document finds are listLastModified's.
+			_, single := ev.Command.Lookup("singleBatch").BooleanOK()
+			if ev.CommandName == "find" && ev.Command.Lookup("find").StringValue() == collectionName && !single {
+				finds = append(finds, ev.Command.Lookup("filter").String())
+			}
+		}
+		// The page read for the ETag hits the missing index first; the
+		// list itself then goes straight to regex.
+		if len(finds) != 3 || !strings.Contains(finds[0], "$text") {
+			mt.Fatalf("finds = %q; want a $text query, then its retry and the list", finds)
+		}
+		for _, find := range finds[1:] {
+			for _, word := range []string{`"$regex": "milk"`, `"$regex": "eggs"`} {
+				if !strings.Contains(find, word) {
+					mt.Errorf("regex filter %s lacks %s", find, word)
+				}
+			}
+		}
+
+		if textIndexReady.Load() {
+			mt.Error("text index still marked ready")
+		}
+		s, err := parseSearch(url.Values{"q": {"milk"}})
+		if err != nil || s.Mode != searchModeRegex {
+			mt.Errorf("next search = %+v, %v; want regex mode", s, err)
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultCSP allows what the home page loads: htmx, Bootstrap, jQuery,
+// Popper and Font Awesome from their CDNs. hx-on and onclick attributes are
+// inline script that htmx runs through Function, hence 'unsafe-inline' and
+// 'unsafe-eval'.
+const defaultCSP = "default-src 'self'; " +
+	"script-src 'self' 'unsafe-inline' 'unsafe-eval' https://unpkg.com https://code.jquery.com https://cdnjs.cloudflare.com https://maxcdn.bootstrapcdn.com; " +
+	"style-src 'self' 'unsafe-inline' https://maxcdn.bootstrapcdn.com; " +
+	"font-src 'self' https://maxcdn.bootstrapcdn.com; " +
+	"img-src 'self' data:; " +
+	"frame-ancestors 'none'"
+
+type securityConfig struct {
+	Enabled               bool
+	FrameOptions          string
+	ContentSecurityPolicy string
+}
+
+// loadSecurityConfig reads the security header settings. They are off
+// unless SECURITY_HEADERS is true. An empty CONTENT_SECURITY_POLICY leaves
+// the header out.
+func loadSecurityConfig() securityConfig {
+	c := securityConfig{
+		Enabled:               envBool("SECURITY_HEADERS", false),
+		FrameOptions:          strings.ToUpper(envString("FRAME_OPTIONS", "DENY")),
+		ContentSecurityPolicy: defaultCSP,
+	}
+	if v, ok := os.LookupEnv("CONTENT_SECURITY_POLICY"); ok {
+		c.ContentSecurityPolicy = strings.TrimSpace(v)
+	}
+	if c.FrameOptions != "DENY" && c.FrameOptions != "SAMEORIGIN" {
+		log.Fatalf("FRAME_OPTIONS must be DENY or SAMEORIGIN, got %q", c.FrameOptions)
+	}
+	return c
+}
+
+// securityHeaders sets the headers on every response, before the handler
+// runs, so errors and shed requests carry them too.
+func securityHeaders(c securityConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !c.Enabled {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("X-Frame-Options", c.FrameOptions)
+			if c.ContentSecurityPolicy != "" {
+				h.Set("Content-Security-Policy", c.ContentSecurityPolicy)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const settingsCollection = "settings"
+
+// Settings are per-user defaults for read endpoints. There are no user
+// accounts, so a user is whoever presents a given Authorization header;
+// requests without one always get the built-in defaults.
+type (
+	settingsModel struct {
+		// ID is the SHA-256 of the caller's Authorization header.
+		ID              string    `bson:"_id"`
+		DefaultSort     string    `bson:"defaultSort,omitempty"`
+		DefaultPageSize int       `bson:"defaultPageSize,omitempty"`
+		Timezone        string    `bson:"timezone,omitempty"`
+		UpdatedAt       time.Time `bson:"updatedAt"`
+	}
+	userSettings struct {
+		DefaultSort     string `json:"default_sort" xml:"default_sort"`
+		DefaultPageSize int    `json:"default_page_size" xml:"default_page_size"`
+		Timezone        string `json:"timezone" xml:"timezone"`
+	}
+)
+
+func settingsKey(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(auth))
+	return hex.EncodeToString(sum[:]), true
+}
+
+// loadSettings returns the caller's settings, or the zero value when the
+// caller is anonymous or has never saved any.
+func loadSettings(ctx context.Context, r *http.Request) (userSettings, error) {
+	key, ok := settingsKey(r)
+	if !ok {
+		return userSettings{}, nil
+	}
+	var m settingsModel
+	err := db.Collection(settingsCollection).FindOne(ctx, bson.M{"_id": key}).Decode(&m)
+	if err == mongo.ErrNoDocuments {
+		return userSettings{}, nil
+	}
+	if err != nil {
+		return userSettings{}, err
+	}
+	return userSettings{DefaultSort: m.DefaultSort, DefaultPageSize: m.DefaultPageSize, Timezone: m.Timezone}, nil
+}
+
+// withDefaults returns q with ?sort and ?limit filled in from s where the
+// request left them out.
+func (s userSettings) withDefaults(q url.Values) url.Values {
+	out := url.Values{}
+	for k, v := range q {
+		out[k] = v
+	}
+	if out.Get("sort") == "" && s.DefaultSort != "" {
+		out.Set("sort", s.DefaultSort)
+	}
+	if out.Get("limit") == "" && s.DefaultPageSize > 0 {
+		out.Set("limit", strconv.Itoa(s.DefaultPageSize))
+	}
+	return out
+}
+
+// validate applies the same rules as the query parameters the settings
+// stand in for.
+func (s userSettings) validate() renderer.M {
+	if s.DefaultPageSize < 0 {
+		return renderer.M{"message": "Invalid settings", "field": "default_page_size", "error": "limit must be a positive integer"}
+	}
+	if _, err := parseListOptions(s.withDefaults(url.Values{})); err != nil {
+		return renderer.M{"message": "Invalid settings", "field": "default_sort", "error": err.Error()}
+	}
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			return renderer.M{"message": "Invalid settings", "field": "timezone", "error": err.Error()}
+		}
+	}
+	return nil
+}
+
+func getSettings(w http.ResponseWriter, r *http.Request) {
+	if _, ok := settingsKey(r); !ok {
+		respond(w, r, http.StatusUnauthorized, renderer.M{"message": "Settings require an Authorization header"})
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	s, err := loadSettings(ctx, r)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch settings", "error": err.Error()})
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{"data": s})
+}
+
+func putSettings(w http.ResponseWriter, r *http.Request) {
+	key, ok := settingsKey(r)
+	if !ok {
+		respond(w, r, http.StatusUnauthorized, renderer.M{"message": "Settings require an Authorization header"})
+		return
+	}
+
+	var s userSettings
+	if !decodeJSON(w, r, &s) {
+		return
+	}
+	if m := s.validate(); m != nil {
+		respond(w, r, http.StatusBadRequest, m)
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	m := settingsModel{
+		ID:              key,
+		DefaultSort:     s.DefaultSort,
+		DefaultPageSize: s.DefaultPageSize,
+		Timezone:        s.Timezone,
+		UpdatedAt:       time.Now(),
+	}
+	_, err := db.Collection(settingsCollection).ReplaceOne(ctx, bson.M{"_id": key}, m, options.Replace().SetUpsert(true))
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to save settings", "error": err.Error()})
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{"message": "Settings saved", "data": s})
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// Routes are cheap unless tagged expensive when registered. Expensive
+// routes, exports, imports, stats, search and NDJSON lists, are turned
+// away first when the server is struggling, so basic CRUD keeps flowing.
+const (
+	classCheap     = "cheap"
+	classExpensive = "expensive"
+)
+
+const (
+	// shedWindow is how far back latencies count towards the p99.
+	shedWindow = 10 * time.Second
+	// shedMinSamples is the fewest cheap requests in the window for their
+	// p99 to mean anything.
+	shedMinSamples = 20
+	// shedHold keeps shedding this long after the thresholds were last
+	// crossed, so it doesn't flap on and off every second.
+	shedHold = 5 * time.Second
+)
+
+// shedThresholds start shedding expensive routes when crossed. A zero
+// threshold is off. They are read from SHED_CHEAP_P99 and SHED_IN_FLIGHT
+// and may be changed at runtime through PUT /admin/shedding.
+type shedThresholds struct {
+	CheapP99MS int `json:"cheap_p99_ms" xml:"cheap_p99_ms"`
+	InFlight   int `json:"in_flight" xml:"in_flight"`
+}
+
+var (
+	shedLimits atomic.Pointer[shedThresholds]
+	shedding   atomic.Bool
+	shedReason atomic.Value // string
+	shedSince  atomic.Int64 // Unix nanoseconds, 0 when not shedding
+
+	latencies = map[string]*latencyWindow{
+		classCheap:     {},
+		classExpensive: {},
+	}
+
+	requestsShedExpensive = newCounter("http_requests_shed_expensive_total", "Expensive requests refused with 503 while load shedding.")
+	cheapP99Gauge         = newGauge("http_request_p99_seconds_cheap", "p99 latency of cheap requests over the last 10 seconds.")
+	expensiveP99Gauge     = newGauge("http_request_p99_seconds_expensive", "p99 latency of expensive requests over the last 10 seconds.")
+)
+
+var _ = newGaugeFunc("load_shedding", "1 while expensive requests are being shed.", func() float64 {
+	if shedding.Load() {
+		return 1
+	}
+	return 0
+})
+
+func init() {
+	shedLimits.Store(&shedThresholds{})
+}
+
+// latencyWindow keeps the latest request latencies of a route class.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples [1024]latencySample
+	next    int
+}
+
+type latencySample struct {
+	at time.Time
+	d  time.Duration
+}
+
+func (lw *latencyWindow) add(at time.Time, d time.Duration) {
+	lw.mu.Lock()
+	lw.samples[lw.next] = latencySample{at: at, d: d}
+	lw.next = (lw.next + 1) % len(lw.samples)
+	lw.mu.Unlock()
+}
+
+// p99 returns the 99th percentile of the latencies recorded since since,
+// and how many there were.
+func (lw *latencyWindow) p99(since time.Time) (time.Duration, int) {
+	lw.mu.Lock()
+	var ds []time.Duration
+	for _, s := range lw.samples {
+		if s.at.After(since) {
+			ds = append(ds, s.d)
+		}
+	}
+	lw.mu.Unlock()
+	if len(ds) == 0 {
+		return 0, 0
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds[(len(ds)*99-1)/100], len(ds)
+}
+
+type routeClassKey struct{}
+
+// trackLatency records how long each request took under the class its
+// route was tagged with. Requests exempt from the concurrency limit aren't
+// recorded.
+func trackLatency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exemptFromConcurrencyLimit(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		class := classCheap
+		start := time.Now()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeClassKey{}, &class)))
+		latencies[class].add(time.Now(), time.Since(start))
+	})
+}
+
+// expensive tags a route as expensive.
+func expensive(next http.Handler) http.Handler {
+	return expensiveWhen(nil)(next)
+}
+
+// expensiveWhen tags a route as expensive for the requests pred accepts,
+// or for every request when pred is nil. Those are refused with 503 while
+// shedding.
+func expensiveWhen(pred func(r *http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if pred != nil && !pred(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if class, ok := r.Context().Value(routeClassKey{}).(*string); ok {
+				*class = classExpensive
+			}
+			if shouldShed() {
+				requestsShedExpensive.Inc()
+				w.Header().Set("Retry-After", "5")
+				respond(w, r, http.StatusServiceUnavailable, renderer.M{
+					"message": "The server is busy, so this request is refused for now; try again shortly",
+					"code":    "shedding",
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// shouldShed reports whether an expensive request is to be refused. The
+// in-flight count is checked on the spot as well, since it moves faster
+// than the once a second evaluation.
+func shouldShed() bool {
+	if shedding.Load() {
+		return true
+	}
+	limits := shedLimits.Load()
+	return limits.InFlight > 0 && inFlight.Load() >= int64(limits.InFlight)
+}
+
+// isCostlyList reports whether a list request is a ?q= search or an NDJSON
+// stream. A stream walks every matching todo and can run for as long as the
+// collection is large, so counted as cheap it would drag the cheap p99 up
+// and shed the very routes it competes with.
+func isCostlyList(r *http.Request) bool {
+	q := r.URL.Query()
+	return strings.TrimSpace(q.Get("q")) != "" || q.Get("format") == "ndjson"
+}
+
+// runShedder decides once a second whether to shed.
+func runShedder(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	var lastTripped time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			reason := evaluateShedding(now)
+			if reason != "" {
+				lastTripped = now
+				shedReason.Store(reason)
+			}
+			on := !lastTripped.IsZero() && now.Sub(lastTripped) < shedHold
+			if on && !shedding.Load() {
+				shedSince.Store(now.UnixNano())
+			} else if !on {
+				shedSince.Store(0)
+			}
+			shedding.Store(on)
+		}
+	}
+}
+
+// evaluateShedding updates the latency gauges and returns why expensive
+// routes should be shed, or "" if they shouldn't.
+func evaluateShedding(now time.Time) string {
+	since := now.Add(-shedWindow)
+	cheap, n := latencies[classCheap].p99(since)
+	costly, _ := latencies[classExpensive].p99(since)
+	cheapP99Gauge.Set(cheap.Seconds())
+	expensiveP99Gauge.Set(costly.Seconds())
+
+	limits := shedLimits.Load()
+	if limits.CheapP99MS > 0 && n >= shedMinSamples && cheap > time.Duration(limits.CheapP99MS)*time.Millisecond {
+		return fmt.Sprintf("cheap p99 %dms is over %dms", cheap.Milliseconds(), limits.CheapP99MS)
+	}
+	if limits.InFlight > 0 && inFlight.Load() >= int64(limits.InFlight) {
+		return fmt.Sprintf("%d requests in flight, limit %d", inFlight.Load(), limits.InFlight)
+	}
+	return ""
+}
+
+// sheddingStatus describes the shedding state, for /healthz and the admin
+// API.
+func sheddingStatus() renderer.M {
+	since := time.Now().Add(-shedWindow)
+	cheap, _ := latencies[classCheap].p99(since)
+	costly, _ := latencies[classExpensive].p99(since)
+	res := renderer.M{
+		"active":                shedding.Load(),
+		"since":                 nil,
+		"reason":                nil,
+		"cheap_p99_seconds":     cheap.Seconds(),
+		"expensive_p99_seconds": costly.Seconds(),
+		"in_flight":             inFlight.Load(),
+		"thresholds":            *shedLimits.Load(),
+	}
+	if ns := shedSince.Load(); ns != 0 {
+		res["since"] = time.Unix(0, ns)
+		res["reason"], _ = shedReason.Load().(string)
+	}
+	return res
+}
+
+func getShedding(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, renderer.M{"data": sheddingStatus()})
+}
+
+// putShedding changes the shedding thresholds. Fields left out keep their
+// value; zero turns a threshold off.
+func putShedding(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CheapP99MS *int `json:"cheap_p99_ms"`
+		InFlight   *int `json:"in_flight"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	limits := *shedLimits.Load()
+	if req.CheapP99MS != nil {
+		if *req.CheapP99MS < 0 {
+			respond(w, r, http.StatusBadRequest, renderer.M{"message": "cheap_p99_ms may not be negative", "field": "cheap_p99_ms"})
+			return
+		}
+		limits.CheapP99MS = *req.CheapP99MS
+	}
+	if req.InFlight != nil {
+		if *req.InFlight < 0 {
+			respond(w, r, http.StatusBadRequest, renderer.M{"message": "in_flight may not be negative", "field": "in_flight"})
+			return
+		}
+		limits.InFlight = *req.InFlight
+	}
+	shedLimits.Store(&limits)
+	respond(w, r, http.StatusOK, renderer.M{"message": "Shedding thresholds updated", "data": sheddingStatus()})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+)
+
+func TestIsCostlyList(t *testing.T) {
+	for url, want := range map[string]bool{
+		"/todos":                     false,
+		"/todos?format=json":         false,
+		"/todos?q=milk":              true,
+		"/todos?q=+":                 false,
+		"/todos?format=ndjson":       true,
+		"/todos?completed=false&q=x": true,
+	} {
+		if got := isCostlyList(httptest.NewRequest(http.MethodGet, url, nil)); got != want {
+			t.Errorf("isCostlyList(%s) = %v; want %v", url, got, want)
+		}
+	}
+}
+
+// loadTestServer serves a cheap route and an expensive one sharing a pool
+// of dbSlots database connections. The expensive route holds its
+// connection far longer, the way an export holds a cursor open.
+func loadTestServer(t *testing.T, dbSlots int) *httptest.Server {
+	pool := make(chan struct{}, dbSlots)
+	query := func(d time.Duration) {
+		pool <- struct{}{}
+		time.Sleep(d)
+		<-pool
+	}
+	r := chi.NewRouter()
+	r.Use(limitConcurrency(0))
+	r.Use(trackLatency)
+	r.Get("/todos/{id}", func(w http.ResponseWriter, r *http.Request) {
+		query(time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+	r.With(expensive).Get("/export", func(w http.ResponseWriter, r *http.Request) {
+		query(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// hammerCheap reads a todo n times while 16 clients hammer the export,
+// and returns the 95th percentile latency of the reads, how many of them
+// failed and how many exports were shed.
+func hammerCheap(t *testing.T, srv *httptest.Server, n int) (p95 time.Duration, failed, shed int64) {
+	hc := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 32}}
+	defer hc.CloseIdleConnections()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var shedCount atomic.Int64
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				res, err := hc.Get(srv.URL + "/export")
+				if err != nil {
+					continue
+				}
+				res.Body.Close()
+				if res.StatusCode == http.StatusServiceUnavailable {
+					shedCount.Add(1)
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}()
+	}
+	// Let the exports pile up first.
+	time.Sleep(50 * time.Millisecond)
+
+	ds := make([]time.Duration, 0, n)
+	for i := 0; i < n; i++ {
+		start := time.Now()
+		res, err := hc.Get(srv.URL + "/todos/1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		ds = append(ds, time.Since(start))
+		if res.StatusCode != http.StatusOK {
+			failed++
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds[len(ds)*95/100], failed, shedCount.Load()
+}
+
+// TestSheddingProtectsCheapRoutes hammers an expensive route and expects
+// reads to stay fast with an in-flight threshold set, where without one
+// they queue behind the exports for a connection.
+func TestSheddingProtectsCheapRoutes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test")
+	}
+	rnd = renderer.New()
+	defer func(l *shedThresholds) { shedLimits.Store(l) }(shedLimits.Load())
+
+	shedLimits.Store(&shedThresholds{})
+	unprotected, _, shed := hammerCheap(t, loadTestServer(t, 4), 30)
+	if shed != 0 {
+		t.Fatalf("%d exports shed without thresholds", shed)
+	}
+
+	// Fewer requests in flight than connections leaves one for reads.
+	shedLimits.Store(&shedThresholds{InFlight: 3})
+	protected, failed, shed := hammerCheap(t, loadTestServer(t, 4), 30)
+	if failed != 0 {
+		t.Errorf("%d reads failed while shedding; want none", failed)
+	}
+	if shed == 0 {
+		t.Error("no exports were shed")
+	}
+	t.Logf("read p95: %v unprotected, %v while shedding", unprotected, protected)
+	if protected*2 > unprotected {
+		t.Errorf("read p95 was %v while shedding, %v without; want it well below", protected, unprotected)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Short IDs are human-friendly aliases for ObjectIDs. The alphabet drops
+// characters that are easy to confuse (0/o, 1/l/i), and at 7 characters a
+// short ID can never be mistaken for a 24 character ObjectID.
+const (
+	shortIDAlphabet   = "23456789abcdefghjkmnpqrstuvwxyz"
+	shortIDLength     = 7
+	shortIDCollection = "short_ids"
+	shortIDAttempts   = 5
+)
+
+// shortIDRetention is how long a deleted todo's short ID stays reserved
+// before it may be handed out again.
+var shortIDRetention = 90 * 24 * time.Hour
+
+type shortIDReservation struct {
+	ID        string    `bson:"_id"`
+	CreatedAt time.Time `bson:"createdAt"`
+	// ExpireAt is unset while the todo exists and set on delete, when the
+	// TTL index starts counting down the retention window.
+	ExpireAt *time.Time `bson:"expireAt,omitempty"`
+}
+
+func isShortID(s string) bool {
+	if len(s) != shortIDLength {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(shortIDAlphabet, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func newShortID() (string, error) {
+	max := big.NewInt(int64(len(shortIDAlphabet)))
+	b := make([]byte, shortIDLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = shortIDAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// reserveShortID claims a fresh short ID. Reservations outlive the todo by
+// shortIDRetention, so the unique _id of the reservation collection is what
+// prevents both collisions and reuse.
+func reserveShortID(ctx context.Context) (string, error) {
+	collection := db.Collection(shortIDCollection)
+	for i := 0; i < shortIDAttempts; i++ {
+		id, err := newShortID()
+		if err != nil {
+			return "", err
+		}
+		_, err = collection.InsertOne(ctx, shortIDReservation{ID: id, CreatedAt: time.Now()})
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+	return "", errors.New("could not find a free short ID")
+}
+
+// releaseShortID starts the retention countdown for a deleted todo's ID.
+func releaseShortID(ctx context.Context, id string) error {
+	if id == "" {
+		return nil
+	}
+	expireAt := time.Now().Add(shortIDRetention)
+	_, err := db.Collection(shortIDCollection).UpdateOne(ctx,
+		bson.M{"_id": id}, bson.M{"$set": bson.M{"expireAt": expireAt}})
+	return err
+}
+
+// todoIDFilter resolves the {id} of a route, which may be an ObjectID hex
+// string or a short ID, to a filter selecting that todo.
+func todoIDFilter(raw string) (bson.M, bool) {
+	raw = strings.TrimSpace(raw)
+	if primitive.IsValidObjectID(raw) {
+		id, _ := primitive.ObjectIDFromHex(raw)
+		return bson.M{"_id": id}, true
+	}
+	if isShortID(raw) {
+		return bson.M{"shortId": raw}, true
+	}
+	return nil, false
+}
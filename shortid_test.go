@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestIsShortID(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"abc2345", true},
+		{"zzzzzzz", true},
+		{"abc234", false},
+		{"abc23456", false},
+		{"abc2340", false},
+		{"abc234l", false},
+		{"ABC2345", false},
+		{"abc 234", false},
+		{"", false},
+		{"65ff00aa11bb22cc33dd44ee", false},
+	}
+	for _, tt := range tests {
+		if got := isShortID(tt.s); got != tt.want {
+			t.Errorf("isShortID(%q) = %v; want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestNewShortIDIsShortID(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		id, err := newShortID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isShortID(id) {
+			t.Fatalf("newShortID() = %q, which isShortID rejects", id)
+		}
+	}
+}
+
+func TestTodoIDFilter(t *testing.T) {
+	oid := primitive.NewObjectID()
+	tests := []struct {
+		raw    string
+		want   bson.M
+		wantOK bool
+	}{
+		{oid.Hex(), bson.M{"_id": oid}, true},
+		{" " + oid.Hex() + " ", bson.M{"_id": oid}, true},
+		{strings.ToUpper(oid.Hex()), bson.M{"_id": oid}, true},
+		{"abc2345", bson.M{"shortId": "abc2345"}, true},
+		{"abc2345\n", bson.M{"shortId": "abc2345"}, true},
+		{"abc0345", nil, false},
+		{oid.Hex()[:23], nil, false},
+		{"", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := todoIDFilter(tt.raw)
+		if ok != tt.wantOK || len(got) != len(tt.want) {
+			t.Errorf("todoIDFilter(%q) = %v, %v; want %v, %v", tt.raw, got, ok, tt.want, tt.wantOK)
+			continue
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("todoIDFilter(%q) = %v; want %v", tt.raw, got, tt.want)
+			}
+		}
+	}
+}
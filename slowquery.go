@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// slowQueryThreshold is the duration above which a Mongo command is logged
+// and counted. Zero disables the check.
+var slowQueryThreshold = 200 * time.Millisecond
+
+var slowQueries = newCounter("slow_queries_total", "Mongo commands slower than SLOW_QUERY_MS.")
+
+// pendingCommands remembers the filter summary of in-flight commands by
+// request ID until they finish.
+var pendingCommands sync.Map
+
+// slowQueryMonitor watches every command the driver sends. Handlers build
+// their contexts with dbContext, so the chi route is available here.
+func slowQueryMonitor() *event.CommandMonitor {
+	finish := func(ctx context.Context, evt event.CommandFinishedEvent) {
+		summary, _ := pendingCommands.LoadAndDelete(evt.RequestID)
+		if slowQueryThreshold <= 0 || evt.Duration < slowQueryThreshold {
+			return
+		}
+		slowQueries.Inc()
+
+		route := "-"
+		if rc := chi.RouteContext(ctx); rc != nil && rc.RoutePattern() != "" {
+			route = rc.RoutePattern()
+		}
+		log.Printf("WARN slow query: route=%s command=%s filter=%v duration=%s",
+			route, evt.CommandName, summary, evt.Duration)
+	}
+
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, evt *event.CommandStartedEvent) {
+			if slowQueryThreshold > 0 {
+				pendingCommands.Store(evt.RequestID, commandSummary(evt.Command))
+			}
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			finish(ctx, evt.CommandFinishedEvent)
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			finish(ctx, evt.CommandFinishedEvent)
+		},
+	}
+}
+
+// commandSummary pulls the part of a command that explains its cost: the
+// filter, pipeline or the first update/delete statement's query.
+func commandSummary(cmd bson.Raw) string {
+	var summary string
+	for _, key := range []string{"filter", "pipeline", "query", "updates", "deletes"} {
+		if v, err := cmd.LookupErr(key); err == nil {
+			summary = key + ":" + v.String()
+			break
+		}
+	}
+	if len(summary) > 200 {
+		summary = summary[:200] + "…"
+	}
+	return summary
+}
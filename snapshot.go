@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Stale reads, configurable through STALE_SNAPSHOT, SNAPSHOT_INTERVAL and
+// SNAPSHOT_MAX_AGE. When enabled, the full todo list is copied into memory
+// every snapshotInterval, and while Mongo is unreachable GET /todos serves
+// that copy, if it is younger than snapshotMaxAge, instead of failing.
+var (
+	staleSnapshots   = false
+	snapshotInterval = time.Minute
+	snapshotMaxAge   = 24 * time.Hour
+)
+
+// Circuit breaker settings. After breakerThreshold consecutive failures to
+// reach Mongo, requests stop trying for breakerCooldown; the first request
+// after that is let through to probe it.
+const (
+	breakerThreshold = 3
+	breakerCooldown  = 10 * time.Second
+)
+
+type todoSnapshot struct {
+	Todos   []todoModel
+	TakenAt time.Time
+}
+
+var lastSnapshot atomic.Pointer[todoSnapshot]
+
+// dbBreaker tracks whether Mongo is reachable, as seen by requests and the
+// snapshot worker.
+var dbBreaker breaker
+
+type breaker struct {
+	mu          sync.Mutex
+	failures    int
+	lastFailure time.Time
+}
+
+// record notes the outcome of a database call. Only errors meaning the
+// database couldn't be reached count as failures.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case err == nil:
+		b.failures = 0
+	case isDBUnavailable(err):
+		b.failures++
+		b.lastFailure = time.Now()
+	}
+}
+
+func (b *breaker) open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= breakerThreshold && time.Since(b.lastFailure) < breakerCooldown
+}
+
+func isDBUnavailable(err error) bool {
+	return mongo.IsNetworkError(err) || mongo.IsTimeout(err)
+}
+
+// runSnapshots refreshes lastSnapshot every snapshotInterval until ctx is
+// cancelled.
+func runSnapshots(ctx context.Context) {
+	takeSnapshot(ctx)
+
+	t := time.NewTicker(snapshotInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			takeSnapshot(ctx)
+		}
+	}
+}
+
+func takeSnapshot(parent context.Context) {
+	ctx, cancel := context.WithTimeout(parent, 30*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(listOptions{SortField: "createAt"}.sort())
+	cur, err := db.Collection(collectionName).Find(ctx, bson.M{}, opts)
+	if err == nil {
+		var todos []todoModel
+		if err = cur.All(ctx, &todos); err == nil {
+			lastSnapshot.Store(&todoSnapshot{Todos: todos, TakenAt: time.Now()})
+		}
+	}
+	if parent.Err() != nil {
+		return
+	}
+	dbBreaker.record(err)
+	if err != nil {
+		log.Printf("snapshot: %v", err)
+	}
+}
+
+// freshSnapshot returns the last snapshot if stale reads are enabled and it
+// is young enough to serve.
+func freshSnapshot() *todoSnapshot {
+	if !staleSnapshots {
+		return nil
+	}
+	s := lastSnapshot.Load()
+	if s == nil || time.Since(s.TakenAt) > snapshotMaxAge {
+		return nil
+	}
+	return s
+}
+
+// serveSnapshot answers a GET /todos from the last snapshot, marking it
+// stale. The snapshot is the whole list in creation order, so only requests
+// without filters, sorting or paging can be answered from it; it reports
+// false for the rest, and when there is no fresh snapshot.
+func serveSnapshot(w http.ResponseWriter, r *http.Request) bool {
+	s := freshSnapshot()
+	if s == nil {
+		return false
+	}
+	q := r.URL.Query()
+	if len(unknownParams(q, []string{"time_format"})) > 0 {
+		return false
+	}
+	tf, err := parseTimeFormat(q)
+	if err != nil {
+		return false
+	}
+
+	list := []todo{}
+	for _, t := range s.Todos {
+		list = append(list, t.toTodo().withTimeFormat(tf))
+	}
+	w.Header().Set("Warning", `110 - "Response is Stale"`)
+	w.Header().Set("X-Data-Stale-Since", s.TakenAt.UTC().Format(time.RFC3339))
+	respond(w, r, http.StatusOK, renderer.M{"data": list})
+	return true
+}
+
+// failFastWhenDown answers writes with 503 while the breaker is open rather
+// than letting each one wait out the database timeout.
+func failFastWhenDown(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if dbBreaker.open() {
+				w.Header().Set("Retry-After", "10")
+				respond(w, r, http.StatusServiceUnavailable, renderer.M{"message": "Database unavailable, try again later"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultStaleAge = 3 * 24 * time.Hour
+	maxStaleAge     = 365 * 24 * time.Hour
+)
+
+// parseStaleAge reads ?older_than, a Go duration such as "36h" or a number
+// of days such as "3d".
+func parseStaleAge(r *http.Request) (time.Duration, error) {
+	s := r.URL.Query().Get("older_than")
+	if s == "" {
+		return defaultStaleAge, nil
+	}
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 || d > maxStaleAge {
+		return 0, fmt.Errorf("older_than must be a positive duration up to 365d, such as 3d or 36h")
+	}
+	return d, nil
+}
+
+// staleFilter matches the in-progress todos whose status last changed
+// before cutoff. Todos from before statusChangedAt was recorded fall back
+// to when their status field last changed, then to when they were created.
+func staleFilter(cutoff time.Time) bson.M {
+	changed := bson.M{"$ifNull": bson.A{"$statusChangedAt", bson.M{"$ifNull": bson.A{"$fieldUpdatedAt.status", "$createAt"}}}}
+	return bson.M{
+		"completed": false,
+		"status":    statusInProgress,
+		"$expr":     bson.M{"$lt": bson.A{changed, cutoff}},
+	}
+}
+
+// fetchStaleTodos lists the todos in progress for longer than
+// ?older_than, longest first.
+func fetchStaleTodos(w http.ResponseWriter, r *http.Request) {
+	age, err := parseStaleAge(r)
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid older_than", "error": err.Error()})
+		return
+	}
+	tf, err := parseTimeFormat(r.URL.Query())
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid time format", "error": err.Error()})
+		return
+	}
+	page, err := parsePaging(r.URL.Query())
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid list options", "error": err.Error()})
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	filter := staleFilter(time.Now().Add(-age))
+	opts := page.apply(options.Find().SetSort(bson.D{{Key: "statusChangedAt", Value: 1}, {Key: "createAt", Value: 1}, {Key: "_id", Value: 1}}))
+	cur, err := db.Collection(collectionName).Find(ctx, scoped(ctx, filter), opts)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch todo", "error": err.Error()})
+		return
+	}
+	defer cur.Close(ctx)
+
+	var todos []todoModel
+	if err := cur.All(ctx, &todos); err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to decode todos", "error": err.Error()})
+		return
+	}
+
+	list := []todo{}
+	for _, t := range todos {
+		list = append(list, t.toTodo().withTimeFormat(tf))
+	}
+	respond(w, r, http.StatusOK, renderer.M{"data": list, "paging": page.meta(w)})
+}
+
+// resetStaleTodos moves every todo GET /todos/stale would list with the
+// same ?older_than back to todo.
+func resetStaleTodos(w http.ResponseWriter, r *http.Request) {
+	age, err := parseStaleAge(r)
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid older_than", "error": err.Error()})
+		return
+	}
+
+	fw := newFieldWrites(time.Now())
+	fw.setStatus(statusTodo)
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	filter := staleFilter(fw.now.Add(-age))
+	modified, err := updateManyAudited(ctx, filter, fw.pipeline())
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to update todos", "error": err.Error()})
+		return
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{"message": "Successfully updated TODOs", "modified": modified})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestResetStaleTodosRecordsHistory(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("stale reset", func(mt *mtest.T) {
+		useMockDB(mt)
+		drainAudit()
+
+		id := primitive.NewObjectID()
+		ns := mt.DB.Name() + "." + collectionName
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: id}, {Key: "title", Value: "t"}, {Key: "status", Value: statusInProgress}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: id}, {Key: "title", Value: "t"}}),
+		)
+
+		r := httptest.NewRequest(http.MethodPost, "/todos/stale/reset?older_than=3d", nil)
+		w := httptest.NewRecorder()
+		testRouter().ServeHTTP(w, r)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"modified":1`) {
+			mt.Fatalf("stale reset: %d %s", w.Code, w.Body)
+		}
+
+		entries := drainAudit()
+		if len(entries) != 1 {
+			mt.Fatalf("history has %d entries; want 1", len(entries))
+		}
+		e := entries[0]
+		if e.TodoID != id || e.Before.Status != statusInProgress || todoStatus(e.After.Completed, e.After.Status) != statusTodo {
+			mt.Errorf("entry = %s of %s from %q to %q; want in_progress to todo", e.Type, e.TodoID.Hex(), e.Before.Status, e.After.Status)
+		}
+	})
+}
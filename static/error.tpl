@@ -0,0 +1,22 @@
+<!doctype html>
+<html lang="en">
+  <head>
+    <title>{{.Status}} {{.Title}} · Todo</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1, shrink-to-fit=no">
+    <link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/4.0.0-beta.2/css/bootstrap.min.css" integrity="sha384-PsH8R72JQ3SOdhVi3uxftmaW6Vc51MKb0q5P2rRUpPvrszuE4W1povHYgTpBfshb" crossorigin="anonymous">
+    <link rel="stylesheet" href="{{asset "app.css"}}">
+  </head>
+  <body>
+    <div class="container">
+        <div class="row">
+            <div class="col-6 offset-3">
+                <br><br>
+                <div class="todo-title">{{.Status}} · {{.Title}}</div>
+                <p class="text-center mt-3">{{.Message}}</p>
+                <p class="text-center"><a href="/">Back to your todos</a></p>
+            </div>
+        </div>
+    </div>
+  </body>
+</html>
@@ -0,0 +1,42 @@
+{{/* Fragments shared by home.tpl and the /partials endpoints, so the full
+     page and htmx updates are rendered from the same markup. */}}
+
+{{define "todo-summary"}}
+<div id="todo-summary" class="todo-summary"{{if .OOB}} hx-swap-oob="true"{{end}}>
+  {{if .Total}}{{.Completed}} of {{.Total}} done{{with .Pending}} &middot; {{.}} to go{{end}}{{if not .LastAdded.IsZero}} &middot; <span title="{{formatDate .LastAdded "Mon Jan 2, 2006 15:04"}}">last added {{timeAgo .LastAdded}}</span>{{end}}{{end}}
+</div>
+{{end}}
+
+{{define "todo-row"}}
+<li id="todo-{{.ID}}" class="list-group-item {{if .Completed}}checked{{else}}not-checked{{end}}"
+    hx-post="/partials/todo/{{.ID}}/toggle" hx-target="this" hx-swap="outerHTML">
+  <i class="{{if .Completed}}fa fa-check-circle text-success{{else}}fa fa-circle{{end}}">&nbsp;</i>
+  <span class="{{if .Completed}}del{{end}}">{{.Title}}</span>
+  <div class="btn-group float-right" role="group">
+    <button type="button" class="btn btn-danger btn-sm custom-button"
+            hx-delete="/todos/{{.ID}}" hx-confirm="Are you sure ?" hx-swap="none"
+            hx-on::after-request="if (event.detail.successful) this.closest('li').remove()"
+            onclick="event.stopPropagation()"><span class="fa fa-trash"></span></button>
+  </div>
+</li>
+{{end}}
+
+{{define "todo-row-update"}}
+{{template "todo-row" .Todo}}
+{{template "todo-summary" .Summary}}
+{{end}}
+
+{{define "todo-list"}}
+<div id="todo-list">
+  <ul class="list-group">
+    {{range .Todos}}{{template "todo-row" .}}{{end}}
+  </ul>
+  {{if or .PrevPage .NextPage}}
+  <nav class="d-flex justify-content-between p-2">
+    {{with .PrevPage}}<a href="/?page={{.}}" hx-get="/?page={{.}}" hx-target="#todo-list" hx-swap="outerHTML" hx-push-url="true">&laquo; Previous</a>{{else}}<span></span>{{end}}
+    <span>Page {{.Page}}</span>
+    {{with .NextPage}}<a href="/?page={{.}}" hx-get="/?page={{.}}" hx-target="#todo-list" hx-swap="outerHTML" hx-push-url="true">Next &raquo;</a>{{else}}<span></span>{{end}}
+  </nav>
+  {{end}}
+</div>
+{{end}}
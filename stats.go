@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// todoStats is everything GET /todos/stats reports: the open backlog, how
+// fast it was worked through over the last Days days, how todos spread
+// over the priorities and how full the WIP limits are.
+type todoStats struct {
+	Open int64 `json:"open" xml:"open"`
+	// Inbox counts open todos still waiting to be triaged.
+	Inbox int64 `json:"inbox" xml:"inbox"`
+	Stale int64 `json:"stale" xml:"stale"`
+	// StaleAfterDays is 0 when staleness is off, and Stale with it.
+	StaleAfterDays int `json:"stale_after_days" xml:"stale_after_days"`
+	// MedianOpenAgeSeconds is how long ago the median open todo was
+	// created, or nil without open todos.
+	MedianOpenAgeSeconds *float64 `json:"median_open_age_seconds" xml:"median_open_age_seconds"`
+	// PendingMinutes sums the estimates of open todos; todos without one
+	// count as zero.
+	PendingMinutes int64 `json:"pending_estimate_minutes" xml:"pending_estimate_minutes"`
+
+	Days      int     `json:"days" xml:"days"`
+	Completed int64   `json:"completed" xml:"completed"`
+	PerDay    float64 `json:"per_day" xml:"per_day"`
+	// DaysToClear is null when nothing was completed in the window, since
+	// the backlog would then never clear.
+	DaysToClear *float64 `json:"days_to_clear" xml:"days_to_clear"`
+	// AvgTriageHours is the mean time from creation to first triage of the
+	// todos triaged in the window, null when there were none.
+	AvgTriageHours *float64 `json:"avg_triage_hours" xml:"avg_triage_hours"`
+
+	ByPriority []priorityCount `json:"by_priority" xml:"by_priority>entry"`
+	// WIPLimits is how full each limited status is.
+	WIPLimits []wipLimit `json:"wip_limits" xml:"wip_limits>limit"`
+}
+
+// fetchTodoStats serves GET /todos/stats. ?days= sets the window of the
+// completion figures.
+func fetchTodoStats(w http.ResponseWriter, r *http.Request) {
+	days := defaultVelocityDays
+	if s := r.URL.Query().Get("days"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxVelocityDays {
+			respond(w, r, http.StatusBadRequest, renderer.M{
+				"message": "Invalid days",
+				"error":   fmt.Sprintf("days must be an integer between 1 and %d", maxVelocityDays),
+			})
+			return
+		}
+		days = n
+	}
+
+	stats, err := cachedRead(r, "stats:"+strconv.Itoa(days), func() (interface{}, error) {
+		ctx, cancel := dbContext(r)
+		defer cancel()
+		return computeStats(ctx, days)
+	})
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch stats", "error": err.Error()})
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{"data": stats})
+}
+
+// computeStats runs the queries behind GET /todos/stats.
+func computeStats(ctx context.Context, days int) (todoStats, error) {
+	s := todoStats{Days: days}
+	if err := s.addVelocity(ctx); err != nil {
+		return todoStats{}, err
+	}
+	if err := s.addOpenAge(ctx, time.Now()); err != nil {
+		return todoStats{}, err
+	}
+	var err error
+	if s.ByPriority, err = countByPriority(ctx); err != nil {
+		return todoStats{}, err
+	}
+	if s.WIPLimits, err = wipUtilization(ctx); err != nil {
+		return todoStats{}, err
+	}
+	return s, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestStatsShape answers the queries behind GET /todos/stats and expects
+// one object holding the backlog, velocity and priority figures.
+func TestStatsShape(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("stats", func(mt *mtest.T) {
+		useMockDB(mt)
+		defer func(n int) { staleAfterDays = n }(staleAfterDays)
+		staleAfterDays = 30
+
+		ns := mt.DB.Name() + "." + collectionName
+		cursor := func(docs ...bson.D) bson.D {
+			return mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, docs...)
+		}
+		mt.AddMockResponses(
+			cursor(bson.D{{Key: "completed", Value: 14}}),
+			cursor(bson.D{{Key: "count", Value: 3}, {Key: "estimate", Value: 90}, {Key: "inbox", Value: 1}}),
+			cursor(),
+			cursor(bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: 1}}),
+			cursor(bson.D{{Key: "createAt", Value: time.Now().Add(-time.Hour)}}),
+			cursor(bson.D{{Key: "_id", Value: priorityHigh}, {Key: "count", Value: 2}}, bson.D{{Key: "_id", Value: nil}, {Key: "count", Value: 1}}),
+			mtest.CreateCursorResponse(0, mt.DB.Name()+"."+wipLimitsCollection, mtest.FirstBatch, bson.D{{Key: "_id", Value: statusInProgress}, {Key: "limit", Value: 3}}),
+			cursor(bson.D{{Key: "n", Value: 2}}),
+		)
+
+		w := httptest.NewRecorder()
+		testRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos/stats?days=7", nil))
+		if w.Code != http.StatusOK {
+			mt.Fatalf("GET /todos/stats: %d %s", w.Code, w.Body)
+		}
+		var res struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			mt.Fatal(err)
+		}
+		want := map[string]interface{}{
+			"open": 3.0, "inbox": 1.0, "stale": 1.0, "stale_after_days": 30.0, "pending_estimate_minutes": 90.0,
+			"days": 7.0, "completed": 14.0, "per_day": 2.0, "days_to_clear": 1.5, "avg_triage_hours": nil,
+		}
+		for k, v := range want {
+			if got, ok := res.Data[k]; !ok || got != v {
+				mt.Errorf("%s = %v; want %v", k, got, v)
+			}
+		}
+		if age, _ := res.Data["median_open_age_seconds"].(float64); age < 3600 || age > 3700 {
+			mt.Errorf("median_open_age_seconds = %v; want about an hour", res.Data["median_open_age_seconds"])
+		}
+		byPriority, _ := res.Data["by_priority"].([]interface{})
+		if len(byPriority) != 4 {
+			mt.Fatalf("by_priority = %v; want high, medium, low and none", res.Data["by_priority"])
+		}
+		if first := byPriority[0].(map[string]interface{}); first["priority"] != priorityHigh || first["count"] != 2.0 {
+			mt.Errorf("by_priority[0] = %v; want 2 high", first)
+		}
+		limits, _ := res.Data["wip_limits"].([]interface{})
+		if len(limits) != 1 {
+			mt.Fatalf("wip_limits = %v; want the in_progress limit", res.Data["wip_limits"])
+		}
+		if l := limits[0].(map[string]interface{}); l["status"] != statusInProgress || l["limit"] != 3.0 || l["count"] != 2.0 {
+			mt.Errorf("wip_limits[0] = %v; want 2 of 3 in_progress", l)
+		}
+	})
+}
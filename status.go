@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Todo statuses. Only in_progress and blocked are stored: todo and done
+// follow from the completed flag, which stays the source of truth so
+// clients that only know about completed keep working. Completing a todo
+// clears its stored status; reopening it leaves it at todo.
+const (
+	statusTodo       = "todo"
+	statusInProgress = "in_progress"
+	statusDone       = "done"
+	statusBlocked    = "blocked"
+)
+
+var statuses = map[string]bool{statusTodo: true, statusInProgress: true, statusDone: true, statusBlocked: true}
+
+func validateStatus(s string) renderer.M {
+	if !statuses[s] {
+		return renderer.M{"message": "Status must be todo, in_progress, done or blocked", "field": "status"}
+	}
+	return nil
+}
+
+// todoStatus is the status of a stored todo.
+func todoStatus(completed bool, stored string) string {
+	switch {
+	case completed:
+		return statusDone
+	case stored == "":
+		return statusTodo
+	}
+	return stored
+}
+
+// storedStatus is the value kept in the status field for s, nil for the
+// statuses that are derived.
+func storedStatus(s string) interface{} {
+	if s == statusTodo || s == statusDone {
+		return nil
+	}
+	return s
+}
+
+// setStatus records s along with the completed flag it implies.
+func (fw *fieldWrites) setStatus(s string) {
+	fw.writeCompleted(s == statusDone)
+	if s != statusDone {
+		fw.set("status", "status", storedStatus(s))
+	}
+	fw.stampStatus(s)
+	fw.status = s
+}
+
+// parseStatuses reads ?status=, a comma separated list of statuses.
+func parseStatuses(raw string) ([]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var out []string
+	for _, s := range strings.Split(raw, ",") {
+		s = strings.TrimSpace(s)
+		if !statuses[s] {
+			return nil, fmt.Errorf("status must be todo, in_progress, done or blocked")
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// statusCond matches todos in any of ss.
+func statusCond(ss []string) bson.M {
+	var or bson.A
+	var stored bson.A
+	for _, s := range ss {
+		switch s {
+		case statusDone:
+			or = append(or, bson.M{"completed": true})
+		case statusTodo:
+			or = append(or, bson.M{"completed": false, "status": nil})
+		default:
+			stored = append(stored, s)
+		}
+	}
+	if len(stored) > 0 {
+		or = append(or, bson.M{"completed": false, "status": bson.M{"$in": stored}})
+	}
+	if len(or) == 1 {
+		return or[0].(bson.M)
+	}
+	return bson.M{"$or": or}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type tagCount struct {
+	Tag   string `bson:"_id" json:"tag" xml:"tag"`
+	Count int64  `bson:"count" json:"count" xml:"count"`
+}
+
+// fetchTags lists every tag in use with the number of todos carrying it,
+// most used first.
+func fetchTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := cachedRead(r, "tags", func() (interface{}, error) {
+		ctx, cancel := dbContext(r)
+		defer cancel()
+
+		cur, err := db.Collection(collectionName).Aggregate(ctx, scopedPipeline(ctx, mongo.Pipeline{
+			{{Key: "$unwind", Value: "$tags"}},
+			{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+			{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		}))
+		if err != nil {
+			return nil, err
+		}
+		defer cur.Close(ctx)
+
+		tags := []tagCount{}
+		err = cur.All(ctx, &tags)
+		return tags, err
+	})
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch tags", "error": err.Error()})
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{"data": tags})
+}
+
+// renameTag replaces one tag with another on every todo. A todo that
+// already carries the new tag keeps a single copy, in the position of
+// whichever came first.
+func renameTag(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	from := normalizeTag(req.From)
+	to := normalizeTag(req.To)
+	if from == "" {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "From field is required", "field": "from"})
+		return
+	}
+	if to == "" {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "To field is required", "field": "to"})
+		return
+	}
+	if m := validateTags([]string{to}); m != nil {
+		m["field"] = "to"
+		respond(w, r, http.StatusBadRequest, m)
+		return
+	}
+	if from == to {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "From and to are the same tag"})
+		return
+	}
+
+	// Tags are user input, so they are wrapped in $literal to keep a tag
+	// like "$title" from being read as a field path.
+	renamed := bson.M{"$map": bson.M{
+		"input": "$tags",
+		"in": bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{"$$this", bson.M{"$literal": from}}},
+			bson.M{"$literal": to},
+			"$$this",
+		}},
+	}}
+	deduped := bson.M{"$reduce": bson.M{
+		"input":        renamed,
+		"initialValue": bson.A{},
+		"in": bson.M{"$cond": bson.A{
+			bson.M{"$in": bson.A{"$$this", "$$value"}},
+			"$$value",
+			bson.M{"$concatArrays": bson.A{"$$value", bson.A{"$$this"}}},
+		}},
+	}}
+	now := time.Now()
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"tags":                deduped,
+		"updatedAt":           now,
+		"fieldUpdatedAt.tags": now,
+	}}}}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	modified, err := updateManyAudited(ctx, bson.M{"tags": from}, update)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to rename tag", "error": err.Error()})
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{"message": "Tag renamed", "modified": modified})
+}
+
+// deleteTag removes a tag from every todo carrying it.
+func deleteTag(w http.ResponseWriter, r *http.Request) {
+	name, err := url.PathUnescape(chi.URLParam(r, "name"))
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid tag", "error": err.Error()})
+		return
+	}
+	name = normalizeTag(name)
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	now := time.Now()
+	modified, err := updateManyAudited(ctx, bson.M{"tags": name}, bson.M{
+		"$pull": bson.M{"tags": name},
+		"$set":  bson.M{"updatedAt": now, "fieldUpdatedAt.tags": now},
+	})
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to delete tag", "error": err.Error()})
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{"message": "Tag deleted", "modified": modified})
+}
+
+func tagHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(causalConsistency)
+
+	rg.With(expensive).Get("/", fetchTags)
+	rg.With(requireJSON).Post("/rename", renameTag)
+	rg.Delete("/{name}", deleteTag)
+	return rg
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// staticFiles is the static directory, built into the binary so the server
+// doesn't depend on the directory it is started from.
+//
+//go:embed static
+var staticFiles embed.FS
+
+// pages holds every template under static, parsed once at startup.
+var pages *template.Template
+
+var templateFuncs = template.FuncMap{
+	"asset":      assetURL,
+	"formatDate": formatDate,
+	"timeAgo":    timeAgo,
+}
+
+type (
+	homePage struct {
+		Summary homeSummary
+		List    todoListView
+	}
+	errorPage struct {
+		Status  int
+		Title   string
+		Message string
+	}
+)
+
+// requiredPages are the templates handlers render by name, including the
+// fragments defined in partials.tpl. A missing one would otherwise only
+// show up when its page is first requested.
+var requiredPages = []string{"home.tpl", "error.tpl", "todo-list", "todo-row", "todo-row-update", "todo-summary"}
+
+func loadTemplates() error {
+	static, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		return err
+	}
+	// Assets come first, since templates link to them through asset.
+	if err := loadAssets(static); err != nil {
+		return err
+	}
+	t, err := template.New("").Funcs(templateFuncs).ParseFS(static, "*.tpl")
+	if err != nil {
+		return err
+	}
+	for _, name := range requiredPages {
+		if t.Lookup(name) == nil {
+			return fmt.Errorf("template %s not found in static", name)
+		}
+	}
+	pages = t
+	return nil
+}
+
+// renderPage executes the named template into a buffer before writing, so a
+// failure halfway through becomes a clean error page instead of a truncated
+// response. Work stops early if the client has already gone away.
+func renderPage(w http.ResponseWriter, r *http.Request, status int, name string, data interface{}) {
+	if r.Context().Err() != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := pages.ExecuteTemplate(&buf, name, data); err != nil {
+		log.Printf("rendering %s: %v", name, err)
+		if name != "error.tpl" {
+			renderErrorPage(w, r, http.StatusInternalServerError, "Something went wrong while rendering this page.")
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Context().Err() != nil {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+func renderErrorPage(w http.ResponseWriter, r *http.Request, status int, message string) {
+	renderPage(w, r, status, "error.tpl", errorPage{
+		Status:  status,
+		Title:   http.StatusText(status),
+		Message: message,
+	})
+}
+
+// wantsHTML reports whether the client prefers an HTML page over JSON.
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func formatDate(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(layout)
+}
+
+// timeAgo renders t relative to now, e.g. "just now" or "2 days ago".
+func timeAgo(t time.Time) string {
+	d := time.Since(t)
+	unit := func(n int, name string) string {
+		if n == 1 {
+			return "1 " + name + " ago"
+		}
+		return fmt.Sprintf("%d %ss ago", n, name)
+	}
+
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return unit(int(d.Minutes()), "minute")
+	case d < 24*time.Hour:
+		return unit(int(d.Hours()), "hour")
+	case d < 30*24*time.Hour:
+		return unit(int(d.Hours()/24), "day")
+	case d < 365*24*time.Hour:
+		return unit(int(d.Hours()/(24*30)), "month")
+	}
+	return unit(int(d.Hours()/(24*365)), "year")
+}
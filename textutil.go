@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/thedevsaddam/renderer"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxTitleLength is the longest title, in characters, configurable through
+// MAX_TITLE_LENGTH.
+var maxTitleLength = 200
+
+// Error codes reported for text fields in the "code" field of the envelope.
+const (
+	codeTextTooLong      = "text_too_long"
+	codeInvalidEncoding  = "invalid_encoding"
+	codeControlCharacter = "control_character"
+)
+
+const zeroWidthJoiner = '\u200d'
+
+// normalizeText puts s in Unicode NFC, so that an "é" typed as one code
+// point and one written as "e" plus a combining accent are stored, and so
+// compared and searched, the same way.
+func normalizeText(s string) string {
+	return norm.NFC.String(s)
+}
+
+// textLength counts the characters a reader would see in s. It
+// approximates grapheme clusters: combining marks, variation selectors and
+// skin tone modifiers belong to the character before them, a zero width
+// joiner glues two emoji into one, and two regional indicators form one
+// flag.
+func textLength(s string) int {
+	n := 0
+	joined, flagOpen := false, false
+	for _, r := range s {
+		switch {
+		case joined:
+			joined = r == zeroWidthJoiner
+			continue
+		case r == zeroWidthJoiner && n > 0:
+			joined = true
+			continue
+		case extendsCharacter(r) && n > 0:
+			continue
+		case r >= 0x1f1e6 && r <= 0x1f1ff:
+			flagOpen = !flagOpen
+			if !flagOpen {
+				continue
+			}
+			n++
+			continue
+		}
+		flagOpen = false
+		n++
+	}
+	return n
+}
+
+func extendsCharacter(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Variation_Selector) ||
+		(r >= 0x1f3fb && r <= 0x1f3ff) // skin tone modifiers
+}
+
+// checkText rejects text that isn't valid UTF-8, contains control
+// characters or is longer than max characters. Invalid UTF-8 and unpaired
+// UTF-16 surrogates in JSON strings both decode to U+FFFD, so that is
+// rejected too.
+func checkText(s string, max int) (code string, err error) {
+	if !utf8.ValidString(s) || strings.ContainsRune(s, utf8.RuneError) {
+		return codeInvalidEncoding, fmt.Errorf("text is not valid UTF-8 or contains an unpaired surrogate")
+	}
+	if i := strings.IndexFunc(s, unicode.IsControl); i >= 0 {
+		r, _ := utf8.DecodeRuneInString(s[i:])
+		return codeControlCharacter, fmt.Errorf("control character %U is not allowed", r)
+	}
+	if textLength(s) > max {
+		return codeTextTooLong, fmt.Errorf("text is longer than %d characters", max)
+	}
+	return "", nil
+}
+
+// validateTitle checks a title that is already normalized and non-empty.
+func validateTitle(title string) renderer.M {
+	code, err := checkText(title, maxTitleLength)
+	if err == nil {
+		return nil
+	}
+	m := renderer.M{"message": "Invalid title", "field": "title", "code": code, "error": err.Error()}
+	if code == codeTextTooLong {
+		m["message"] = fmt.Sprintf("Title may be at most %d characters", maxTitleLength)
+	}
+	return m
+}
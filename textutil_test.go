@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"ascii", "buy milk", "buy milk"},
+		{"composed accent", "café", "café"},
+		{"combining accent", "cafe\u0301", "café"},
+		{"hangul jamo", "\u1112\u1161\u11ab", "한"},
+		{"angstrom sign", "\u212b", "Å"},
+		{"emoji untouched", "\U0001f469\u200d\U0001f4bb", "\U0001f469\u200d\U0001f4bb"},
+		{"rtl untouched", "שלום", "שלום"},
+	}
+	for _, tt := range tests {
+		if got := normalizeText(tt.in); got != tt.want {
+			t.Errorf("%s: normalizeText(%+q) = %+q; want %+q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTextLength(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "buy milk", 8},
+		{"composed accent", "café", 4},
+		{"combining accent", "cafe\u0301", 4},
+		{"stacked combining marks", "a\u0301\u0323\u0308", 1},
+		{"leading combining mark", "\u0301a", 2},
+		{"emoji", "\U0001f44d", 1},
+		{"twenty emoji", strings.Repeat("\U0001f44d", 20), 20},
+		{"skin tone", "\U0001f44d\U0001f3fd", 1},
+		{"variation selector", "❤\ufe0f", 1},
+		{"keycap", "1\ufe0f\u20e3", 1},
+		{"zwj sequence", "\U0001f469\u200d\U0001f4bb", 1},
+		{"zwj family", "\U0001f468\u200d\U0001f469\u200d\U0001f467\u200d\U0001f466", 1},
+		{"zwj with skin tone", "\U0001f469\U0001f3fd\u200d\U0001f4bb", 1},
+		{"zwj with selector", "\U0001f469\u200d❤\ufe0f\u200d\U0001f468", 1},
+		{"leading zwj", "\u200da", 2},
+		{"flag", "\U0001f1fa\U0001f1f8", 1},
+		{"two flags", "\U0001f1fa\U0001f1f8\U0001f1ec\U0001f1e7", 2},
+		{"lone regional indicator", "\U0001f1fa", 1},
+		{"flag then text", "\U0001f1fa\U0001f1f8 ok", 4},
+		{"hebrew", "שלום", 4},
+		{"arabic with harakat", "م\u064eر\u0652ح\u064eب\u064bا", 5},
+		{"mixed direction", "ship שלום v2", 12},
+		{"cjk", "猫を飼う", 4},
+	}
+	for _, tt := range tests {
+		if got := textLength(tt.in); got != tt.want {
+			t.Errorf("%s: textLength(%+q) = %d; want %d", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCheckText(t *testing.T) {
+	// JSON decoding is how text reaches checkText, and it turns an unpaired
+	// surrogate into U+FFFD.
+	var unpaired string
+	if err := json.Unmarshal([]byte(`"a\ud83d b"`), &unpaired); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		max  int
+		code string
+	}{
+		{"ascii", "buy milk", 10, ""},
+		{"at the limit", strings.Repeat("a", 10), 10, ""},
+		{"over the limit", strings.Repeat("a", 11), 10, codeTextTooLong},
+		{"emoji at the limit", strings.Repeat("\U0001f44d", 10), 10, ""},
+		{"emoji over the limit", strings.Repeat("\U0001f44d", 11), 10, codeTextTooLong},
+		{"zwj sequences at the limit", strings.Repeat("\U0001f469\u200d\U0001f4bb", 10), 10, ""},
+		{"combining marks at the limit", strings.Repeat("e\u0301", 10), 10, ""},
+		{"rtl", "שלום", 10, ""},
+		{"bidi mark", "a\u200fb", 10, ""},
+		{"invalid utf-8", "a\xffb", 10, codeInvalidEncoding},
+		{"unpaired surrogate", unpaired, 10, codeInvalidEncoding},
+		{"replacement character", "a\ufffdb", 10, codeInvalidEncoding},
+		{"nul", "a\x00b", 10, codeControlCharacter},
+		{"newline", "a\nb", 10, codeControlCharacter},
+		{"tab", "a\tb", 10, codeControlCharacter},
+		{"escape", "a\x1b[31mb", 10, codeControlCharacter},
+		{"c1 control", "a\u0085b", 10, codeControlCharacter},
+		{"delete", "a\x7fb", 10, codeControlCharacter},
+	}
+	for _, tt := range tests {
+		code, err := checkText(tt.in, tt.max)
+		if code != tt.code || (err == nil) != (tt.code == "") {
+			t.Errorf("%s: checkText(%+q, %d) = %q, %v; want %q", tt.name, tt.in, tt.max, code, err, tt.code)
+		}
+	}
+}
+
+func TestValidateTitle(t *testing.T) {
+	defer func(n int) { maxTitleLength = n }(maxTitleLength)
+	maxTitleLength = 20
+
+	if m := validateTitle(strings.Repeat("\U0001f600", 20)); m != nil {
+		t.Errorf("twenty emoji refused: %v", m)
+	}
+	m := validateTitle(strings.Repeat("\U0001f600", 21))
+	if m == nil || m["code"] != codeTextTooLong || m["field"] != "title" {
+		t.Errorf("validateTitle of 21 emoji = %v; want %s on title", m, codeTextTooLong)
+	}
+	if m := validateTitle("a\x00b"); m == nil || m["code"] != codeControlCharacter {
+		t.Errorf("validateTitle with NUL = %v; want %s", m, codeControlCharacter)
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Work", "work"},
+		{"  work\t", "work"},
+		{"Cafe\u0301", "café"},
+		{"CAFÉ", "café"},
+		{"עבודה", "עבודה"},
+		{"\U0001f3e0", "\U0001f3e0"},
+	}
+	for _, tt := range tests {
+		if got := normalizeTag(tt.in); got != tt.want {
+			t.Errorf("normalizeTag(%+q) = %+q; want %+q", tt.in, got, tt.want)
+		}
+	}
+	if normalizeTag("cafe\u0301") != normalizeTag("café") {
+		t.Error("the two spellings of café are different tags")
+	}
+}
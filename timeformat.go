@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// timeFormat selects how read endpoints serialize todo timestamps.
+type timeFormat int
+
+const (
+	timeFormatRFC3339 timeFormat = iota
+	// timeFormatEpoch writes timestamps as integer Unix milliseconds.
+	timeFormatEpoch
+)
+
+func parseTimeFormat(q url.Values) (timeFormat, error) {
+	switch q.Get("time_format") {
+	case "", "rfc3339":
+		return timeFormatRFC3339, nil
+	case "epoch":
+		return timeFormatEpoch, nil
+	}
+	return 0, fmt.Errorf("time_format must be rfc3339 or epoch")
+}
+
+// withTimeFormat returns a copy of t that marshals its timestamps in f.
+func (t todo) withTimeFormat(f timeFormat) todo {
+	t.timeFormat = f
+	return t
+}
+
+func (t todo) MarshalJSON() ([]byte, error) {
+	type plain todo
+	if t.timeFormat != timeFormatEpoch {
+		return json.Marshal(plain(t))
+	}
+
+	// The outer fields shadow the embedded ones with the same JSON name.
+	type epochPreview struct {
+		linkPreview
+		FetchedAt int64 `json:"fetched_at"`
+	}
+	out := struct {
+		plain
+		CreatedAt      int64            `json:"create_at"`
+		DueDate        *int64           `json:"due_date,omitempty"`
+		CompletedAt    *int64           `json:"completed_at,omitempty"`
+		StatusChanged  *int64           `json:"status_changed_at,omitempty"`
+		UpdatedAt      *int64           `json:"updated_at,omitempty"`
+		TriagedAt      *int64           `json:"triaged_at,omitempty"`
+		ReopenedAt     *int64           `json:"reopened_at,omitempty"`
+		LastSurfacedAt *int64           `json:"last_surfaced_at,omitempty"`
+		LinkPreview    *epochPreview    `json:"link_preview,omitempty"`
+		FieldUpdatedAt map[string]int64 `json:"field_updated_at,omitempty"`
+	}{
+		plain:          plain(t),
+		CreatedAt:      t.CreatedAt.UnixMilli(),
+		DueDate:        epochMillis(t.DueDate),
+		CompletedAt:    epochMillis(t.CompletedAt),
+		StatusChanged:  epochMillis(t.StatusChangedAt),
+		UpdatedAt:      epochMillis(t.UpdatedAt),
+		TriagedAt:      epochMillis(t.TriagedAt),
+		ReopenedAt:     epochMillis(t.ReopenedAt),
+		LastSurfacedAt: epochMillis(t.LastSurfacedAt),
+	}
+	if lp := t.LinkPreview; lp != nil {
+		out.LinkPreview = &epochPreview{linkPreview: *lp, FetchedAt: lp.FetchedAt.UnixMilli()}
+	}
+	if t.FieldUpdatedAt != nil {
+		out.FieldUpdatedAt = make(map[string]int64, len(t.FieldUpdatedAt))
+		for k, v := range t.FieldUpdatedAt {
+			out.FieldUpdatedAt[k] = v.UnixMilli()
+		}
+	}
+	return json.Marshal(out)
+}
+
+func epochMillis(t *time.Time) *int64 {
+	if t == nil {
+		return nil
+	}
+	ms := t.UnixMilli()
+	return &ms
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEpochCoversEveryTimestamp sets every timestamp field of a todo and
+// expects each to come out as a number under time_format=epoch, so a
+// timestamp added to todo without an epoch form fails here.
+func TestEpochCoversEveryTimestamp(t *testing.T) {
+	at := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+	var td todo
+	v := reflect.ValueOf(&td).Elem()
+	var names []string
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch f.Type {
+		case reflect.TypeOf(time.Time{}):
+			v.Field(i).Set(reflect.ValueOf(at))
+		case reflect.TypeOf(&time.Time{}):
+			v.Field(i).Set(reflect.ValueOf(&at))
+		default:
+			continue
+		}
+		names = append(names, name)
+	}
+	td.FieldUpdatedAt = fieldTimes{"title": at}
+	td.LinkPreview = &linkPreview{URL: "https://example.com", Title: "Example", FetchedAt: at}
+
+	data, err := json.Marshal(td.withTimeFormat(timeFormatEpoch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := float64(at.UnixMilli())
+	for _, name := range names {
+		if out[name] != want {
+			t.Errorf("%s = %v; want %v", name, out[name], want)
+		}
+	}
+	if fu, _ := out["field_updated_at"].(map[string]interface{}); fu["title"] != want {
+		t.Errorf("field_updated_at = %v; want title at %v", out["field_updated_at"], want)
+	}
+	lp, _ := out["link_preview"].(map[string]interface{})
+	if lp["fetched_at"] != want || lp["title"] != "Example" {
+		t.Errorf("link_preview = %v; want fetched_at %v and the rest kept", out["link_preview"], want)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const templateCollection = "templates"
+
+// Templates are reusable todo blueprints. Their title may contain
+// placeholders such as {{date}} that are expanded on instantiation.
+type (
+	templateModel struct {
+		ID       primitive.ObjectID `bson:"_id,omitempty"`
+		Name     string             `bson:"name"`
+		Title    string             `bson:"title"`
+		Tags     []string           `bson:"tags,omitempty"`
+		CreateAt time.Time          `bson:"createAt"`
+		Schedule *templateSchedule  `bson:"schedule,omitempty"`
+
+		// SessionID and SessionExpireAt are only set in demo mode.
+		SessionID       string     `bson:"sessionId,omitempty"`
+		SessionExpireAt *time.Time `bson:"sessionExpireAt,omitempty"`
+	}
+	todoTemplate struct {
+		ID        string    `json:"id" xml:"id"`
+		Name      string    `json:"name" xml:"name"`
+		Title     string    `json:"title" xml:"title"`
+		Tags      []string  `json:"tags,omitempty" xml:"tags,omitempty"`
+		CreatedAt time.Time `json:"create_at" xml:"create_at"`
+
+		Schedule *templateSchedule `json:"schedule,omitempty" xml:"schedule,omitempty"`
+	}
+)
+
+func (t templateModel) toTemplate() todoTemplate {
+	return todoTemplate{ID: t.ID.Hex(), Name: t.Name, Title: t.Title, Tags: t.Tags, CreatedAt: t.CreateAt, Schedule: t.Schedule}
+}
+
+var placeholderRe = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// placeholders maps each title placeholder to its value at a given time.
+var placeholders = map[string]func(time.Time) string{
+	"date":    func(t time.Time) string { return t.Format("2006-01-02") },
+	"time":    func(t time.Time) string { return t.Format("15:04") },
+	"weekday": func(t time.Time) string { return t.Weekday().String() },
+}
+
+func expandTitle(title string, now time.Time) string {
+	return placeholderRe.ReplaceAllStringFunc(title, func(m string) string {
+		name := placeholderRe.FindStringSubmatch(m)[1]
+		if f, ok := placeholders[name]; ok {
+			return f(now)
+		}
+		return m
+	})
+}
+
+func validateTemplate(t *todoTemplate) renderer.M {
+	if t.Name == "" {
+		return renderer.M{"message": "Name field is required", "field": "name"}
+	}
+	if t.Title == "" {
+		return renderer.M{"message": "Title field is required", "field": "title"}
+	}
+	t.Title = normalizeText(t.Title)
+	if m := validateTitle(t.Title); m != nil {
+		return m
+	}
+	if t.Schedule != nil {
+		return renderer.M{"message": "Set schedules with PUT /templates/{id}/schedule", "field": "schedule"}
+	}
+	for _, m := range placeholderRe.FindAllStringSubmatch(t.Title, -1) {
+		if _, ok := placeholders[m[1]]; !ok {
+			return renderer.M{
+				"message": "Unknown placeholder in title",
+				"field":   "title",
+				"error":   fmt.Sprintf("%s is not one of {{date}}, {{time}}, {{weekday}}", m[0]),
+			}
+		}
+	}
+	if t.Tags != nil {
+		t.Tags = normalizeTags(t.Tags, nil)
+		if m := validateTags(t.Tags); m != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+func templateFilter(w http.ResponseWriter, r *http.Request) (bson.M, bool) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid ID"})
+		return nil, false
+	}
+	return scoped(r.Context(), bson.M{"_id": id}), true
+}
+
+func fetchTemplates(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}})
+	cur, err := db.Collection(templateCollection).Find(ctx, scoped(ctx, bson.M{}), opts)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch templates", "error": err.Error()})
+		return
+	}
+	defer cur.Close(ctx)
+
+	var models []templateModel
+	if err := cur.All(ctx, &models); err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to decode templates", "error": err.Error()})
+		return
+	}
+
+	list := []todoTemplate{}
+	for _, m := range models {
+		list = append(list, m.toTemplate())
+	}
+	respond(w, r, http.StatusOK, renderer.M{"data": list})
+}
+
+func createTemplate(w http.ResponseWriter, r *http.Request) {
+	var t todoTemplate
+	if !decodeJSON(w, r, &t) {
+		return
+	}
+	if m := validateTemplate(&t); m != nil {
+		respond(w, r, http.StatusBadRequest, m)
+		return
+	}
+
+	tm := templateModel{
+		ID:       primitive.NewObjectID(),
+		Name:     t.Name,
+		Title:    t.Title,
+		Tags:     t.Tags,
+		CreateAt: time.Now(),
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	tm.SessionID, tm.SessionExpireAt = demoExpiry(ctx)
+	if err := demoRoom(ctx, templateCollection, 1); err != nil {
+		if err == errDemoFull {
+			demoFull(w, r)
+			return
+		}
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to save template", "error": err.Error()})
+		return
+	}
+	if _, err := db.Collection(templateCollection).InsertOne(ctx, tm); err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to save template", "error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Location", "/templates/"+tm.ID.Hex())
+	respond(w, r, http.StatusOK, renderer.M{"message": "Template successfully saved", "data": tm.toTemplate()})
+}
+
+func getTemplate(w http.ResponseWriter, r *http.Request) {
+	filter, ok := templateFilter(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	var tm templateModel
+	err := db.Collection(templateCollection).FindOne(ctx, filter).Decode(&tm)
+	if err == mongo.ErrNoDocuments {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Template not found"})
+		return
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch template", "error": err.Error()})
+		return
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{"data": tm.toTemplate()})
+}
+
+func deleteTemplate(w http.ResponseWriter, r *http.Request) {
+	filter, ok := templateFilter(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	res, err := db.Collection(templateCollection).DeleteOne(ctx, filter)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to delete template", "error": err.Error()})
+		return
+	}
+	if res.DeletedCount == 0 {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Template not found"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// instantiateTemplate creates a todo from a template, expanding title
+// placeholders in the timezone given by ?tz, falling back to the caller's
+// settings and then UTC. The todo goes through the same validation as
+// POST /todos; tags that no longer fit the current tag limits are dropped
+// and reported in "warnings" rather than failing the request.
+func instantiateTemplate(w http.ResponseWriter, r *http.Request) {
+	filter, ok := templateFilter(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		settings, err := loadSettings(ctx, r)
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch settings", "error": err.Error()})
+			return
+		}
+		tz = settings.Timezone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid timezone", "error": err.Error()})
+		return
+	}
+
+	var tm templateModel
+	err = db.Collection(templateCollection).FindOne(ctx, filter).Decode(&tm)
+	if err == mongo.ErrNoDocuments {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "Template not found"})
+		return
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch template", "error": err.Error()})
+		return
+	}
+
+	t, dropped := tm.newTodo(time.Now().In(loc))
+	if m := validateTodo(&t, nil); m != nil {
+		respond(w, r, http.StatusBadRequest, m)
+		return
+	}
+	warnings, ok := checkWarnings(w, r, ctx, warningInput{Title: &t.Title, Tags: t.Tags})
+	if !ok {
+		return
+	}
+
+	created, err := insertTodo(ctx, t)
+	if err == errDemoFull {
+		demoFull(w, r)
+		return
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to save todo", "error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Location", "/todos/"+created.ID.Hex())
+	respond(w, r, http.StatusOK, withWarnings(r, renderer.M{
+		"message":  "Todo successfully saved",
+		"Todo ID":  created.ID.Hex(),
+		"short_id": created.ShortID,
+	}, append(dropped, warnings...)))
+}
+
+// newTodo builds the todo the template describes at now, along with
+// warnings for anything that had to be dropped.
+func (tm templateModel) newTodo(now time.Time) (todo, []warning) {
+	tags, warnings := fitTags(normalizeTags(tm.Tags, nil))
+	return todo{Title: expandTitle(tm.Title, now), Tags: tags}, warnings
+}
+
+// fitTags drops the tags a template was saved with that the current
+// MAX_TAGS and MAX_TAG_LENGTH no longer allow.
+func fitTags(tags []string) ([]string, []warning) {
+	var kept []string
+	var warnings []warning
+	for _, tag := range tags {
+		switch {
+		case utf8.RuneCountInString(tag) > maxTagLength:
+			warnings = append(warnings, warning{
+				Code:    warnTagDropped,
+				Field:   "tags",
+				Message: fmt.Sprintf("tag %q dropped: longer than %d characters", tag, maxTagLength),
+			})
+		case len(kept) == maxTags:
+			warnings = append(warnings, warning{
+				Code:    warnTagDropped,
+				Field:   "tags",
+				Message: fmt.Sprintf("tag %q dropped: a todo may have at most %d tags", tag, maxTags),
+			})
+		default:
+			kept = append(kept, tag)
+		}
+	}
+	return kept, warnings
+}
+
+func templateHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(causalConsistency)
+
+	rg.Get("/", fetchTemplates)
+	rg.With(requireJSON).Post("/", createTemplate)
+	rg.Get("/{id}", getTemplate)
+	rg.Delete("/{id}", deleteTemplate)
+	rg.Post("/{id}/instantiate", instantiateTemplate)
+	rg.With(requireJSON).Put("/{id}/schedule", putSchedule)
+	rg.Delete("/{id}/schedule", deleteSchedule)
+	return rg
+}
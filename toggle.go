@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// toggleByFilterRequest sets the completed flag of every todo matching
+// Filter. An empty filter is refused unless All is set, so a missing or
+// misspelled filter can't silently rewrite the whole collection.
+type toggleByFilterRequest struct {
+	Filter    bulkFilter `json:"filter"`
+	Completed *bool      `json:"completed"`
+	All       bool       `json:"all"`
+}
+
+func toggleByFilter(w http.ResponseWriter, r *http.Request) {
+	var req toggleByFilterRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Completed == nil {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Completed field is required", "field": "completed"})
+		return
+	}
+
+	filter, m := req.Filter.todoFilter()
+	if m != nil {
+		respond(w, r, http.StatusBadRequest, m)
+		return
+	}
+	if filter.empty() && !req.All {
+		respond(w, r, http.StatusBadRequest, renderer.M{
+			"message": "Filter is empty; set \"all\": true to update every todo",
+			"field":   "filter",
+		})
+		return
+	}
+
+	// Todos already in the target state are left alone, so ModifiedCount
+	// only counts real changes.
+	query := bson.M{"$and": bson.A{filter.query(), bson.M{"completed": bson.M{"$ne": *req.Completed}}}}
+
+	fw := newFieldWrites(time.Now())
+	fw.setCompleted(*req.Completed)
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	modified, err := updateManyAudited(ctx, query, fw.pipeline())
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to update todos", "error": err.Error()})
+		return
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{"message": "Successfully updated TODOs", "modified": modified})
+}
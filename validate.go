@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// Tag limits, configurable through MAX_TAGS and MAX_TAG_LENGTH.
+var (
+	maxTags      = 20
+	maxTagLength = 32
+)
+
+// Bounds of a todo's estimate, in minutes.
+const (
+	minEstimate = 1
+	maxEstimate = 6000
+)
+
+// normalizations lists what the server changed in a write's payload, such
+// as "tags: lowercased", for the meta of its response. Recording into a nil
+// *normalizations does nothing, for callers that don't report them.
+type normalizations []string
+
+func (n *normalizations) note(field, change string) {
+	if n == nil {
+		return
+	}
+	entry := field + ": " + change
+	for _, e := range *n {
+		if e == entry {
+			return
+		}
+	}
+	*n = append(*n, entry)
+}
+
+// withNormalizations adds n to the meta of a write's response envelope.
+func withNormalizations(m renderer.M, n normalizations) renderer.M {
+	if len(n) > 0 {
+		m["meta"] = renderer.M{"normalizations": n}
+	}
+	return m
+}
+
+// validateTodo checks a create or update payload and returns the error
+// envelope to send with a 400, or nil when the payload is acceptable.
+// The title and tags are normalized in place, and the changes noted in n.
+func validateTodo(t *todo, n *normalizations) renderer.M {
+	if t.Title == "" {
+		return renderer.M{"message": "Title field is required", "field": "title"}
+	}
+	t.Title = normalizeTitle(t.Title, n)
+	if m := validateTitle(t.Title); m != nil {
+		return m
+	}
+	if t.Location != nil {
+		if err := t.Location.validate(); err != nil {
+			return renderer.M{"message": "Invalid location", "field": "location", "error": err.Error()}
+		}
+	}
+	if t.Tags != nil {
+		t.Tags = normalizeTags(t.Tags, n)
+		if m := validateTags(t.Tags); m != nil {
+			return m
+		}
+	}
+	if t.Estimate != nil {
+		if m := validateEstimate(*t.Estimate); m != nil {
+			return m
+		}
+	}
+	if t.Bucket != "" {
+		if m := validateBucket(t.Bucket); m != nil {
+			return m
+		}
+	}
+	if t.Priority != "" {
+		if m := validatePriority(t.Priority); m != nil {
+			return m
+		}
+	}
+	if t.Metadata != nil {
+		if m := validateMetadata(t.Metadata); m != nil {
+			return m
+		}
+	}
+	if t.Status != "" {
+		if m := validateStatus(t.Status); m != nil {
+			return m
+		}
+		// A new todo is compared against completed being false, which is
+		// also what leaving it out decodes to, so a false one gives way
+		// to status and only a true one can win. updateTodo settles the
+		// two against the stored todo before getting here.
+		if t.Completed {
+			reconcileStatus(t, false, n)
+		}
+	}
+	return nil
+}
+
+// reconcileStatus settles a body whose completed and status disagree,
+// given the completed value of the todo it writes. A completed that
+// differs from it was changed on purpose and wins in either direction, so
+// a client echoing a fetched todo with only completed flipped completes or
+// reopens it. Otherwise the body contradicts itself, and the error
+// envelope to send with a 400 is returned.
+func reconcileStatus(t *todo, stored bool, n *normalizations) renderer.M {
+	if t.Status == "" || t.Completed == (t.Status == statusDone) {
+		return nil
+	}
+	if t.Completed == stored {
+		return renderer.M{
+			"message": "completed and status disagree; send them in agreement, or change completed alone",
+			"field":   "status",
+			"code":    "status_conflict",
+		}
+	}
+	t.Status = todoStatus(t.Completed, "")
+	n.note("status", fmt.Sprintf("set to %s as completed is %t", t.Status, t.Completed))
+	return nil
+}
+
+// validateTodoPayload checks a POST /todos body without saving it, for
+// forms that validate as the user types. Nothing is read from or written
+// to the database, so duplicates and warnings aren't reported.
+func validateTodoPayload(w http.ResponseWriter, r *http.Request) {
+	var t todo
+	if !decodeJSON(w, r, &t) {
+		return
+	}
+	if m := validateTodo(&t, nil); m != nil {
+		m["valid"] = false
+		respond(w, r, http.StatusUnprocessableEntity, m)
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{"valid": true})
+}
+
+// normalizeTitle puts a title in NFC, noting it in n if that changed it.
+func normalizeTitle(title string, n *normalizations) string {
+	out := normalizeText(title)
+	if out != title {
+		n.note("title", "normalized to Unicode NFC")
+	}
+	return out
+}
+
+// normalizeTags trims, lowercases and NFC-normalizes tags, dropping empty
+// ones and duplicates while keeping the first occurrence's position. Each
+// kind of change is noted in n once.
+func normalizeTags(tags []string, n *normalizations) []string {
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		trimmed := strings.TrimSpace(tag)
+		if trimmed != tag {
+			n.note("tags", "trimmed whitespace")
+		}
+		lower := strings.ToLower(trimmed)
+		if lower != trimmed {
+			n.note("tags", "lowercased")
+		}
+		tag = normalizeText(lower)
+		if tag != lower {
+			n.note("tags", "normalized to Unicode NFC")
+		}
+		if tag == "" {
+			n.note("tags", "removed empty tags")
+			continue
+		}
+		if seen[tag] {
+			n.note("tags", "removed duplicates")
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out
+}
+
+func normalizeTag(tag string) string {
+	return normalizeText(strings.ToLower(strings.TrimSpace(tag)))
+}
+
+func validateEstimate(minutes int) renderer.M {
+	if minutes < minEstimate || minutes > maxEstimate {
+		return renderer.M{
+			"message": fmt.Sprintf("Estimate must be between %d and %d minutes", minEstimate, maxEstimate),
+			"field":   "estimate",
+		}
+	}
+	return nil
+}
+
+// validateTags checks normalized tags against the configured limits.
+func validateTags(tags []string) renderer.M {
+	if len(tags) > maxTags {
+		return renderer.M{
+			"message": fmt.Sprintf("A todo may have at most %d tags", maxTags),
+			"field":   "tags",
+		}
+	}
+	for _, tag := range tags {
+		code, err := checkText(tag, maxTagLength)
+		if code == codeTextTooLong {
+			return renderer.M{
+				"message": fmt.Sprintf("Tags may be at most %d characters", maxTagLength),
+				"field":   "tags",
+				"code":    code,
+				"error":   fmt.Sprintf("tag %q is too long", tag),
+			}
+		}
+		if err != nil {
+			return renderer.M{"message": "Invalid tag", "field": "tags", "code": code, "error": err.Error()}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestReconcileStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		completed  bool
+		status     string
+		stored     bool
+		wantStatus string
+		conflict   bool
+	}{
+		{"agree, done", true, statusDone, false, statusDone, false},
+		{"agree, in progress", false, statusInProgress, true, statusInProgress, false},
+		{"no status", true, "", false, "", false},
+		{"completed flipped to true", true, statusTodo, false, statusDone, false},
+		{"completed flipped to true from in progress", true, statusInProgress, false, statusDone, false},
+		{"completed flipped to false", false, statusDone, true, statusTodo, false},
+		{"status done, completed unchanged", false, statusDone, false, "", true},
+		{"status reopened, completed unchanged", true, statusTodo, true, "", true},
+		{"status blocked, completed unchanged", true, statusBlocked, true, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := todo{Completed: tt.completed, Status: tt.status}
+			var notes normalizations
+			m := reconcileStatus(&td, tt.stored, &notes)
+			if tt.conflict {
+				if m == nil || m["code"] != "status_conflict" {
+					t.Errorf("reconcileStatus = %v; want status_conflict", m)
+				}
+				return
+			}
+			if m != nil {
+				t.Fatalf("reconcileStatus = %v; want nil", m)
+			}
+			if td.Status != tt.wantStatus || td.Completed != tt.completed {
+				t.Errorf("status %q, completed %v; want %q, %v", td.Status, td.Completed, tt.wantStatus, tt.completed)
+			}
+			if changed := tt.status != tt.wantStatus; changed != (len(notes) == 1) {
+				t.Errorf("notes = %v; want one only when the status changed", notes)
+			}
+		})
+	}
+}
+
+// A new todo is reconciled against completed being false, so a true one
+// wins and a false one, as when it is left out, gives way to status.
+func TestValidateTodoReconcilesNewTodo(t *testing.T) {
+	td := todo{Title: "write tests", Completed: true, Status: statusInProgress}
+	if m := validateTodo(&td, nil); m != nil || td.Status != statusDone {
+		t.Errorf("completed with status in_progress: %v, status %q; want done", m, td.Status)
+	}
+	td = todo{Title: "write tests", Status: statusDone}
+	if m := validateTodo(&td, nil); m != nil || td.Status != statusDone || td.Completed {
+		t.Errorf("status done without completed: %v, status %q, completed %v; want done kept", m, td.Status, td.Completed)
+	}
+}
+
+// TestPutReconcilesStatus echoes a fetched todo with only completed
+// flipped, the way an older client does, and expects it completed and
+// reopened; a status that disagrees with an unchanged completed is refused.
+func TestPutReconcilesStatus(t *testing.T) {
+	useTestDB(t)
+	tm, err := insertTodo(context.Background(), todo{Title: "write tests", Status: statusInProgress})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := testRouter()
+	put := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, "/todos/"+tm.ID.Hex(), strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+		return w
+	}
+	stored := func() todoModel {
+		var got todoModel
+		if err := db.Collection(collectionName).FindOne(context.Background(), bson.M{"_id": tm.ID}).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if w := put(`{"title":"write tests","completed":true,"status":"in_progress"}`); w.Code != http.StatusOK {
+		t.Fatalf("completing: %d %s", w.Code, w.Body)
+	}
+	if got := stored(); !got.Completed || todoStatus(got.Completed, got.Status) != statusDone {
+		t.Errorf("after completing: completed %v, status %q; want done", got.Completed, got.Status)
+	}
+
+	if w := put(`{"title":"write tests","completed":false,"status":"done"}`); w.Code != http.StatusOK {
+		t.Fatalf("reopening: %d %s", w.Code, w.Body)
+	}
+	if got := stored(); got.Completed || todoStatus(got.Completed, got.Status) != statusTodo {
+		t.Errorf("after reopening: completed %v, status %q; want todo", got.Completed, got.Status)
+	}
+
+	if w := put(`{"title":"write tests","completed":false,"status":"done"}`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "status_conflict") {
+		t.Errorf("status done with completed unchanged: %d %s; want 400 status_conflict", w.Code, w.Body)
+	}
+}
+
+func numberedTags(n int) []string {
+	tags := make([]string, n)
+	for i := range tags {
+		tags[i] = "tag" + strconv.Itoa(i)
+	}
+	return tags
+}
+
+func TestValidateTodoTagLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		tags []string
+		ok   bool
+	}{
+		{"one under", numberedTags(maxTags - 1), true},
+		{"at the limit", numberedTags(maxTags), true},
+		{"one over", numberedTags(maxTags + 1), false},
+		// Duplicates are dropped before counting.
+		{"over with a duplicate", append(numberedTags(maxTags), " TAG0 "), true},
+		{"longest tag", []string{strings.Repeat("a", maxTagLength)}, true},
+		{"tag one too long", []string{strings.Repeat("a", maxTagLength+1)}, false},
+	} {
+		td := todo{Title: "write tests", Tags: append([]string(nil), tc.tags...)}
+		patchTags := append([]string(nil), tc.tags...)
+		p := todoPatch{Tags: &patchTags}
+		for via, m := range map[string]renderer.M{"create": validateTodo(&td, nil), "patch": p.validate(nil)} {
+			if tc.ok && m != nil {
+				t.Errorf("%s, %s: %v; want valid", via, tc.name, m)
+			}
+			if !tc.ok && (m == nil || m["field"] != "tags") {
+				t.Errorf("%s, %s: %v; want an error on tags", via, tc.name, m)
+			}
+		}
+	}
+}
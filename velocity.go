@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultVelocityDays = 30
+	maxVelocityDays     = 365
+)
+
+// addVelocity fills in the backlog of s and how fast it was worked
+// through over the last s.Days days.
+func (s *todoStats) addVelocity(ctx context.Context) error {
+	collection := db.Collection(collectionName)
+
+	since := time.Now().AddDate(0, 0, -s.Days)
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: scoped(ctx, bson.M{"completed": true, "completedAt": bson.M{"$gte": since}})}},
+		{{Key: "$count", Value: "completed"}},
+	})
+	if err != nil {
+		return err
+	}
+	var counts []struct {
+		Completed int64 `bson:"completed"`
+	}
+	if err := cur.All(ctx, &counts); err != nil {
+		return err
+	}
+
+	cur, err = collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: scoped(ctx, bson.M{"completed": false})}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      nil,
+			"count":    bson.M{"$sum": 1},
+			"estimate": bson.M{"$sum": "$estimate"},
+			"inbox": bson.M{"$sum": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$bucket", bucketInbox}}, bucketInbox}}, 1, 0,
+			}}},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	var backlog []struct {
+		Count    int64 `bson:"count"`
+		Estimate int64 `bson:"estimate"`
+		Inbox    int64 `bson:"inbox"`
+	}
+	if err := cur.All(ctx, &backlog); err != nil {
+		return err
+	}
+
+	cur, err = collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: scoped(ctx, bson.M{"triagedAt": bson.M{"$gte": since}})}},
+		{{Key: "$group", Value: bson.M{
+			"_id": nil,
+			"ms":  bson.M{"$avg": bson.M{"$subtract": bson.A{"$triagedAt", "$createAt"}}},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	var triage []struct {
+		Ms float64 `bson:"ms"`
+	}
+	if err := cur.All(ctx, &triage); err != nil {
+		return err
+	}
+
+	if len(counts) > 0 {
+		s.Completed = counts[0].Completed
+	}
+	if len(backlog) > 0 {
+		s.Open, s.PendingMinutes, s.Inbox = backlog[0].Count, backlog[0].Estimate, backlog[0].Inbox
+	}
+	if len(triage) > 0 {
+		h := round2(triage[0].Ms / float64(time.Hour/time.Millisecond))
+		s.AvgTriageHours = &h
+	}
+	s.PerDay = round2(float64(s.Completed) / float64(s.Days))
+	if s.Completed > 0 {
+		d := round2(float64(s.Open) * float64(s.Days) / float64(s.Completed))
+		s.DaysToClear = &d
+	}
+	return nil
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Warning codes are part of the API; clients may match on them, so they
+// must never change.
+const (
+	warnDueDatePast    = "due_date_past"
+	warnDuplicateTitle = "duplicate_title"
+	warnManyTags       = "many_tags"
+	warnTagDropped     = "tag_dropped"
+	warnTitleLong      = "title_near_limit"
+	warnSomedayDueSoon = "someday_due_soon"
+)
+
+// manyTagsWarning is the tag count above which a write is warned about.
+// MAX_TAGS is the hard limit.
+const manyTagsWarning = 10
+
+// longTitleWarning is the share of MAX_TITLE_LENGTH from which a title is
+// warned about.
+const longTitleWarning = 0.9
+
+// strictWarnings holds the codes that Prefer: handling=strict turns into
+// a 422, configurable through STRICT_WARNINGS.
+var strictWarnings = map[string]bool{
+	warnDueDatePast:    true,
+	warnDuplicateTitle: true,
+	warnManyTags:       true,
+	warnSomedayDueSoon: true,
+}
+
+// warning is a check that failed without blocking the write. It is
+// reported next to the result under "warnings".
+type warning struct {
+	Code    string `json:"code" xml:"code"`
+	Field   string `json:"field,omitempty" xml:"field,omitempty"`
+	Message string `json:"message" xml:"message"`
+}
+
+// warningInput holds the fields of a write that soft checks look at. Nil
+// fields were not written. ID is zero on create.
+type warningInput struct {
+	ID      primitive.ObjectID
+	Title   *string
+	DueDate *time.Time
+	Tags    []string
+	Bucket  string
+}
+
+func todoWarnings(ctx context.Context, in warningInput) ([]warning, error) {
+	var warnings []warning
+	if in.DueDate != nil && in.DueDate.Before(time.Now()) {
+		warnings = append(warnings, warning{Code: warnDueDatePast, Field: "due_date", Message: "Due date is in the past"})
+	}
+	if in.Bucket == bucketSomeday && in.DueDate != nil && in.DueDate.Before(time.Now().Add(somedayDueWindow)) {
+		warnings = append(warnings, warning{
+			Code:    warnSomedayDueSoon,
+			Field:   "bucket",
+			Message: "Todo is due within a week but was put off to someday",
+		})
+	}
+	if in.Title != nil && float64(textLength(*in.Title)) >= longTitleWarning*float64(maxTitleLength) {
+		warnings = append(warnings, warning{
+			Code:    warnTitleLong,
+			Field:   "title",
+			Message: fmt.Sprintf("Title is close to the %d character limit", maxTitleLength),
+		})
+	}
+	if in.Title != nil {
+		dup, err := duplicateTitle(ctx, in.ID, *in.Title)
+		if err != nil {
+			return nil, err
+		}
+		if dup {
+			warnings = append(warnings, warning{Code: warnDuplicateTitle, Field: "title", Message: "Another todo has the same title"})
+		}
+	}
+	if len(in.Tags) > manyTagsWarning {
+		warnings = append(warnings, warning{
+			Code:    warnManyTags,
+			Field:   "tags",
+			Message: fmt.Sprintf("More than %d tags", manyTagsWarning),
+		})
+	}
+	return warnings, nil
+}
+
+// duplicateTitle reports whether a todo other than id already has title,
+// ignoring case.
+func duplicateTitle(ctx context.Context, id primitive.ObjectID, title string) (bool, error) {
+	filter := scoped(ctx, bson.M{"title": title})
+	if !id.IsZero() {
+		filter["_id"] = bson.M{"$ne": id}
+	}
+	opts := options.Count().SetLimit(1).SetCollation(&options.Collation{Locale: "en", Strength: 2})
+	n, err := db.Collection(collectionName).CountDocuments(ctx, filter, opts)
+	return n > 0, err
+}
+
+// preferStrict reports whether the request asked for Prefer:
+// handling=strict (RFC 7240).
+func preferStrict(r *http.Request) bool {
+	return hasPreference(r, "handling=strict")
+}
+
+// hasPreference reports whether a Prefer header of r carries pref.
+func hasPreference(r *http.Request, pref string) bool {
+	for _, v := range r.Header.Values("Prefer") {
+		for _, p := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.ReplaceAll(strings.TrimSpace(p), " ", ""), pref) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wantWarnings reports whether the request opted into warnings with
+// ?warnings=true. Without it, successful writes never carry them.
+func wantWarnings(r *http.Request) bool {
+	ok, _ := strconv.ParseBool(r.URL.Query().Get("warnings"))
+	return ok
+}
+
+// checkWarnings runs the soft checks for a write, unless the request
+// neither opted into warnings nor asked for strict handling. Under strict
+// handling a warning in strictWarnings rejects the write with 422 before
+// anything is stored. It reports whether the handler should go on.
+func checkWarnings(w http.ResponseWriter, r *http.Request, ctx context.Context, in warningInput) ([]warning, bool) {
+	if !wantWarnings(r) && !preferStrict(r) {
+		return nil, true
+	}
+	warnings, err := todoWarnings(ctx, in)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to validate todo", "error": err.Error()})
+		return nil, false
+	}
+	if !preferStrict(r) {
+		return warnings, true
+	}
+
+	w.Header().Set("Preference-Applied", "handling=strict")
+	var blocking []warning
+	for _, wn := range warnings {
+		if strictWarnings[wn.Code] {
+			blocking = append(blocking, wn)
+		}
+	}
+	if len(blocking) > 0 {
+		respond(w, r, http.StatusUnprocessableEntity, renderer.M{
+			"message":  "Rejected by strict handling",
+			"code":     "strict_warning",
+			"warnings": blocking,
+		})
+		return nil, false
+	}
+	return warnings, true
+}
+
+// withWarnings adds warnings to a response envelope when the request
+// opted into them. The array is present, possibly empty, whenever it did,
+// so clients can tell "no warnings" from "not asked".
+func withWarnings(r *http.Request, m renderer.M, warnings []warning) renderer.M {
+	if wantWarnings(r) {
+		if warnings == nil {
+			warnings = []warning{}
+		}
+		m["warnings"] = warnings
+	}
+	return m
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestCheckWarnings raises each warning of a write in turn, once opted
+// into with ?warnings=true and once under Prefer: handling=strict.
+func TestCheckWarnings(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	soon := time.Now().Add(time.Hour)
+	title := "write tests"
+	longTitle := strings.Repeat("a", maxTitleLength)
+
+	tests := []struct {
+		code string
+		in   warningInput
+		// duplicates is what the duplicate title count finds, for inputs
+		// with a title.
+		duplicates int
+	}{
+		{warnDueDatePast, warningInput{DueDate: &past}, 0},
+		{warnSomedayDueSoon, warningInput{DueDate: &soon, Bucket: bucketSomeday}, 0},
+		{warnTitleLong, warningInput{Title: &longTitle}, 0},
+		{warnDuplicateTitle, warningInput{Title: &title}, 1},
+		{warnManyTags, warningInput{Tags: numberedTags(manyTagsWarning + 1)}, 0},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, tt := range tests {
+		for _, strict := range []bool{false, true} {
+			name := tt.code + "/lenient"
+			if strict {
+				name = tt.code + "/strict"
+			}
+			mt.Run(name, func(mt *mtest.T) {
+				useMockDB(mt)
+				if tt.in.Title != nil {
+					ns := mt.DB.Name() + "." + collectionName
+					mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: tt.duplicates}}))
+				}
+
+				r := httptest.NewRequest(http.MethodPost, "/todos?warnings=true", nil)
+				if strict {
+					r.Header.Set("Prefer", "handling=strict")
+				}
+				w := httptest.NewRecorder()
+				warnings, ok := checkWarnings(w, r, context.Background(), tt.in)
+
+				if !strict || !strictWarnings[tt.code] {
+					if !ok || len(warnings) != 1 || warnings[0].Code != tt.code {
+						mt.Fatalf("checkWarnings = %+v, %v; want the write to go on with %s", warnings, ok, tt.code)
+					}
+					if strict && w.Header().Get("Preference-Applied") != "handling=strict" {
+						mt.Error("Preference-Applied not set")
+					}
+					return
+				}
+				if ok {
+					mt.Fatalf("checkWarnings = %+v, true; want the write refused", warnings)
+				}
+				var res struct {
+					Code     string    `json:"code"`
+					Warnings []warning `json:"warnings"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+					mt.Fatal(err)
+				}
+				if w.Code != http.StatusUnprocessableEntity || res.Code != "strict_warning" || len(res.Warnings) != 1 || res.Warnings[0].Code != tt.code {
+					mt.Errorf("strict response %d %s; want 422 strict_warning with %s", w.Code, w.Body, tt.code)
+				}
+			})
+		}
+	}
+
+	mt.Run("not asked", func(mt *mtest.T) {
+		useMockDB(mt)
+		w := httptest.NewRecorder()
+		warnings, ok := checkWarnings(w, httptest.NewRequest(http.MethodPost, "/todos", nil), context.Background(), warningInput{Title: &title, DueDate: &past})
+		if !ok || warnings != nil {
+			mt.Errorf("checkWarnings = %+v, %v; want nothing checked", warnings, ok)
+		}
+		if n := len(mt.GetAllStartedEvents()); n != 0 {
+			mt.Errorf("ran %d queries; want none", n)
+		}
+	})
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const wipLimitsCollection = "wip_limits"
+
+// limitableStatuses are the statuses a WIP limit can cap. todo is where
+// work waits and done where it ends, so capping either would only get in
+// the way of adding or finishing todos.
+var limitableStatuses = map[string]bool{statusInProgress: true, statusBlocked: true}
+
+type (
+	// wipLimitModel caps how many todos may be in a status at once. There
+	// is one per limited status, shared by the whole instance.
+	wipLimitModel struct {
+		Status string `bson:"_id"`
+		Limit  int    `bson:"limit"`
+		// Moves is bumped by every checked move into the status, so two
+		// moves at once write the same document and one of them retries.
+		Moves     int64     `bson:"moves"`
+		UpdatedAt time.Time `bson:"updatedAt"`
+	}
+	// wipLimit is a limit with how many todos are in its status now.
+	wipLimit struct {
+		Status string `json:"status" xml:"status"`
+		Limit  int    `json:"limit" xml:"limit"`
+		Count  int64  `json:"count" xml:"count"`
+	}
+	wipLimitBody struct {
+		Limit int `json:"limit"`
+	}
+)
+
+// wipLimitError refuses a move into a status that is at its limit.
+type wipLimitError struct {
+	limit     wipLimitModel
+	occupants []todoHeadline
+}
+
+func (e *wipLimitError) Error() string {
+	return fmt.Sprintf("%s is at its limit of %d", e.limit.Status, e.limit.Limit)
+}
+
+// response is the body of the 409 a refused move gets, listing the todos
+// holding the status.
+func (e *wipLimitError) response() renderer.M {
+	occupants := make([]todo, len(e.occupants))
+	for i, h := range e.occupants {
+		occupants[i] = h.toTodo()
+	}
+	return renderer.M{
+		"message":   "Status " + e.Error(),
+		"field":     "status",
+		"code":      "wip_limit",
+		"limit":     e.limit.Limit,
+		"occupants": occupants,
+	}
+}
+
+func validateWIPStatus(s string) renderer.M {
+	if !limitableStatuses[s] {
+		return renderer.M{"message": "Only in_progress and blocked can be limited", "field": "status"}
+	}
+	return nil
+}
+
+// wipUtilization lists every limit with how many todos are in its status.
+func wipUtilization(ctx context.Context) ([]wipLimit, error) {
+	cur, err := db.Collection(wipLimitsCollection).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var limits []wipLimitModel
+	if err := cur.All(ctx, &limits); err != nil {
+		return nil, err
+	}
+	out := []wipLimit{}
+	for _, l := range limits {
+		n, err := db.Collection(collectionName).CountDocuments(ctx, scoped(ctx, statusCond([]string{l.Status})))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, wipLimit{Status: l.Status, Limit: l.Limit, Count: n})
+	}
+	return out, nil
+}
+
+// updateTodoLimited is updateTodoAudited for writes that may move a todo
+// into a limited status. Such a move runs in a transaction that counts the
+// todos already there and bumps the limit's Moves: concurrent moves into
+// the status then conflict on the limit, and each retry counts again, so
+// they can't all pass the limit at once. A move that would go over it
+// fails with a *wipLimitError.
+func updateTodoLimited(ctx context.Context, filter bson.M, fw *fieldWrites) (todoModel, error) {
+	if !limitableStatuses[fw.status] {
+		return updateTodoAudited(ctx, filter, fw.pipeline())
+	}
+	limits := db.Collection(wipLimitsCollection)
+	err := limits.FindOne(ctx, bson.M{"_id": fw.status}).Err()
+	if err == mongo.ErrNoDocuments {
+		// Statuses without a limit don't need a transaction, and so no
+		// replica set.
+		return updateTodoAudited(ctx, filter, fw.pipeline())
+	}
+	if err != nil {
+		return todoModel{}, err
+	}
+
+	sess := mongo.SessionFromContext(ctx)
+	if sess == nil {
+		if sess, err = client.StartSession(); err != nil {
+			return todoModel{}, err
+		}
+		defer sess.EndSession(ctx)
+	}
+
+	filter = scoped(ctx, filter)
+	var before, after todoModel
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		collection := db.Collection(collectionName)
+		if err := collection.FindOne(sc, filter).Decode(&before); err != nil {
+			return nil, err
+		}
+		if todoStatus(before.Completed, before.Status) != fw.status {
+			var limit wipLimitModel
+			err := limits.FindOneAndUpdate(sc, bson.M{"_id": fw.status}, bson.M{"$inc": bson.M{"moves": 1}}).Decode(&limit)
+			switch {
+			case err == mongo.ErrNoDocuments:
+				// The limit was lifted in the meantime.
+			case err != nil:
+				return nil, err
+			default:
+				cur, err := collection.Find(sc, scoped(ctx, statusCond([]string{fw.status})),
+					options.Find().SetProjection(projectionOf(todoHeadline{})).SetSort(bson.M{"statusChangedAt": 1}))
+				if err != nil {
+					return nil, err
+				}
+				var occupants []todoHeadline
+				if err := cur.All(sc, &occupants); err != nil {
+					return nil, err
+				}
+				if len(occupants) >= limit.Limit {
+					return nil, &wipLimitError{limit: limit, occupants: occupants}
+				}
+			}
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		return nil, collection.FindOneAndUpdate(sc, bson.M{"$and": bson.A{filter, bson.M{"_id": before.ID}}}, fw.pipeline(), opts).Decode(&after)
+	})
+	if err != nil {
+		return todoModel{}, err
+	}
+
+	// As with a swap, the change is recorded only once the transaction has
+	// committed, as it may have been retried.
+	todosChanged()
+	recordChange(auditUpdate, &before, &after)
+	return after, nil
+}
+
+// fetchWIPLimits serves GET /limits with the utilization of each limit.
+func fetchWIPLimits(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	limits, err := wipUtilization(ctx)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to fetch WIP limits", "error": err.Error()})
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{"data": limits})
+}
+
+// putWIPLimit sets the limit of a status. Todos already over a lowered
+// limit stay where they are; only moves into the status are refused.
+func putWIPLimit(w http.ResponseWriter, r *http.Request) {
+	status := chi.URLParam(r, "status")
+	if m := validateWIPStatus(status); m != nil {
+		respond(w, r, http.StatusBadRequest, m)
+		return
+	}
+	if demoMode {
+		respond(w, r, http.StatusForbidden, renderer.M{"message": "WIP limits can't be changed in demo mode"})
+		return
+	}
+	var body wipLimitBody
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	if body.Limit < 1 {
+		respond(w, r, http.StatusBadRequest, renderer.M{"message": "Invalid limit", "field": "limit", "error": "limit must be a positive integer"})
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"limit": body.Limit, "updatedAt": time.Now()}}
+	if _, err := db.Collection(wipLimitsCollection).UpdateOne(ctx, bson.M{"_id": status}, update, options.Update().SetUpsert(true)); err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to save WIP limit", "error": err.Error()})
+		return
+	}
+	// Cached stats report the limits too.
+	todosChanged()
+
+	n, err := db.Collection(collectionName).CountDocuments(ctx, scoped(ctx, statusCond([]string{status})))
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to count todos", "error": err.Error()})
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{"message": "WIP limit saved", "data": wipLimit{Status: status, Limit: body.Limit, Count: n}})
+}
+
+// deleteWIPLimit lifts the limit of a status.
+func deleteWIPLimit(w http.ResponseWriter, r *http.Request) {
+	status := chi.URLParam(r, "status")
+	if m := validateWIPStatus(status); m != nil {
+		respond(w, r, http.StatusBadRequest, m)
+		return
+	}
+	if demoMode {
+		respond(w, r, http.StatusForbidden, renderer.M{"message": "WIP limits can't be changed in demo mode"})
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+
+	res, err := db.Collection(wipLimitsCollection).DeleteOne(ctx, bson.M{"_id": status})
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, renderer.M{"message": "Failed to delete WIP limit", "error": err.Error()})
+		return
+	}
+	if res.DeletedCount == 0 {
+		respond(w, r, http.StatusNotFound, renderer.M{"message": "No WIP limit on " + status})
+		return
+	}
+	todosChanged()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func wipLimitHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(causalConsistency)
+
+	rg.Get("/", fetchWIPLimits)
+	rg.With(requireJSON).Put("/{status}", putWIPLimit)
+	rg.Delete("/{status}", deleteWIPLimit)
+	return rg
+}
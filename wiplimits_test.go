@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func patchStatus(h http.Handler, id primitive.ObjectID, status string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPatch, "/todos/"+id.Hex(), strings.NewReader(`{"status": "`+status+`"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// TestWIPLimitRefusesMove moves a todo into in_progress while it is at
+// its limit, and expects a 409 listing the todos there, with the count
+// read in the transaction that bumps the limit.
+func TestWIPLimitRefusesMove(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("at limit", func(mt *mtest.T) {
+		useMockDB(mt)
+
+		id := primitive.NewObjectID()
+		ns := mt.DB.Name() + "." + collectionName
+		limitNS := mt.DB.Name() + "." + wipLimitsCollection
+		limit := bson.D{{Key: "_id", Value: statusInProgress}, {Key: "limit", Value: 2}}
+		occupant := func(title string) bson.D {
+			return bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "title", Value: title}}
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, limitNS, mtest.FirstBatch, limit),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: id}, {Key: "title", Value: "write tests"}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: limit}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, occupant("fix the build"), occupant("review the PR")),
+			mtest.CreateSuccessResponse(),
+		)
+
+		w := patchStatus(testRouter(), id, statusInProgress)
+		if w.Code != http.StatusConflict {
+			mt.Fatalf("PATCH status: %d %s; want 409", w.Code, w.Body)
+		}
+		var res struct {
+			Code      string `json:"code"`
+			Limit     int    `json:"limit"`
+			Occupants []todo `json:"occupants"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			mt.Fatal(err)
+		}
+		if res.Code != "wip_limit" || res.Limit != 2 || len(res.Occupants) != 2 || res.Occupants[0].Title != "fix the build" {
+			mt.Errorf("409 body = %s; want code wip_limit, the limit and both occupants", w.Body)
+		}
+
+		var inTxn []string
+		for _, ev := range mt.GetAllStartedEvents() {
+			if _, ok := ev.Command.Lookup("txnNumber").Int64OK(); ok {
+				inTxn = append(inTxn, ev.CommandName)
+			}
+			if ev.CommandName == "findAndModify" && ev.Command.Lookup("findAndModify").StringValue() == collectionName {
+				mt.Error("todo was written past its limit")
+			}
+		}
+		want := []string{"find", "findAndModify", "find", "abortTransaction"}
+		if strings.Join(inTxn, ",") != strings.Join(want, ",") {
+			mt.Errorf("commands in the transaction = %q; want %q", inTxn, want)
+		}
+	})
+}
+
+// TestWIPLimitConcurrentMoves moves many todos into in_progress at once
+// with a limit of one, and expects exactly one move to get through. It
+// needs TEST_MONGO_URI to point at a replica set.
+func TestWIPLimitConcurrentMoves(t *testing.T) {
+	useTestDB(t)
+	ctx := context.Background()
+	var hello bson.M
+	if err := db.RunCommand(ctx, bson.M{"hello": 1}).Decode(&hello); err != nil {
+		t.Fatal(err)
+	}
+	if hello["setName"] == nil {
+		t.Skip("WIP limits need a replica set")
+	}
+
+	if _, err := db.Collection(wipLimitsCollection).InsertOne(ctx, wipLimitModel{Status: statusInProgress, Limit: 1, UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	const n = 8
+	ids := make([]primitive.ObjectID, n)
+	var docs []interface{}
+	for i := range ids {
+		ids[i] = primitive.NewObjectID()
+		docs = append(docs, todoModel{ID: ids[i], Title: "todo", CreateAt: time.Now()})
+	}
+	if _, err := db.Collection(collectionName).InsertMany(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+
+	h := testRouter()
+	codes := make([]int, n)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id primitive.ObjectID) {
+			defer wg.Done()
+			codes[i] = patchStatus(h, id, statusInProgress).Code
+		}(i, id)
+	}
+	wg.Wait()
+
+	moved := 0
+	for _, c := range codes {
+		switch c {
+		case http.StatusOK:
+			moved++
+		case http.StatusConflict:
+		default:
+			t.Errorf("PATCH status: %d; want 200 or 409", c)
+		}
+	}
+	count, err := db.Collection(collectionName).CountDocuments(ctx, statusCond([]string{statusInProgress}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 1 || count != 1 {
+		t.Errorf("%d moves succeeded and %d todos are in_progress; want 1 of each", moved, count)
+	}
+}